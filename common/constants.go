@@ -35,6 +35,9 @@ var DefaultCollapseSidebar = false // default value of collapse sidebar
 var SessionSecret = uuid.New().String()
 var CryptoSecret = uuid.New().String()
 
+// EphemeralTokenSecret 临时令牌的签名密钥，只能通过环境变量配置，未配置时不能签发临时令牌
+var EphemeralTokenSecret = ""
+
 var OptionMap map[string]string
 var OptionMapRWMutex sync.RWMutex
 
@@ -209,7 +212,8 @@ const (
 )
 
 const (
-	TopUpStatusPending = "pending"
-	TopUpStatusSuccess = "success"
-	TopUpStatusExpired = "expired"
+	TopUpStatusPending  = "pending"
+	TopUpStatusSuccess  = "success"
+	TopUpStatusExpired  = "expired"
+	TopUpStatusRefunded = "refunded"
 )
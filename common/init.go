@@ -61,6 +61,7 @@ func InitEnv() {
 	} else {
 		CryptoSecret = SessionSecret
 	}
+	EphemeralTokenSecret = os.Getenv("EPHEMERAL_TOKEN_SECRET")
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -147,6 +148,11 @@ func initConstantEnv() {
 	constant.TaskQueryLimit = GetEnvOrDefault("TASK_QUERY_LIMIT", 1000)
 	// 异步任务超时时间（分钟），超过此时间未完成的任务将被标记为失败并退款。0 表示禁用。
 	constant.TaskTimeoutMinutes = GetEnvOrDefault("TASK_TIMEOUT_MINUTES", 1440)
+	// 异步导出文件的存放目录和保留时间（小时）
+	constant.ExportDir = GetEnvOrDefaultString("EXPORT_DIR", filepath.Join(os.TempDir(), "new-api-exports"))
+	constant.ExportFileRetentionHours = GetEnvOrDefault("EXPORT_FILE_RETENTION_HOURS", 24)
+	// 管理操作审计日志的保留天数，0 表示永久保留
+	constant.AdminAuditRetentionDays = GetEnvOrDefault("ADMIN_AUDIT_RETENTION_DAYS", 180)
 
 	soraPatchStr := GetEnvOrDefaultString("TASK_PRICE_PATCH", "")
 	if soraPatchStr != "" {
@@ -13,9 +13,13 @@ import (
 //go:embed lua/rate_limit.lua
 var rateLimitScript string
 
+//go:embed lua/token_bucket.lua
+var tokenBucketScript string
+
 type RedisLimiter struct {
 	client         *redis.Client
 	limitScriptSHA string
+	bucketScript   *redis.Script
 }
 
 var (
@@ -33,6 +37,7 @@ func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 		instance = &RedisLimiter{
 			client:         r,
 			limitScriptSHA: limitSHA,
+			bucketScript:   redis.NewScript(tokenBucketScript),
 		}
 	})
 
@@ -40,17 +45,7 @@ func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 }
 
 func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (bool, error) {
-	// 默认配置
-	config := &Config{
-		Capacity:  10,
-		Rate:      1,
-		Requested: 1,
-	}
-
-	// 应用选项模式
-	for _, opt := range opts {
-		opt(config)
-	}
+	config := newConfig(opts...)
 
 	// 执行限流
 	result, err := rl.client.EvalSha(
@@ -68,11 +63,68 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 	return result == 1, nil
 }
 
+// Take 从令牌桶中取出令牌并返回剩余令牌数，Requested 为负数时归还令牌
+func (rl *RedisLimiter) Take(ctx context.Context, key string, opts ...Option) (bool, int64, error) {
+	config := newConfig(opts...)
+	force := 0
+	if config.Force {
+		force = 1
+	}
+	result, err := rl.bucketScript.Run(
+		ctx,
+		rl.client,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+		force,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("rate limit failed: %w", err)
+	}
+	return result[0] == 1, result[1], nil
+}
+
+// Acquire 占用一个并发名额，超过 max 时返回 false
+func (rl *RedisLimiter) Acquire(ctx context.Context, key string, max int64) (bool, error) {
+	current, err := rl.client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("concurrency limit failed: %w", err)
+	}
+	// 防止进程异常退出导致名额无法释放
+	rl.client.Expire(ctx, key, concurrencyKeyTTL)
+	if current > max {
+		rl.client.Decr(ctx, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Release 释放一个并发名额
+func (rl *RedisLimiter) Release(ctx context.Context, key string) error {
+	return rl.client.Decr(ctx, key).Err()
+}
+
 // Config 配置选项模式
 type Config struct {
 	Capacity  int64
 	Rate      int64
 	Requested int64
+	Force     bool
+}
+
+func newConfig(opts ...Option) *Config {
+	// 默认配置
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+	// 应用选项模式
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
 }
 
 type Option func(*Config)
@@ -88,3 +140,8 @@ func WithRate(r int64) Option {
 func WithRequested(n int64) Option {
 	return func(cfg *Config) { cfg.Requested = n }
 }
+
+// WithForce 令牌不足时仍然扣除，用于请求完成后按实际用量修正
+func WithForce() Option {
+	return func(cfg *Config) { cfg.Force = true }
+}
@@ -0,0 +1,118 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+const concurrencyKeyTTL = time.Hour
+
+// Limiter 令牌桶与并发限制，Redis 未启用时使用内存实现
+type Limiter interface {
+	Take(ctx context.Context, key string, opts ...Option) (bool, int64, error)
+	Acquire(ctx context.Context, key string, max int64) (bool, error)
+	Release(ctx context.Context, key string) error
+}
+
+type memoryBucket struct {
+	tokens   int64
+	lastTime int64
+	capacity int64
+	rate     int64
+}
+
+// MemoryLimiter 单机内存版令牌桶，语义与 Redis 脚本一致
+type MemoryLimiter struct {
+	mutex       sync.Mutex
+	buckets     map[string]*memoryBucket
+	concurrency map[string]int64
+}
+
+var (
+	memoryInstance *MemoryLimiter
+	memoryOnce     sync.Once
+)
+
+func NewMemory() *MemoryLimiter {
+	memoryOnce.Do(func() {
+		memoryInstance = &MemoryLimiter{
+			buckets:     make(map[string]*memoryBucket),
+			concurrency: make(map[string]int64),
+		}
+		go memoryInstance.clearFullBuckets()
+	})
+	return memoryInstance
+}
+
+// Default 根据是否启用 Redis 选择限流器实现
+func Default(ctx context.Context) Limiter {
+	if common.RedisEnabled {
+		return New(ctx, common.RDB)
+	}
+	return NewMemory()
+}
+
+func (b *memoryBucket) refill(now int64) {
+	b.tokens = min(b.capacity, b.tokens+(now-b.lastTime)*b.rate)
+	b.lastTime = now
+}
+
+func (ml *MemoryLimiter) Take(_ context.Context, key string, opts ...Option) (bool, int64, error) {
+	config := newConfig(opts...)
+	now := time.Now().Unix()
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	bucket, ok := ml.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: config.Capacity, lastTime: now}
+		ml.buckets[key] = bucket
+	}
+	bucket.capacity = config.Capacity
+	bucket.rate = config.Rate
+	bucket.refill(now)
+	if !config.Force && bucket.tokens < config.Requested {
+		return false, bucket.tokens, nil
+	}
+	bucket.tokens = min(bucket.capacity, bucket.tokens-config.Requested)
+	return true, bucket.tokens, nil
+}
+
+func (ml *MemoryLimiter) Acquire(_ context.Context, key string, max int64) (bool, error) {
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	if ml.concurrency[key] >= max {
+		return false, nil
+	}
+	ml.concurrency[key]++
+	return true, nil
+}
+
+func (ml *MemoryLimiter) Release(_ context.Context, key string) error {
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	if ml.concurrency[key] <= 1 {
+		delete(ml.concurrency, key)
+		return nil
+	}
+	ml.concurrency[key]--
+	return nil
+}
+
+// clearFullBuckets 定期清理已回满的令牌桶，回满的桶与不存在等价
+func (ml *MemoryLimiter) clearFullBuckets() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now().Unix()
+		ml.mutex.Lock()
+		for key, bucket := range ml.buckets {
+			bucket.refill(now)
+			if bucket.tokens >= bucket.capacity {
+				delete(ml.buckets, key)
+			}
+		}
+		ml.mutex.Unlock()
+	}
+}
@@ -19,6 +19,16 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenRpmLimit          ContextKey = "token_rpm_limit"
+	ContextKeyTokenTpmLimit          ContextKey = "token_tpm_limit"
+	ContextKeyTokenConcurrencyLimit  ContextKey = "token_concurrency_limit"
+	ContextKeyTokenTpmReserved       ContextKey = "token_tpm_reserved"
+	ContextKeyTokenEphemeral         ContextKey = "token_ephemeral"
+	ContextKeyTokenEphemeralId       ContextKey = "token_ephemeral_id"
+	ContextKeyTokenEphemeralQuota    ContextKey = "token_ephemeral_quota"
+	ContextKeyTokenEphemeralExpires  ContextKey = "token_ephemeral_expires"
+	ContextKeyTokenLabels            ContextKey = "token_labels"
+	ContextKeyConsumedTokens         ContextKey = "consumed_tokens"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
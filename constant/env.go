@@ -17,6 +17,9 @@ var GenerateDefaultToken bool
 var ErrorLogEnabled bool
 var TaskQueryLimit int
 var TaskTimeoutMinutes int
+var ExportDir string
+var ExportFileRetentionHours int
+var AdminAuditRetentionDays int
 
 // temporary variable for sora patch, will be removed in future
 var TaskPricePatches []string
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAffiliateCommissions 邀请人查看返佣汇总和返佣流水
+func GetAffiliateCommissions(c *gin.Context) {
+	userId := c.GetInt("id")
+	summary, err := model.GetUserAffiliateCommissionSummary(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	entries, total, err := model.GetUserAffiliateCommissions(userId, pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(entries)
+	setting := operation_setting.GetAffiliateSetting()
+	common.ApiSuccess(c, gin.H{
+		"enabled":       setting.CommissionEnabled,
+		"basis":         setting.Basis,
+		"duration_days": setting.DurationDays,
+		"tiers":         setting.Tiers,
+		"summary":       summary,
+		"commissions":   pageInfo,
+	})
+}
+
+// GetAffiliatePayoutReport 管理员按邀请人汇总返佣，用于结算
+func GetAffiliatePayoutReport(c *gin.Context) {
+	inviterId, _ := strconv.Atoi(c.Query("inviter_id"))
+	startTime, _ := strconv.ParseInt(c.Query("start_time"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_time"), 10, 64)
+	pageInfo := common.GetPageQuery(c)
+	rows, total, err := model.GetAffiliatePayoutReport(inviterId, startTime, endTime, pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(rows)
+	common.ApiSuccess(c, pageInfo)
+}
@@ -804,12 +804,16 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	originChannel, _ := model.GetChannelById(id, true)
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	if originChannel != nil {
+		model.RecordAdminAudit(c, "channel.delete", "channels", id, originChannel, nil)
+	}
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1097,6 +1101,9 @@ func UpdateChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if updatedChannel, err := model.GetChannelById(channel.Id, true); err == nil {
+		model.RecordAdminAudit(c, "channel.update", "channels", channel.Id, originChannel, updatedChannel)
+	}
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
 	channel.Key = ""
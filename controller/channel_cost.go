@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMarginReport 管理员查看按渠道、模型、分组或时间段汇总的收入、上游成本和毛利
+func GetMarginReport(c *gin.Context) {
+	filter := model.MarginReportFilter{
+		GroupBy:   c.DefaultQuery("group_by", model.MarginGroupByChannel),
+		ModelName: c.Query("model_name"),
+		Group:     c.Query("group"),
+	}
+	filter.StartTimestamp, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	filter.EndTimestamp, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	filter.Channel, _ = strconv.Atoi(c.Query("channel"))
+	rows, err := model.GetMarginReport(filter)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	total := model.MarginReportRow{Dimension: "total"}
+	for _, row := range rows {
+		total.RequestCount += row.RequestCount
+		total.Revenue += row.Revenue
+		total.UpstreamCost += row.UpstreamCost
+	}
+	total.Margin = total.Revenue - total.UpstreamCost
+	if total.Revenue > 0 {
+		total.MarginRate = float64(total.Margin) / float64(total.Revenue)
+	}
+	common.ApiSuccess(c, gin.H{
+		"group_by": filter.GroupBy,
+		"items":    rows,
+		"total":    total,
+	})
+}
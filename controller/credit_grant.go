@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+)
+
+// GetSelfCreditGrants 获取当前用户的余额构成和授予记录
+func GetSelfCreditGrants(c *gin.Context) {
+	getCreditGrants(c, c.GetInt("id"))
+}
+
+// GetUserCreditGrants 管理员获取指定用户的余额构成和授予记录
+func GetUserCreditGrants(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userId <= 0 {
+		common.ApiErrorMsg(c, "无效的用户ID")
+		return
+	}
+	getCreditGrants(c, userId)
+}
+
+func getCreditGrants(c *gin.Context, userId int) {
+	balance, err := model.GetUserCreditBalance(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	grants, total, err := model.GetUserCreditGrants(userId, c.Query("status"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(grants)
+	common.ApiSuccess(c, gin.H{
+		"enabled": operation_setting.IsCreditLedgerEnabled(),
+		"balance": balance,
+		"grants":  pageInfo,
+	})
+}
+
+type AdminGrantCreditRequest struct {
+	Quota     int64  `json:"quota"`
+	ExpiresAt *int64 `json:"expires_at"` // 不传表示使用促销额度的默认有效期，0 或 -1 表示永不过期
+	Remark    string `json:"remark"`
+}
+
+// AdminGrantCredit 管理员向用户发放带有效期的促销额度
+func AdminGrantCredit(c *gin.Context) {
+	if !operation_setting.IsCreditLedgerEnabled() {
+		common.ApiErrorMsg(c, "额度账本未启用")
+		return
+	}
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userId <= 0 {
+		common.ApiErrorMsg(c, "无效的用户ID")
+		return
+	}
+	var req AdminGrantCreditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	myRole := c.GetInt("role")
+	if myRole <= user.Role && myRole != common.RoleRootUser {
+		common.ApiErrorI18n(c, i18n.MsgUserNoPermissionSameLevel)
+		return
+	}
+	var expiresAt int64
+	switch {
+	case req.ExpiresAt == nil:
+		expiresAt = model.CreditGrantExpireTime(model.CreditSourcePromotion)
+	case *req.ExpiresAt == 0 || *req.ExpiresAt == -1:
+		expiresAt = 0
+	default:
+		expiresAt = *req.ExpiresAt
+	}
+	sourceRef := req.Remark
+	if len(sourceRef) > 255 {
+		sourceRef = sourceRef[:255]
+	}
+	if err := model.GrantCreditWithExpiry(userId, model.CreditSourcePromotion, sourceRef, req.Quota, expiresAt); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	expireText := "永不过期"
+	if expiresAt > 0 {
+		expireText = fmt.Sprintf("到期时间 %s", time.Unix(expiresAt, 0).Format("2006-01-02 15:04:05"))
+	}
+	model.RecordLog(userId, model.LogTypeManage, fmt.Sprintf("管理员发放促销额度 %s，%s", logger.LogQuota(int(req.Quota)), expireText))
+	common.ApiSuccess(c, nil)
+}
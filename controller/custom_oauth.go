@@ -71,7 +71,7 @@ func toCustomOAuthProviderResponse(p *model.CustomOAuthProvider) *CustomOAuthPro
 
 // GetCustomOAuthProviders returns all custom OAuth providers
 func GetCustomOAuthProviders(c *gin.Context) {
-	providers, err := model.GetAllCustomOAuthProviders()
+	providers, err := model.GetCustomOAuthProvidersByProtocol(model.CustomOAuthProtocolOAuth2)
 	if err != nil {
 		common.ApiError(c, err)
 		return
@@ -99,7 +99,7 @@ func GetCustomOAuthProvider(c *gin.Context) {
 	}
 
 	provider, err := model.GetCustomOAuthProviderById(id)
-	if err != nil {
+	if err != nil || provider.IsSAML() {
 		common.ApiErrorMsg(c, "未找到该 OAuth 提供商")
 		return
 	}
@@ -305,7 +305,7 @@ func UpdateCustomOAuthProvider(c *gin.Context) {
 
 	// Get existing provider
 	provider, err := model.GetCustomOAuthProviderById(id)
-	if err != nil {
+	if err != nil || provider.IsSAML() {
 		common.ApiErrorMsg(c, "未找到该 OAuth 提供商")
 		return
 	}
@@ -410,7 +410,7 @@ func DeleteCustomOAuthProvider(c *gin.Context) {
 
 	// Get existing provider to get slug
 	provider, err := model.GetCustomOAuthProviderById(id)
-	if err != nil {
+	if err != nil || provider.IsSAML() {
 		common.ApiErrorMsg(c, "未找到该 OAuth 提供商")
 		return
 	}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/gin-gonic/gin"
+)
+
+// exportFilterFromQuery 解析与 GetAllLogs / GetUserLogs 相同的筛选参数；用户范围固定为当前用户，
+// 管理员范围额外支持审计日志的 action、target_type、target_id
+func exportFilterFromQuery(c *gin.Context, scope string) model.ExportFilter {
+	filter := model.ExportFilter{}
+	filter.LogType, _ = strconv.Atoi(c.Query("type"))
+	filter.StartTimestamp, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	filter.EndTimestamp, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	filter.TokenName = c.Query("token_name")
+	filter.ModelName = c.Query("model_name")
+	filter.Group = c.Query("group")
+	filter.RequestId = c.Query("request_id")
+	if scope == service.ExportScopeUser {
+		filter.UserId = c.GetInt("id")
+	} else {
+		filter.Username = c.Query("username")
+		filter.Channel, _ = strconv.Atoi(c.Query("channel"))
+		filter.Action = c.Query("action")
+		filter.TargetType = c.Query("target_type")
+		filter.TargetId = c.Query("target_id")
+	}
+	return filter
+}
+
+func exportKindAndFormat(c *gin.Context, scope string) (string, string, bool) {
+	kind := c.DefaultQuery("kind", model.ExportKindLogs)
+	format := c.DefaultQuery("format", service.ExportFormatCSV)
+	if !service.IsValidExportKind(kind, scope) {
+		common.ApiErrorMsg(c, "不支持的导出类型")
+		return "", "", false
+	}
+	if !service.IsValidExportFormat(format) {
+		common.ApiErrorMsg(c, "不支持的导出格式")
+		return "", "", false
+	}
+	return kind, format, true
+}
+
+// ExportLogs 管理员同步流式导出日志或用量数据，仅支持较小的时间范围
+func ExportLogs(c *gin.Context) {
+	streamExport(c, service.ExportScopeAdmin)
+}
+
+// ExportSelfLogs 用户同步流式导出自己的日志或用量数据
+func ExportSelfLogs(c *gin.Context) {
+	streamExport(c, service.ExportScopeUser)
+}
+
+func streamExport(c *gin.Context, scope string) {
+	kind, format, ok := exportKindAndFormat(c, scope)
+	if !ok {
+		return
+	}
+	filter := exportFilterFromQuery(c, scope)
+	endTimestamp := filter.EndTimestamp
+	if endTimestamp == 0 {
+		endTimestamp = common.GetTimestamp()
+	}
+	if filter.StartTimestamp == 0 || endTimestamp-filter.StartTimestamp > service.ExportSyncMaxRange {
+		common.ApiErrorMsg(c, fmt.Sprintf("直接导出的时间范围不能超过 %d 天，请创建导出任务", service.ExportSyncMaxRange/86400))
+		return
+	}
+	c.Header("Content-Type", service.ExportContentType(format))
+	c.Header("Content-Disposition", "attachment; filename="+service.ExportFileName(kind, format))
+	c.Status(http.StatusOK)
+	// 响应已开始写出，出错时只能记录日志
+	if _, err := service.WriteExport(c.Writer, kind, format, scope, filter); err != nil {
+		common.SysError(fmt.Sprintf("failed to stream %s export: %s", kind, err.Error()))
+	}
+}
+
+// CreateExportJob 管理员创建异步导出任务
+func CreateExportJob(c *gin.Context) {
+	createExportJob(c, service.ExportScopeAdmin)
+}
+
+// CreateSelfExportJob 用户创建自己数据的异步导出任务
+func CreateSelfExportJob(c *gin.Context) {
+	createExportJob(c, service.ExportScopeUser)
+}
+
+func createExportJob(c *gin.Context, scope string) {
+	kind, format, ok := exportKindAndFormat(c, scope)
+	if !ok {
+		return
+	}
+	job, err := service.CreateExportJob(c.GetInt("id"), scope, kind, format, exportFilterFromQuery(c, scope))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, job)
+}
+
+// GetExportJobs 管理员查看自己创建的导出任务
+func GetExportJobs(c *gin.Context) {
+	getExportJobs(c, service.ExportScopeAdmin)
+}
+
+// GetSelfExportJobs 用户查看自己的导出任务
+func GetSelfExportJobs(c *gin.Context) {
+	getExportJobs(c, service.ExportScopeUser)
+}
+
+func getExportJobs(c *gin.Context, scope string) {
+	pageInfo := common.GetPageQuery(c)
+	jobs, total, err := model.GetExportJobs(c.GetInt("id"), scope, pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(jobs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// DownloadExportJob 管理员下载导出文件
+func DownloadExportJob(c *gin.Context) {
+	downloadExportJob(c, service.ExportScopeAdmin)
+}
+
+// DownloadSelfExportJob 用户下载自己的导出文件
+func DownloadSelfExportJob(c *gin.Context) {
+	downloadExportJob(c, service.ExportScopeUser)
+}
+
+func downloadExportJob(c *gin.Context, scope string) {
+	jobId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || jobId <= 0 {
+		common.ApiErrorMsg(c, "无效的任务ID")
+		return
+	}
+	userId := 0
+	if scope == service.ExportScopeUser {
+		userId = c.GetInt("id")
+	}
+	job, err := model.GetExportJob(jobId, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if job.Scope != scope {
+		common.ApiErrorMsg(c, "导出任务不存在")
+		return
+	}
+	if job.Status != model.ExportJobStatusCompleted {
+		common.ApiErrorMsg(c, "导出任务尚未完成或已过期")
+		return
+	}
+	if _, err := os.Stat(job.FilePath); err != nil {
+		// 导出文件保存在执行任务的节点本地，多节点部署时需要共享 EXPORT_DIR
+		common.ApiErrorMsg(c, "导出文件不存在或已被清理")
+		return
+	}
+	c.Header("Content-Type", service.ExportContentType(job.Format))
+	c.FileAttachment(job.FilePath, job.FileName)
+}
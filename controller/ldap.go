@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/oauth"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// LDAPLogin 使用 LDAP / AD 账号登录。首次登录时按配置即时创建用户，每次登录按目录组同步分组与角色
+func LDAPLogin(c *gin.Context) {
+	provider, ok := oauth.GetProvider("ldap").(oauth.PasswordProvider)
+	if !ok {
+		common.ApiErrorI18n(c, i18n.MsgOAuthUnknownProvider)
+		return
+	}
+	if !provider.IsEnabled() {
+		common.ApiErrorI18n(c, i18n.MsgOAuthNotEnabled, providerParams(provider.GetName()))
+		return
+	}
+	var loginRequest LoginRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&loginRequest); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	oauthUser, err := provider.Authenticate(c.Request.Context(), loginRequest.Username, loginRequest.Password)
+	if err != nil {
+		handleOAuthError(c, err)
+		return
+	}
+	if !provider.IsUserIDTaken(oauthUser.ProviderUserID) && !provider.AutoCreateUser() {
+		common.ApiErrorI18n(c, i18n.MsgLDAPUserNotProvisioned)
+		return
+	}
+	user, err := findOrCreateOAuthUser(c, provider, oauthUser, sessions.Default(c))
+	if err != nil {
+		handleFindOrCreateUserError(c, err)
+		return
+	}
+	if user.Status != common.UserStatusEnabled {
+		common.ApiErrorI18n(c, i18n.MsgOAuthUserBanned)
+		return
+	}
+	groups, _ := oauthUser.Extra["groups"].([]string)
+	if err := syncLDAPUserMapping(user, groups); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	setupLoginWith2FA(user, c)
+}
+
+// syncLDAPUserMapping 按目录组映射同步用户分组与角色。
+// 分组只在匹配到映射时更新；配置了角色映射时角色以目录为准，未匹配到的用户恢复为普通用户，超级管理员不受影响。
+// 映射指向不存在的分组或角色时记录日志并跳过，不影响登录
+func syncLDAPUserMapping(user *model.User, groups []string) error {
+	settings := system_setting.GetLDAPSettings()
+	if group, ok := oauth.MatchLDAPGroupMapping(settings.GroupMapping, groups); ok && group != "" && group != user.Group {
+		if _, exists := ratio_setting.GetGroupRatioCopy()[group]; !exists {
+			common.SysError(fmt.Sprintf("[LDAP] group mapping for user %d points to unknown group %s", user.Id, group))
+		} else {
+			if err := model.DB.Model(&model.User{}).Where("id = ?", user.Id).Update("group", group).Error; err != nil {
+				return err
+			}
+			_ = model.UpdateUserGroupCache(user.Id, group)
+		}
+	}
+	if len(settings.RoleMapping) > 0 && user.Role < common.RoleRootUser {
+		role, ok := oauth.MatchLDAPGroupMapping(settings.RoleMapping, groups)
+		if !ok {
+			role = model.BuiltinRoleUser
+		}
+		if err := model.AssignUserRole(user.Id, role); err != nil {
+			common.SysError(fmt.Sprintf("[LDAP] failed to assign role %s to user %d: %s", role, user.Id, err.Error()))
+		}
+	}
+	// 重新读取用户，使 session 中的分组与角色与同步后的一致
+	return user.FillUserById()
+}
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func validateManagementKey(key *model.ManagementKey) error {
+	if len(key.Name) > 64 {
+		return fmt.Errorf("名称长度不能超过 64")
+	}
+	scopes, invalidScope := model.NormalizePermissions(key.Scopes)
+	if invalidScope != "" {
+		return fmt.Errorf("无效的权限范围：%s", invalidScope)
+	}
+	if scopes == "" {
+		return fmt.Errorf("至少需要授予一项权限范围")
+	}
+	key.Scopes = scopes
+	for _, ip := range key.GetIpLimits() {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return fmt.Errorf("无效的 IP 或 CIDR：%s", ip)
+			}
+		}
+	}
+	key.AllowIps = strings.Join(key.GetIpLimits(), "\n")
+	if key.ExpiredTime == 0 {
+		key.ExpiredTime = -1
+	}
+	if key.ExpiredTime != -1 && key.ExpiredTime <= common.GetTimestamp() {
+		return fmt.Errorf("过期时间必须晚于当前时间")
+	}
+	return nil
+}
+
+func GetManagementKeys(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	keys, total, err := model.GetUserManagementKeys(c.GetInt("id"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(keys)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetManagementScopes(c *gin.Context) {
+	common.ApiSuccess(c, model.Permissions)
+}
+
+// AddManagementKey 创建管理 API 密钥，密钥明文只在创建时返回一次
+func AddManagementKey(c *gin.Context) {
+	var key model.ManagementKey
+	if err := c.ShouldBindJSON(&key); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateManagementKey(&key); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	secret, err := common.GenerateKey()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean := model.ManagementKey{
+		UserId:      c.GetInt("id"),
+		Name:        key.Name,
+		Scopes:      key.Scopes,
+		AllowIps:    key.AllowIps,
+		Status:      model.ManagementKeyStatusEnabled,
+		ExpiredTime: key.ExpiredTime,
+		CreatedTime: common.GetTimestamp(),
+	}
+	clean.SetSecret(secret)
+	if err := clean.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"id":  clean.Id,
+		"key": model.ManagementKeyPrefix + secret,
+	})
+}
+
+func UpdateManagementKey(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	var key model.ManagementKey
+	if err := c.ShouldBindJSON(&key); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean, err := model.GetManagementKeyByIds(key.Id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if statusOnly != "" {
+		if key.Status != model.ManagementKeyStatusEnabled && key.Status != model.ManagementKeyStatusDisabled {
+			common.ApiErrorMsg(c, "无效的状态")
+			return
+		}
+		clean.Status = key.Status
+	} else {
+		if err := validateManagementKey(&key); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		// 如有新增可修改的字段，请同步更新 ManagementKey.Update()
+		clean.Name = key.Name
+		clean.Scopes = key.Scopes
+		clean.AllowIps = key.AllowIps
+		clean.ExpiredTime = key.ExpiredTime
+	}
+	if err := clean.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, clean)
+}
+
+func DeleteManagementKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteManagementKeyById(id, c.GetInt("id")); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetManagementKeyAudits 查询管理 API 密钥的调用记录
+func GetManagementKeyAudits(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	audits, total, err := model.GetManagementKeyAudits(id, c.GetInt("id"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(audits)
+	common.ApiSuccess(c, pageInfo)
+}
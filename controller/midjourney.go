@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
 
 	"github.com/gin-gonic/gin"
@@ -177,21 +178,24 @@ func UpdateMidjourneyTaskBulk() {
 				if err != nil {
 					logger.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
 				} else if won && shouldReturnQuota {
-					err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
+					err = service.AdjustWalletQuota(task.UserId, -task.Quota)
 					if err != nil {
 						logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
+					modelName := service.CovertMjpActionToModelName(task.Action)
+					modelPrice, _ := ratio_setting.GetModelPrice(modelName, false)
 					model.RecordTaskBillingLog(model.RecordTaskBillingLogParams{
 						UserId:    task.UserId,
 						LogType:   model.LogTypeRefund,
 						Content:   "",
 						ChannelId: task.ChannelId,
-						ModelName: service.CovertMjpActionToModelName(task.Action),
+						ModelName: modelName,
 						Quota:     task.Quota,
 						Other: map[string]interface{}{
 							"task_id": task.MjId,
 							"reason":  "构图失败",
 						},
+						UpstreamCost: model.CalcChannelUpstreamCost(task.ChannelId, modelName, model.UpstreamUsage{ModelPrice: modelPrice}),
 					})
 				}
 			}
@@ -105,6 +105,7 @@ func GetStatus(c *gin.Context) {
 		"oidc_enabled":                system_setting.GetOIDCSettings().Enabled,
 		"oidc_client_id":              system_setting.GetOIDCSettings().ClientId,
 		"oidc_authorization_endpoint": system_setting.GetOIDCSettings().AuthorizationEndpoint,
+		"ldap_enabled":                system_setting.GetLDAPSettings().Enabled,
 		"passkey_login":               passkeySetting.Enabled,
 		"passkey_display_name":        passkeySetting.RPDisplayName,
 		"passkey_rp_id":               passkeySetting.RPID,
@@ -158,6 +159,30 @@ func GetStatus(c *gin.Context) {
 		data["custom_oauth_providers"] = providersInfo
 	}
 
+	// Add enabled SAML providers, login starts at login_url with the state from /api/oauth/state
+	samlProviders := oauth.GetEnabledSAMLProviders()
+	if len(samlProviders) > 0 {
+		type SAMLInfo struct {
+			Id       int    `json:"id"`
+			Name     string `json:"name"`
+			Slug     string `json:"slug"`
+			Icon     string `json:"icon"`
+			LoginUrl string `json:"login_url"`
+		}
+		providersInfo := make([]SAMLInfo, 0, len(samlProviders))
+		for _, p := range samlProviders {
+			config := p.GetConfig()
+			providersInfo = append(providersInfo, SAMLInfo{
+				Id:       config.Id,
+				Name:     config.Name,
+				Slug:     config.Slug,
+				Icon:     config.Icon,
+				LoginUrl: "/api/saml/" + config.Slug + "/login",
+			})
+		}
+		data["saml_providers"] = providersInfo
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -101,6 +101,7 @@ func CreateModelMeta(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	model.RecordAdminAudit(c, "model.create", "models", m.Id, nil, &m)
 	model.RefreshPricing()
 	common.ApiSuccess(c, &m)
 }
@@ -118,6 +119,8 @@ func UpdateModelMeta(c *gin.Context) {
 		common.ApiErrorMsg(c, "缺少模型 ID")
 		return
 	}
+	var origin model.Model
+	model.DB.First(&origin, m.Id)
 
 	if statusOnly {
 		// 只更新状态，防止误清空其他字段
@@ -140,6 +143,10 @@ func UpdateModelMeta(c *gin.Context) {
 			return
 		}
 	}
+	var current model.Model
+	if err := model.DB.First(&current, m.Id).Error; err == nil {
+		model.RecordAdminAudit(c, "model.update", "models", m.Id, &origin, &current)
+	}
 	model.RefreshPricing()
 	common.ApiSuccess(c, &m)
 }
@@ -152,10 +159,15 @@ func DeleteModelMeta(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	var origin model.Model
+	model.DB.First(&origin, id)
 	if err := model.DB.Delete(&model.Model{}, id).Error; err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	if origin.Id != 0 {
+		model.RecordAdminAudit(c, "model.delete", "models", id, &origin, nil)
+	}
 	model.RefreshPricing()
 	common.ApiSuccess(c, nil)
 }
@@ -106,14 +106,7 @@ func HandleOAuth(c *gin.Context) {
 	// 7. Find or create user
 	user, err := findOrCreateOAuthUser(c, provider, oauthUser, session)
 	if err != nil {
-		switch err.(type) {
-		case *OAuthUserDeletedError:
-			common.ApiErrorI18n(c, i18n.MsgOAuthUserDeleted)
-		case *OAuthRegistrationDisabledError:
-			common.ApiErrorI18n(c, i18n.MsgUserRegisterDisabled)
-		default:
-			common.ApiError(c, err)
-		}
+		handleFindOrCreateUserError(c, err)
 		return
 	}
 
@@ -173,9 +166,9 @@ func handleOAuthBind(c *gin.Context, provider oauth.Provider) {
 	}
 
 	// Handle binding based on provider type
-	if genericProvider, ok := provider.(*oauth.GenericOAuthProvider); ok {
+	if bindingProvider, ok := provider.(oauth.BindingProvider); ok {
 		// Custom provider: use user_oauth_bindings table
-		err = model.UpdateUserOAuthBinding(user.Id, genericProvider.GetProviderId(), oauthUser.ProviderUserID)
+		err = model.UpdateUserOAuthBinding(user.Id, bindingProvider.GetProviderId(), oauthUser.ProviderUserID)
 		if err != nil {
 			common.ApiError(c, err)
 			return
@@ -230,8 +223,9 @@ func findOrCreateOAuthUser(c *gin.Context, provider oauth.Provider, oauthUser *o
 		}
 	}
 
-	// User doesn't exist, create new user if registration is enabled
-	if !common.RegisterEnabled {
+	// User doesn't exist, create new user if registration is enabled.
+	// Providers with auto-create enabled (LDAP, SAML) provision users regardless
+	if !common.RegisterEnabled && !autoCreatesUser(provider) {
 		return nil, &OAuthRegistrationDisabledError{}
 	}
 
@@ -268,7 +262,7 @@ func findOrCreateOAuthUser(c *gin.Context, provider oauth.Provider, oauthUser *o
 	}
 
 	// Use transaction to ensure user creation and OAuth binding are atomic
-	if genericProvider, ok := provider.(*oauth.GenericOAuthProvider); ok {
+	if bindingProvider, ok := provider.(oauth.BindingProvider); ok {
 		// Custom provider: create user and binding in a transaction
 		err := model.DB.Transaction(func(tx *gorm.DB) error {
 			// Create user
@@ -279,7 +273,7 @@ func findOrCreateOAuthUser(c *gin.Context, provider oauth.Provider, oauthUser *o
 			// Create OAuth binding
 			binding := &model.UserOAuthBinding{
 				UserId:         user.Id,
-				ProviderId:     genericProvider.GetProviderId(),
+				ProviderId:     bindingProvider.GetProviderId(),
 				ProviderUserId: oauthUser.ProviderUserID,
 			}
 			if err := model.CreateUserOAuthBindingWithTx(tx, binding); err != nil {
@@ -311,6 +305,7 @@ func findOrCreateOAuthUser(c *gin.Context, provider oauth.Provider, oauthUser *o
 				"linux_do_id": user.LinuxDOId,
 				"wechat_id":   user.WeChatId,
 				"telegram_id": user.TelegramId,
+				"ldap_id":     user.LdapId,
 			}).Error; err != nil {
 				return err
 			}
@@ -328,6 +323,23 @@ func findOrCreateOAuthUser(c *gin.Context, provider oauth.Provider, oauthUser *o
 	return user, nil
 }
 
+// autoCreatesUser reports whether the provider provisions unknown users even when registration is disabled
+func autoCreatesUser(provider oauth.Provider) bool {
+	autoCreateProvider, ok := provider.(oauth.AutoCreateProvider)
+	return ok && autoCreateProvider.AutoCreateUser()
+}
+
+func handleFindOrCreateUserError(c *gin.Context, err error) {
+	switch err.(type) {
+	case *OAuthUserDeletedError:
+		common.ApiErrorI18n(c, i18n.MsgOAuthUserDeleted)
+	case *OAuthRegistrationDisabledError:
+		common.ApiErrorI18n(c, i18n.MsgUserRegisterDisabled)
+	default:
+		common.ApiError(c, err)
+	}
+}
+
 // Error types for OAuth
 type OAuthUserDeletedError struct{}
 
@@ -25,7 +25,8 @@ func GetOptions(c *gin.Context) {
 			strings.HasSuffix(k, "Secret") ||
 			strings.HasSuffix(k, "Key") ||
 			strings.HasSuffix(k, "secret") ||
-			strings.HasSuffix(k, "api_key") {
+			strings.HasSuffix(k, "api_key") ||
+			strings.HasSuffix(k, "password") {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -242,11 +243,16 @@ func UpdateOption(c *gin.Context) {
 			return
 		}
 	}
+	common.OptionMapRWMutex.RLock()
+	originValue := common.OptionMap[option.Key]
+	common.OptionMapRWMutex.RUnlock()
 	err = model.UpdateOption(option.Key, option.Value.(string))
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	model.RecordAdminAudit(c, "option.update", "options", option.Key,
+		gin.H{"key": option.Key, "value": originValue}, gin.H{"key": option.Key, "value": option.Value})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,571 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/payment"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// paymentProviderForMethod 根据订单记录的支付方式找到对应的支付平台；
+// 易支付订单记录的是 alipay / wxpay 等子类型
+func paymentProviderForMethod(method string) payment.Provider {
+	if provider := payment.GetProvider(method); provider != nil {
+		return provider
+	}
+	if operation_setting.ContainsPayMethod(method) {
+		return payment.GetProvider(payment.ProviderEpay)
+	}
+	return nil
+}
+
+// orderPaymentMethod 订单上记录的支付方式，易支付保留子类型以兼容历史数据
+func orderPaymentMethod(provider payment.Provider, method string) string {
+	if provider.Name() == payment.ProviderEpay && method != "" {
+		return method
+	}
+	return provider.Name()
+}
+
+// PaymentWebhook 通用支付回调入口 /api/payment/:provider/webhook
+func PaymentWebhook(c *gin.Context) {
+	handlePaymentWebhook(c, c.Param("provider"))
+}
+
+// handlePaymentWebhook 验签并解析回调事件，逐个处理后按平台要求响应
+func handlePaymentWebhook(c *gin.Context, providerName string) {
+	provider := payment.GetProvider(providerName)
+	if provider == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	events, err := provider.ParseWebhook(c)
+	if err != nil {
+		log.Printf("%s 支付回调解析失败: %v", providerName, err)
+	} else {
+		for _, event := range events {
+			if err = processPaymentEvent(provider, event); err != nil {
+				log.Printf("%s 支付回调处理失败: %v, 事件: %s, 订单号: %s, 订阅: %s",
+					providerName, err, event.Type, event.TradeNo, event.SubscriptionId)
+				break
+			}
+		}
+	}
+	provider.WriteWebhookResponse(c, err)
+}
+
+// processPaymentEvent 处理统一支付事件，充值和订阅订单共用
+func processPaymentEvent(provider payment.Provider, event *payment.Event) error {
+	var err error
+	switch event.Type {
+	case payment.EventPaid:
+		return completePaymentOrder(provider, event)
+	case payment.EventExpired:
+		return expirePaymentOrder(event.TradeNo)
+	case payment.EventRenewed:
+		tradeNo := event.TradeNo
+		if tradeNo == "" {
+			tradeNo = event.PaymentRef
+		}
+		err = model.RenewRecurringSubscription(provider.Name(), event.SubscriptionId, tradeNo,
+			event.Money, event.PeriodEnd, event.Payload)
+		if err == nil {
+			model.SetPaymentProviderTradeNo(tradeNo, event.PaymentRef)
+		}
+	case payment.EventRefunded, payment.EventDisputed:
+		return refundPaymentOrderByEvent(event)
+	case payment.EventRenewalFailed:
+		var sub *model.UserSubscription
+		var firstFailure bool
+		sub, firstFailure, err = model.MarkRecurringSubscriptionPastDue(provider.Name(), event.SubscriptionId)
+		if err == nil && firstFailure {
+			service.NotifySubscriptionRenewalFailed(sub)
+		}
+	case payment.EventSubscriptionUpdated:
+		err = model.SetRecurringSubscriptionCancelAtPeriodEnd(provider.Name(), event.SubscriptionId, event.CancelAtPeriodEnd)
+	case payment.EventSubscriptionEnded:
+		err = model.EndRecurringSubscription(provider.Name(), event.SubscriptionId)
+	}
+	// 非本系统创建的平台订阅，忽略
+	if errors.Is(err, model.ErrRecurringSubscriptionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// completePaymentOrder 支付成功：优先按订阅订单处理，找不到时按充值订单处理
+func completePaymentOrder(provider payment.Provider, event *payment.Event) error {
+	if event.TradeNo == "" {
+		return errors.New("未提供支付单号")
+	}
+	// 订单只能由创建时选择的支付平台完成，避免用一个平台的有效回调完成另一个平台的订单
+	method, err := model.GetPaymentOrderMethod(event.TradeNo)
+	if err != nil {
+		return err
+	}
+	if orderProvider := paymentProviderForMethod(method); orderProvider == nil || orderProvider.Name() != provider.Name() {
+		return fmt.Errorf("订单支付方式 %s 与回调平台 %s 不一致", method, provider.Name())
+	}
+	LockOrder(event.TradeNo)
+	defer UnlockOrder(event.TradeNo)
+
+	err = model.CompleteSubscriptionOrder(event.TradeNo, event.Payload)
+	if err == nil {
+		// 订阅模式的首期支付，绑定平台订阅对象以便后续自动续费
+		if event.SubscriptionId != "" {
+			if bindErr := model.BindSubscriptionOrderRecurring(event.TradeNo, provider.Name(), event.SubscriptionId); bindErr != nil {
+				log.Printf("绑定%s订阅失败: %s, 订单号: %s", provider.Name(), bindErr.Error(), event.TradeNo)
+			}
+		}
+	} else if errors.Is(err, model.ErrSubscriptionOrderNotFound) {
+		err = provider.FulfillTopUp(event)
+	}
+	if err != nil {
+		return err
+	}
+	model.SetPaymentProviderTradeNo(event.TradeNo, event.PaymentRef)
+	log.Printf("收到款项：%s, %s, %.2f", provider.Name(), event.TradeNo, event.Money)
+	return nil
+}
+
+// refundPaymentOrderByEvent 支付平台退款或拒付通知：撤销订单并扣回额度
+func refundPaymentOrderByEvent(event *payment.Event) error {
+	tradeNo := model.FindPaymentOrderTradeNo(event.TradeNo, event.PaymentRef)
+	if tradeNo == "" {
+		log.Printf("退款通知未找到对应订单: %s, %s", event.TradeNo, event.PaymentRef)
+		return nil
+	}
+	source := model.RefundSourceProvider
+	if event.Type == payment.EventDisputed {
+		source = model.RefundSourceDispute
+	}
+	LockOrder(tradeNo)
+	defer UnlockOrder(tradeNo)
+	result, err := model.RefundPaymentOrder(tradeNo, source, event.Reason)
+	if err != nil {
+		if errors.Is(err, model.ErrPaymentOrderNotFound) {
+			return nil
+		}
+		return err
+	}
+	cancelRefundedSubscription(result)
+	return nil
+}
+
+// cancelRefundedSubscription 订单退款后停止平台侧的自动续费，避免继续扣款
+func cancelRefundedSubscription(result *model.PaymentRefundResult) {
+	if result.AlreadyRefunded || result.ProviderSubscriptionId == "" {
+		return
+	}
+	provider := payment.GetProvider(result.PaymentProvider)
+	if provider == nil {
+		return
+	}
+	if err := provider.CancelSubscription(result.ProviderSubscriptionId); err != nil {
+		log.Printf("退款后取消%s订阅失败: %v, 订阅: %s", provider.Name(), err, result.ProviderSubscriptionId)
+	}
+}
+
+func expirePaymentOrder(tradeNo string) error {
+	if tradeNo == "" {
+		return errors.New("未提供支付单号")
+	}
+	LockOrder(tradeNo)
+	defer UnlockOrder(tradeNo)
+	err := model.ExpireSubscriptionOrder(tradeNo)
+	if err == nil || !errors.Is(err, model.ErrSubscriptionOrderNotFound) {
+		return err
+	}
+	return model.ExpireTopUp(tradeNo)
+}
+
+// createAmountTopUp 按充值数量计价创建充值订单（易支付、人工转账等按金额收款的平台）
+// promoCode 为空时按原价下单。
+func createAmountTopUp(userId int, provider payment.Provider, method string, amount int64, promoCode string) (*model.TopUp, *payment.CheckoutResult, error) {
+	if amount < getMinTopup() {
+		return nil, nil, fmt.Errorf("充值数量不能小于 %d", getMinTopup())
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		return nil, nil, errors.New("获取用户信息失败")
+	}
+	group, err := model.GetUserGroup(userId, true)
+	if err != nil {
+		return nil, nil, errors.New("获取用户分组失败")
+	}
+	payMoney := getPayMoney(amount, group)
+	if payMoney < 0.01 {
+		return nil, nil, errors.New("充值金额过低")
+	}
+	promo, err := quotePromoCode(userId, promoCode, model.PromoCodeScopeTopUp, 0, payMoney)
+	if err != nil {
+		return nil, nil, err
+	}
+	if promo != nil {
+		payMoney = promo.PayMoney
+	}
+
+	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
+	tradeNo = fmt.Sprintf("USR%dNO%s", userId, tradeNo)
+	if err := reservePromoCode(promo, userId, 0, tradeNo); err != nil {
+		return nil, nil, err
+	}
+	result, err := provider.CreateCheckout(&payment.CheckoutRequest{
+		Kind:    payment.OrderKindTopUp,
+		TradeNo: tradeNo,
+		Title:   fmt.Sprintf("TUC%d", amount),
+		Money:   payMoney,
+		Units:   amount,
+		Method:  method,
+		User:    user,
+	})
+	if err != nil {
+		log.Printf("%s 拉起支付失败: %v", provider.Name(), err)
+		model.ReleasePromoCode(tradeNo)
+		return nil, nil, err
+	}
+
+	if operation_setting.GetQuotaDisplayType() == operation_setting.QuotaDisplayTypeTokens {
+		dAmount := decimal.NewFromInt(amount)
+		dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+		amount = dAmount.Div(dQuotaPerUnit).IntPart()
+	}
+	topUp := &model.TopUp{
+		UserId:        userId,
+		Amount:        amount,
+		Money:         payMoney,
+		TradeNo:       tradeNo,
+		PaymentMethod: orderPaymentMethod(provider, method),
+		CreateTime:    time.Now().Unix(),
+		Status:        common.TopUpStatusPending,
+	}
+	if err := topUp.Insert(); err != nil {
+		model.ReleasePromoCode(tradeNo)
+		return nil, nil, errors.New("创建订单失败")
+	}
+	return topUp, result, nil
+}
+
+type PaymentTopUpRequest struct {
+	Amount        int64  `json:"amount"`
+	PaymentMethod string `json:"payment_method"`
+	PromoCode     string `json:"promo_code"`
+}
+
+// RequestPaymentTopUp 通用充值下单 /api/user/pay/:provider，适用于按充值数量计价的支付平台
+func RequestPaymentTopUp(c *gin.Context) {
+	var req PaymentTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	provider := payment.GetProvider(c.Param("provider"))
+	if provider == nil || !provider.IsEnabled() {
+		common.ApiErrorMsg(c, "支付方式不存在")
+		return
+	}
+	// Stripe 和 Creem 按平台商品计价，使用各自的下单接口
+	if provider.Name() == payment.ProviderStripe || provider.Name() == payment.ProviderCreem {
+		common.ApiErrorMsg(c, "请使用对应的支付接口")
+		return
+	}
+	topUp, result, err := createAmountTopUp(c.GetInt("id"), provider, req.PaymentMethod, req.Amount, req.PromoCode)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"trade_no":     topUp.TradeNo,
+		"money":        topUp.Money,
+		"url":          result.Link(),
+		"instructions": result.Instructions,
+	})
+}
+
+// checkSubscriptionPurchasable 校验套餐可购买及用户购买上限
+func checkSubscriptionPurchasable(userId int, planId int) (*model.SubscriptionPlan, error) {
+	plan, err := model.GetSubscriptionPlanById(planId)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Enabled {
+		return nil, errors.New("套餐未启用")
+	}
+	if plan.MaxPurchasePerUser > 0 {
+		count, err := model.CountUserSubscriptionsByPlan(userId, plan.Id)
+		if err != nil {
+			return nil, err
+		}
+		if count >= int64(plan.MaxPurchasePerUser) {
+			return nil, errors.New("已达到该套餐购买上限")
+		}
+	}
+	return plan, nil
+}
+
+// createSubscriptionCheckout 先创建待支付的订阅订单，再到支付平台下单；下单失败时关闭订单。
+// promo 不为空时按优惠后金额下单并占用优惠码名额。
+func createSubscriptionCheckout(provider payment.Provider, order *model.SubscriptionOrder, req *payment.CheckoutRequest, promo *model.PromoQuote) (*payment.CheckoutResult, error) {
+	if promo != nil {
+		if err := reservePromoCode(promo, order.UserId, order.PlanId, order.TradeNo); err != nil {
+			return nil, err
+		}
+		order.Money = promo.PayMoney
+		req.DiscountPercent = promo.DiscountPercent()
+		req.PromoCode = promo.Code
+	}
+	if err := order.Insert(); err != nil {
+		model.ReleasePromoCode(order.TradeNo)
+		return nil, errors.New("创建订单失败")
+	}
+	req.Kind = payment.OrderKindSubscription
+	req.TradeNo = order.TradeNo
+	req.Money = order.Money
+	result, err := provider.CreateCheckout(req)
+	if err != nil {
+		log.Printf("%s 拉起订阅支付失败: %v, 订单号: %s", provider.Name(), err, order.TradeNo)
+		_ = model.ExpireSubscriptionOrder(order.TradeNo)
+		return nil, errors.New("拉起支付失败")
+	}
+	return result, nil
+}
+
+type SubscriptionPayRequest struct {
+	PlanId        int    `json:"plan_id"`
+	PaymentMethod string `json:"payment_method"`
+	PromoCode     string `json:"promo_code"`
+}
+
+// SubscriptionRequestPay 通用订阅下单 /api/subscription/pay/:provider，按套餐价格一次性收款
+func SubscriptionRequestPay(c *gin.Context) {
+	var req SubscriptionPayRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.PlanId <= 0 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	provider := payment.GetProvider(c.Param("provider"))
+	if provider == nil || !provider.IsEnabled() {
+		common.ApiErrorMsg(c, "支付方式不存在")
+		return
+	}
+	if provider.Name() == payment.ProviderStripe || provider.Name() == payment.ProviderCreem {
+		common.ApiErrorMsg(c, "请使用对应的支付接口")
+		return
+	}
+	userId := c.GetInt("id")
+	plan, err := checkSubscriptionPurchasable(userId, req.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if plan.PriceAmount < 0.01 {
+		common.ApiErrorMsg(c, "套餐金额过低")
+		return
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	promo, err := quotePromoCode(userId, req.PromoCode, model.PromoCodeScopeSubscription, plan.Id, plan.PriceAmount)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
+	tradeNo = fmt.Sprintf("SUBUSR%dNO%s", userId, tradeNo)
+	order := &model.SubscriptionOrder{
+		UserId:        userId,
+		PlanId:        plan.Id,
+		Money:         plan.PriceAmount,
+		TradeNo:       tradeNo,
+		PaymentMethod: orderPaymentMethod(provider, req.PaymentMethod),
+		CreateTime:    time.Now().Unix(),
+		Status:        common.TopUpStatusPending,
+	}
+	result, err := createSubscriptionCheckout(provider, order, &payment.CheckoutRequest{
+		Title:  fmt.Sprintf("SUB:%s", plan.Title),
+		Method: req.PaymentMethod,
+		User:   user,
+	}, promo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"trade_no":     tradeNo,
+		"money":        order.Money,
+		"url":          result.Link(),
+		"instructions": result.Instructions,
+	})
+}
+
+type AdminManualPaymentRequest struct {
+	TradeNo string `json:"trade_no"`
+}
+
+// getManualPaymentOrder 查找人工转账订单（充值或订阅），返回订单金额
+func getManualPaymentOrder(tradeNo string) (money float64, err error) {
+	if topUp := model.GetTopUpByTradeNo(tradeNo); topUp != nil {
+		if topUp.PaymentMethod != payment.ProviderManual {
+			return 0, errors.New("该订单不是人工转账订单")
+		}
+		return topUp.Money, nil
+	}
+	if order := model.GetSubscriptionOrderByTradeNo(tradeNo); order != nil {
+		if order.PaymentMethod != payment.ProviderManual {
+			return 0, errors.New("该订单不是人工转账订单")
+		}
+		return order.Money, nil
+	}
+	return 0, errors.New("订单不存在")
+}
+
+// AdminConfirmManualPayment 管理员确认人工转账已到账，完成订单
+func AdminConfirmManualPayment(c *gin.Context) {
+	var req AdminManualPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	money, err := getManualPaymentOrder(req.TradeNo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	event := &payment.Event{
+		Type:    payment.EventPaid,
+		TradeNo: req.TradeNo,
+		Money:   money,
+		Payload: common.GetJsonString(gin.H{"confirmed_by": c.GetInt("id"), "confirmed_at": common.GetTimestamp()}),
+	}
+	if err := processPaymentEvent(payment.GetProvider(payment.ProviderManual), event); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// AdminRejectManualPayment 管理员驳回人工转账订单（未收到款项）
+func AdminRejectManualPayment(c *gin.Context) {
+	var req AdminManualPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	if _, err := getManualPaymentOrder(req.TradeNo); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := expirePaymentOrder(req.TradeNo); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// AdminQueryPaymentStatus 向支付平台查询订单的实际支付状态，用于排查掉单
+func AdminQueryPaymentStatus(c *gin.Context) {
+	tradeNo := c.Query("trade_no")
+	if tradeNo == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	var method, localStatus, providerTradeNo string
+	if topUp := model.GetTopUpByTradeNo(tradeNo); topUp != nil {
+		method, localStatus, providerTradeNo = topUp.PaymentMethod, topUp.Status, topUp.ProviderTradeNo
+	} else if order := model.GetSubscriptionOrderByTradeNo(tradeNo); order != nil {
+		method, localStatus, providerTradeNo = order.PaymentMethod, order.Status, order.ProviderTradeNo
+	} else {
+		common.ApiErrorMsg(c, "订单不存在")
+		return
+	}
+	provider := paymentProviderForMethod(method)
+	if provider == nil {
+		common.ApiErrorMsg(c, "不支持的支付平台")
+		return
+	}
+	status, err := provider.QueryStatus(tradeNo, providerTradeNo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"trade_no":          tradeNo,
+		"provider":          provider.Name(),
+		"provider_trade_no": providerTradeNo,
+		"local_status":      localStatus,
+		"provider_status":   status,
+	})
+}
+
+type AdminRefundPaymentRequest struct {
+	TradeNo     string `json:"trade_no"`
+	Reason      string `json:"reason"`
+	ViaProvider bool   `json:"via_provider"` // 是否同时通过支付平台原路退款
+}
+
+// AdminRefundPayment 管理员发起退款：撤销订单、扣回额度并作废订阅
+func AdminRefundPayment(c *gin.Context) {
+	var req AdminRefundPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	LockOrder(req.TradeNo)
+	defer UnlockOrder(req.TradeNo)
+
+	var method, status, providerTradeNo string
+	var money float64
+	// 订阅订单同时写入了充值记录，需优先按订阅订单处理
+	if order := model.GetSubscriptionOrderByTradeNo(req.TradeNo); order != nil {
+		method, status, providerTradeNo, money = order.PaymentMethod, order.Status, order.ProviderTradeNo, order.Money
+	} else if topUp := model.GetTopUpByTradeNo(req.TradeNo); topUp != nil {
+		method, status, providerTradeNo, money = topUp.PaymentMethod, topUp.Status, topUp.ProviderTradeNo, topUp.Money
+	} else {
+		common.ApiErrorMsg(c, "订单不存在")
+		return
+	}
+	if status != common.TopUpStatusSuccess && status != common.TopUpStatusRefunded {
+		common.ApiErrorMsg(c, "订单未支付，无法退款")
+		return
+	}
+	if req.ViaProvider && status == common.TopUpStatusSuccess {
+		provider := paymentProviderForMethod(method)
+		if provider == nil {
+			common.ApiErrorMsg(c, "不支持的支付平台")
+			return
+		}
+		err := provider.Refund(&payment.RefundRequest{
+			TradeNo:    req.TradeNo,
+			PaymentRef: providerTradeNo,
+			Money:      money,
+			Reason:     req.Reason,
+		})
+		if errors.Is(err, payment.ErrNotSupported) {
+			common.ApiErrorMsg(c, fmt.Sprintf("%s 不支持接口退款，请在支付平台后台退款后再标记为已退款", provider.Name()))
+			return
+		}
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	result, err := model.RefundPaymentOrder(req.TradeNo, model.RefundSourceAdmin, req.Reason)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	cancelRefundedSubscription(result)
+	common.ApiSuccess(c, result)
+}
@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/payment"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/thanhpk/randstr"
+)
+
+// CreatePostpaidPaymentLink 为后付费账单创建充值订单和支付链接。
+// 订单完成后由充值回调通过 trade_no 将账单标记为已支付。
+func CreatePostpaidPaymentLink(statement *model.PostpaidStatement, paymentMethod string) (string, error) {
+	if statement == nil || statement.Status != model.PostpaidStatementStatusUnpaid || statement.AmountDue <= 0 {
+		return "", errors.New("账单无需支付")
+	}
+	// 同一支付方式的订单仍未完成时复用原链接，避免重复下单导致账单无法对账
+	if statement.TradeNo != "" && statement.PaymentLink != "" && statement.PaymentMethod == paymentMethod {
+		if topUp := model.GetTopUpByTradeNo(statement.TradeNo); topUp != nil && topUp.Status == common.TopUpStatusPending {
+			return statement.PaymentLink, nil
+		}
+	}
+	user, err := model.GetUserById(statement.UserId, false)
+	if err != nil {
+		return "", err
+	}
+	// Stripe/Creem 按产品单位计价，只能收取整数个充值单位，向上取整；
+	// 其他支付方式按分向上取整收款。无论实付多少，回调入账都只补回账单应付额度
+	units := int64(math.Ceil(float64(statement.AmountDue) / common.QuotaPerUnit))
+	if units < 1 {
+		units = 1
+	}
+	money := float64(units)
+
+	var (
+		tradeNo string
+		link    string
+		topUp   *model.TopUp
+	)
+	var req *payment.CheckoutRequest
+	switch paymentMethod {
+	case PaymentMethodStripe:
+		reference := fmt.Sprintf("new-api-postpaid-%d-%d-%s", statement.Id, time.Now().UnixMilli(), randstr.String(4))
+		tradeNo = "ref_" + common.Sha1([]byte(reference))
+		req = &payment.CheckoutRequest{Units: units}
+		topUp = &model.TopUp{Amount: units, Money: float64(units)}
+	case PaymentMethodCreem:
+		productId := operation_setting.GetPostpaidSetting().CreemProductId
+		if productId == "" {
+			return "", errors.New("未配置后付费账单的Creem产品")
+		}
+		tradeNo = fmt.Sprintf("ref_%d_%d_%s", statement.Id, time.Now().UnixMilli(), randstr.String(6))
+		quota := units * int64(common.QuotaPerUnit)
+		req = &payment.CheckoutRequest{
+			Title:     fmt.Sprintf("Postpaid statement #%d", statement.Id),
+			ProductId: productId,
+			Units:     units,
+			Metadata:  map[string]string{"quota": fmt.Sprintf("%d", quota)},
+		}
+		// Creem 回调直接把 Amount 作为充值额度
+		topUp = &model.TopUp{Amount: quota, Money: float64(units)}
+	default:
+		if !operation_setting.ContainsPayMethod(paymentMethod) {
+			return "", errors.New("支付方式不存在")
+		}
+		tradeNo = fmt.Sprintf("USR%dNO%s%d", user.Id, common.GetRandomString(6), time.Now().Unix())
+		req = &payment.CheckoutRequest{
+			Title:      fmt.Sprintf("BILL%d", statement.Id),
+			Method:     paymentMethod,
+			SuccessURL: system_setting.ServerAddress + "/console/topup",
+		}
+		money = math.Ceil(float64(statement.AmountDue)/common.QuotaPerUnit*100) / 100
+		if money < 0.01 {
+			money = 0.01
+		}
+		topUp = &model.TopUp{Amount: units, Money: money}
+	}
+	provider := paymentProviderForMethod(paymentMethod)
+	if provider == nil {
+		return "", errors.New("支付方式不存在")
+	}
+	req.Kind = payment.OrderKindTopUp
+	req.TradeNo = tradeNo
+	req.Money = money
+	req.User = user
+	result, err := provider.CreateCheckout(req)
+	if err != nil {
+		return "", err
+	}
+	link = result.Link()
+
+	topUp.UserId = user.Id
+	topUp.TradeNo = tradeNo
+	topUp.PaymentMethod = paymentMethod
+	topUp.CreateTime = time.Now().Unix()
+	topUp.Status = common.TopUpStatusPending
+	if err := topUp.Insert(); err != nil {
+		return "", err
+	}
+	if err := model.UpdatePostpaidStatementPayment(statement.Id, paymentMethod, tradeNo, link); err != nil {
+		return "", err
+	}
+	return link, nil
+}
+
+// GetSelfPostpaid 获取当前用户的后付费账户和账单
+func GetSelfPostpaid(c *gin.Context) {
+	getPostpaid(c, c.GetInt("id"))
+}
+
+// GetUserPostpaid 管理员获取用户的后付费账户和账单
+func GetUserPostpaid(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userId <= 0 {
+		common.ApiErrorMsg(c, "无效的用户ID")
+		return
+	}
+	getPostpaid(c, userId)
+}
+
+func getPostpaid(c *gin.Context, userId int) {
+	account, err := model.GetPostpaidAccountByUserId(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	statements, total, err := model.GetUserPostpaidStatements(userId, pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(statements)
+	common.ApiSuccess(c, gin.H{
+		"enabled":    operation_setting.IsPostpaidEnabled(),
+		"account":    account,
+		"statements": pageInfo,
+	})
+}
+
+type PostpaidPayRequest struct {
+	PaymentMethod string `json:"payment_method"`
+}
+
+// RequestPostpaidStatementPay 用户为自己的未付账单发起支付
+func RequestPostpaidStatementPay(c *gin.Context) {
+	statementId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || statementId <= 0 {
+		common.ApiErrorMsg(c, "无效的账单ID")
+		return
+	}
+	var req PostpaidPayRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.PaymentMethod == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	statement, err := model.GetPostpaidStatementById(statementId)
+	if err != nil || statement.UserId != c.GetInt("id") {
+		common.ApiErrorMsg(c, "账单不存在")
+		return
+	}
+	link, err := CreatePostpaidPaymentLink(statement, req.PaymentMethod)
+	if err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("create postpaid payment link failed (statement=%d): %s", statementId, err.Error()))
+		common.ApiErrorMsg(c, "拉起支付失败")
+		return
+	}
+	common.ApiSuccess(c, gin.H{"pay_link": link})
+}
+
+type UpdatePostpaidAccountRequest struct {
+	Enabled      bool   `json:"enabled"`
+	CreditLimit  *int64 `json:"credit_limit"`
+	BillingCycle string `json:"billing_cycle"`
+	Remark       string `json:"remark"`
+}
+
+// UpdateUserPostpaid 管理员开通、调整或关闭用户的后付费账户
+func UpdateUserPostpaid(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userId <= 0 {
+		common.ApiErrorMsg(c, "无效的用户ID")
+		return
+	}
+	var req UpdatePostpaidAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	setting := operation_setting.GetPostpaidSetting()
+	creditLimit := int64(setting.DefaultCreditLimit)
+	if req.CreditLimit != nil {
+		creditLimit = *req.CreditLimit
+	}
+	cycle := req.BillingCycle
+	if cycle == "" {
+		cycle = setting.DefaultBillingCycle
+	}
+	account, err := model.UpsertPostpaidAccount(userId, req.Enabled, creditLimit, cycle, req.Remark)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(userId, model.LogTypeManage, fmt.Sprintf("管理员更新后付费账户：启用 %t，信用额度 %s，账期 %s",
+		account.Enabled, logger.LogQuota(int(account.CreditLimit)), account.BillingCycle))
+	common.ApiSuccess(c, account)
+}
+
+// AdminMarkPostpaidStatementPaid 管理员确认线下收款
+func AdminMarkPostpaidStatementPaid(c *gin.Context) {
+	statementId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || statementId <= 0 {
+		common.ApiErrorMsg(c, "无效的账单ID")
+		return
+	}
+	statement, err := model.AdminMarkPostpaidStatementPaid(statementId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(statement.UserId, model.LogTypeManage, fmt.Sprintf("管理员确认后付费账单线下收款，账单ID %d，额度 %s",
+		statement.Id, logger.LogQuota(int(statement.AmountDue))))
+	common.ApiSuccess(c, statement)
+}
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetPromoCodes(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	promos, total, err := model.GetPromoCodes(c.Query("keyword"), c.Query("campaign"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(promos)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetPromoCode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	promo, err := model.GetPromoCodeById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, promo)
+}
+
+func AddPromoCode(c *gin.Context) {
+	var promo model.PromoCode
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if promo.Code == "" {
+		promo.Code = common.GetRandomString(10)
+	}
+	if err := promo.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean := model.PromoCode{
+		Code:         promo.Code,
+		Name:         promo.Name,
+		Campaign:     promo.Campaign,
+		Type:         promo.Type,
+		Percent:      promo.Percent,
+		Scope:        promo.Scope,
+		PlanIds:      promo.PlanIds,
+		UserGroups:   promo.UserGroups,
+		MinMoney:     promo.MinMoney,
+		PerUserLimit: promo.PerUserLimit,
+		TotalLimit:   promo.TotalLimit,
+		StartTime:    promo.StartTime,
+		EndTime:      promo.EndTime,
+		Status:       model.PromoCodeStatusEnabled,
+		CreatedBy:    c.GetInt("id"),
+	}
+	if err := clean.Insert(); err != nil {
+		common.SysError("failed to insert promo code: " + err.Error())
+		common.ApiErrorMsg(c, "创建优惠码失败，优惠码可能已存在")
+		return
+	}
+	common.ApiSuccess(c, clean)
+}
+
+func UpdatePromoCode(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	var promo model.PromoCode
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean, err := model.GetPromoCodeById(promo.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if statusOnly != "" {
+		if promo.Status != model.PromoCodeStatusEnabled && promo.Status != model.PromoCodeStatusDisabled {
+			common.ApiErrorMsg(c, "无效的状态")
+			return
+		}
+		clean.Status = promo.Status
+	} else {
+		// 优惠码本身不可修改，其余字段如有新增请同步更新 PromoCode.Update()
+		promo.Code = clean.Code
+		promo.Status = clean.Status
+		if err := promo.Validate(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		clean.Name = promo.Name
+		clean.Campaign = promo.Campaign
+		clean.Type = promo.Type
+		clean.Percent = promo.Percent
+		clean.Scope = promo.Scope
+		clean.PlanIds = promo.PlanIds
+		clean.UserGroups = promo.UserGroups
+		clean.MinMoney = promo.MinMoney
+		clean.PerUserLimit = promo.PerUserLimit
+		clean.TotalLimit = promo.TotalLimit
+		clean.StartTime = promo.StartTime
+		clean.EndTime = promo.EndTime
+	}
+	if err := clean.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, clean)
+}
+
+func DeletePromoCode(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeletePromoCodeById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetPromoCodeStats 按活动统计优惠码的使用次数、优惠金额和赠送额度
+func GetPromoCodeStats(c *gin.Context) {
+	startTime, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	stats, err := model.GetPromoCodeStats(c.Query("campaign"), startTime, endTime)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, stats)
+}
+
+type PromoCodeCheckRequest struct {
+	Code          string `json:"code"`
+	PaymentMethod string `json:"payment_method"`
+	Amount        int64  `json:"amount"`  // 充值数量
+	PlanId        int    `json:"plan_id"` // 订阅套餐，非空时按订阅订单试算
+}
+
+// CheckPromoCode 下单前试算优惠码，返回优惠后的支付金额
+func CheckPromoCode(c *gin.Context) {
+	var req PromoCodeCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	userId := c.GetInt("id")
+	var quote *model.PromoQuote
+	var err error
+	if req.PlanId > 0 {
+		plan, planErr := model.GetSubscriptionPlanById(req.PlanId)
+		if planErr != nil {
+			common.ApiError(c, planErr)
+			return
+		}
+		quote, err = quotePromoCode(userId, req.Code, model.PromoCodeScopeSubscription, plan.Id, plan.PriceAmount)
+	} else {
+		group, groupErr := model.GetUserGroup(userId, true)
+		if groupErr != nil {
+			common.ApiErrorMsg(c, "获取用户分组失败")
+			return
+		}
+		money := getPayMoney(req.Amount, group)
+		if req.PaymentMethod == PaymentMethodStripe {
+			money = getStripePayMoney(float64(req.Amount), group)
+		}
+		quote, err = quotePromoCode(userId, req.Code, model.PromoCodeScopeTopUp, 0, money)
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, quote)
+}
+
+// quotePromoCode 按用户当前分组试算优惠码，code 为空时返回 nil
+func quotePromoCode(userId int, code string, scope string, planId int, money float64) (*model.PromoQuote, error) {
+	if model.NormalizePromoCode(code) == "" {
+		return nil, nil
+	}
+	group, err := model.GetUserGroup(userId, true)
+	if err != nil {
+		return nil, errors.New("获取用户分组失败")
+	}
+	return model.QuotePromoCode(code, userId, group, scope, planId, money)
+}
+
+// reservePromoCode 为订单占用优惠码名额，quote 为 nil 时不做处理
+func reservePromoCode(quote *model.PromoQuote, userId int, planId int, tradeNo string) error {
+	if quote == nil {
+		return nil
+	}
+	group, err := model.GetUserGroup(userId, true)
+	if err != nil {
+		return errors.New("获取用户分组失败")
+	}
+	return model.ReservePromoCode(quote, userId, group, planId, tradeNo)
+}
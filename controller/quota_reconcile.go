@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RunQuotaReconcile 管理员发起一次额度对账，可选择立即修正差异
+func RunQuotaReconcile(c *gin.Context) {
+	var opts model.QuotaReconcileOptions
+	if err := c.ShouldBindJSON(&opts); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	opts.OperatorId = c.GetInt("id")
+	run, err := model.RunQuotaReconcile(opts)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, run)
+}
+
+func GetQuotaReconcileRuns(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	runs, total, err := model.GetQuotaReconcileRuns(pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(runs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetQuotaReconcileRun 查看对账结果和差异明细
+func GetQuotaReconcileRun(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	run, err := model.GetQuotaReconcileRunById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	items, total, err := model.GetQuotaReconcileItems(id, c.Query("entity_type"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(items)
+	common.ApiSuccess(c, gin.H{
+		"run":   run,
+		"items": pageInfo,
+	})
+}
+
+type ApplyQuotaReconcileRequest struct {
+	ItemIds         []int `json:"item_ids"` // 为空时修正全部未修正的差异
+	AdjustUserQuota bool  `json:"adjust_user_quota"`
+}
+
+// ApplyQuotaReconcile 修正对账发现的差异
+func ApplyQuotaReconcile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req ApplyQuotaReconcileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetQuotaReconcileRunById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	applied, err := model.ApplyQuotaReconcileItems(id, req.ItemIds, req.AdjustUserQuota, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"applied": applied})
+}
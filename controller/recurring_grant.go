@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetRecurringGrants(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	grants, total, err := model.GetRecurringGrants(pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(grants)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetRecurringGrant(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	grant, err := model.GetRecurringGrantById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, grant)
+}
+
+func AddRecurringGrant(c *gin.Context) {
+	var grant model.RecurringGrant
+	if err := c.ShouldBindJSON(&grant); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := grant.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean := model.RecurringGrant{
+		Name:        grant.Name,
+		Quota:       grant.Quota,
+		TargetType:  grant.TargetType,
+		TargetGroup: grant.TargetGroup,
+		UserIds:     grant.UserIds,
+		Schedule:    grant.Schedule,
+		ExpireDays:  grant.ExpireDays,
+		Mode:        grant.Mode,
+		Status:      model.RecurringGrantStatusEnabled,
+		NextRunTime: grant.NextRunTime,
+		CreatedBy:   c.GetInt("id"),
+	}
+	if err := clean.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, clean)
+}
+
+func UpdateRecurringGrant(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	var grant model.RecurringGrant
+	if err := c.ShouldBindJSON(&grant); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean, err := model.GetRecurringGrantById(grant.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	scheduleChanged := false
+	if statusOnly != "" {
+		if grant.Status != model.RecurringGrantStatusEnabled && grant.Status != model.RecurringGrantStatusDisabled {
+			common.ApiErrorMsg(c, "无效的状态")
+			return
+		}
+		clean.Status = grant.Status
+	} else {
+		grant.Status = clean.Status
+		if err := grant.Validate(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		scheduleChanged = grant.Schedule != clean.Schedule
+		clean.Name = grant.Name
+		clean.Quota = grant.Quota
+		clean.TargetType = grant.TargetType
+		clean.TargetGroup = grant.TargetGroup
+		clean.UserIds = grant.UserIds
+		clean.Schedule = grant.Schedule
+		clean.ExpireDays = grant.ExpireDays
+		clean.Mode = grant.Mode
+	}
+	if err := clean.Update(scheduleChanged); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, clean)
+}
+
+func DeleteRecurringGrant(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteRecurringGrantById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetRecurringGrantRecords 管理员查看定期赠送发放记录，可按规则或用户筛选
+func GetRecurringGrantRecords(c *gin.Context) {
+	grantId, _ := strconv.Atoi(c.Param("id"))
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getRecurringGrantRecords(c, grantId, userId)
+}
+
+// GetSelfRecurringGrantRecords 用户查看自己收到的定期赠送
+func GetSelfRecurringGrantRecords(c *gin.Context) {
+	getRecurringGrantRecords(c, 0, c.GetInt("id"))
+}
+
+func getRecurringGrantRecords(c *gin.Context, grantId int, userId int) {
+	pageInfo := common.GetPageQuery(c)
+	records, total, err := model.GetRecurringGrantRecords(grantId, userId, pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(records)
+	common.ApiSuccess(c, pageInfo)
+}
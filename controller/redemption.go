@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"unicode/utf8"
@@ -8,13 +9,21 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/i18n"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
 
 func GetAllRedemptions(c *gin.Context) {
 	pageInfo := common.GetPageQuery(c)
-	redemptions, total, err := model.GetAllRedemptions(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	var redemptions []*model.Redemption
+	var total int64
+	var err error
+	if campaignId, _ := strconv.Atoi(c.Query("campaign_id")); campaignId > 0 {
+		redemptions, total, err = model.GetRedemptionsByCampaign(campaignId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	} else {
+		redemptions, total, err = model.GetAllRedemptions(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	}
 	if err != nil {
 		common.ApiError(c, err)
 		return
@@ -185,3 +194,189 @@ func validateExpiredTime(c *gin.Context, expired int64) (bool, string) {
 	}
 	return true, ""
 }
+
+func GetRedemptionCampaigns(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	campaigns, total, err := model.GetRedemptionCampaigns(c.Query("keyword"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(campaigns)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetRedemptionCampaign(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, campaign)
+}
+
+type RedemptionCampaignRequest struct {
+	model.RedemptionCampaign
+	Count int `json:"count"` // 创建活动时同时生成的兑换码数量
+}
+
+// AddRedemptionCampaign 创建兑换码活动，可同时批量生成兑换码
+func AddRedemptionCampaign(c *gin.Context) {
+	var req RedemptionCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if valid, msg := validateExpiredTime(c, req.ExpiredTime); !valid {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": msg})
+		return
+	}
+	if req.Count < 0 || req.Count > model.RedemptionCampaignMaxBatch {
+		common.ApiErrorMsg(c, fmt.Sprintf("生成数量必须在0-%d之间", model.RedemptionCampaignMaxBatch))
+		return
+	}
+	campaign := model.RedemptionCampaign{
+		Name:          req.Name,
+		Partner:       req.Partner,
+		Description:   req.Description,
+		GrantType:     req.GrantType,
+		Quota:         req.Quota,
+		PlanId:        req.PlanId,
+		UpgradeGroup:  req.UpgradeGroup,
+		AllowedGroups: req.AllowedGroups,
+		ExpiredTime:   req.ExpiredTime,
+		Status:        common.RedemptionCodeStatusEnabled,
+		CreatedBy:     c.GetInt("id"),
+	}
+	if err := campaign.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := campaign.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var keys []string
+	if req.Count > 0 {
+		var err error
+		keys, err = model.GenerateCampaignRedemptions(&campaign, req.Count, c.GetInt("id"))
+		if err != nil {
+			common.SysError("failed to generate campaign redemptions: " + err.Error())
+			common.ApiErrorMsg(c, i18n.T(c, i18n.MsgRedemptionCreateFailed))
+			return
+		}
+	}
+	common.ApiSuccess(c, gin.H{"campaign": campaign, "keys": keys})
+}
+
+// UpdateRedemptionCampaign 更新活动名称、合作方、有效期和可用分组，发放内容不可修改
+func UpdateRedemptionCampaign(c *gin.Context) {
+	var req model.RedemptionCampaign
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	campaign, err := model.GetRedemptionCampaignById(req.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if valid, msg := validateExpiredTime(c, req.ExpiredTime); !valid {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": msg})
+		return
+	}
+	// If you add more fields, please also update RedemptionCampaign.Update()
+	campaign.Name = req.Name
+	campaign.Partner = req.Partner
+	campaign.Description = req.Description
+	campaign.AllowedGroups = req.AllowedGroups
+	campaign.ExpiredTime = req.ExpiredTime
+	if err := campaign.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := campaign.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, campaign)
+}
+
+type GenerateRedemptionsRequest struct {
+	Count int `json:"count"`
+}
+
+// GenerateCampaignRedemptions 为已有活动追加生成兑换码
+func GenerateCampaignRedemptions(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req GenerateRedemptionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	keys, err := model.GenerateCampaignRedemptions(campaign, req.Count, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, keys)
+}
+
+// ExportCampaignRedemptions 以 CSV 导出活动下未使用的兑换码
+func ExportCampaignRedemptions(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	redemptions, err := model.GetCampaignUnusedRedemptions(campaign.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=redemption-campaign-%d.csv", campaign.Id))
+	if err := service.WriteRedemptionCodesCSV(c.Writer, campaign, redemptions); err != nil {
+		common.SysError("failed to write redemption csv: " + err.Error())
+	}
+}
+
+// DisableRedemptionCampaign 停用活动并批量停用其下未使用的兑换码
+func DisableRedemptionCampaign(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if _, err := model.GetRedemptionCampaignById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	rows, err := model.DisableCampaignRedemptions(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, rows)
+}
+
+// GetRedemptionCampaignStats 活动兑换率及按天（granularity=hour 时按小时）汇总的兑换趋势
+func GetRedemptionCampaignStats(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	startTime, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	stats, err := model.GetRedemptionCampaignStats(id, c.Query("granularity"), startTime, endTime)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, stats)
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRoles 返回内置角色与自定义角色
+func GetRoles(c *gin.Context) {
+	roles, err := model.GetAllRoles()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, roles)
+}
+
+func GetPermissions(c *gin.Context) {
+	common.ApiSuccess(c, model.Permissions)
+}
+
+func AddRole(c *gin.Context) {
+	var role model.Role
+	if err := c.ShouldBindJSON(&role); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := role.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean := model.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+	}
+	if err := clean.Insert(); err != nil {
+		common.SysError("failed to insert role: " + err.Error())
+		common.ApiErrorMsg(c, "创建角色失败，角色名称可能已存在")
+		return
+	}
+	common.ApiSuccess(c, clean)
+}
+
+func UpdateRole(c *gin.Context) {
+	var role model.Role
+	if err := c.ShouldBindJSON(&role); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	clean, err := model.GetRoleById(role.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := role.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	// 如有新增可修改的字段，请同步更新 Role.Update()
+	clean.Name = role.Name
+	clean.Description = role.Description
+	clean.Permissions = role.Permissions
+	if err := clean.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, clean)
+}
+
+func DeleteRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteRoleById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+type AssignRoleRequest struct {
+	UserId int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// AssignRole 为用户分配内置角色（admin、user）或自定义角色
+func AssignRole(c *gin.Context) {
+	var req AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.UserId == 0 || req.Role == "" {
+		common.ApiErrorMsg(c, "用户和角色不能为空")
+		return
+	}
+	if err := model.AssignUserRole(req.UserId, req.Role); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
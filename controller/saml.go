@@ -0,0 +1,457 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/oauth"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// SAMLProviderResponse is the response structure for SAML providers
+// It excludes the SP private key
+type SAMLProviderResponse struct {
+	Id                  int    `json:"id"`
+	Name                string `json:"name"`
+	Slug                string `json:"slug"`
+	Icon                string `json:"icon"`
+	Enabled             bool   `json:"enabled"`
+	IdpMetadata         string `json:"idp_metadata"`
+	IdpEntityId         string `json:"idp_entity_id"`
+	IdpSsoUrl           string `json:"idp_sso_url"`
+	IdpCertificate      string `json:"idp_certificate"`
+	SpCertificate       string `json:"sp_certificate"`
+	NameIdFormat        string `json:"name_id_format"`
+	UserIdField         string `json:"user_id_field"`
+	UsernameField       string `json:"username_field"`
+	DisplayNameField    string `json:"display_name_field"`
+	EmailField          string `json:"email_field"`
+	AccessPolicy        string `json:"access_policy"`
+	AccessDeniedMessage string `json:"access_denied_message"`
+	AutoCreateUser      bool   `json:"auto_create_user"`
+	// SP endpoints to be configured in the IdP
+	MetadataUrl string `json:"metadata_url"`
+	AcsUrl      string `json:"acs_url"`
+}
+
+func toSAMLProviderResponse(p *model.CustomOAuthProvider) *SAMLProviderResponse {
+	samlProvider := oauth.NewSAMLProvider(p)
+	return &SAMLProviderResponse{
+		Id:                  p.Id,
+		Name:                p.Name,
+		Slug:                p.Slug,
+		Icon:                p.Icon,
+		Enabled:             p.Enabled,
+		IdpMetadata:         p.SamlIdpMetadata,
+		IdpEntityId:         p.SamlIdpEntityId,
+		IdpSsoUrl:           p.SamlIdpSsoUrl,
+		IdpCertificate:      p.SamlIdpCertificate,
+		SpCertificate:       p.SamlSpCertificate,
+		NameIdFormat:        p.SamlNameIdFormat,
+		UserIdField:         p.UserIdField,
+		UsernameField:       p.UsernameField,
+		DisplayNameField:    p.DisplayNameField,
+		EmailField:          p.EmailField,
+		AccessPolicy:        p.AccessPolicy,
+		AccessDeniedMessage: p.AccessDeniedMessage,
+		AutoCreateUser:      p.AutoCreateUser,
+		MetadataUrl:         samlProvider.MetadataURL(),
+		AcsUrl:              samlProvider.AcsURL(),
+	}
+}
+
+// getSAMLProviderById returns the SAML provider config by the id path parameter
+func getSAMLProviderById(c *gin.Context) (*model.CustomOAuthProvider, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "无效的 ID")
+		return nil, false
+	}
+	provider, err := model.GetCustomOAuthProviderById(id)
+	if err != nil || !provider.IsSAML() {
+		common.ApiErrorMsg(c, "未找到该 SAML 提供商")
+		return nil, false
+	}
+	return provider, true
+}
+
+// GetSAMLProviders returns all SAML providers
+func GetSAMLProviders(c *gin.Context) {
+	providers, err := model.GetCustomOAuthProvidersByProtocol(model.CustomOAuthProtocolSAML)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	response := make([]*SAMLProviderResponse, len(providers))
+	for i, p := range providers {
+		response[i] = toSAMLProviderResponse(p)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    response,
+	})
+}
+
+// GetSAMLProvider returns a single SAML provider by ID
+func GetSAMLProvider(c *gin.Context) {
+	provider, ok := getSAMLProviderById(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    toSAMLProviderResponse(provider),
+	})
+}
+
+// CreateSAMLProviderRequest is the request structure for creating a SAML provider.
+// Either idp_metadata or idp_entity_id, idp_sso_url and idp_certificate are required.
+// The SP key pair is generated when sp_certificate and sp_private_key are both empty
+type CreateSAMLProviderRequest struct {
+	Name                string `json:"name" binding:"required"`
+	Slug                string `json:"slug" binding:"required"`
+	Icon                string `json:"icon"`
+	Enabled             bool   `json:"enabled"`
+	IdpMetadata         string `json:"idp_metadata"`
+	IdpEntityId         string `json:"idp_entity_id"`
+	IdpSsoUrl           string `json:"idp_sso_url"`
+	IdpCertificate      string `json:"idp_certificate"`
+	SpCertificate       string `json:"sp_certificate"`
+	SpPrivateKey        string `json:"sp_private_key"`
+	NameIdFormat        string `json:"name_id_format"`
+	UserIdField         string `json:"user_id_field"`
+	UsernameField       string `json:"username_field"`
+	DisplayNameField    string `json:"display_name_field"`
+	EmailField          string `json:"email_field"`
+	AccessPolicy        string `json:"access_policy"`
+	AccessDeniedMessage string `json:"access_denied_message"`
+	AutoCreateUser      bool   `json:"auto_create_user"`
+}
+
+// setSAMLKeyPair sets the SP key pair, generating one when both are empty
+func setSAMLKeyPair(provider *model.CustomOAuthProvider, certificate string, privateKey string) error {
+	if certificate == "" && privateKey == "" {
+		generatedCert, generatedKey, err := oauth.GenerateSAMLKeyPair(provider.Slug)
+		if err != nil {
+			return err
+		}
+		certificate, privateKey = generatedCert, generatedKey
+	}
+	provider.SamlSpCertificate = certificate
+	provider.SamlSpPrivateKey = privateKey
+	return nil
+}
+
+// CreateSAMLProvider creates a new SAML provider
+func CreateSAMLProvider(c *gin.Context) {
+	var req CreateSAMLProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "无效的请求参数: "+err.Error())
+		return
+	}
+
+	// Check if slug is already taken
+	if model.IsSlugTaken(req.Slug, 0) {
+		common.ApiErrorMsg(c, "该 Slug 已被使用")
+		return
+	}
+
+	// Check if slug conflicts with built-in providers
+	if oauth.IsProviderRegistered(req.Slug) && !oauth.IsCustomProvider(req.Slug) {
+		common.ApiErrorMsg(c, "该 Slug 与内置 OAuth 提供商冲突")
+		return
+	}
+
+	provider := &model.CustomOAuthProvider{
+		Protocol:            model.CustomOAuthProtocolSAML,
+		Name:                req.Name,
+		Slug:                req.Slug,
+		Icon:                req.Icon,
+		Enabled:             req.Enabled,
+		SamlIdpMetadata:     req.IdpMetadata,
+		SamlIdpEntityId:     req.IdpEntityId,
+		SamlIdpSsoUrl:       req.IdpSsoUrl,
+		SamlIdpCertificate:  req.IdpCertificate,
+		SamlNameIdFormat:    req.NameIdFormat,
+		UserIdField:         req.UserIdField,
+		UsernameField:       req.UsernameField,
+		DisplayNameField:    req.DisplayNameField,
+		EmailField:          req.EmailField,
+		AccessPolicy:        req.AccessPolicy,
+		AccessDeniedMessage: req.AccessDeniedMessage,
+		AutoCreateUser:      req.AutoCreateUser,
+	}
+	if err := setSAMLKeyPair(provider, req.SpCertificate, req.SpPrivateKey); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := oauth.ValidateSAMLConfig(provider); err != nil {
+		common.ApiErrorMsg(c, "SAML 配置无效: "+err.Error())
+		return
+	}
+
+	if err := model.CreateCustomOAuthProvider(provider); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	// Register the provider in the OAuth registry
+	oauth.RegisterOrUpdateCustomProvider(provider)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "创建成功",
+		"data":    toSAMLProviderResponse(provider),
+	})
+}
+
+// UpdateSAMLProviderRequest is the request structure for updating a SAML provider
+type UpdateSAMLProviderRequest struct {
+	Name                string  `json:"name"`
+	Slug                string  `json:"slug"`
+	Icon                *string `json:"icon"`         // Optional: if nil, keep existing
+	Enabled             *bool   `json:"enabled"`      // Optional: if nil, keep existing
+	IdpMetadata         *string `json:"idp_metadata"` // Optional: if nil, keep existing; empty to configure the IdP manually
+	IdpEntityId         string  `json:"idp_entity_id"`
+	IdpSsoUrl           string  `json:"idp_sso_url"`
+	IdpCertificate      string  `json:"idp_certificate"`
+	SpCertificate       string  `json:"sp_certificate"`         // Optional: replaced together with sp_private_key
+	SpPrivateKey        string  `json:"sp_private_key"`         // Optional: if empty, keep existing
+	RegenerateSpKeyPair bool    `json:"regenerate_sp_key_pair"` // Generate a new SP key pair, the IdP must import the new metadata
+	NameIdFormat        *string `json:"name_id_format"`         // Optional: if nil, keep existing
+	UserIdField         string  `json:"user_id_field"`
+	UsernameField       string  `json:"username_field"`
+	DisplayNameField    string  `json:"display_name_field"`
+	EmailField          string  `json:"email_field"`
+	AccessPolicy        *string `json:"access_policy"`         // Optional: if nil, keep existing
+	AccessDeniedMessage *string `json:"access_denied_message"` // Optional: if nil, keep existing
+	AutoCreateUser      *bool   `json:"auto_create_user"`      // Optional: if nil, keep existing
+}
+
+// UpdateSAMLProvider updates an existing SAML provider
+func UpdateSAMLProvider(c *gin.Context) {
+	var req UpdateSAMLProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "无效的请求参数: "+err.Error())
+		return
+	}
+
+	provider, ok := getSAMLProviderById(c)
+	if !ok {
+		return
+	}
+
+	oldSlug := provider.Slug
+
+	// Check if new slug is taken by another provider
+	if req.Slug != "" && req.Slug != provider.Slug {
+		if model.IsSlugTaken(req.Slug, provider.Id) {
+			common.ApiErrorMsg(c, "该 Slug 已被使用")
+			return
+		}
+		// Check if slug conflicts with built-in providers
+		if oauth.IsProviderRegistered(req.Slug) && !oauth.IsCustomProvider(req.Slug) {
+			common.ApiErrorMsg(c, "该 Slug 与内置 OAuth 提供商冲突")
+			return
+		}
+	}
+
+	// Update fields
+	if req.Name != "" {
+		provider.Name = req.Name
+	}
+	if req.Slug != "" {
+		provider.Slug = req.Slug
+	}
+	if req.Icon != nil {
+		provider.Icon = *req.Icon
+	}
+	if req.Enabled != nil {
+		provider.Enabled = *req.Enabled
+	}
+	if req.IdpMetadata != nil {
+		provider.SamlIdpMetadata = *req.IdpMetadata
+	}
+	if req.IdpEntityId != "" {
+		provider.SamlIdpEntityId = req.IdpEntityId
+	}
+	if req.IdpSsoUrl != "" {
+		provider.SamlIdpSsoUrl = req.IdpSsoUrl
+	}
+	if req.IdpCertificate != "" {
+		provider.SamlIdpCertificate = req.IdpCertificate
+	}
+	if req.RegenerateSpKeyPair {
+		if err := setSAMLKeyPair(provider, "", ""); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	} else if req.SpPrivateKey != "" {
+		provider.SamlSpCertificate = req.SpCertificate
+		provider.SamlSpPrivateKey = req.SpPrivateKey
+	}
+	if req.NameIdFormat != nil {
+		provider.SamlNameIdFormat = *req.NameIdFormat
+	}
+	if req.UserIdField != "" {
+		provider.UserIdField = req.UserIdField
+	}
+	if req.UsernameField != "" {
+		provider.UsernameField = req.UsernameField
+	}
+	if req.DisplayNameField != "" {
+		provider.DisplayNameField = req.DisplayNameField
+	}
+	if req.EmailField != "" {
+		provider.EmailField = req.EmailField
+	}
+	if req.AccessPolicy != nil {
+		provider.AccessPolicy = *req.AccessPolicy
+	}
+	if req.AccessDeniedMessage != nil {
+		provider.AccessDeniedMessage = *req.AccessDeniedMessage
+	}
+	if req.AutoCreateUser != nil {
+		provider.AutoCreateUser = *req.AutoCreateUser
+	}
+
+	if err := oauth.ValidateSAMLConfig(provider); err != nil {
+		common.ApiErrorMsg(c, "SAML 配置无效: "+err.Error())
+		return
+	}
+	if err := model.UpdateCustomOAuthProvider(provider); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	// Update the provider in the OAuth registry
+	if oldSlug != provider.Slug {
+		oauth.UnregisterCustomProvider(oldSlug)
+	}
+	oauth.RegisterOrUpdateCustomProvider(provider)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "更新成功",
+		"data":    toSAMLProviderResponse(provider),
+	})
+}
+
+// DeleteSAMLProvider deletes a SAML provider
+func DeleteSAMLProvider(c *gin.Context) {
+	provider, ok := getSAMLProviderById(c)
+	if !ok {
+		return
+	}
+
+	// Check if there are any user bindings
+	count, err := model.GetBindingCountByProviderId(provider.Id)
+	if err != nil {
+		common.SysError("Failed to get binding count for provider " + strconv.Itoa(provider.Id) + ": " + err.Error())
+		common.ApiErrorMsg(c, "检查用户绑定时发生错误，请稍后重试")
+		return
+	}
+	if count > 0 {
+		common.ApiErrorMsg(c, "该 SAML 提供商还有用户绑定，无法删除。请先解除所有用户绑定。")
+		return
+	}
+
+	if err := model.DeleteCustomOAuthProvider(provider.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	// Unregister the provider from the OAuth registry
+	oauth.UnregisterCustomProvider(provider.Slug)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "删除成功",
+	})
+}
+
+// getSAMLProvider returns the registered SAML provider for the slug path parameter
+func getSAMLProvider(c *gin.Context) (*oauth.SAMLProvider, bool) {
+	provider, ok := oauth.GetProvider(c.Param("slug")).(*oauth.SAMLProvider)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": i18n.T(c, i18n.MsgOAuthUnknownProvider),
+		})
+		return nil, false
+	}
+	return provider, true
+}
+
+// SAMLMetadata serves the SP metadata to be imported into the IdP, also before the provider is enabled
+func SAMLMetadata(c *gin.Context) {
+	provider, ok := getSAMLProvider(c)
+	if !ok {
+		return
+	}
+	metadata, err := provider.Metadata()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAMLLogin redirects the browser to the IdP with a signed AuthnRequest.
+// The state must come from GenerateOAuthCode, it is checked again by the OAuth callback
+func SAMLLogin(c *gin.Context) {
+	provider, ok := getSAMLProvider(c)
+	if !ok {
+		return
+	}
+	if !provider.IsEnabled() {
+		common.ApiErrorI18n(c, i18n.MsgOAuthNotEnabled, providerParams(provider.GetName()))
+		return
+	}
+
+	session := sessions.Default(c)
+	state := c.Query("state")
+	if state == "" || session.Get("oauth_state") == nil || state != session.Get("oauth_state").(string) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": i18n.T(c, i18n.MsgOAuthStateInvalid),
+		})
+		return
+	}
+
+	redirectURL, err := provider.MakeAuthnRequestURL(c.Request.Context(), state)
+	if err != nil {
+		handleOAuthError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// SAMLAssertionConsumerService receives the IdP response and redirects to the frontend OAuth callback,
+// which completes login or binding through HandleOAuth
+func SAMLAssertionConsumerService(c *gin.Context) {
+	provider, ok := getSAMLProvider(c)
+	if !ok {
+		return
+	}
+	if !provider.IsEnabled() {
+		common.ApiErrorI18n(c, i18n.MsgOAuthNotEnabled, providerParams(provider.GetName()))
+		return
+	}
+
+	redirectURL, err := provider.HandleResponse(c.Request.Context(), c.Request)
+	if err != nil {
+		handleOAuthError(c, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, redirectURL)
+}
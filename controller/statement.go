@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/gin-gonic/gin"
+)
+
+func statementPeriodQuery(c *gin.Context) string {
+	period := c.Query("period")
+	if period == "" {
+		period = model.PreviousStatementPeriod(time.Now())
+	}
+	return period
+}
+
+// GetSelfStatement 获取当前用户的月度账单，format 支持 json / csv / html
+func GetSelfStatement(c *gin.Context) {
+	writeUserStatement(c, c.GetInt("id"))
+}
+
+// GetUserStatement 管理员获取指定用户的月度账单
+func GetUserStatement(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userId <= 0 {
+		common.ApiErrorMsg(c, "无效的用户ID")
+		return
+	}
+	writeUserStatement(c, userId)
+}
+
+func writeUserStatement(c *gin.Context, userId int) {
+	period := statementPeriodQuery(c)
+	detail, err := model.BuildUserStatement(userId, period)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=statement-%d-%s.csv", userId, period))
+		if err := service.WriteStatementCSV(c.Writer, detail); err != nil {
+			common.SysError("failed to write statement csv: " + err.Error())
+		}
+	case "html":
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := service.RenderStatementHTML(c.Writer, detail); err != nil {
+			common.SysError("failed to render statement html: " + err.Error())
+		}
+	default:
+		common.ApiSuccess(c, gin.H{
+			"statement": detail,
+			"totals":    service.CalcStatementTotals(detail),
+		})
+	}
+}
+
+// GetStatementUsage 管理员获取全平台按用户、模型、令牌汇总的月度消费明细
+func GetStatementUsage(c *gin.Context) {
+	period := statementPeriodQuery(c)
+	rows, err := model.GetStatementUsageRows(period)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=statement-usage-%s.csv", period))
+		if err := service.WriteStatementUsageCSV(c.Writer, rows); err != nil {
+			common.SysError("failed to write statement usage csv: " + err.Error())
+		}
+		return
+	}
+	common.ApiSuccess(c, rows)
+}
@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/payment"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -55,10 +57,24 @@ func GetSubscriptionSelf(c *gin.Context) {
 		activeSubscriptions = []model.SubscriptionSummary{}
 	}
 
+	// Allowance usage of active subscriptions, keyed by subscription id
+	allowances := make(map[int][]model.SubscriptionAllowanceStatus, len(activeSubscriptions))
+	for _, summary := range activeSubscriptions {
+		if summary.Subscription == nil {
+			continue
+		}
+		statuses, err := model.GetSubscriptionAllowanceStatuses(summary.Subscription)
+		if err != nil || len(statuses) == 0 {
+			continue
+		}
+		allowances[summary.Subscription.Id] = statuses
+	}
+
 	common.ApiSuccess(c, gin.H{
 		"billing_preference": pref,
 		"subscriptions":      activeSubscriptions, // all active subscriptions
 		"all_subscriptions":  allSubscriptions,    // all subscriptions including expired
+		"allowances":         allowances,
 	})
 }
 
@@ -86,6 +102,40 @@ func UpdateSubscriptionPreference(c *gin.Context) {
 	common.ApiSuccess(c, gin.H{"billing_preference": pref})
 }
 
+// CancelSubscriptionAutoRenew 取消自动续费：当前周期结束后不再扣款，已支付的周期仍然有效
+func CancelSubscriptionAutoRenew(c *gin.Context) {
+	subId, _ := strconv.Atoi(c.Param("id"))
+	if subId <= 0 {
+		common.ApiErrorMsg(c, "无效的订阅ID")
+		return
+	}
+	sub, err := model.GetUserRecurringSubscription(c.GetInt("id"), subId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub.CancelAtPeriodEnd {
+		common.ApiSuccess(c, nil)
+		return
+	}
+	provider := payment.GetProvider(sub.PaymentProvider)
+	if provider == nil {
+		common.ApiErrorMsg(c, "不支持的支付平台")
+		return
+	}
+	err = provider.CancelSubscription(sub.ProviderSubscriptionId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to cancel %s subscription %s: %s", sub.PaymentProvider, sub.ProviderSubscriptionId, err.Error()))
+		common.ApiErrorMsg(c, "取消自动续费失败，请稍后重试")
+		return
+	}
+	if err := model.SetRecurringSubscriptionCancelAtPeriodEnd(sub.PaymentProvider, sub.ProviderSubscriptionId, true); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
 // ---- Admin APIs ----
 
 func AdminListSubscriptionPlans(c *gin.Context) {
@@ -162,6 +212,7 @@ func AdminCreateSubscriptionPlan(c *gin.Context) {
 		return
 	}
 	model.InvalidateSubscriptionPlanCache(req.Plan.Id)
+	model.RecordAdminAudit(c, "subscription_plan.create", "billing", req.Plan.Id, nil, req.Plan)
 	common.ApiSuccess(c, req.Plan)
 }
 
@@ -219,8 +270,13 @@ func AdminUpdateSubscriptionPlan(c *gin.Context) {
 		common.ApiErrorMsg(c, "自定义重置周期需大于0秒")
 		return
 	}
+	originPlan, err := model.GetSubscriptionPlanById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 
-	err := model.DB.Transaction(func(tx *gorm.DB) error {
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
 		// update plan (allow zero values updates with map)
 		updateMap := map[string]interface{}{
 			"title":                      req.Plan.Title,
@@ -251,6 +307,9 @@ func AdminUpdateSubscriptionPlan(c *gin.Context) {
 		return
 	}
 	model.InvalidateSubscriptionPlanCache(id)
+	if plan, err := model.GetSubscriptionPlanById(id); err == nil {
+		model.RecordAdminAudit(c, "subscription_plan.update", "billing", id, originPlan, plan)
+	}
 	common.ApiSuccess(c, nil)
 }
 
@@ -269,11 +328,17 @@ func AdminUpdateSubscriptionPlanStatus(c *gin.Context) {
 		common.ApiErrorMsg(c, "参数错误")
 		return
 	}
+	originPlan, err := model.GetSubscriptionPlanById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	if err := model.DB.Model(&model.SubscriptionPlan{}).Where("id = ?", id).Update("enabled", *req.Enabled).Error; err != nil {
 		common.ApiError(c, err)
 		return
 	}
 	model.InvalidateSubscriptionPlanCache(id)
+	model.RecordAdminAudit(c, "subscription_plan.status", "billing", id, gin.H{"enabled": originPlan.Enabled}, gin.H{"enabled": *req.Enabled})
 	common.ApiSuccess(c, nil)
 }
 
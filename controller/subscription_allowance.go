@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+)
+
+// ---- Admin: plan allowance rules ----
+
+func AdminListSubscriptionAllowances(c *gin.Context) {
+	planId, _ := strconv.Atoi(c.Param("id"))
+	if planId <= 0 {
+		common.ApiErrorMsg(c, "无效的ID")
+		return
+	}
+	allowances, err := model.GetSubscriptionAllowancesByPlan(planId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, allowances)
+}
+
+func AdminCreateSubscriptionAllowance(c *gin.Context) {
+	planId, _ := strconv.Atoi(c.Param("id"))
+	if planId <= 0 {
+		common.ApiErrorMsg(c, "无效的ID")
+		return
+	}
+	var allowance model.SubscriptionAllowance
+	if err := c.ShouldBindJSON(&allowance); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	allowance.Id = 0
+	allowance.PlanId = planId
+	if err := model.CreateSubscriptionAllowance(&allowance); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, allowance)
+}
+
+func AdminUpdateSubscriptionAllowance(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id <= 0 {
+		common.ApiErrorMsg(c, "无效的ID")
+		return
+	}
+	existing, err := model.GetSubscriptionAllowanceById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var allowance model.SubscriptionAllowance
+	if err := c.ShouldBindJSON(&allowance); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	allowance.Id = id
+	allowance.PlanId = existing.PlanId
+	if err := model.UpdateSubscriptionAllowance(&allowance); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func AdminDeleteSubscriptionAllowance(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id <= 0 {
+		common.ApiErrorMsg(c, "无效的ID")
+		return
+	}
+	if err := model.DeleteSubscriptionAllowance(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/payment"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+)
+
+const subscriptionChangePayBalance = "balance"
+
+type SubscriptionChangeRequest struct {
+	SubscriptionId int    `json:"subscription_id"`
+	PlanId         int    `json:"plan_id"`
+	PaymentMethod  string `json:"payment_method"`
+}
+
+// GetSubscriptionChangeQuote 查询将订阅变更为目标套餐的补付/退回金额
+func GetSubscriptionChangeQuote(c *gin.Context) {
+	subId, _ := strconv.Atoi(c.Query("subscription_id"))
+	planId, _ := strconv.Atoi(c.Query("plan_id"))
+	if subId <= 0 || planId <= 0 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	quote, err := model.QuoteSubscriptionChange(c.GetInt("id"), subId, planId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, quote)
+}
+
+// ChangeSubscription 变更套餐：无需补付或选择余额支付时立即生效，否则通过易支付补付差价，支付成功后生效
+func ChangeSubscription(c *gin.Context) {
+	var req SubscriptionChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.SubscriptionId <= 0 || req.PlanId <= 0 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	userId := c.GetInt("id")
+	quote, err := model.QuoteSubscriptionChange(userId, req.SubscriptionId, req.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if quote.AmountDue <= 0 || req.PaymentMethod == subscriptionChangePayBalance {
+		quote, err = model.ChangeUserSubscriptionWithBalance(userId, req.SubscriptionId, req.PlanId)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		common.ApiSuccess(c, quote)
+		return
+	}
+	// Stripe / Creem 使用固定价格，无法按差价收款
+	if !operation_setting.ContainsPayMethod(req.PaymentMethod) {
+		common.ApiErrorMsg(c, "该支付方式不支持套餐变更补差价")
+		return
+	}
+	if quote.AmountDue < 0.01 {
+		common.ApiErrorMsg(c, "补付金额过低")
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	provider := payment.GetProvider(payment.ProviderEpay)
+	if !provider.IsEnabled() {
+		common.ApiErrorMsg(c, "当前管理员未配置支付信息")
+		return
+	}
+
+	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
+	tradeNo = fmt.Sprintf("SUBCHG%dNO%s", userId, tradeNo)
+
+	order := &model.SubscriptionOrder{
+		UserId:                   userId,
+		PlanId:                   plan.Id,
+		Money:                    quote.AmountDue,
+		TradeNo:                  tradeNo,
+		PaymentMethod:            req.PaymentMethod,
+		CreateTime:               time.Now().Unix(),
+		Status:                   common.TopUpStatusPending,
+		ChangeFromSubscriptionId: req.SubscriptionId,
+	}
+	result, err := createSubscriptionCheckout(provider, order, &payment.CheckoutRequest{
+		Title:  fmt.Sprintf("SUBCHG:%s", plan.Title),
+		Method: req.PaymentMethod,
+	}, nil)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success", "data": result.Params, "url": result.URL, "quote": quote})
+}
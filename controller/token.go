@@ -108,7 +108,7 @@ func GetTokenUsage(c *gin.Context) {
 	}
 	tokenKey := parts[1]
 
-	token, err := model.GetTokenByKey(strings.TrimPrefix(tokenKey, "sk-"), false)
+	token, err := model.GetTokenBySecret(strings.TrimPrefix(tokenKey, "sk-"))
 	if err != nil {
 		common.SysError("failed to get token by key: " + err.Error())
 		common.ApiErrorI18n(c, i18n.MsgTokenGetInfoFailed)
@@ -132,6 +132,8 @@ func GetTokenUsage(c *gin.Context) {
 			"unlimited_quota":      token.UnlimitedQuota,
 			"model_limits":         token.GetModelLimitsMap(),
 			"model_limits_enabled": token.ModelLimitsEnabled,
+			"scopes":               token.GetScopes(),
+			"labels":               token.GetLabels(),
 			"expires_at":           expiredAt,
 		},
 	})
@@ -148,6 +150,22 @@ func AddToken(c *gin.Context) {
 		common.ApiErrorI18n(c, i18n.MsgTokenNameTooLong)
 		return
 	}
+	scopes, invalidScope := model.NormalizeTokenScopes(token.Scopes)
+	if invalidScope != "" {
+		common.ApiErrorI18n(c, i18n.MsgTokenScopeInvalid, map[string]any{"Scope": invalidScope})
+		return
+	}
+	token.Scopes = scopes
+	labels, invalidLabel := model.NormalizeTokenLabels(token.Labels)
+	if invalidLabel != "" {
+		common.ApiErrorI18n(c, i18n.MsgTokenLabelInvalid, map[string]any{"Label": invalidLabel})
+		return
+	}
+	token.Labels = labels
+	if token.RpmLimit < 0 || token.TpmLimit < 0 || token.ConcurrencyLimit < 0 {
+		common.ApiErrorI18n(c, i18n.MsgTokenRateLimitNegative)
+		return
+	}
 	// 非无限额度时，检查额度值是否超出有效范围
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
@@ -183,7 +201,6 @@ func AddToken(c *gin.Context) {
 	cleanToken := model.Token{
 		UserId:             c.GetInt("id"),
 		Name:               token.Name,
-		Key:                key,
 		CreatedTime:        common.GetTimestamp(),
 		AccessedTime:       common.GetTimestamp(),
 		ExpiredTime:        token.ExpiredTime,
@@ -194,15 +211,27 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		Scopes:             token.Scopes,
+		RpmLimit:           token.RpmLimit,
+		TpmLimit:           token.TpmLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		Labels:             token.Labels,
+		AllowRequestLabels: token.AllowRequestLabels,
 	}
+	cleanToken.SetSecret(key)
 	err = cleanToken.Insert()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	// 令牌明文只在创建时返回一次
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
+		"data": gin.H{
+			"id":  cleanToken.Id,
+			"key": "sk-" + key,
+		},
 	})
 	return
 }
@@ -235,6 +264,22 @@ func UpdateToken(c *gin.Context) {
 		common.ApiErrorI18n(c, i18n.MsgTokenNameTooLong)
 		return
 	}
+	scopes, invalidScope := model.NormalizeTokenScopes(token.Scopes)
+	if invalidScope != "" {
+		common.ApiErrorI18n(c, i18n.MsgTokenScopeInvalid, map[string]any{"Scope": invalidScope})
+		return
+	}
+	token.Scopes = scopes
+	labels, invalidLabel := model.NormalizeTokenLabels(token.Labels)
+	if invalidLabel != "" {
+		common.ApiErrorI18n(c, i18n.MsgTokenLabelInvalid, map[string]any{"Label": invalidLabel})
+		return
+	}
+	token.Labels = labels
+	if token.RpmLimit < 0 || token.TpmLimit < 0 || token.ConcurrencyLimit < 0 {
+		common.ApiErrorI18n(c, i18n.MsgTokenRateLimitNegative)
+		return
+	}
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
 			common.ApiErrorI18n(c, i18n.MsgTokenQuotaNegative)
@@ -274,6 +319,12 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.Scopes = token.Scopes
+		cleanToken.RpmLimit = token.RpmLimit
+		cleanToken.TpmLimit = token.TpmLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		cleanToken.Labels = token.Labels
+		cleanToken.AllowRequestLabels = token.AllowRequestLabels
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -287,6 +338,32 @@ func UpdateToken(c *gin.Context) {
 	})
 }
 
+// RotateToken 为令牌生成新的 key，旧 key 在宽限期内仍然有效
+func RotateToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	token, err := model.GetTokenByIds(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	secret, err := token.Rotate(operation_setting.GetTokenRotationGraceSeconds())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	// 新的令牌明文只在轮换时返回一次
+	common.ApiSuccess(c, gin.H{
+		"id":                        token.Id,
+		"key":                       "sk-" + secret,
+		"key_prefix":                token.KeyPrefix,
+		"previous_key_expired_time": token.PreviousKeyExpiredTime,
+	})
+}
+
 type TokenBatch struct {
 	Ids []int `json:"ids"`
 }
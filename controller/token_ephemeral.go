@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 未指定有效期时临时令牌的默认有效期（分钟）
+const defaultEphemeralTTLMinutes = 10
+
+func ephemeralTokenError(c *gin.Context, statusCode int, err error) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: common.MessageWithRequestId(err.Error(), c.GetString(common.RequestIdKey)),
+			Type:    "new_api_error",
+			Code:    string(types.ErrorCodeInvalidRequest),
+		},
+	})
+}
+
+// CreateEphemeralToken 由持有普通令牌的服务端为浏览器、移动端签发短期临时令牌，用量计入当前令牌
+func CreateEphemeralToken(c *gin.Context) {
+	if common.GetContextKeyBool(c, constant.ContextKeyTokenEphemeral) {
+		ephemeralTokenError(c, http.StatusForbidden, errors.New("临时令牌不能签发新的临时令牌"))
+		return
+	}
+	if !model.EphemeralTokenEnabled() {
+		ephemeralTokenError(c, http.StatusForbidden, model.ErrEphemeralTokenDisabled)
+		return
+	}
+	var req model.EphemeralTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ephemeralTokenError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.TTLMinutes == 0 {
+		req.TTLMinutes = min(defaultEphemeralTTLMinutes, operation_setting.GetEphemeralMaxTTLMinutes())
+	}
+	parent, err := model.GetTokenById(c.GetInt("token_id"))
+	if err != nil {
+		ephemeralTokenError(c, http.StatusInternalServerError, err)
+		return
+	}
+	key, claims, err := model.MintEphemeralToken(parent, req, operation_setting.GetEphemeralMaxTTLMinutes())
+	if err != nil {
+		ephemeralTokenError(c, http.StatusBadRequest, err)
+		return
+	}
+	scopes := make([]string, 0)
+	if claims.Scopes != "" {
+		scopes = strings.Split(claims.Scopes, ",")
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":     "ephemeral_token",
+		"value":      key,
+		"expires_at": claims.ExpiresAt,
+		"models":     claims.Models,
+		"scopes":     scopes,
+		"quota":      claims.Quota,
+	})
+}
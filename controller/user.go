@@ -59,6 +59,11 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	setupLoginWith2FA(&user, c)
+}
+
+// setupLoginWith2FA 用户启用了 2FA 时先设置待验证的 session，否则直接登录
+func setupLoginWith2FA(user *model.User, c *gin.Context) {
 	// 检查是否启用2FA
 	if model.IsTwoFAEnabled(user.Id) {
 		// 设置pending session，等待2FA验证
@@ -81,7 +86,7 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	setupLogin(&user, c)
+	setupLogin(user, c)
 }
 
 // setup session & cookies and then return user info
@@ -202,7 +207,6 @@ func Register(c *gin.Context) {
 		token := model.Token{
 			UserId:             insertedUser.Id, // 使用插入后的用户ID
 			Name:               cleanUser.Username + "的初始令牌",
-			Key:                key,
 			CreatedTime:        common.GetTimestamp(),
 			AccessedTime:       common.GetTimestamp(),
 			ExpiredTime:        -1,     // 永不过期
@@ -210,6 +214,7 @@ func Register(c *gin.Context) {
 			UnlimitedQuota:     true,
 			ModelLimitsEnabled: false,
 		}
+		token.SetSecret(key)
 		if setting.DefaultUseAutoGroup {
 			token.Group = "auto"
 		}
@@ -575,6 +580,9 @@ func UpdateUser(c *gin.Context) {
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", logger.LogQuota(originUser.Quota), logger.LogQuota(updatedUser.Quota)))
 	}
+	if currentUser, err := model.GetUserById(originUser.Id, false); err == nil {
+		model.RecordAdminAudit(c, "user.update", "users", originUser.Id, originUser, currentUser)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -866,6 +874,7 @@ func ManageUser(c *gin.Context) {
 		common.ApiErrorI18n(c, i18n.MsgUserNoPermissionHigherLevel)
 		return
 	}
+	originUser := user
 	switch req.Action {
 	case "disable":
 		user.Status = common.UserStatusDisabled
@@ -913,6 +922,19 @@ func ManageUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	// 升降级后恢复为内置角色，避免再次升级时沿用之前的自定义角色
+	if req.Action == "promote" || req.Action == "demote" {
+		if err := model.ClearUserRoleId(user.Id); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		user.RoleId = 0
+	}
+	if req.Action == "delete" {
+		model.RecordAdminAudit(c, "user.delete", "users", user.Id, originUser, nil)
+	} else {
+		model.RecordAdminAudit(c, "user."+req.Action, "users", user.Id, originUser, user)
+	}
 	clearUser := model.User{
 		Role:   user.Role,
 		Status: user.Status,
@@ -1015,7 +1037,7 @@ func TopUp(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	quota, err := model.Redeem(req.Key, id)
+	result, err := model.RedeemCode(req.Key, id)
 	if err != nil {
 		if errors.Is(err, model.ErrRedeemFailed) {
 			common.ApiErrorI18n(c, i18n.MsgRedeemFailed)
@@ -1024,10 +1046,12 @@ func TopUp(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	// data 保持为兑换的额度以兼容旧版前端，活动兑换码的发放内容见 grant
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data":    quota,
+		"data":    result.Quota,
+		"grant":   result,
 	})
 }
 
@@ -34,6 +34,23 @@ type ChannelOtherSettings struct {
 	AllowSafetyIdentifier   bool          `json:"allow_safety_identifier,omitempty"`   // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
 	AllowIncludeObfuscation bool          `json:"allow_include_obfuscation,omitempty"` // 是否允许 stream_options.include_obfuscation 透传（默认过滤以避免关闭流混淆保护）
 	AwsKeyType              AwsKeyType    `json:"aws_key_type,omitempty"`
+	// 上游成本倍率：相对公开价格（不含分组倍率）的实际成本比例，未设置时按 1 计算
+	CostRatio      *float64           `json:"cost_ratio,omitempty"`
+	ModelCostRatio map[string]float64 `json:"model_cost_ratio,omitempty"` // 按模型覆盖的上游成本倍率
+}
+
+// GetCostRatio 返回指定模型的上游成本倍率，优先使用按模型配置的倍率
+func (s *ChannelOtherSettings) GetCostRatio(modelName string) float64 {
+	if s == nil {
+		return 1
+	}
+	if ratio, ok := s.ModelCostRatio[modelName]; ok && ratio >= 0 {
+		return ratio
+	}
+	if s.CostRatio != nil && *s.CostRatio >= 0 {
+		return *s.CostRatio
+	}
+	return 1
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
@@ -13,6 +13,9 @@ const (
 	NotifyTypeQuotaExceed   = "quota_exceed"
 	NotifyTypeChannelUpdate = "channel_update"
 	NotifyTypeChannelTest   = "channel_test"
+
+	NotifyTypeSubscriptionRenewal = "subscription_renewal"
+	NotifyTypeLoyaltyTier         = "loyalty_tier"
 )
 
 func NewNotify(t string, title string, content string, values []interface{}) Notify {
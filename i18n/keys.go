@@ -42,6 +42,11 @@ const (
 	MsgTokenExhausted            = "token.exhausted"
 	MsgTokenStatusUnavailable    = "token.status_unavailable"
 	MsgTokenDbError              = "token.db_error"
+	MsgTokenScopeInvalid         = "token.scope_invalid"
+	MsgTokenScopeDenied          = "token.scope_denied"
+	MsgTokenScopeUnrestricted    = "token.scope_unrestricted_only"
+	MsgTokenRateLimitNegative    = "token.rate_limit_negative"
+	MsgTokenLabelInvalid         = "token.label_invalid"
 )
 
 // Redemption related messages
@@ -278,6 +283,13 @@ const (
 	MsgOAuthTokenFailed     = "oauth.token_failed"
 	MsgOAuthUserInfoEmpty   = "oauth.user_info_empty"
 	MsgOAuthTrustLevelLow   = "oauth.trust_level_low"
+
+	MsgLDAPInvalidCredentials = "ldap.invalid_credentials"
+	MsgLDAPMultipleUsers      = "ldap.multiple_users"
+	MsgLDAPUserNotProvisioned = "ldap.user_not_provisioned"
+
+	MsgSAMLConfigInvalid   = "saml.config_invalid"
+	MsgSAMLResponseInvalid = "saml.response_invalid"
 )
 
 // Model layer error messages (for translation in controller)
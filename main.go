@@ -112,6 +112,35 @@ func main() {
 	// Subscription quota reset task (daily/weekly/monthly/custom)
 	service.StartSubscriptionQuotaResetTask()
 
+	// Credit grant expiry task (credit ledger)
+	service.StartCreditGrantExpireTask()
+
+	// Quota ledger reconciliation task
+	service.StartQuotaReconcileTask()
+
+	// Recurring free-credit grant task
+	service.StartRecurringGrantTask()
+
+	// Affiliate commission settlement task (consumption basis)
+	service.StartAffiliateCommissionTask()
+
+	// Loyalty tier task (group upgrades by cumulative top-up)
+	model.LoyaltyTierChangedFunc = service.NotifyLoyaltyTierChanged
+	service.StartLoyaltyTierTask()
+
+	// Postpaid billing task (statements, payment links, overdue suspension)
+	service.PostpaidPaymentLinkFunc = controller.CreatePostpaidPaymentLink
+	service.StartPostpaidBillingTask()
+
+	// Monthly statement email task
+	service.StartStatementEmailTask()
+
+	// Export file cleanup task
+	service.StartExportCleanupTask()
+
+	// Admin audit log retention task
+	service.StartAdminAuditCleanupTask()
+
 	// Wire task polling adaptor factory (breaks service -> relay import cycle)
 	service.GetTaskAdaptorFunc = func(platform constant.TaskPlatform) service.TaskPollingAdaptor {
 		a := relay.GetTaskAdaptor(platform)
@@ -265,6 +294,13 @@ func InitResources() error {
 		return err
 	}
 
+	// 加载令牌哈希密钥并迁移明文存储的令牌，需在 model.InitDB() 之后
+	err = model.InitTokenKeyHash()
+	if err != nil {
+		common.FatalLog("failed to initialize token key hash: " + err.Error())
+		return err
+	}
+
 	model.CheckSetup()
 
 	// Initialize options, should after model.InitDB()
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuditBodyLimit 通用审计日志记录请求体的上限，超过时只记录操作本身
+const adminAuditBodyLimit = 64 << 10
+
+// 同一路由叠加多个鉴权中间件时只由最外层记录
+const adminAuditPendingKey = "admin_audit_pending"
+
+func isMutatingMethod(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// beginAdminAudit 判断请求是否需要记录通用审计日志，需要时读取 JSON 请求体并重新放回供接口使用
+func beginAdminAudit(c *gin.Context, minRole int) (bool, []byte) {
+	if minRole < common.RoleAdminUser || !isMutatingMethod(c.Request.Method) || c.GetBool(adminAuditPendingKey) {
+		return false, nil
+	}
+	c.Set(adminAuditPendingKey, true)
+	if c.Request.Body == nil || c.Request.ContentLength <= 0 || c.Request.ContentLength > adminAuditBodyLimit ||
+		!strings.HasPrefix(c.ContentType(), "application/json") {
+		return true, nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return true, nil
+	}
+	return true, body
+}
+
+// recordAdminAudit 请求结束后记录通用审计日志：操作为方法加路由，对象为路由中的 id，
+// 变更内容为脱敏后的请求体。接口已记录详细的修改前后差异时跳过
+func recordAdminAudit(c *gin.Context, resource string, body []byte) {
+	var payload any
+	if len(body) > 0 {
+		if err := common.Unmarshal(body, &payload); err != nil {
+			payload = nil
+		}
+	}
+	route := c.FullPath()
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+	model.RecordGenericAdminAudit(c, c.Request.Method+" "+route, adminAuditTargetType(c, resource), c.Param("id"), payload)
+}
+
+func adminAuditTargetType(c *gin.Context, resource string) string {
+	if resource != "" {
+		return resource
+	}
+	path := c.Request.URL.Path
+	for _, item := range managementResourcePrefixes {
+		if strings.HasPrefix(path, item.prefix) {
+			return item.resource
+		}
+	}
+	return ""
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/i18n"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
@@ -30,13 +31,16 @@ func validUserInfo(username string, role int) bool {
 	return true
 }
 
-func authHelper(c *gin.Context, minRole int) {
+// authHelper 校验登录状态与用户等级；resource 不为空时还需拥有该资源对应的读写权限
+func authHelper(c *gin.Context, minRole int, resource string) {
 	session := sessions.Default(c)
 	username := session.Get("username")
 	role := session.Get("role")
 	id := session.Get("id")
 	status := session.Get("status")
 	useAccessToken := false
+	var managementKey *model.ManagementKey
+	managementScope := ""
 	if username == nil {
 		// Check access token
 		accessToken := c.Request.Header.Get("Authorization")
@@ -48,7 +52,17 @@ func authHelper(c *gin.Context, minRole int) {
 			c.Abort()
 			return
 		}
-		user := model.ValidateAccessToken(accessToken)
+		var user *model.User
+		if secret := strings.TrimPrefix(accessToken, "Bearer "); model.IsManagementKey(secret) {
+			// 管理 API 密钥只能访问授权范围内的接口
+			var ok bool
+			managementKey, user, managementScope, ok = authManagementKey(c, secret, minRole, resource)
+			if !ok {
+				return
+			}
+		} else {
+			user = model.ValidateAccessToken(accessToken)
+		}
 		if user != nil && user.Username != "" {
 			if !validUserInfo(user.Username, user.Role) {
 				c.JSON(http.StatusOK, gin.H{
@@ -125,6 +139,9 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
+	if resource != "" && !checkPermission(c, id.(int), resource) {
+		return
+	}
 	// 防止不同newapi版本冲突，导致数据不通用
 	c.Header("Auth-Version", "864b7076dbcd0a3c01b5520316720ebf")
 	c.Set("username", username)
@@ -133,8 +150,22 @@ func authHelper(c *gin.Context, minRole int) {
 	c.Set("group", session.Get("group"))
 	c.Set("user_group", session.Get("group"))
 	c.Set("use_access_token", useAccessToken)
+	// 同一路由叠加多个鉴权中间件时只记录一次调用
+	_, audited := c.Get("management_key_id")
+	if managementKey != nil {
+		c.Set("management_key_id", managementKey.Id)
+	}
+
+	// 管理后台的修改类请求记录审计日志
+	adminAudit, adminAuditBody := beginAdminAudit(c, minRole)
 
 	c.Next()
+	if managementKey != nil && !audited {
+		recordManagementKeyAudit(c, managementKey, managementScope)
+	}
+	if adminAudit {
+		recordAdminAudit(c, resource, adminAuditBody)
+	}
 }
 
 func TryUserAuth() func(c *gin.Context) {
@@ -150,19 +181,27 @@ func TryUserAuth() func(c *gin.Context) {
 
 func UserAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleCommonUser)
+		authHelper(c, common.RoleCommonUser, "")
 	}
 }
 
 func AdminAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleAdminUser)
+		authHelper(c, common.RoleAdminUser, "")
 	}
 }
 
 func RootAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleRootUser)
+		authHelper(c, common.RoleRootUser, "")
+	}
+}
+
+// PermissionAuth 管理后台路由分组的鉴权：要求管理员等级，并拥有 resource 对应的读写权限。
+// 超级管理员和内置管理员角色的权限与原有的 AdminAuth/RootAuth 一致，自定义角色只能访问授权的资源
+func PermissionAuth(resource string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		authHelper(c, common.RoleAdminUser, resource)
 	}
 }
 
@@ -210,7 +249,7 @@ func TokenAuthReadOnly() func(c *gin.Context) {
 		parts := strings.Split(key, "-")
 		key = parts[0]
 
-		token, err := model.GetTokenByKey(key, false)
+		token, err := model.GetTokenBySecret(key)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
@@ -238,6 +277,15 @@ func TokenAuthReadOnly() func(c *gin.Context) {
 			return
 		}
 
+		if !token.HasScope(model.TokenScopeRead) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": i18n.T(c, i18n.MsgTokenScopeDenied, map[string]any{"Scope": model.TokenScopeRead}),
+			})
+			c.Abort()
+			return
+		}
+
 		c.Set("id", token.UserId)
 		c.Set("token_id", token.Id)
 		c.Set("token_key", token.Key)
@@ -288,20 +336,28 @@ func TokenAuth() func(c *gin.Context) {
 		if strings.HasPrefix(key, "Bearer ") || strings.HasPrefix(key, "bearer ") {
 			key = strings.TrimSpace(key[7:])
 		}
-		if key == "" || key == "midjourney-proxy" {
-			key = c.Request.Header.Get("mj-api-secret")
-			if strings.HasPrefix(key, "Bearer ") || strings.HasPrefix(key, "bearer ") {
-				key = strings.TrimSpace(key[7:])
-			}
-			key = strings.TrimPrefix(key, "sk-")
-			parts = strings.Split(key, "-")
-			key = parts[0]
+		var token *model.Token
+		var ephemeral *model.EphemeralTokenClaims
+		var err error
+		if model.IsEphemeralTokenKey(key) {
+			// 临时令牌自带签名，不参与 sk- 前缀和指定渠道的解析
+			token, ephemeral, err = model.ValidateEphemeralToken(key)
 		} else {
-			key = strings.TrimPrefix(key, "sk-")
-			parts = strings.Split(key, "-")
-			key = parts[0]
+			if key == "" || key == "midjourney-proxy" {
+				key = c.Request.Header.Get("mj-api-secret")
+				if strings.HasPrefix(key, "Bearer ") || strings.HasPrefix(key, "bearer ") {
+					key = strings.TrimSpace(key[7:])
+				}
+				key = strings.TrimPrefix(key, "sk-")
+				parts = strings.Split(key, "-")
+				key = parts[0]
+			} else {
+				key = strings.TrimPrefix(key, "sk-")
+				parts = strings.Split(key, "-")
+				key = parts[0]
+			}
+			token, err = model.ValidateUserToken(key)
 		}
-		token, err := model.ValidateUserToken(key)
 		if token != nil {
 			id := c.GetInt("id")
 			if id == 0 {
@@ -329,6 +385,13 @@ func TokenAuth() func(c *gin.Context) {
 			logger.LogDebug(c, "Client IP %s passed the token IP restrictions check", clientIp)
 		}
 
+		if !checkTokenScope(c, token) {
+			return
+		}
+		if !setupTokenLabels(c, token) {
+			return
+		}
+
 		userCache, err := model.GetUserCache(token.UserId)
 		if err != nil {
 			abortWithOpenAiMessage(c, http.StatusInternalServerError, err.Error())
@@ -365,6 +428,12 @@ func TokenAuth() func(c *gin.Context) {
 		if err != nil {
 			return
 		}
+		if ephemeral != nil {
+			common.SetContextKey(c, constant.ContextKeyTokenEphemeral, true)
+			common.SetContextKey(c, constant.ContextKeyTokenEphemeralId, ephemeral.Id)
+			common.SetContextKey(c, constant.ContextKeyTokenEphemeralQuota, ephemeral.Quota)
+			common.SetContextKey(c, constant.ContextKeyTokenEphemeralExpires, ephemeral.ExpiresAt)
+		}
 		c.Next()
 	}
 }
@@ -389,8 +458,12 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenRpmLimit, token.RpmLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenTpmLimit, token.TpmLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenConcurrencyLimit, token.ConcurrencyLimit)
 	if len(parts) > 1 {
-		if model.IsAdmin(token.UserId) {
+		// 自定义角色的用户同样是管理员等级，指定渠道需要渠道管理权限
+		if allowed, err := model.UserHasPermission(token.UserId, model.PermissionChannelsWrite); err == nil && allowed {
 			c.Set("specific_channel_id", parts[1])
 		} else {
 			c.Header("specific_channel_version", "701e3ae1dc3f7975556d354e0675168d004891c8")
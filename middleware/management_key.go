@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// managementResourcePrefixes /api 下各路由前缀对应的管理资源，未列出的路由（如个人账户、支付、管理密钥自身）不允许使用管理 API 密钥
+var managementResourcePrefixes = []struct {
+	prefix   string
+	resource string
+}{
+	{"/api/channel", "channels"},
+	{"/api/ratio_sync", "options"},
+	{"/api/user/", "users"},
+	{"/api/token", "tokens"},
+	{"/api/log", "logs"},
+	{"/api/data", "logs"},
+	{"/api/mj", "logs"},
+	{"/api/task", "logs"},
+	{"/api/option", "options"},
+	{"/api/custom-oauth-provider", "options"},
+	{"/api/performance", "options"},
+	{"/api/redemption", "redemptions"},
+	{"/api/promo_code", "redemptions"},
+	{"/api/models", "models"},
+	{"/api/vendors", "models"},
+	{"/api/prefill_group", "models"},
+	{"/api/group", "models"},
+	{"/api/deployments", "models"},
+	{"/api/subscription/admin", "billing"},
+	{"/api/recurring_grant", "billing"},
+	{"/api/quota_reconcile", "billing"},
+}
+
+// managementScopeForRequest 判断管理 API 密钥所需的权限范围，返回空字符串表示不允许访问。
+// 路由分组已声明资源时直接使用，否则按路径前缀推断；/api/user 下只开放管理员接口，
+// 个人账户相关接口（生成 access token、2FA、充值等）不对管理密钥开放
+func managementScopeForRequest(c *gin.Context, minRole int, resource string) string {
+	if resource != "" {
+		return permissionForRequest(c, resource)
+	}
+	path := c.Request.URL.Path
+	if strings.HasPrefix(path, "/api/user/") && minRole < common.RoleAdminUser {
+		return ""
+	}
+	for _, item := range managementResourcePrefixes {
+		if strings.HasPrefix(path, item.prefix) {
+			return permissionForRequest(c, item.resource)
+		}
+	}
+	return ""
+}
+
+// authManagementKey 校验管理 API 密钥的有效期、IP 白名单和权限范围，失败时中止请求
+func authManagementKey(c *gin.Context, secret string, minRole int, resource string) (*model.ManagementKey, *model.User, string, bool) {
+	abort := func(statusCode int, message string) (*model.ManagementKey, *model.User, string, bool) {
+		c.JSON(statusCode, gin.H{
+			"success": false,
+			"message": message,
+		})
+		c.Abort()
+		return nil, nil, "", false
+	}
+	key, err := model.ValidateManagementKey(secret)
+	if err != nil {
+		return abort(http.StatusUnauthorized, "无权进行此操作，"+err.Error())
+	}
+	if allowIps := key.GetIpLimits(); len(allowIps) > 0 {
+		ip := net.ParseIP(c.ClientIP())
+		if ip == nil || !common.IsIpInCIDRList(ip, allowIps) {
+			return abort(http.StatusForbidden, "无权进行此操作，IP 不在管理 API 密钥允许访问的列表中")
+		}
+	}
+	scope := managementScopeForRequest(c, minRole, resource)
+	if scope == "" {
+		return abort(http.StatusForbidden, "无权进行此操作，该接口不支持使用管理 API 密钥")
+	}
+	if !key.HasScope(scope) {
+		return abort(http.StatusForbidden, "无权进行此操作，管理 API 密钥缺少 "+scope+" 权限")
+	}
+	user, err := model.GetUserById(key.UserId, false)
+	if err != nil {
+		return abort(http.StatusUnauthorized, "无权进行此操作，管理 API 密钥所属用户不存在")
+	}
+	return key, user, scope, true
+}
+
+// recordManagementKeyAudit 请求结束后异步记录管理 API 密钥的调用
+func recordManagementKeyAudit(c *gin.Context, key *model.ManagementKey, scope string) {
+	audit := &model.ManagementKeyAudit{
+		KeyId:      key.Id,
+		UserId:     key.UserId,
+		Scope:      scope,
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		StatusCode: c.Writer.Status(),
+		Ip:         c.ClientIP(),
+		RequestId:  c.GetString(common.RequestIdKey),
+	}
+	gopool.Go(func() {
+		model.RecordManagementKeyAudit(audit)
+	})
+}
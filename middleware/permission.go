@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeGetPrefixes 会产生副作用的 GET 接口，需要 write 权限
+var writeGetPrefixes = []string{
+	"/api/channel/test",
+	"/api/channel/update_balance",
+	"/api/channel/fetch_models",
+}
+
+// permissionForRequest 根据请求方法得到访问 resource 所需的权限：GET 为 read，其余为 write
+func permissionForRequest(c *gin.Context, resource string) string {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return resource + ":write"
+	}
+	for _, prefix := range writeGetPrefixes {
+		if strings.HasPrefix(c.Request.URL.Path, prefix) {
+			return resource + ":write"
+		}
+	}
+	return resource + ":read"
+}
+
+// checkPermission 用户缺少访问 resource 所需的权限时中止请求
+func checkPermission(c *gin.Context, userId int, resource string) bool {
+	permission := permissionForRequest(c, resource)
+	allowed, err := model.UserHasPermission(userId, permission)
+	if err != nil {
+		common.ApiError(c, err)
+		c.Abort()
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权进行此操作，缺少 " + permission + " 权限",
+		})
+		c.Abort()
+		return false
+	}
+	return true
+}
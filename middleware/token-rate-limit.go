@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenRateLimit 令牌级限流中间件：并发数、RPM，以及请求结束后修正 TPM 预占
+// TPM 的预占在计算出预估输入 token 后由 service.ReserveTokenTPM 完成
+func TokenRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		allowed, release, err := service.AcquireTokenConcurrency(c)
+		if err != nil {
+			logger.LogError(c, "token concurrency check failed: "+err.Error())
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return
+		}
+		if !allowed {
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("令牌已达到并发请求数限制：最多同时 %d 个请求",
+				common.GetContextKeyInt(c, constant.ContextKeyTokenConcurrencyLimit)), types.ErrorCodeTokenRateLimitExceeded)
+			return
+		}
+		defer release()
+
+		allowed, err = service.TakeTokenRequestRate(c)
+		if err != nil {
+			logger.LogError(c, "token rpm check failed: "+err.Error())
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return
+		}
+		if !allowed {
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("令牌已达到请求数限制：每分钟最多请求 %d 次",
+				common.GetContextKeyInt(c, constant.ContextKeyTokenRpmLimit)), types.ErrorCodeTokenRateLimitExceeded)
+			return
+		}
+
+		c.Next()
+
+		service.SettleTokenTPM(c)
+	}
+}
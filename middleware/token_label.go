@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenLabelsHeader 请求级标签，格式与令牌标签相同：key=value，逗号分隔
+const TokenLabelsHeader = "X-New-Api-Labels"
+
+// setupTokenLabels 合并令牌标签与请求头标签写入上下文，供记录日志时使用；
+// 令牌未允许请求级标签或标签格式错误时中止请求
+func setupTokenLabels(c *gin.Context, token *model.Token) bool {
+	requestLabels := c.Request.Header.Get(TokenLabelsHeader)
+	if requestLabels == "" {
+		common.SetContextKey(c, constant.ContextKeyTokenLabels, token.Labels)
+		return true
+	}
+	if !token.AllowRequestLabels {
+		abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌不允许通过请求头设置标签", types.ErrorCodeAccessDenied)
+		return false
+	}
+	labels, invalid := token.MergeRequestLabels(requestLabels)
+	if invalid != "" {
+		abortWithOpenAiMessage(c, http.StatusBadRequest, i18n.T(c, i18n.MsgTokenLabelInvalid, map[string]any{"Label": invalid}),
+			types.ErrorCodeInvalidRequest)
+		return false
+	}
+	common.SetContextKey(c, constant.ContextKeyTokenLabels, labels)
+	return true
+}
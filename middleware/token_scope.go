@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenScopeUnmapped 未归类到任何权限范围的接口（文件、微调、临时令牌签发以及以后新增的接口），
+// 只有未设置权限范围的令牌可以访问。该值不是合法的权限范围，不能授予令牌
+const tokenScopeUnmapped = "unmapped"
+
+// tokenScopeForRequest 根据请求路径判断所需的令牌权限范围，返回空字符串表示无需检查（如模型列表）
+func tokenScopeForRequest(c *gin.Context) string {
+	path := c.Request.URL.Path
+	if c.Request.Method == http.MethodGet && (strings.HasPrefix(path, "/v1/models") ||
+		strings.HasPrefix(path, "/v1beta/models") || strings.HasPrefix(path, "/v1beta/openai/models")) {
+		return ""
+	}
+	switch {
+	case strings.Contains(path, "/dashboard/billing"):
+		return model.TokenScopeRead
+	case strings.HasPrefix(path, "/v1/realtime"):
+		return model.TokenScopeRealtime
+	case strings.HasPrefix(path, "/v1/messages"),
+		strings.HasPrefix(path, "/v1/chat/completions"),
+		strings.HasPrefix(path, "/v1/completions"),
+		strings.HasPrefix(path, "/v1/responses"),
+		strings.HasPrefix(path, "/v1/moderations"):
+		return model.TokenScopeChat
+	case strings.HasPrefix(path, "/v1/embeddings"), strings.HasSuffix(path, "/embeddings"):
+		return model.TokenScopeEmbeddings
+	case strings.HasPrefix(path, "/v1/images"), strings.HasPrefix(path, "/v1/edits"), strings.Contains(path, "/mj/"):
+		return model.TokenScopeImages
+	case strings.HasPrefix(path, "/v1/audio"):
+		return model.TokenScopeAudio
+	case strings.HasPrefix(path, "/v1/rerank"):
+		return model.TokenScopeRerank
+	case strings.HasPrefix(path, "/v1/video"), strings.HasPrefix(path, "/suno"),
+		strings.HasPrefix(path, "/kling"), strings.HasPrefix(path, "/jimeng"):
+		return model.TokenScopeTask
+	case strings.HasPrefix(path, "/v1beta/models/"), strings.HasPrefix(path, "/v1/models/"):
+		// Gemini 路径格式: /v1beta/models/{model_name}:{action}
+		action := path[strings.LastIndex(path, ":")+1:]
+		switch action {
+		case "embedContent", "batchEmbedContents":
+			return model.TokenScopeEmbeddings
+		case "predict", "predictLongRunning":
+			return model.TokenScopeImages
+		}
+		return model.TokenScopeChat
+	}
+	return tokenScopeUnmapped
+}
+
+// abortWithTokenScopeDenied 以客户端使用的原生格式返回 403
+func abortWithTokenScopeDenied(c *gin.Context, scope string) {
+	msg := i18n.T(c, i18n.MsgTokenScopeDenied, map[string]any{"Scope": scope})
+	if scope == tokenScopeUnmapped {
+		msg = i18n.T(c, i18n.MsgTokenScopeUnrestricted)
+	}
+	message := common.MessageWithRequestId(msg, c.GetString(common.RequestIdKey))
+	path := c.Request.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/v1/messages"):
+		c.JSON(http.StatusForbidden, gin.H{
+			"type": "error",
+			"error": types.ClaudeError{
+				Type:    "permission_error",
+				Message: message,
+			},
+		})
+	case strings.HasPrefix(path, "/v1beta/"), strings.HasPrefix(path, "/v1/models/"):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": gin.H{
+				"code":    http.StatusForbidden,
+				"message": message,
+				"status":  "PERMISSION_DENIED",
+			},
+		})
+	default:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": gin.H{
+				"message": message,
+				"type":    "new_api_error",
+				"code":    string(types.ErrorCodeAccessDenied),
+			},
+		})
+	}
+	c.Abort()
+	logger.LogError(c.Request.Context(), "token scope denied: "+scope)
+}
+
+// checkTokenScope 令牌缺少请求所需的权限范围时中止请求
+func checkTokenScope(c *gin.Context, token *model.Token) bool {
+	scope := tokenScopeForRequest(c)
+	if scope == "" || token.HasScope(scope) {
+		return true
+	}
+	abortWithTokenScopeDenied(c, scope)
+	return false
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkTokenScopeForPath(token *model.Token, method string, path string) (bool, int) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, path, nil)
+	allowed := checkTokenScope(c, token)
+	return allowed, recorder.Code
+}
+
+func TestCheckTokenScopeUnmappedEndpoints(t *testing.T) {
+	require.NoError(t, i18n.Init())
+	restricted := &model.Token{Scopes: "chat"}
+	for _, path := range []string{"/v1/files", "/v1/fine-tunes", "/v1/ephemeral_tokens"} {
+		allowed, code := checkTokenScopeForPath(restricted, http.MethodPost, path)
+		assert.False(t, allowed, path)
+		assert.Equal(t, http.StatusForbidden, code, path)
+	}
+
+	allowed, _ := checkTokenScopeForPath(restricted, http.MethodPost, "/v1/chat/completions")
+	assert.True(t, allowed)
+	allowed, _ = checkTokenScopeForPath(restricted, http.MethodGet, "/v1/models")
+	assert.True(t, allowed)
+
+	unrestricted := &model.Token{}
+	allowed, _ = checkTokenScopeForPath(unrestricted, http.MethodPost, "/v1/files")
+	assert.True(t, allowed)
+}
@@ -0,0 +1,282 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdminAuditLog 管理操作审计日志，写入日志库。记录操作人、操作、对象以及脱敏后的字段变更，
+// 管理后台的修改类请求由鉴权中间件统一记录，渠道、用户、系统设置等关键操作由接口记录修改前后的完整差异
+type AdminAuditLog struct {
+	Id              int    `json:"id"`
+	CreatedAt       int64  `json:"created_at" gorm:"bigint;index"`
+	UserId          int    `json:"user_id" gorm:"index"`
+	Username        string `json:"username" gorm:"type:varchar(64);default:''"`
+	ManagementKeyId int    `json:"management_key_id" gorm:"default:0"` // 通过管理 API 密钥调用时的密钥 id
+	Action          string `json:"action" gorm:"type:varchar(128);index"`
+	TargetType      string `json:"target_type" gorm:"type:varchar(32);default:''"`
+	TargetId        string `json:"target_id" gorm:"type:varchar(64);default:''"`
+	Ip              string `json:"ip" gorm:"type:varchar(64);default:''"`
+	RequestId       string `json:"request_id" gorm:"type:varchar(64);default:''"`
+	StatusCode      int    `json:"status_code"`
+	Changes         string `json:"changes" gorm:"type:text"` // JSON，格式为 {"字段": {"before": ..., "after": ...}}
+}
+
+// AdminAuditChange 单个字段的变更，before 为 null 表示新增，after 为 null 表示删除
+type AdminAuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+const (
+	adminAuditMask = "******"
+	// 接口已记录详细审计时设置，鉴权中间件不再重复记录
+	adminAuditRecordedKey = "admin_audit_recorded"
+)
+
+// 以这些后缀结尾的字段（忽略大小写和下划线）视为敏感字段，审计日志中只记录是否变化
+var sensitiveAuditSuffixes = []string{"key", "keys", "secret", "token", "password", "credential", "credentials"}
+
+func IsSensitiveAuditField(name string) bool {
+	name = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+	for _, suffix := range sensitiveAuditSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSensitiveAuditEntry 判断 m 中的字段是否敏感。形如 {"key": ..., "value": ...} 的设置项（如系统设置）
+// 按 key 的取值判断 value 是否敏感，key 本身保留
+func isSensitiveAuditEntry(m map[string]any, field string) bool {
+	if name, ok := m["key"].(string); ok {
+		if _, hasValue := m["value"]; hasValue {
+			switch field {
+			case "key":
+				return false
+			case "value":
+				return IsSensitiveAuditField(name)
+			}
+		}
+	}
+	return IsSensitiveAuditField(field)
+}
+
+func maskAuditSecret(v any) any {
+	if v == nil || v == "" {
+		return v
+	}
+	return adminAuditMask
+}
+
+// maskAuditValue 递归脱敏对象和数组中的敏感字段
+func maskAuditValue(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		masked := make(map[string]any, len(value))
+		for field, item := range value {
+			if isSensitiveAuditEntry(value, field) {
+				masked[field] = maskAuditSecret(item)
+			} else {
+				masked[field] = maskAuditValue(item)
+			}
+		}
+		return masked
+	case []any:
+		masked := make([]any, len(value))
+		for i, item := range value {
+			masked[i] = maskAuditValue(item)
+		}
+		return masked
+	}
+	return v
+}
+
+// toAuditMap 将任意值按 JSON 序列化结果转为对象，非对象的值放在 value 字段中
+func toAuditMap(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	data, err := common.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if err := common.Unmarshal(data, &m); err == nil {
+		return m
+	}
+	var value any
+	if err := common.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	return map[string]any{"value": value}
+}
+
+// DiffAdminAudit 对比 before 与 after 序列化后的顶层字段，返回有变化的字段及脱敏后的前后值。
+// before 为 nil 表示新建，after 为 nil 表示删除；敏感字段变化时前后值均记为掩码
+func DiffAdminAudit(before any, after any) map[string]AdminAuditChange {
+	beforeMap, afterMap := toAuditMap(before), toAuditMap(after)
+	// 新建和删除时只有一侧有值，按有值的一侧判断字段是否敏感
+	entries := afterMap
+	if entries == nil {
+		entries = beforeMap
+	}
+	changes := make(map[string]AdminAuditChange)
+	diff := func(field string) {
+		if _, ok := changes[field]; ok {
+			return
+		}
+		beforeValue, afterValue := beforeMap[field], afterMap[field]
+		if reflect.DeepEqual(beforeValue, afterValue) {
+			return
+		}
+		if isSensitiveAuditEntry(entries, field) {
+			changes[field] = AdminAuditChange{Before: maskAuditSecret(beforeValue), After: maskAuditSecret(afterValue)}
+			return
+		}
+		changes[field] = AdminAuditChange{Before: maskAuditValue(beforeValue), After: maskAuditValue(afterValue)}
+	}
+	for field := range beforeMap {
+		diff(field)
+	}
+	for field := range afterMap {
+		diff(field)
+	}
+	return changes
+}
+
+func newAdminAuditLog(c *gin.Context, action string, targetType string, targetId string, changes map[string]AdminAuditChange) *AdminAuditLog {
+	changesJson, err := common.Marshal(changes)
+	if err != nil {
+		changesJson = []byte("{}")
+	}
+	if len(targetId) > 64 {
+		targetId = targetId[:64]
+	}
+	if len(action) > 128 {
+		action = action[:128]
+	}
+	return &AdminAuditLog{
+		CreatedAt:       common.GetTimestamp(),
+		UserId:          c.GetInt("id"),
+		Username:        c.GetString("username"),
+		ManagementKeyId: c.GetInt("management_key_id"),
+		Action:          action,
+		TargetType:      targetType,
+		TargetId:        targetId,
+		Ip:              c.ClientIP(),
+		RequestId:       c.GetString(common.RequestIdKey),
+		StatusCode:      c.Writer.Status(),
+		Changes:         string(changesJson),
+	}
+}
+
+// RecordAdminAudit 记录一次管理操作及修改前后的差异，异步写入日志库。
+// 调用后鉴权中间件不再为该请求记录通用的审计日志
+func RecordAdminAudit(c *gin.Context, action string, targetType string, targetId any, before any, after any) {
+	c.Set(adminAuditRecordedKey, true)
+	audit := newAdminAuditLog(c, action, targetType, fmt.Sprint(targetId), DiffAdminAudit(before, after))
+	gopool.Go(func() {
+		insertAdminAuditLog(audit)
+	})
+}
+
+// RecordGenericAdminAudit 由鉴权中间件在请求结束后调用，以请求体作为变更内容；
+// 接口已调用 RecordAdminAudit 时跳过
+func RecordGenericAdminAudit(c *gin.Context, action string, targetType string, targetId string, body any) {
+	if c.GetBool(adminAuditRecordedKey) {
+		return
+	}
+	RecordAdminAudit(c, action, targetType, targetId, nil, body)
+}
+
+func insertAdminAuditLog(audit *AdminAuditLog) {
+	if err := LOG_DB.Create(audit).Error; err != nil {
+		common.SysLog("failed to record admin audit log: " + err.Error())
+	}
+}
+
+func (f *ExportFilter) adminAuditQuery() *gorm.DB {
+	tx := LOG_DB.Model(&AdminAuditLog{})
+	if f.Username != "" {
+		tx = tx.Where("username = ?", f.Username)
+	}
+	if f.Action != "" {
+		tx = tx.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		tx = tx.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetId != "" {
+		tx = tx.Where("target_id = ?", f.TargetId)
+	}
+	if f.RequestId != "" {
+		tx = tx.Where("request_id = ?", f.RequestId)
+	}
+	if f.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", f.StartTimestamp)
+	}
+	if f.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", f.EndTimestamp)
+	}
+	return tx
+}
+
+func GetAdminAuditLogs(filter ExportFilter, pageInfo *common.PageInfo) (logs []*AdminAuditLog, total int64, err error) {
+	if err = filter.adminAuditQuery().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = filter.adminAuditQuery().Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&logs).Error
+	return logs, total, err
+}
+
+// IterateAdminAuditLogs 按 id 游标分批遍历审计日志
+func IterateAdminAuditLogs(filter ExportFilter, batchSize int, fn func(logs []*AdminAuditLog) error) error {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	lastId := 0
+	for {
+		var logs []*AdminAuditLog
+		if err := filter.adminAuditQuery().Where("id > ?", lastId).Order("id asc").Limit(batchSize).Find(&logs).Error; err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		lastId = logs[len(logs)-1].Id
+		if err := fn(logs); err != nil {
+			return err
+		}
+		if len(logs) < batchSize {
+			return nil
+		}
+	}
+}
+
+// DeleteOldAdminAuditLogs 分批删除 targetTimestamp 之前的审计日志
+func DeleteOldAdminAuditLogs(ctx context.Context, targetTimestamp int64, limit int) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		result := LOG_DB.Where("created_at < ?", targetTimestamp).Limit(limit).Delete(&AdminAuditLog{})
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(limit) {
+			return total, nil
+		}
+	}
+}
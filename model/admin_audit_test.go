@@ -0,0 +1,67 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffAdminAuditMasksSensitiveFields(t *testing.T) {
+	before := &Channel{Id: 1, Name: "old", Key: "sk-old", Models: "gpt-4o"}
+	after := &Channel{Id: 1, Name: "new", Key: "sk-new", Models: "gpt-4o"}
+	changes := DiffAdminAudit(before, after)
+
+	assert.Equal(t, AdminAuditChange{Before: "old", After: "new"}, changes["name"])
+	assert.Equal(t, AdminAuditChange{Before: adminAuditMask, After: adminAuditMask}, changes["key"])
+	assert.NotContains(t, changes, "models")
+	assert.NotContains(t, changes, "id")
+
+	// 设置项按 key 的取值判断 value 是否敏感
+	changes = DiffAdminAudit(nil, map[string]any{"key": "GitHubClientSecret", "value": "secret"})
+	assert.Equal(t, AdminAuditChange{Before: nil, After: "GitHubClientSecret"}, changes["key"])
+	assert.Equal(t, AdminAuditChange{Before: nil, After: adminAuditMask}, changes["value"])
+	changes = DiffAdminAudit(map[string]any{"key": "QuotaPerUnit", "value": "1"}, map[string]any{"key": "QuotaPerUnit", "value": "2"})
+	assert.Equal(t, AdminAuditChange{Before: "1", After: "2"}, changes["value"])
+
+	// 嵌套对象中的敏感字段同样脱敏
+	changes = DiffAdminAudit(nil, map[string]any{"setting": map[string]any{"api_key": "abc", "region": "us"}})
+	assert.Equal(t, map[string]any{"api_key": adminAuditMask, "region": "us"}, changes["setting"].After)
+
+	assert.True(t, IsSensitiveAuditField("AccessToken"))
+	assert.True(t, IsSensitiveAuditField("client_secret"))
+	assert.False(t, IsSensitiveAuditField("max_tokens"))
+}
+
+func TestAdminAuditLogQueryAndRetention(t *testing.T) {
+	require.NoError(t, LOG_DB.AutoMigrate(&AdminAuditLog{}))
+	t.Cleanup(func() { LOG_DB.Exec("DELETE FROM admin_audit_logs") })
+
+	now := common.GetTimestamp()
+	insertAdminAuditLog(&AdminAuditLog{CreatedAt: now - 100*86400, Username: "root", Action: "channel.update", TargetType: "channels", TargetId: "1"})
+	insertAdminAuditLog(&AdminAuditLog{CreatedAt: now, Username: "root", Action: "channel.update", TargetType: "channels", TargetId: "2"})
+	insertAdminAuditLog(&AdminAuditLog{CreatedAt: now, Username: "ops", Action: "option.update", TargetType: "options", TargetId: "QuotaPerUnit"})
+
+	pageInfo := &common.PageInfo{Page: 1, PageSize: 10}
+	logs, total, err := GetAdminAuditLogs(ExportFilter{Action: "channel.update"}, pageInfo)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, "2", logs[0].TargetId)
+
+	_, total, err = GetAdminAuditLogs(ExportFilter{Username: "ops", TargetType: "options"}, pageInfo)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, total)
+
+	removed, err := DeleteOldAdminAuditLogs(context.Background(), now-30*86400, 100)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, removed)
+
+	var exported []*AdminAuditLog
+	require.NoError(t, IterateAdminAuditLogs(ExportFilter{}, 1, func(batch []*AdminAuditLog) error {
+		exported = append(exported, batch...)
+		return nil
+	}))
+	assert.Len(t, exported, 2)
+}
@@ -0,0 +1,327 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Affiliate commission sources
+const (
+	AffiliateCommissionSourceTopUp        = "topup"
+	AffiliateCommissionSourceSubscription = "subscription"
+	AffiliateCommissionSourceConsumption  = "consumption"
+)
+
+const (
+	AffiliateCommissionStatusAccrued  = "accrued"
+	AffiliateCommissionStatusReversed = "reversed" // 来源订单退款后冲回
+)
+
+// affiliateConsumptionWindowSeconds 按消耗返佣时的结算窗口
+const affiliateConsumptionWindowSeconds = 24 * 60 * 60
+
+// AffiliateCommission 邀请返佣流水：被邀请用户每笔充值或每个结算窗口的消耗对应一条记录，
+// 返佣计入邀请人的 AffQuota，由邀请人自行划转到余额
+type AffiliateCommission struct {
+	Id         int     `json:"id"`
+	InviterId  int     `json:"inviter_id" gorm:"index"`
+	InviteeId  int     `json:"invitee_id" gorm:"index"`
+	Source     string  `json:"source" gorm:"type:varchar(16)"`
+	SourceRef  string  `json:"source_ref" gorm:"type:varchar(255);uniqueIndex"` // 充值订单号或消耗结算窗口，保证同一来源只返佣一次
+	BaseQuota  int64   `json:"base_quota" gorm:"type:bigint"`
+	Percent    float64 `json:"percent"`
+	Commission int64   `json:"commission" gorm:"type:bigint"`
+	Status     string  `json:"status" gorm:"type:varchar(16);index"`
+	CreatedAt  int64   `json:"created_at" gorm:"bigint;index"`
+	ReversedAt int64   `json:"reversed_at" gorm:"bigint;default:0"`
+}
+
+// AffiliateCommissionSummary 邀请人返佣汇总
+type AffiliateCommissionSummary struct {
+	Invitees        int64   `json:"invitees"`
+	BaseQuota       int64   `json:"base_quota"`
+	Commission      int64   `json:"commission"`
+	ReversedQuota   int64   `json:"reversed_quota"`
+	CurrentPercent  float64 `json:"current_percent"`
+	AffQuota        int     `json:"aff_quota"`
+	AffHistoryQuota int     `json:"aff_history_quota"`
+}
+
+// AffiliatePayoutRow 管理员返佣结算报表中的一行，按邀请人汇总
+type AffiliatePayoutRow struct {
+	InviterId     int    `json:"inviter_id"`
+	Username      string `json:"username"`
+	Invitees      int64  `json:"invitees"`
+	Entries       int64  `json:"entries"`
+	BaseQuota     int64  `json:"base_quota"`
+	Commission    int64  `json:"commission"`
+	ReversedQuota int64  `json:"reversed_quota"`
+	AffQuota      int    `json:"aff_quota"` // 当前未划转的邀请额度
+}
+
+func affiliateCommissionBasis(source string) string {
+	if source == AffiliateCommissionSourceConsumption {
+		return operation_setting.AffiliateBasisConsumption
+	}
+	return operation_setting.AffiliateBasisTopUp
+}
+
+// accrueAffiliateCommissionTx 按被邀请用户的一笔流水为其邀请人计提返佣，未启用或不满足条件时返回 nil
+func accrueAffiliateCommissionTx(tx *gorm.DB, inviteeId int, source string, sourceRef string, baseQuota int64) (*AffiliateCommission, error) {
+	if baseQuota <= 0 || sourceRef == "" {
+		return nil, nil
+	}
+	if !operation_setting.IsAffiliateCommissionEnabled(affiliateCommissionBasis(source)) {
+		return nil, nil
+	}
+	var invitee User
+	if err := tx.Select("id", "inviter_id", "invited_time").Where("id = ?", inviteeId).First(&invitee).Error; err != nil {
+		return nil, err
+	}
+	if invitee.InviterId <= 0 || invitee.InviterId == invitee.Id {
+		return nil, nil
+	}
+	setting := operation_setting.GetAffiliateSetting()
+	now := common.GetTimestamp()
+	if setting.DurationDays > 0 {
+		invitedTime := invitee.InvitedTime
+		if invitedTime == 0 {
+			// 早期邀请关系没有记录邀请时间，以首笔返佣时间作为起点
+			var first AffiliateCommission
+			result := tx.Where("invitee_id = ?", inviteeId).Order("id").Limit(1).Find(&first)
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			if result.RowsAffected > 0 {
+				invitedTime = first.CreatedAt
+			}
+		}
+		if invitedTime > 0 && now-invitedTime > int64(setting.DurationDays)*24*60*60 {
+			return nil, nil
+		}
+	}
+	var exists int64
+	if err := tx.Model(&AffiliateCommission{}).Where("source_ref = ?", sourceRef).Count(&exists).Error; err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, nil
+	}
+	var volume int64
+	if err := tx.Model(&AffiliateCommission{}).
+		Where("inviter_id = ? AND status = ?", invitee.InviterId, AffiliateCommissionStatusAccrued).
+		Select("COALESCE(SUM(base_quota), 0)").Scan(&volume).Error; err != nil {
+		return nil, err
+	}
+	percent := setting.CommissionPercent(volume)
+	commission := decimal.NewFromInt(baseQuota).Mul(decimal.NewFromFloat(percent)).Div(decimal.NewFromInt(100)).IntPart()
+	if commission <= 0 {
+		return nil, nil
+	}
+	entry := &AffiliateCommission{
+		InviterId:  invitee.InviterId,
+		InviteeId:  inviteeId,
+		Source:     source,
+		SourceRef:  sourceRef,
+		BaseQuota:  baseQuota,
+		Percent:    percent,
+		Commission: commission,
+		Status:     AffiliateCommissionStatusAccrued,
+		CreatedAt:  now,
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&User{}).Where("id = ?", invitee.InviterId).Updates(map[string]interface{}{
+		"aff_quota":   gorm.Expr("aff_quota + ?", commission),
+		"aff_history": gorm.Expr("aff_history + ?", commission),
+	}).Error; err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// reverseAffiliateCommissionTx 来源订单退款时冲回返佣；邀请额度已被划转时允许为负，由后续返佣抵扣
+func reverseAffiliateCommissionTx(tx *gorm.DB, sourceRef string, now int64) error {
+	var entry AffiliateCommission
+	result := tx.Where("source_ref = ? AND status = ?", sourceRef, AffiliateCommissionStatusAccrued).Limit(1).Find(&entry)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	if err := tx.Model(&AffiliateCommission{}).Where("id = ?", entry.Id).Updates(map[string]interface{}{
+		"status":      AffiliateCommissionStatusReversed,
+		"reversed_at": now,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&User{}).Where("id = ?", entry.InviterId).Updates(map[string]interface{}{
+		"aff_quota":   gorm.Expr("aff_quota - ?", entry.Commission),
+		"aff_history": gorm.Expr("aff_history - ?", entry.Commission),
+	}).Error
+}
+
+// AffiliateConsumptionWindow 返回时间点所在的消耗结算窗口 [start, end)
+func AffiliateConsumptionWindow(timestamp int64) (int64, int64) {
+	start := timestamp - timestamp%affiliateConsumptionWindowSeconds
+	return start, start + affiliateConsumptionWindowSeconds
+}
+
+// SettleAffiliateConsumptionCommissions 汇总被邀请用户在窗口内的消耗并计提返佣（幂等），返回新增的返佣记录数。
+// 消耗数据来自消费日志，需开启消费日志记录
+func SettleAffiliateConsumptionCommissions(windowStart int64, windowEnd int64) (int, error) {
+	if !operation_setting.IsAffiliateCommissionEnabled(operation_setting.AffiliateBasisConsumption) {
+		return 0, nil
+	}
+	if windowEnd <= windowStart {
+		return 0, errors.New("无效的结算窗口")
+	}
+	settled := 0
+	lastId := 0
+	for {
+		var invitees []User
+		if err := DB.Select("id").Where("inviter_id > 0 AND id > ?", lastId).
+			Order("id").Limit(500).Find(&invitees).Error; err != nil {
+			return settled, err
+		}
+		if len(invitees) == 0 {
+			return settled, nil
+		}
+		ids := make([]int, 0, len(invitees))
+		for _, invitee := range invitees {
+			ids = append(ids, invitee.Id)
+		}
+		lastId = ids[len(ids)-1]
+
+		type usageRow struct {
+			UserId int
+			Quota  int64
+		}
+		var rows []usageRow
+		if err := LOG_DB.Model(&Log{}).Select("user_id, COALESCE(SUM(quota), 0) as quota").
+			Where("type = ? AND user_id IN ? AND created_at >= ? AND created_at < ?", LogTypeConsume, ids, windowStart, windowEnd).
+			Group("user_id").Scan(&rows).Error; err != nil {
+			return settled, err
+		}
+		for _, row := range rows {
+			if row.Quota <= 0 {
+				continue
+			}
+			ref := fmt.Sprintf("consume:%d:%d", row.UserId, windowStart)
+			var entry *AffiliateCommission
+			err := DB.Transaction(func(tx *gorm.DB) error {
+				var err error
+				entry, err = accrueAffiliateCommissionTx(tx, row.UserId, AffiliateCommissionSourceConsumption, ref, row.Quota)
+				return err
+			})
+			if err != nil {
+				return settled, err
+			}
+			if entry != nil {
+				settled++
+			}
+		}
+		if len(invitees) < 500 {
+			return settled, nil
+		}
+	}
+}
+
+// GetUserAffiliateCommissions 邀请人查看自己的返佣流水
+func GetUserAffiliateCommissions(inviterId int, pageInfo *common.PageInfo) (entries []*AffiliateCommission, total int64, err error) {
+	query := DB.Model(&AffiliateCommission{}).Where("inviter_id = ?", inviterId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&entries).Error
+	return entries, total, err
+}
+
+// GetUserAffiliateCommissionSummary 邀请人的返佣汇总
+func GetUserAffiliateCommissionSummary(inviterId int) (*AffiliateCommissionSummary, error) {
+	var user User
+	if err := DB.Select("id", "aff_count", "aff_quota", "aff_history").Where("id = ?", inviterId).First(&user).Error; err != nil {
+		return nil, err
+	}
+	summary := &AffiliateCommissionSummary{
+		Invitees:        int64(user.AffCount),
+		AffQuota:        user.AffQuota,
+		AffHistoryQuota: user.AffHistoryQuota,
+	}
+	type row struct {
+		Status     string
+		BaseQuota  int64
+		Commission int64
+	}
+	var rows []row
+	if err := DB.Model(&AffiliateCommission{}).Select("status, SUM(base_quota) as base_quota, SUM(commission) as commission").
+		Where("inviter_id = ?", inviterId).Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		switch r.Status {
+		case AffiliateCommissionStatusAccrued:
+			summary.BaseQuota = r.BaseQuota
+			summary.Commission = r.Commission
+		case AffiliateCommissionStatusReversed:
+			summary.ReversedQuota = r.Commission
+		}
+	}
+	summary.CurrentPercent = operation_setting.GetAffiliateSetting().CommissionPercent(summary.BaseQuota)
+	return summary, nil
+}
+
+// GetAffiliatePayoutReport 管理员返佣结算报表：按邀请人汇总时间范围内计提和冲回的返佣
+func GetAffiliatePayoutReport(inviterId int, startTime int64, endTime int64, pageInfo *common.PageInfo) ([]*AffiliatePayoutRow, int64, error) {
+	query := DB.Model(&AffiliateCommission{})
+	if inviterId > 0 {
+		query = query.Where("inviter_id = ?", inviterId)
+	}
+	if startTime > 0 {
+		query = query.Where("created_at >= ?", startTime)
+	}
+	if endTime > 0 {
+		query = query.Where("created_at <= ?", endTime)
+	}
+	var total int64
+	if err := query.Session(&gorm.Session{}).Distinct("inviter_id").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []*AffiliatePayoutRow
+	err := query.Select("inviter_id, count(distinct invitee_id) as invitees, count(*) as entries, "+
+		"SUM(CASE WHEN status = ? THEN base_quota ELSE 0 END) as base_quota, "+
+		"SUM(CASE WHEN status = ? THEN commission ELSE 0 END) as commission, "+
+		"SUM(CASE WHEN status = ? THEN commission ELSE 0 END) as reversed_quota",
+		AffiliateCommissionStatusAccrued, AffiliateCommissionStatusAccrued, AffiliateCommissionStatusReversed).
+		Group("inviter_id").Order("commission desc").
+		Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(rows) == 0 {
+		return rows, total, nil
+	}
+	ids := make([]int, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.InviterId)
+	}
+	var users []User
+	if err := DB.Unscoped().Select("id", "username", "aff_quota").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	userMap := make(map[int]User, len(users))
+	for _, user := range users {
+		userMap[user.Id] = user
+	}
+	for _, row := range rows {
+		if user, ok := userMap[row.InviterId]; ok {
+			row.Username = user.Username
+			row.AffQuota = user.AffQuota
+		}
+	}
+	return rows, total, nil
+}
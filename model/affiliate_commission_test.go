@@ -0,0 +1,118 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAffiliateCommission(t *testing.T, basis string) {
+	t.Helper()
+	setupTopUpTables(t)
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM logs")
+	})
+	setting := operation_setting.GetAffiliateSetting()
+	original := *setting
+	t.Cleanup(func() { *setting = original })
+	setting.CommissionEnabled = true
+	setting.Basis = basis
+	setting.Percent = 10
+	setting.DurationDays = 0
+	setting.Tiers = nil
+}
+
+func TestAffiliateCommission_TopUpTiersAndRefund(t *testing.T) {
+	setupAffiliateCommission(t, operation_setting.AffiliateBasisTopUp)
+	base := int64(10 * common.QuotaPerUnit)
+	operation_setting.GetAffiliateSetting().Tiers = []operation_setting.AffiliateCommissionTier{{MinQuota: base, Percent: 20}}
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "aff_inviter", AffCode: "af01"}).Error)
+	require.NoError(t, DB.Create(&User{Id: 2, Username: "aff_invitee", AffCode: "af02", InviterId: 1,
+		InvitedTime: common.GetTimestamp()}).Error)
+
+	for _, tradeNo := range []string{"USR2NOaff1", "USR2NOaff2"} {
+		require.NoError(t, (&TopUp{UserId: 2, Amount: 10, Money: 70, TradeNo: tradeNo, PaymentMethod: "alipay",
+			Status: common.TopUpStatusPending}).Insert())
+		require.NoError(t, RechargeEpay(tradeNo))
+	}
+	// 重复回调不重复返佣
+	require.NoError(t, RechargeEpay("USR2NOaff2"))
+
+	var inviter User
+	require.NoError(t, DB.First(&inviter, 1).Error)
+	// 首笔按基础比例 10%，累计流水达到阶梯后按 20%
+	assert.Equal(t, int(base/10+base/5), inviter.AffQuota)
+	assert.Equal(t, int(base/10+base/5), inviter.AffHistoryQuota)
+
+	summary, err := GetUserAffiliateCommissionSummary(1)
+	require.NoError(t, err)
+	assert.Equal(t, 2*base, summary.BaseQuota)
+	assert.Equal(t, 20.0, summary.CurrentPercent)
+
+	_, err = RefundPaymentOrder("USR2NOaff2", RefundSourceAdmin, "")
+	require.NoError(t, err)
+	require.NoError(t, DB.First(&inviter, 1).Error)
+	assert.Equal(t, int(base/10), inviter.AffQuota)
+
+	rows, total, err := GetAffiliatePayoutReport(0, 0, 0, &common.PageInfo{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "aff_inviter", rows[0].Username)
+	assert.Equal(t, int64(2), rows[0].Entries)
+	assert.Equal(t, base/10, rows[0].Commission)
+	assert.Equal(t, base/5, rows[0].ReversedQuota)
+}
+
+func TestAffiliateCommission_Duration(t *testing.T) {
+	setupAffiliateCommission(t, operation_setting.AffiliateBasisTopUp)
+	operation_setting.GetAffiliateSetting().DurationDays = 30
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "aff_old_inviter", AffCode: "af03"}).Error)
+	require.NoError(t, DB.Create(&User{Id: 2, Username: "aff_old_invitee", AffCode: "af04", InviterId: 1,
+		InvitedTime: common.GetTimestamp() - 31*24*60*60}).Error)
+	require.NoError(t, (&TopUp{UserId: 2, Amount: 10, Money: 70, TradeNo: "USR2NOold", PaymentMethod: "alipay",
+		Status: common.TopUpStatusPending}).Insert())
+	require.NoError(t, RechargeEpay("USR2NOold"))
+
+	var inviter User
+	require.NoError(t, DB.First(&inviter, 1).Error)
+	assert.Equal(t, 0, inviter.AffQuota)
+}
+
+func TestSettleAffiliateConsumptionCommissions(t *testing.T) {
+	setupAffiliateCommission(t, operation_setting.AffiliateBasisConsumption)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "aff_c_inviter", AffCode: "af05"}).Error)
+	require.NoError(t, DB.Create(&User{Id: 2, Username: "aff_c_invitee", AffCode: "af06", InviterId: 1}).Error)
+	require.NoError(t, DB.Create(&User{Id: 3, Username: "aff_c_other", AffCode: "af07"}).Error)
+
+	start, end := AffiliateConsumptionWindow(common.GetTimestamp())
+	for _, log := range []*Log{
+		{UserId: 2, Type: LogTypeConsume, Quota: 3000, CreatedAt: start + 10},
+		{UserId: 2, Type: LogTypeConsume, Quota: 7000, CreatedAt: start + 20},
+		{UserId: 2, Type: LogTypeConsume, Quota: 9000, CreatedAt: end + 1},
+		{UserId: 3, Type: LogTypeConsume, Quota: 5000, CreatedAt: start + 10},
+	} {
+		require.NoError(t, LOG_DB.Create(log).Error)
+	}
+
+	n, err := SettleAffiliateConsumptionCommissions(start, end)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = SettleAffiliateConsumptionCommissions(start, end)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	var inviter User
+	require.NoError(t, DB.First(&inviter, 1).Error)
+	assert.Equal(t, 1000, inviter.AffQuota)
+
+	entries, total, err := GetUserAffiliateCommissions(1, &common.PageInfo{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(10000), entries[0].BaseQuota)
+	assert.Equal(t, AffiliateCommissionSourceConsumption, entries[0].Source)
+}
@@ -0,0 +1,221 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Margin report dimensions
+const (
+	MarginGroupByChannel = "channel"
+	MarginGroupByModel   = "model"
+	MarginGroupByGroup   = "group"
+	MarginGroupByDay     = "day"
+	MarginGroupByHour    = "hour"
+)
+
+// channelCostSettings 获取渠道成本配置，优先使用请求上下文中已加载的渠道设置
+func channelCostSettings(c *gin.Context, channelId int) (dto.ChannelOtherSettings, bool) {
+	if channelId <= 0 {
+		return dto.ChannelOtherSettings{}, false
+	}
+	if c != nil && common.GetContextKeyInt(c, constant.ContextKeyChannelId) == channelId {
+		if settings, ok := common.GetContextKeyType[dto.ChannelOtherSettings](c, constant.ContextKeyChannelOtherSetting); ok {
+			return settings, true
+		}
+	}
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		return dto.ChannelOtherSettings{}, false
+	}
+	return channel.GetOtherSettings(), true
+}
+
+func otherFloat(other map[string]interface{}, key string) (float64, bool) {
+	switch v := other[key].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// UpstreamUsage 计算上游成本所需的用量
+type UpstreamUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	CacheTokens      int     // 命中缓存的输入 token 数，包含在 PromptTokens 中
+	ModelPrice       float64 // 按次计费模型的基础价格（不含分组倍率），不大于 0 时按 token 计费
+	UsageRatio       float64 // 按次计费的用量倍数（任务时长、分辨率等），不大于 0 时按 1 计算
+}
+
+// CalcUpstreamCost 按模型公开价格、用量和渠道成本倍率计算请求的上游成本额度。
+// 成本与向用户收取的额度无关，分组倍率、免费分组和单次请求的价格调整都不影响成本。
+func CalcUpstreamCost(settings dto.ChannelOtherSettings, modelName string, usage UpstreamUsage) int {
+	costRatio := settings.GetCostRatio(modelName)
+	if costRatio <= 0 {
+		return 0
+	}
+	var publicQuota float64
+	if usage.ModelPrice > 0 {
+		usageRatio := usage.UsageRatio
+		if usageRatio <= 0 {
+			usageRatio = 1
+		}
+		publicQuota = usage.ModelPrice * common.QuotaPerUnit * usageRatio
+	} else {
+		modelRatio, _, _ := ratio_setting.GetModelRatio(modelName)
+		cacheRatio, ok := ratio_setting.GetCacheRatio(modelName)
+		if !ok {
+			cacheRatio = 1
+		}
+		cacheTokens := min(max(usage.CacheTokens, 0), usage.PromptTokens)
+		tokens := float64(usage.PromptTokens-cacheTokens) + float64(cacheTokens)*cacheRatio +
+			float64(usage.CompletionTokens)*ratio_setting.GetCompletionRatio(modelName)
+		publicQuota = tokens * modelRatio
+	}
+	return int(math.Round(publicQuota * costRatio))
+}
+
+// CalcChannelUpstreamCost 按渠道的成本配置计算上游成本额度，渠道不存在时返回 0
+func CalcChannelUpstreamCost(channelId int, modelName string, usage UpstreamUsage) int {
+	return calcLogUpstreamCost(nil, channelId, modelName, usage)
+}
+
+func calcLogUpstreamCost(c *gin.Context, channelId int, modelName string, usage UpstreamUsage) int {
+	settings, ok := channelCostSettings(c, channelId)
+	if !ok {
+		return 0
+	}
+	return CalcUpstreamCost(settings, modelName, usage)
+}
+
+// MarginReportRow 收入、上游成本和毛利汇总
+type MarginReportRow struct {
+	Dimension    string  `json:"dimension"`
+	ChannelName  string  `json:"channel_name,omitempty"`
+	RequestCount int64   `json:"request_count"`
+	Revenue      int64   `json:"revenue"`
+	UpstreamCost int64   `json:"upstream_cost"`
+	Margin       int64   `json:"margin"`
+	MarginRate   float64 `json:"margin_rate"`
+}
+
+type MarginReportFilter struct {
+	GroupBy        string
+	StartTimestamp int64
+	EndTimestamp   int64
+	Channel        int
+	ModelName      string
+	Group          string
+}
+
+// timeBucketExpr 按服务器时区将时间戳列对齐到时间段起点
+func timeBucketExpr(column string, seconds int64) string {
+	_, offset := time.Now().Zone()
+	return fmt.Sprintf("(%s + %d) - ((%s + %d) %% %d) - %d", column, offset, column, offset, seconds, offset)
+}
+
+// GetMarginReport 按渠道、模型、分组或时间段汇总消费日志中的收入与上游成本
+func GetMarginReport(filter MarginReportFilter) ([]*MarginReportRow, error) {
+	var dimension string
+	switch filter.GroupBy {
+	case MarginGroupByChannel, "":
+		filter.GroupBy = MarginGroupByChannel
+		dimension = "channel_id"
+	case MarginGroupByModel:
+		dimension = "model_name"
+	case MarginGroupByGroup:
+		dimension = logGroupCol
+	case MarginGroupByDay:
+		dimension = timeBucketExpr("created_at", 86400)
+	case MarginGroupByHour:
+		dimension = timeBucketExpr("created_at", 3600)
+	default:
+		return nil, errors.New("不支持的汇总维度")
+	}
+
+	// 异步任务失败或差额结算的退款日志冲减收入和成本，差额补扣的系统日志计入收入和成本但不计请求数；
+	// 充值退款等不属于某个渠道的日志不计入
+	tx := LOG_DB.Table("logs").
+		Select(dimension+" as dimension, "+
+			"COALESCE(sum(CASE WHEN type = ? THEN 1 ELSE 0 END),0) as request_count, "+
+			"COALESCE(sum(CASE WHEN type = ? THEN -quota ELSE quota END),0) as revenue, "+
+			"COALESCE(sum(CASE WHEN type = ? THEN -upstream_cost ELSE upstream_cost END),0) as upstream_cost",
+			LogTypeConsume, LogTypeRefund, LogTypeRefund).
+		Where("(type = ? OR (type IN ? AND channel_id > 0))", LogTypeConsume, []int{LogTypeRefund, LogTypeSystem})
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	if filter.Channel != 0 {
+		tx = tx.Where("channel_id = ?", filter.Channel)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.Group != "" {
+		tx = tx.Where(logGroupCol+" = ?", filter.Group)
+	}
+	order := "revenue desc"
+	if filter.GroupBy == MarginGroupByDay || filter.GroupBy == MarginGroupByHour {
+		order = "dimension asc"
+	}
+	var rows []*MarginReportRow
+	if err := tx.Group(dimension).Order(order).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		row.Margin = row.Revenue - row.UpstreamCost
+		if row.Revenue > 0 {
+			row.MarginRate = math.Round(float64(row.Margin)/float64(row.Revenue)*10000) / 10000
+		}
+	}
+	if filter.GroupBy == MarginGroupByChannel {
+		fillMarginChannelNames(rows)
+	}
+	return rows, nil
+}
+
+func fillMarginChannelNames(rows []*MarginReportRow) {
+	ids := make([]int, 0, len(rows))
+	for _, row := range rows {
+		if id, err := strconv.Atoi(row.Dimension); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	var channels []struct {
+		Id   int    `gorm:"column:id"`
+		Name string `gorm:"column:name"`
+	}
+	if err := DB.Table("channels").Select("id, name").Where("id IN ?", ids).Find(&channels).Error; err != nil {
+		return
+	}
+	names := make(map[string]string, len(channels))
+	for _, channel := range channels {
+		names[strconv.Itoa(channel.Id)] = channel.Name
+	}
+	for _, row := range rows {
+		row.ChannelName = names[row.Dimension]
+	}
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withColumnNames 初始化按数据库类型引用的列名，结束后恢复，TestMain 不调用 initCol
+func withColumnNames(t *testing.T) {
+	t.Helper()
+	groupCol, keyCol, trueVal, falseVal := commonGroupCol, commonKeyCol, commonTrueVal, commonFalseVal
+	logGroup, logKey := logGroupCol, logKeyCol
+	t.Cleanup(func() {
+		commonGroupCol, commonKeyCol, commonTrueVal, commonFalseVal = groupCol, keyCol, trueVal, falseVal
+		logGroupCol, logKeyCol = logGroup, logKey
+	})
+	initCol()
+}
+
+func withCostModelRatios(t *testing.T) {
+	t.Helper()
+	modelRatio, completionRatio, cacheRatio := ratio_setting.ModelRatio2JSONString(), ratio_setting.CompletionRatio2JSONString(), ratio_setting.CacheRatio2JSONString()
+	t.Cleanup(func() {
+		_ = ratio_setting.UpdateModelRatioByJSONString(modelRatio)
+		_ = ratio_setting.UpdateCompletionRatioByJSONString(completionRatio)
+		_ = ratio_setting.UpdateCacheRatioByJSONString(cacheRatio)
+	})
+	require.NoError(t, ratio_setting.UpdateModelRatioByJSONString(`{"cost-model":2,"cheap-model":0.5}`))
+	require.NoError(t, ratio_setting.UpdateCompletionRatioByJSONString(`{"cost-model":4,"cheap-model":1}`))
+	require.NoError(t, ratio_setting.UpdateCacheRatioByJSONString(`{"cost-model":0.1}`))
+}
+
+func TestCalcUpstreamCost(t *testing.T) {
+	withCostModelRatios(t)
+	settings := dto.ChannelOtherSettings{
+		CostRatio:      common.GetPointer(0.5),
+		ModelCostRatio: map[string]float64{"cheap-model": 0.8},
+	}
+	usage := UpstreamUsage{PromptTokens: 1000, CompletionTokens: 100, CacheTokens: 500}
+
+	// 按公开价格计算：(500 + 500*0.1 + 100*4) * 2 = 1900
+	assert.Equal(t, 1900, CalcUpstreamCost(dto.ChannelOtherSettings{}, "cost-model", usage))
+	assert.Equal(t, 950, CalcUpstreamCost(settings, "cost-model", usage))
+	assert.Equal(t, 440, CalcUpstreamCost(settings, "cheap-model", UpstreamUsage{PromptTokens: 1000, CompletionTokens: 100}))
+	// 按次计费：价格 * 用量倍数
+	assert.Equal(t, int(0.04*common.QuotaPerUnit*5*0.5), CalcUpstreamCost(settings, "video-model", UpstreamUsage{ModelPrice: 0.04, UsageRatio: 5}))
+	assert.Equal(t, int(0.04*common.QuotaPerUnit*0.5), CalcUpstreamCost(settings, "video-model", UpstreamUsage{ModelPrice: 0.04}))
+	assert.Equal(t, 0, CalcUpstreamCost(settings, "cost-model", UpstreamUsage{}))
+}
+
+func TestRecordConsumeLogUpstreamCost(t *testing.T) {
+	truncateTables(t)
+	withCostModelRatios(t)
+	require.NoError(t, DB.Create(&Channel{Id: 53, Name: "cost", Key: "k3", OtherSettings: `{"cost_ratio":0.5}`}).Error)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	// 免费分组不向用户收费，上游成本仍按用量记录
+	RecordConsumeLog(c, 1, RecordConsumeLogParams{
+		ChannelId: 53, ModelName: "cost-model", PromptTokens: 1000, CompletionTokens: 100, Quota: 0,
+		Other: map[string]interface{}{"group_ratio": 0.0, "model_price": -1.0, "cache_tokens": 500},
+	})
+	var log Log
+	require.NoError(t, LOG_DB.Order("id desc").First(&log).Error)
+	assert.Equal(t, 0, log.Quota)
+	assert.Equal(t, 950, log.UpstreamCost)
+}
+
+func TestGetMarginReport(t *testing.T) {
+	truncateTables(t)
+	withColumnNames(t)
+
+	require.NoError(t, DB.Create(&Channel{Id: 51, Name: "cheap", Key: "k1"}).Error)
+	require.NoError(t, DB.Create(&Channel{Id: 52, Name: "retail", Key: "k2"}).Error)
+	logs := []*Log{
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: 1000, ChannelId: 51, ModelName: "gpt-4o", Group: "default", Quota: 1000, UpstreamCost: 400},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: 1000, ChannelId: 51, ModelName: "claude", Group: "vip", Quota: 500, UpstreamCost: 100},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: 1000, ChannelId: 52, ModelName: "gpt-4o", Group: "default", Quota: 200, UpstreamCost: 200},
+		{UserId: 1, Type: LogTypeTopup, CreatedAt: 1000, ChannelId: 52, Quota: 9999},
+		// 异步任务退款冲减收入和成本，充值退款不计入
+		{UserId: 1, Type: LogTypeRefund, CreatedAt: 1000, ChannelId: 51, ModelName: "claude", Group: "vip", Quota: 100, UpstreamCost: 20},
+		{UserId: 1, Type: LogTypeRefund, CreatedAt: 1000, Quota: 5000},
+		// 异步任务差额补扣计入收入和成本，但不计请求数
+		{UserId: 1, Type: LogTypeSystem, CreatedAt: 1000, ChannelId: 52, ModelName: "gpt-4o", Group: "default", Quota: 50, UpstreamCost: 30},
+		{UserId: 1, Type: LogTypeSystem, CreatedAt: 1000, Quota: 0},
+	}
+	require.NoError(t, LOG_DB.Create(&logs).Error)
+
+	rows, err := GetMarginReport(MarginReportFilter{GroupBy: MarginGroupByChannel})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "51", rows[0].Dimension)
+	assert.Equal(t, "cheap", rows[0].ChannelName)
+	assert.Equal(t, int64(2), rows[0].RequestCount)
+	assert.Equal(t, int64(1400), rows[0].Revenue)
+	assert.Equal(t, int64(480), rows[0].UpstreamCost)
+	assert.Equal(t, int64(920), rows[0].Margin)
+	assert.Equal(t, int64(1), rows[1].RequestCount)
+	assert.Equal(t, int64(250), rows[1].Revenue)
+	assert.Equal(t, int64(20), rows[1].Margin)
+
+	rows, err = GetMarginReport(MarginReportFilter{GroupBy: MarginGroupByModel, Group: "default"})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "gpt-4o", rows[0].Dimension)
+	assert.Equal(t, int64(2), rows[0].RequestCount)
+
+	rows, err = GetMarginReport(MarginReportFilter{GroupBy: MarginGroupByDay})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(1650), rows[0].Revenue)
+
+	_, err = GetMarginReport(MarginReportFilter{GroupBy: "token"})
+	assert.Error(t, err)
+}
@@ -106,6 +106,11 @@ func userCheckinWithTransaction(checkin *Checkin, userId int, quotaAwarded int)
 			return errors.New("签到失败：更新额度出错")
 		}
 
+		// 步骤3: 记录额度账本
+		if err := RecordCreditGrantTx(tx, userId, CreditSourceCheckin, checkin.CheckinDate, int64(quotaAwarded)); err != nil {
+			return errors.New("签到失败：记录额度出错")
+		}
+
 		return nil
 	})
 
@@ -136,6 +141,7 @@ func userCheckinWithoutTransaction(checkin *Checkin, userId int, quotaAwarded in
 		DB.Delete(checkin)
 		return nil, errors.New("签到失败：更新额度出错")
 	}
+	RecordCreditGrant(userId, CreditSourceCheckin, checkin.CheckinDate, int64(quotaAwarded))
 
 	return checkin, nil
 }
@@ -0,0 +1,416 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"gorm.io/gorm"
+)
+
+// Credit grant sources
+const (
+	CreditSourceTopUp        = "topup"
+	CreditSourceRedemption   = "redemption"
+	CreditSourceCheckin      = "checkin"
+	CreditSourcePromotion    = "promotion"
+	CreditSourceAffiliate    = "affiliate"
+	CreditSourceAdmin        = "admin"
+	CreditSourceSubscription = "subscription" // 套餐降级时按剩余价值退回的额度
+	CreditSourceRecurring    = "recurring"    // 定期赠送的额度
+)
+
+// Credit grant status
+const (
+	CreditGrantStatusActive    = "active"
+	CreditGrantStatusExhausted = "exhausted"
+	CreditGrantStatusExpired   = "expired"
+)
+
+// CreditGrant 额度授予记录（额度账本）。
+// User.Quota 仍是用户可用余额的唯一来源，CreditGrant 记录余额的组成和到期时间；
+// 启用额度账本后，钱包扣费按到期时间先后（永不过期的最后）消耗授予额度。
+type CreditGrant struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id" gorm:"index;index:idx_credit_grant_user_status,priority:1"`
+	Source    string `json:"source" gorm:"type:varchar(32);index"`
+	SourceRef string `json:"source_ref" gorm:"type:varchar(255);default:''"`
+	Amount    int64  `json:"amount" gorm:"type:bigint;not null;default:0"`
+	Remaining int64  `json:"remaining" gorm:"type:bigint;not null;default:0"`
+	Forfeited int64  `json:"forfeited" gorm:"type:bigint;not null;default:0"` // 因到期作废、未再计入余额的额度
+	ExpiresAt int64  `json:"expires_at" gorm:"type:bigint;default:0;index"`   // 0 表示永不过期
+	Status    string `json:"status" gorm:"type:varchar(16);index;index:idx_credit_grant_user_status,priority:2"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+	UpdatedAt int64  `json:"updated_at" gorm:"bigint"`
+}
+
+func (g *CreditGrant) BeforeCreate(tx *gorm.DB) error {
+	now := common.GetTimestamp()
+	g.CreatedAt = now
+	g.UpdatedAt = now
+	return nil
+}
+
+func (g *CreditGrant) BeforeUpdate(tx *gorm.DB) error {
+	g.UpdatedAt = common.GetTimestamp()
+	return nil
+}
+
+// CreditGrantAllocation 记录一次扣费从某条授予记录中消耗的额度，用于退款时原路返还。
+type CreditGrantAllocation struct {
+	GrantId int
+	Amount  int64
+}
+
+// CreditSourceBalance 按来源汇总的余额
+type CreditSourceBalance struct {
+	Source    string `json:"source"`
+	Remaining int64  `json:"remaining"`
+}
+
+// CreditBalance 用户余额构成
+type CreditBalance struct {
+	Quota        int                   `json:"quota"`
+	Sources      []CreditSourceBalance `json:"sources"`
+	Untracked    int64                 `json:"untracked"`     // 未被账本记录的余额（启用账本前的余额、管理员直接修改等），永不过期
+	ExpiringSoon int64                 `json:"expiring_soon"` // 7 天内到期的额度
+	NextExpireAt int64                 `json:"next_expire_at"`
+}
+
+// CreditGrantExpireTime 根据来源的默认有效天数计算到期时间，0 表示永不过期。
+func CreditGrantExpireTime(source string) int64 {
+	setting := operation_setting.GetCreditLedgerSetting()
+	days := 0
+	switch source {
+	case CreditSourceTopUp:
+		days = setting.TopUpExpireDays
+	case CreditSourceRedemption:
+		days = setting.RedemptionExpireDays
+	case CreditSourceCheckin:
+		days = setting.CheckinExpireDays
+	case CreditSourcePromotion:
+		days = setting.PromotionExpireDays
+	case CreditSourceAffiliate:
+		days = setting.AffiliateExpireDays
+	}
+	if days <= 0 {
+		return 0
+	}
+	return common.GetTimestamp() + int64(days)*24*3600
+}
+
+// RecordCreditGrantTx 在事务中记录一笔授予额度，使用来源的默认有效期。
+// 未启用额度账本时不记录。
+func RecordCreditGrantTx(tx *gorm.DB, userId int, source string, sourceRef string, amount int64) error {
+	return RecordCreditGrantWithExpiryTx(tx, userId, source, sourceRef, amount, CreditGrantExpireTime(source))
+}
+
+// RecordCreditGrantWithExpiryTx 在事务中记录一笔指定到期时间的授予额度。
+func RecordCreditGrantWithExpiryTx(tx *gorm.DB, userId int, source string, sourceRef string, amount int64, expiresAt int64) error {
+	if !operation_setting.IsCreditLedgerEnabled() || amount <= 0 || userId <= 0 {
+		return nil
+	}
+	if tx == nil {
+		tx = DB
+	}
+	grant := &CreditGrant{
+		UserId:    userId,
+		Source:    source,
+		SourceRef: sourceRef,
+		Amount:    amount,
+		Remaining: amount,
+		ExpiresAt: expiresAt,
+		Status:    CreditGrantStatusActive,
+	}
+	return tx.Create(grant).Error
+}
+
+// RecordCreditGrant 记录一笔授予额度（额度已由调用方加到 User.Quota 上）。
+func RecordCreditGrant(userId int, source string, sourceRef string, amount int64) {
+	if err := RecordCreditGrantTx(nil, userId, source, sourceRef, amount); err != nil {
+		common.SysLog(fmt.Sprintf("failed to record credit grant (userId=%d, source=%s): %s", userId, source, err.Error()))
+	}
+}
+
+// GrantCreditWithExpiry 增加用户额度并记录授予记录，用于管理员发放促销额度。
+func GrantCreditWithExpiry(userId int, source string, sourceRef string, amount int64, expiresAt int64) error {
+	if userId <= 0 {
+		return errors.New("invalid user id")
+	}
+	if amount <= 0 {
+		return errors.New("额度必须大于0")
+	}
+	if expiresAt != 0 && expiresAt <= common.GetTimestamp() {
+		return errors.New("到期时间必须晚于当前时间")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userId).
+			Update("quota", gorm.Expr("quota + ?", amount)).Error; err != nil {
+			return err
+		}
+		return RecordCreditGrantWithExpiryTx(tx, userId, source, sourceRef, amount, expiresAt)
+	})
+	if err != nil {
+		return err
+	}
+	_ = cacheIncrUserQuota(userId, amount)
+	return nil
+}
+
+func creditGrantConsumeOrder() string {
+	// 有到期时间的优先，到期越早越先消耗；永不过期的最后消耗
+	return "CASE WHEN expires_at = 0 THEN 1 ELSE 0 END, expires_at asc, id asc"
+}
+
+// ConsumeCreditGrants 按到期时间先后消耗用户的授予额度，返回每条授予记录的消耗明细。
+// 授予额度不足时只消耗可用部分，剩余部分视为从未记录的余额中扣除。
+// 注意：本函数只维护账本，User.Quota 的扣减由调用方负责。
+func ConsumeCreditGrants(userId int, amount int64) ([]CreditGrantAllocation, error) {
+	if userId <= 0 || amount <= 0 {
+		return nil, nil
+	}
+	var allocations []CreditGrantAllocation
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		allocations, err = ConsumeCreditGrantsTx(tx, userId, amount)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allocations, nil
+}
+
+// ConsumeCreditGrantsTx 在事务中消耗授予额度，供与 User.Quota 扣减在同一事务中完成的调用方使用。
+// 未启用额度账本时不做任何处理。
+func ConsumeCreditGrantsTx(tx *gorm.DB, userId int, amount int64) ([]CreditGrantAllocation, error) {
+	if !operation_setting.IsCreditLedgerEnabled() || userId <= 0 || amount <= 0 {
+		return nil, nil
+	}
+	now := common.GetTimestamp()
+	var grants []CreditGrant
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("user_id = ? AND status = ? AND remaining > 0 AND (expires_at = 0 OR expires_at > ?)",
+			userId, CreditGrantStatusActive, now).
+		Order(creditGrantConsumeOrder()).
+		Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	var allocations []CreditGrantAllocation
+	left := amount
+	for i := range grants {
+		if left <= 0 {
+			break
+		}
+		grant := &grants[i]
+		take := grant.Remaining
+		if take > left {
+			take = left
+		}
+		updates := map[string]interface{}{
+			"remaining":  gorm.Expr("remaining - ?", take),
+			"updated_at": now,
+		}
+		if take == grant.Remaining {
+			updates["status"] = CreditGrantStatusExhausted
+		}
+		if err := tx.Model(&CreditGrant{}).Where("id = ?", grant.Id).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, CreditGrantAllocation{GrantId: grant.Id, Amount: take})
+		left -= take
+	}
+	return allocations, nil
+}
+
+// RestoreCreditGrants 将消耗明细按相反顺序返还到原授予记录。
+// 已过期的授予记录不再返还，返回值 forfeited 为因此作废的额度，调用方不应把这部分加回 User.Quota。
+func RestoreCreditGrants(allocations []CreditGrantAllocation, amount int64) (restored []CreditGrantAllocation, forfeited int64, err error) {
+	if amount <= 0 || len(allocations) == 0 {
+		return nil, 0, nil
+	}
+	now := common.GetTimestamp()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		restored = restored[:0]
+		forfeited = 0
+		left := amount
+		for i := len(allocations) - 1; i >= 0 && left > 0; i-- {
+			alloc := allocations[i]
+			give := alloc.Amount
+			if give > left {
+				give = left
+			}
+			if give <= 0 {
+				continue
+			}
+			var grant CreditGrant
+			if err := tx.Set("gorm:query_option", "FOR UPDATE").
+				Where("id = ?", alloc.GrantId).First(&grant).Error; err != nil {
+				return err
+			}
+			if grant.Status == CreditGrantStatusExpired || (grant.ExpiresAt > 0 && grant.ExpiresAt <= now) {
+				forfeited += give
+				if err := tx.Model(&CreditGrant{}).Where("id = ?", grant.Id).
+					Update("forfeited", gorm.Expr("forfeited + ?", give)).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Model(&CreditGrant{}).Where("id = ?", grant.Id).Updates(map[string]interface{}{
+				"remaining":  gorm.Expr("remaining + ?", give),
+				"status":     CreditGrantStatusActive,
+				"updated_at": now,
+			}).Error; err != nil {
+				return err
+			}
+			restored = append(restored, CreditGrantAllocation{GrantId: alloc.GrantId, Amount: give})
+			left -= give
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return restored, forfeited, nil
+}
+
+// ExpireDueCreditGrants 将到期的授予记录标记为过期，并从用户余额中扣除其剩余额度。
+func ExpireDueCreditGrants(limit int) (int, error) {
+	if limit <= 0 {
+		limit = 200
+	}
+	now := GetDBTimestamp()
+	var grants []CreditGrant
+	if err := DB.Where("status = ? AND expires_at > 0 AND expires_at <= ?", CreditGrantStatusActive, now).
+		Order("expires_at asc, id asc").
+		Limit(limit).
+		Find(&grants).Error; err != nil {
+		return 0, err
+	}
+	expiredCount := 0
+	for _, candidate := range grants {
+		grantId := candidate.Id
+		var userId int
+		var deducted int64
+		var source string
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			var grant CreditGrant
+			if err := tx.Set("gorm:query_option", "FOR UPDATE").
+				Where("id = ? AND status = ?", grantId, CreditGrantStatusActive).
+				First(&grant).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil
+				}
+				return err
+			}
+			userId = grant.UserId
+			source = grant.Source
+			deducted = grant.Remaining
+			if deducted > 0 {
+				// 账本记录的剩余额度之和超过余额时，说明超出部分已被未经账本的路径（管理员修改、后付费透支等）花掉。
+				// 按先到期先消耗的规则，这部分归属最早到期的授予记录，只扣除账本仍能对应到余额上的部分
+				var quota, tracked int64
+				if err := tx.Model(&User{}).Where("id = ?", grant.UserId).Select("quota").Scan(&quota).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&CreditGrant{}).Select("COALESCE(SUM(remaining), 0)").
+					Where("user_id = ? AND status = ?", grant.UserId, CreditGrantStatusActive).
+					Scan(&tracked).Error; err != nil {
+					return err
+				}
+				if overTracked := tracked - quota; overTracked > 0 {
+					deducted -= overTracked
+				}
+				if deducted < 0 {
+					deducted = 0
+				}
+				if deducted > 0 {
+					if err := tx.Model(&User{}).Where("id = ?", grant.UserId).
+						Update("quota", gorm.Expr("quota - ?", deducted)).Error; err != nil {
+						return err
+					}
+				}
+			}
+			if err := tx.Model(&CreditGrant{}).Where("id = ?", grant.Id).Updates(map[string]interface{}{
+				"remaining":  0,
+				"forfeited":  gorm.Expr("forfeited + ?", deducted),
+				"status":     CreditGrantStatusExpired,
+				"updated_at": common.GetTimestamp(),
+			}).Error; err != nil {
+				return err
+			}
+			expiredCount++
+			return nil
+		})
+		if err != nil {
+			return expiredCount, err
+		}
+		if deducted > 0 && userId > 0 {
+			_ = cacheDecrUserQuota(userId, deducted)
+			RecordLog(userId, LogTypeSystem, fmt.Sprintf("额度到期作废 %s，来源: %s，授予记录ID %d", logger.LogQuota(int(deducted)), source, grantId))
+		}
+	}
+	return expiredCount, nil
+}
+
+// GetUserCreditBalance 返回用户余额按来源的构成。
+func GetUserCreditBalance(userId int) (*CreditBalance, error) {
+	if userId <= 0 {
+		return nil, errors.New("invalid user id")
+	}
+	quota, err := GetUserQuota(userId, true)
+	if err != nil {
+		return nil, err
+	}
+	now := common.GetTimestamp()
+	balance := &CreditBalance{
+		Quota:   quota,
+		Sources: []CreditSourceBalance{},
+	}
+	if err := DB.Model(&CreditGrant{}).
+		Select("source, COALESCE(SUM(remaining), 0) as remaining").
+		Where("user_id = ? AND status = ? AND remaining > 0 AND (expires_at = 0 OR expires_at > ?)", userId, CreditGrantStatusActive, now).
+		Group("source").
+		Order("source").
+		Scan(&balance.Sources).Error; err != nil {
+		return nil, err
+	}
+	var tracked int64
+	for _, s := range balance.Sources {
+		tracked += s.Remaining
+	}
+	if untracked := int64(quota) - tracked; untracked > 0 {
+		balance.Untracked = untracked
+	}
+	soon := now + int64(7*24*time.Hour/time.Second)
+	if err := DB.Model(&CreditGrant{}).
+		Select("COALESCE(SUM(remaining), 0)").
+		Where("user_id = ? AND status = ? AND expires_at > ? AND expires_at <= ?", userId, CreditGrantStatusActive, now, soon).
+		Scan(&balance.ExpiringSoon).Error; err != nil {
+		return nil, err
+	}
+	if err := DB.Model(&CreditGrant{}).
+		Select("COALESCE(MIN(expires_at), 0)").
+		Where("user_id = ? AND status = ? AND remaining > 0 AND expires_at > ?", userId, CreditGrantStatusActive, now).
+		Scan(&balance.NextExpireAt).Error; err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// GetUserCreditGrants 分页获取用户的授予记录
+func GetUserCreditGrants(userId int, status string, pageInfo *common.PageInfo) (grants []*CreditGrant, total int64, err error) {
+	query := DB.Model(&CreditGrant{}).Where("user_id = ?", userId)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&grants).Error; err != nil {
+		return nil, 0, err
+	}
+	return grants, total, nil
+}
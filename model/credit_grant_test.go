@@ -0,0 +1,94 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupCreditLedger(t *testing.T) {
+	t.Helper()
+	require.NoError(t, DB.AutoMigrate(&CreditGrant{}))
+	setting := operation_setting.GetCreditLedgerSetting()
+	prev := *setting
+	setting.Enabled = true
+	t.Cleanup(func() {
+		*setting = prev
+		DB.Exec("DELETE FROM credit_grants")
+	})
+	truncateTables(t)
+}
+
+func insertCreditUser(t *testing.T, id int, quota int) {
+	t.Helper()
+	require.NoError(t, DB.Create(&User{Id: id, Username: "credit_user", Quota: quota, AffCode: "cg01"}).Error)
+}
+
+func getGrant(t *testing.T, id int) CreditGrant {
+	t.Helper()
+	var g CreditGrant
+	require.NoError(t, DB.First(&g, id).Error)
+	return g
+}
+
+func TestConsumeCreditGrants_EarliestExpiryFirst(t *testing.T) {
+	setupCreditLedger(t)
+	now := common.GetTimestamp()
+	require.NoError(t, RecordCreditGrantWithExpiryTx(nil, 1, CreditSourceTopUp, "t1", 100, 0))
+	require.NoError(t, RecordCreditGrantWithExpiryTx(nil, 1, CreditSourceCheckin, "c1", 50, now+7200))
+	require.NoError(t, RecordCreditGrantWithExpiryTx(nil, 1, CreditSourcePromotion, "p1", 30, now+3600))
+
+	allocations, err := ConsumeCreditGrants(1, 60)
+	require.NoError(t, err)
+	require.Len(t, allocations, 2)
+	assert.Equal(t, int64(30), allocations[0].Amount)
+	assert.Equal(t, int64(30), allocations[1].Amount)
+
+	promo := getGrant(t, allocations[0].GrantId)
+	assert.Equal(t, CreditSourcePromotion, promo.Source)
+	assert.Equal(t, CreditGrantStatusExhausted, promo.Status)
+	checkin := getGrant(t, allocations[1].GrantId)
+	assert.Equal(t, int64(20), checkin.Remaining)
+
+	restored, forfeited, err := RestoreCreditGrants(allocations, 40)
+	require.NoError(t, err)
+	assert.Zero(t, forfeited)
+	require.Len(t, restored, 2)
+	assert.Equal(t, int64(50), getGrant(t, allocations[1].GrantId).Remaining)
+	promo = getGrant(t, allocations[0].GrantId)
+	assert.Equal(t, int64(10), promo.Remaining)
+	assert.Equal(t, CreditGrantStatusActive, promo.Status)
+}
+
+func TestExpireDueCreditGrants_DeductsRemaining(t *testing.T) {
+	setupCreditLedger(t)
+	insertCreditUser(t, 2, 500)
+	require.NoError(t, RecordCreditGrantWithExpiryTx(nil, 2, CreditSourceCheckin, "c1", 80, 0))
+	require.NoError(t, DB.Model(&CreditGrant{}).Where("user_id = ?", 2).Update("expires_at", common.GetTimestamp()-1).Error)
+
+	n, err := ExpireDueCreditGrants(10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	var user User
+	require.NoError(t, DB.First(&user, 2).Error)
+	assert.Equal(t, 420, user.Quota)
+
+	var grant CreditGrant
+	require.NoError(t, DB.Where("user_id = ?", 2).First(&grant).Error)
+	assert.Equal(t, CreditGrantStatusExpired, grant.Status)
+	assert.Zero(t, grant.Remaining)
+}
+
+func TestRecordCreditGrant_DisabledLedger(t *testing.T) {
+	setupCreditLedger(t)
+	operation_setting.GetCreditLedgerSetting().Enabled = false
+	require.NoError(t, RecordCreditGrantTx(nil, 3, CreditSourceTopUp, "t1", 100))
+
+	var count int64
+	DB.Model(&CreditGrant{}).Where("user_id = ?", 3).Count(&count)
+	assert.Zero(t, count)
+}
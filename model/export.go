@@ -0,0 +1,296 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+// Export kinds
+const (
+	ExportKindLogs      = "logs"
+	ExportKindQuotaData = "quota_data"
+	// 管理操作审计日志，仅管理员范围可导出
+	ExportKindAdminAudit = "admin_audit"
+)
+
+// Export job status
+const (
+	ExportJobStatusPending   = "pending"
+	ExportJobStatusRunning   = "running"
+	ExportJobStatusCompleted = "completed"
+	ExportJobStatusFailed    = "failed"
+	ExportJobStatusExpired   = "expired"
+)
+
+// ExportFilter 导出筛选条件，字段与 GetAllLogs 的查询参数一致。
+// UserId 不为 0 时限定为该用户的数据（用户自助导出）。
+type ExportFilter struct {
+	UserId         int    `json:"user_id,omitempty"`
+	LogType        int    `json:"type,omitempty"`
+	StartTimestamp int64  `json:"start_timestamp,omitempty"`
+	EndTimestamp   int64  `json:"end_timestamp,omitempty"`
+	ModelName      string `json:"model_name,omitempty"`
+	Username       string `json:"username,omitempty"`
+	TokenName      string `json:"token_name,omitempty"`
+	Channel        int    `json:"channel,omitempty"`
+	Group          string `json:"group,omitempty"`
+	RequestId      string `json:"request_id,omitempty"`
+	// 以下仅用于审计日志
+	Action     string `json:"action,omitempty"`
+	TargetType string `json:"target_type,omitempty"`
+	TargetId   string `json:"target_id,omitempty"`
+}
+
+func (f *ExportFilter) logQuery() (*gorm.DB, error) {
+	tx := LOG_DB.Model(&Log{})
+	if f.UserId != 0 {
+		tx = tx.Where("logs.user_id = ?", f.UserId)
+	}
+	if f.LogType != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", f.LogType)
+	}
+	if f.ModelName != "" {
+		// 用户自助导出与 GetUserLogs 一致，对模糊匹配做转义和限制
+		if f.UserId != 0 {
+			modelNamePattern, err := sanitizeLikePattern(f.ModelName)
+			if err != nil {
+				return nil, err
+			}
+			tx = tx.Where("logs.model_name LIKE ? ESCAPE '!'", modelNamePattern)
+		} else {
+			tx = tx.Where("logs.model_name like ?", f.ModelName)
+		}
+	}
+	if f.Username != "" {
+		tx = tx.Where("logs.username = ?", f.Username)
+	}
+	if f.TokenName != "" {
+		tx = tx.Where("logs.token_name = ?", f.TokenName)
+	}
+	if f.RequestId != "" {
+		tx = tx.Where("logs.request_id = ?", f.RequestId)
+	}
+	if f.StartTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", f.StartTimestamp)
+	}
+	if f.EndTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", f.EndTimestamp)
+	}
+	if f.Channel != 0 {
+		tx = tx.Where("logs.channel_id = ?", f.Channel)
+	}
+	if f.Group != "" {
+		tx = tx.Where("logs."+logGroupCol+" = ?", f.Group)
+	}
+	return tx, nil
+}
+
+func (f *ExportFilter) quotaDataQuery() *gorm.DB {
+	tx := DB.Model(&QuotaData{})
+	if f.UserId != 0 {
+		tx = tx.Where("user_id = ?", f.UserId)
+	}
+	if f.Username != "" {
+		tx = tx.Where("username = ?", f.Username)
+	}
+	if f.ModelName != "" {
+		tx = tx.Where("model_name like ?", f.ModelName)
+	}
+	if f.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", f.StartTimestamp)
+	}
+	if f.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", f.EndTimestamp)
+	}
+	return tx
+}
+
+// fillExportChannelNames 为管理员导出填充渠道名称，channelNames 在各批次间复用
+func fillExportChannelNames(logs []*Log, channelNames map[int]string) error {
+	missing := make([]int, 0)
+	for _, log := range logs {
+		if log.ChannelId == 0 {
+			continue
+		}
+		if _, ok := channelNames[log.ChannelId]; !ok {
+			channelNames[log.ChannelId] = ""
+			missing = append(missing, log.ChannelId)
+		}
+	}
+	if len(missing) > 0 {
+		var channels []struct {
+			Id   int    `gorm:"column:id"`
+			Name string `gorm:"column:name"`
+		}
+		if err := DB.Table("channels").Select("id, name").Where("id IN ?", missing).Find(&channels).Error; err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			channelNames[channel.Id] = channel.Name
+		}
+	}
+	for i := range logs {
+		logs[i].ChannelName = channelNames[logs[i].ChannelId]
+	}
+	return nil
+}
+
+// IterateLogs 按 id 游标分批遍历日志，避免大偏移量分页的性能问题。
+// fn 返回错误时停止遍历。
+func IterateLogs(filter ExportFilter, batchSize int, fn func(logs []*Log) error) error {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	channelNames := make(map[int]string)
+	lastId := 0
+	for {
+		tx, err := filter.logQuery()
+		if err != nil {
+			return err
+		}
+		var logs []*Log
+		if err := tx.Where("logs.id > ?", lastId).Order("logs.id asc").Limit(batchSize).Find(&logs).Error; err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		lastId = logs[len(logs)-1].Id
+		if filter.UserId == 0 {
+			if err := fillExportChannelNames(logs, channelNames); err != nil {
+				return err
+			}
+		}
+		if err := fn(logs); err != nil {
+			return err
+		}
+		if len(logs) < batchSize {
+			return nil
+		}
+	}
+}
+
+// IterateQuotaData 按 id 游标分批遍历 quota_data
+func IterateQuotaData(filter ExportFilter, batchSize int, fn func(rows []*QuotaData) error) error {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	lastId := 0
+	for {
+		var rows []*QuotaData
+		if err := filter.quotaDataQuery().Where("id > ?", lastId).Order("id asc").Limit(batchSize).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		lastId = rows[len(rows)-1].Id
+		if err := fn(rows); err != nil {
+			return err
+		}
+		if len(rows) < batchSize {
+			return nil
+		}
+	}
+}
+
+// SanitizeUserExportLog 去除用户自助导出中仅管理员可见的字段，与 formatUserLogs 保持一致
+func SanitizeUserExportLog(log *Log) {
+	log.ChannelName = ""
+	log.UpstreamCost = 0
+	otherMap, _ := common.StrToMap(log.Other)
+	if otherMap != nil {
+		delete(otherMap, "admin_info")
+		delete(otherMap, "reject_reason")
+		log.Other = common.MapToJsonStr(otherMap)
+	}
+}
+
+// ExportJob 异步导出任务。导出文件保存在执行任务的节点本地。
+type ExportJob struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Scope       string `json:"scope" gorm:"type:varchar(16)"` // admin / user
+	Kind        string `json:"kind" gorm:"type:varchar(32)"`
+	Format      string `json:"format" gorm:"type:varchar(16)"`
+	Filter      string `json:"filter" gorm:"type:text"`
+	Status      string `json:"status" gorm:"type:varchar(16);index"`
+	RowCount    int64  `json:"row_count" gorm:"type:bigint;default:0"`
+	FileSize    int64  `json:"file_size" gorm:"type:bigint;default:0"`
+	FilePath    string `json:"-" gorm:"type:varchar(512);default:''"`
+	FileName    string `json:"file_name" gorm:"type:varchar(255);default:''"`
+	Error       string `json:"error" gorm:"type:text"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+	CompletedAt int64  `json:"completed_at" gorm:"bigint;default:0"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint;default:0;index"`
+}
+
+func (j *ExportJob) Insert() error {
+	if j.CreatedAt == 0 {
+		j.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(j).Error
+}
+
+func UpdateExportJob(id int, updates map[string]interface{}) error {
+	return DB.Model(&ExportJob{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// GetExportJob 获取导出任务；userId 不为 0 时只能获取该用户自己创建的任务
+func GetExportJob(id int, userId int) (*ExportJob, error) {
+	var job ExportJob
+	tx := DB.Where("id = ?", id)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err := tx.First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("导出任务不存在")
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func GetExportJobs(userId int, scope string, pageInfo *common.PageInfo) (jobs []*ExportJob, total int64, err error) {
+	tx := DB.Model(&ExportJob{}).Where("user_id = ? AND scope = ?", userId, scope)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err = tx.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&jobs).Error; err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
+
+// CountRunningExportJobs 统计用户进行中的导出任务，用于限制并发
+func CountRunningExportJobs(userId int) (int64, error) {
+	var count int64
+	err := DB.Model(&ExportJob{}).
+		Where("user_id = ? AND status IN ?", userId, []string{ExportJobStatusPending, ExportJobStatusRunning}).
+		Count(&count).Error
+	return count, err
+}
+
+// GetExpiredExportJobs 获取已过期但文件尚未清理的导出任务
+func GetExpiredExportJobs(limit int) ([]*ExportJob, error) {
+	var jobs []*ExportJob
+	err := DB.Where("status = ? AND expires_at > 0 AND expires_at <= ?", ExportJobStatusCompleted, common.GetTimestamp()).
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// FailStaleExportJobs 将创建时间早于 before 仍未完成的导出任务标记为失败（通常是执行节点已重启）
+func FailStaleExportJobs(before int64) error {
+	return DB.Model(&ExportJob{}).
+		Where("status IN ? AND created_at < ?", []string{ExportJobStatusPending, ExportJobStatusRunning}, before).
+		Updates(map[string]interface{}{
+			"status":       ExportJobStatusFailed,
+			"error":        "导出任务超时或执行节点已重启",
+			"completed_at": common.GetTimestamp(),
+		}).Error
+}
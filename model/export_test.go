@@ -0,0 +1,70 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterateLogsCursor(t *testing.T) {
+	truncateTables(t)
+
+	logs := make([]*Log, 0, 7)
+	for i := 0; i < 5; i++ {
+		logs = append(logs, &Log{UserId: 31, Type: LogTypeConsume, CreatedAt: int64(1000 + i), ModelName: "gpt-4o", Other: `{"admin_info":{"x":1},"a":1}`})
+	}
+	logs = append(logs, &Log{UserId: 32, Type: LogTypeConsume, CreatedAt: 1000, ModelName: "gpt-4o"})
+	logs = append(logs, &Log{UserId: 31, Type: LogTypeTopup, CreatedAt: 1000})
+	require.NoError(t, LOG_DB.Create(&logs).Error)
+
+	var batches []int
+	var lastId int
+	err := IterateLogs(ExportFilter{UserId: 31, LogType: LogTypeConsume}, 2, func(batch []*Log) error {
+		batches = append(batches, len(batch))
+		for _, log := range batch {
+			assert.Greater(t, log.Id, lastId)
+			lastId = log.Id
+			SanitizeUserExportLog(log)
+			assert.NotContains(t, log.Other, "admin_info")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 2, 1}, batches)
+
+	stopErr := errors.New("stop")
+	calls := 0
+	err = IterateLogs(ExportFilter{StartTimestamp: 1000, EndTimestamp: 1002}, 1, func(batch []*Log) error {
+		calls++
+		return stopErr
+	})
+	assert.ErrorIs(t, err, stopErr)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIterateQuotaData(t *testing.T) {
+	require.NoError(t, DB.AutoMigrate(&QuotaData{}))
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM quota_data")
+	})
+
+	rows := []*QuotaData{
+		{UserID: 41, Username: "qd", ModelName: "gpt-4o", CreatedAt: 3600, Count: 1, Quota: 10},
+		{UserID: 41, Username: "qd", ModelName: "claude", CreatedAt: 7200, Count: 2, Quota: 20},
+		{UserID: 42, Username: "other", ModelName: "gpt-4o", CreatedAt: 3600, Count: 3, Quota: 30},
+	}
+	require.NoError(t, DB.Create(&rows).Error)
+
+	var total int
+	err := IterateQuotaData(ExportFilter{UserId: 41}, 1, func(batch []*QuotaData) error {
+		for _, row := range batch {
+			assert.Equal(t, 41, row.UserID)
+			total += row.Quota
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 30, total)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -37,6 +38,8 @@ type Log struct {
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	RequestId        string `json:"request_id,omitempty" gorm:"type:varchar(64);index:idx_logs_request_id;default:''"`
 	Other            string `json:"other"`
+	UpstreamCost     int    `json:"upstream_cost,omitempty" gorm:"default:0"`              // 按渠道成本倍率计算的上游成本额度，仅管理员可见
+	Labels           string `json:"labels,omitempty" gorm:"type:varchar(1024);default:''"` // 令牌及请求携带的标签
 }
 
 // don't use iota, avoid change log type value
@@ -53,6 +56,7 @@ const (
 func formatUserLogs(logs []*Log, startIdx int) {
 	for i := range logs {
 		logs[i].ChannelName = ""
+		logs[i].UpstreamCost = 0
 		var otherMap map[string]interface{}
 		otherMap, _ = common.StrToMap(logs[i].Other)
 		if otherMap != nil {
@@ -126,6 +130,7 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 		}(),
 		RequestId: requestId,
 		Other:     otherStr,
+		Labels:    common.GetContextKeyString(c, constant.ContextKeyTokenLabels),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -146,9 +151,12 @@ type RecordConsumeLogParams struct {
 	IsStream         bool                   `json:"is_stream"`
 	Group            string                 `json:"group"`
 	Other            map[string]interface{} `json:"other"`
+	UsageRatio       float64                `json:"usage_ratio,omitempty"` // 按次计费的用量倍数（任务时长、分辨率等），用于计算上游成本
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	// 记录实际用量，供令牌 TPM 限流在请求结束后修正
+	common.SetContextKey(c, constant.ContextKeyConsumedTokens, params.PromptTokens+params.CompletionTokens)
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -185,8 +193,10 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 			return ""
 		}(),
-		RequestId: requestId,
-		Other:     otherStr,
+		RequestId:    requestId,
+		Other:        otherStr,
+		UpstreamCost: calcLogUpstreamCost(c, params.ChannelId, params.ModelName, consumeLogUpstreamUsage(params)),
+		Labels:       common.GetContextKeyString(c, constant.ContextKeyTokenLabels),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -199,16 +209,33 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 	}
 }
 
+// consumeLogUpstreamUsage 从消费日志参数中取出计算上游成本的用量，价格取自计费时记录的模型价格
+func consumeLogUpstreamUsage(params RecordConsumeLogParams) UpstreamUsage {
+	usage := UpstreamUsage{
+		PromptTokens:     params.PromptTokens,
+		CompletionTokens: params.CompletionTokens,
+		UsageRatio:       params.UsageRatio,
+	}
+	if cacheTokens, ok := otherFloat(params.Other, "cache_tokens"); ok {
+		usage.CacheTokens = int(cacheTokens)
+	}
+	if modelPrice, ok := otherFloat(params.Other, "model_price"); ok {
+		usage.ModelPrice = modelPrice
+	}
+	return usage
+}
+
 type RecordTaskBillingLogParams struct {
-	UserId    int
-	LogType   int
-	Content   string
-	ChannelId int
-	ModelName string
-	Quota     int
-	TokenId   int
-	Group     string
-	Other     map[string]interface{}
+	UserId       int
+	LogType      int
+	Content      string
+	ChannelId    int
+	ModelName    string
+	Quota        int
+	TokenId      int
+	Group        string
+	Other        map[string]interface{}
+	UpstreamCost int // 本次扣费或退款对应的上游成本额度，退款日志在毛利报表中冲减成本
 }
 
 func RecordTaskBillingLog(params RecordTaskBillingLogParams) {
@@ -236,6 +263,9 @@ func RecordTaskBillingLog(params RecordTaskBillingLogParams) {
 		Group:     params.Group,
 		Other:     common.MapToJsonStr(params.Other),
 	}
+	if params.LogType == LogTypeConsume || params.LogType == LogTypeRefund || params.LogType == LogTypeSystem {
+		log.UpstreamCost = params.UpstreamCost
+	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
 		common.SysLog("failed to record task billing log: " + err.Error())
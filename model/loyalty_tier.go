@@ -0,0 +1,229 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"gorm.io/gorm"
+)
+
+// LoyaltyMembership 记录由会员等级自动调整的分组，用于降级时恢复原分组；
+// 用户分组与 Group 不一致时说明分组已被管理员或订阅修改，自动调整会暂停
+type LoyaltyMembership struct {
+	Id        int     `json:"id"`
+	UserId    int     `json:"user_id" gorm:"uniqueIndex"`
+	Group     string  `json:"group" gorm:"column:tier_group;type:varchar(64)"`
+	PrevGroup string  `json:"prev_group" gorm:"type:varchar(64);default:''"`
+	Spend     float64 `json:"spend"`
+	CreatedAt int64   `json:"created_at" gorm:"bigint"`
+	UpdatedAt int64   `json:"updated_at" gorm:"bigint"`
+}
+
+// LoyaltyTierChange 一次会员等级分组调整
+type LoyaltyTierChange struct {
+	UserId    int     `json:"user_id"`
+	FromGroup string  `json:"from_group"`
+	ToGroup   string  `json:"to_group"`
+	Spend     float64 `json:"spend"`
+	Upgrade   bool    `json:"upgrade"`
+}
+
+// LoyaltyTierChangedFunc 分组调整后的通知回调，由 service 层注入
+var LoyaltyTierChangedFunc func(change *LoyaltyTierChange)
+
+// sumUserTopUpMoneyTx 统计钱包充值金额。订阅支付会同时写入一条同 trade_no 的充值记录，
+// 订阅消费不计入会员等级，这里排除以免重复统计
+func sumUserTopUpMoneyTx(tx *gorm.DB, userId int, since int64) (float64, error) {
+	var money float64
+	err := tx.Model(&TopUp{}).Where("user_id = ? AND status = ? AND complete_time >= ?", userId, common.TopUpStatusSuccess, since).
+		Where("trade_no NOT IN (?)", tx.Model(&SubscriptionOrder{}).Select("trade_no").Where("user_id = ?", userId)).
+		Select("COALESCE(SUM(money), 0)").Scan(&money).Error
+	return money, err
+}
+
+// matchLoyaltyTierTx 返回用户当前满足的最高等级，未满足任何等级时返回 nil
+func matchLoyaltyTierTx(tx *gorm.DB, userId int, now int64) (*operation_setting.LoyaltyTier, float64, error) {
+	var matched *operation_setting.LoyaltyTier
+	var matchedSpend, maxSpend float64
+	spendByWindow := make(map[int]float64)
+	for _, tier := range operation_setting.GetLoyaltySetting().Tiers {
+		if strings.TrimSpace(tier.Group) == "" {
+			continue
+		}
+		days := tier.TierWindowDays()
+		spend, ok := spendByWindow[days]
+		if !ok {
+			var err error
+			spend, err = sumUserTopUpMoneyTx(tx, userId, now-int64(days)*24*60*60)
+			if err != nil {
+				return nil, 0, err
+			}
+			spendByWindow[days] = spend
+		}
+		if spend > maxSpend {
+			maxSpend = spend
+		}
+		if spend >= tier.MinMoney && (matched == nil || tier.MinMoney > matched.MinMoney) {
+			t := tier
+			matched = &t
+			matchedSpend = spend
+		}
+	}
+	if matched == nil {
+		return nil, maxSpend, nil
+	}
+	return matched, matchedSpend, nil
+}
+
+// EvaluateUserLoyaltyTier 按累计充值重新评估用户的会员等级并调整分组，无变化时返回 nil。
+// 生效中的订阅升级分组、以及非初始分组（人工指定）都不会被覆盖
+func EvaluateUserLoyaltyTier(userId int) (*LoyaltyTierChange, error) {
+	if !operation_setting.IsLoyaltyEnabled() || userId <= 0 {
+		return nil, nil
+	}
+	setting := operation_setting.GetLoyaltySetting()
+	now := common.GetTimestamp()
+	var change *LoyaltyTierChange
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		currentGroup, err := getUserGroupByIdTx(tx, userId)
+		if err != nil {
+			return err
+		}
+		var activeSub UserSubscription
+		activeQuery := tx.Where("user_id = ? AND status = ? AND end_time > ? AND upgrade_group <> ''", userId, "active", now).
+			Limit(1).Find(&activeSub)
+		if activeQuery.Error != nil {
+			return activeQuery.Error
+		}
+		if activeQuery.RowsAffected > 0 {
+			return nil
+		}
+		var membership LoyaltyMembership
+		memberQuery := tx.Set("gorm:query_option", "FOR UPDATE").Where("user_id = ?", userId).Limit(1).Find(&membership)
+		if memberQuery.Error != nil {
+			return memberQuery.Error
+		}
+		isMember := memberQuery.RowsAffected > 0
+		if isMember && membership.Group != currentGroup {
+			return nil
+		}
+		if !isMember && !setting.IsBaseGroup(currentGroup) {
+			return nil
+		}
+		tier, spend, err := matchLoyaltyTierTx(tx, userId, now)
+		if err != nil {
+			return err
+		}
+		targetGroup := ""
+		if tier != nil {
+			targetGroup = tier.Group
+		} else if isMember {
+			targetGroup = membership.PrevGroup
+		}
+		if targetGroup == "" || targetGroup == currentGroup {
+			if isMember {
+				return tx.Model(&LoyaltyMembership{}).Where("id = ?", membership.Id).
+					Updates(map[string]interface{}{"spend": spend, "updated_at": now}).Error
+			}
+			return nil
+		}
+		if err := tx.Model(&User{}).Where("id = ?", userId).Update("group", targetGroup).Error; err != nil {
+			return err
+		}
+		change = &LoyaltyTierChange{UserId: userId, FromGroup: currentGroup, ToGroup: targetGroup, Spend: spend}
+		switch {
+		case tier == nil:
+			// 不再满足任何等级，恢复原分组
+			return tx.Delete(&LoyaltyMembership{}, membership.Id).Error
+		case isMember:
+			prevTier := findLoyaltyTier(membership.Group)
+			change.Upgrade = prevTier == nil || tier.MinMoney > prevTier.MinMoney
+			return tx.Model(&LoyaltyMembership{}).Where("id = ?", membership.Id).Updates(map[string]interface{}{
+				"tier_group": targetGroup,
+				"spend":      spend,
+				"updated_at": now,
+			}).Error
+		default:
+			change.Upgrade = true
+			return tx.Create(&LoyaltyMembership{
+				UserId:    userId,
+				Group:     targetGroup,
+				PrevGroup: currentGroup,
+				Spend:     spend,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}).Error
+		}
+	})
+	if err != nil || change == nil {
+		return nil, err
+	}
+	_ = UpdateUserGroupCache(userId, change.ToGroup)
+	action := "降级"
+	if change.Upgrade {
+		action = "升级"
+	}
+	RecordLog(userId, LogTypeSystem, fmt.Sprintf("会员等级%s，近期累计充值 %.2f，分组由 %s 调整为 %s", action, change.Spend, change.FromGroup, change.ToGroup))
+	if LoyaltyTierChangedFunc != nil {
+		LoyaltyTierChangedFunc(change)
+	}
+	return change, nil
+}
+
+func findLoyaltyTier(group string) *operation_setting.LoyaltyTier {
+	for _, tier := range operation_setting.GetLoyaltySetting().Tiers {
+		if tier.Group == group {
+			t := tier
+			return &t
+		}
+	}
+	return nil
+}
+
+// refreshLoyaltyTier 充值到账后重新评估会员等级，失败不影响充值结果
+func refreshLoyaltyTier(userId int) {
+	if _, err := EvaluateUserLoyaltyTier(userId); err != nil {
+		common.SysLog(fmt.Sprintf("failed to evaluate loyalty tier for user %d: %s", userId, err.Error()))
+	}
+}
+
+// EvaluateLoyaltyTiers 批量评估近期有充值或已有会员等级的用户，返回发生分组调整的用户数
+func EvaluateLoyaltyTiers() (int, error) {
+	if !operation_setting.IsLoyaltyEnabled() {
+		return 0, nil
+	}
+	maxDays := 0
+	for _, tier := range operation_setting.GetLoyaltySetting().Tiers {
+		if days := tier.TierWindowDays(); days > maxDays {
+			maxDays = days
+		}
+	}
+	since := common.GetTimestamp() - int64(maxDays)*24*60*60
+	var userIds []int
+	if err := DB.Model(&TopUp{}).Where("status = ? AND complete_time >= ?", common.TopUpStatusSuccess, since).
+		Distinct().Pluck("user_id", &userIds).Error; err != nil {
+		return 0, err
+	}
+	var memberIds []int
+	if err := DB.Model(&LoyaltyMembership{}).Pluck("user_id", &memberIds).Error; err != nil {
+		return 0, err
+	}
+	seen := make(map[int]struct{}, len(userIds)+len(memberIds))
+	changed := 0
+	for _, userId := range append(userIds, memberIds...) {
+		if _, ok := seen[userId]; ok {
+			continue
+		}
+		seen[userId] = struct{}{}
+		change, err := EvaluateUserLoyaltyTier(userId)
+		if err != nil {
+			return changed, err
+		}
+		if change != nil {
+			changed++
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupLoyaltyTier(t *testing.T) {
+	t.Helper()
+	withColumnNames(t)
+	setupTopUpTables(t)
+	require.NoError(t, DB.AutoMigrate(&UserSubscription{}, &LoyaltyMembership{}))
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM user_subscriptions")
+		DB.Exec("DELETE FROM loyalty_memberships")
+	})
+	setting := operation_setting.GetLoyaltySetting()
+	original := *setting
+	t.Cleanup(func() { *setting = original })
+	setting.Enabled = true
+	setting.BaseGroups = []string{"default"}
+	setting.Tiers = []operation_setting.LoyaltyTier{
+		{Group: "vip", MinMoney: 500, WindowDays: 90},
+		{Group: "svip", MinMoney: 2000, WindowDays: 90},
+	}
+}
+
+func completeLoyaltyTopUp(t *testing.T, userId int, tradeNo string, money float64) {
+	t.Helper()
+	require.NoError(t, (&TopUp{UserId: userId, Amount: int64(money), Money: money, TradeNo: tradeNo, PaymentMethod: "alipay",
+		Status: common.TopUpStatusPending}).Insert())
+	require.NoError(t, RechargeEpay(tradeNo))
+}
+
+func getTestUserGroup(t *testing.T, userId int) string {
+	t.Helper()
+	var user User
+	require.NoError(t, DB.Select("id", "group").First(&user, userId).Error)
+	return user.Group
+}
+
+func TestLoyaltyTier_UpgradeAndDowngrade(t *testing.T) {
+	setupLoyaltyTier(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "loyal_user", AffCode: "ly01", Group: "default"}).Error)
+
+	completeLoyaltyTopUp(t, 1, "USR1NOly1", 300)
+	assert.Equal(t, "default", getTestUserGroup(t, 1))
+	completeLoyaltyTopUp(t, 1, "USR1NOly2", 300)
+	assert.Equal(t, "vip", getTestUserGroup(t, 1))
+
+	_, err := RefundPaymentOrder("USR1NOly2", RefundSourceAdmin, "")
+	require.NoError(t, err)
+	changed, err := EvaluateLoyaltyTiers()
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Equal(t, "default", getTestUserGroup(t, 1))
+
+	var count int64
+	require.NoError(t, DB.Model(&LoyaltyMembership{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestLoyaltyTier_KeepsManualAndSubscriptionGroups(t *testing.T) {
+	setupLoyaltyTier(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "loyal_partner", AffCode: "ly02", Group: "partner"}).Error)
+	require.NoError(t, DB.Create(&User{Id: 2, Username: "loyal_sub", AffCode: "ly03", Group: "pro"}).Error)
+	require.NoError(t, DB.Create(&UserSubscription{UserId: 2, PlanId: 1, Status: "active", EndTime: common.GetTimestamp() + 3600,
+		UpgradeGroup: "pro", PrevUserGroup: "default"}).Error)
+
+	completeLoyaltyTopUp(t, 1, "USR1NOly3", 600)
+	completeLoyaltyTopUp(t, 2, "USR2NOly4", 600)
+	assert.Equal(t, "partner", getTestUserGroup(t, 1))
+	assert.Equal(t, "pro", getTestUserGroup(t, 2))
+}
+
+func TestLoyaltyTier_StopsAfterManualChange(t *testing.T) {
+	setupLoyaltyTier(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "loyal_moved", AffCode: "ly04", Group: "default"}).Error)
+	completeLoyaltyTopUp(t, 1, "USR1NOly5", 600)
+	assert.Equal(t, "vip", getTestUserGroup(t, 1))
+
+	// 管理员手动调整分组后，会员等级不再覆盖
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", 1).Update("group", "partner").Error)
+	completeLoyaltyTopUp(t, 1, "USR1NOly6", 2000)
+	assert.Equal(t, "partner", getTestUserGroup(t, 1))
+
+	// 恢复为会员等级分组后继续按等级调整
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", 1).Update("group", "vip").Error)
+	change, err := EvaluateUserLoyaltyTier(1)
+	require.NoError(t, err)
+	require.NotNil(t, change)
+	assert.True(t, change.Upgrade)
+	assert.Equal(t, "svip", getTestUserGroup(t, 1))
+}
+
+func TestLoyaltyTier_IgnoresSubscriptionOrders(t *testing.T) {
+	setupLoyaltyTier(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "loyal_subscriber", AffCode: "ly05", Group: "default"}).Error)
+
+	// 订阅支付写入的同 trade_no 充值记录不计入钱包充值
+	now := common.GetTimestamp()
+	require.NoError(t, DB.Create(&SubscriptionOrder{UserId: 1, PlanId: 1, Money: 800, TradeNo: "SUB1NOly7", PaymentMethod: "alipay",
+		Status: common.TopUpStatusSuccess, CreateTime: now, CompleteTime: now}).Error)
+	require.NoError(t, DB.Create(&TopUp{UserId: 1, Money: 800, TradeNo: "SUB1NOly7", PaymentMethod: "alipay",
+		Status: common.TopUpStatusSuccess, CreateTime: now, CompleteTime: now}).Error)
+	_, err := EvaluateUserLoyaltyTier(1)
+	require.NoError(t, err)
+	assert.Equal(t, "default", getTestUserGroup(t, 1))
+
+	completeLoyaltyTopUp(t, 1, "USR1NOly8", 600)
+	assert.Equal(t, "vip", getTestUserGroup(t, 1))
+}
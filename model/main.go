@@ -276,6 +276,25 @@ func migrateDB() error {
 		&SubscriptionPreConsumeRecord{},
 		&CustomOAuthProvider{},
 		&UserOAuthBinding{},
+		&CreditGrant{},
+		&PostpaidAccount{},
+		&PostpaidStatement{},
+		&UserStatement{},
+		&ExportJob{},
+		&SubscriptionAllowance{},
+		&SubscriptionAllowanceUsage{},
+		&PromoCode{},
+		&PromoCodeUsage{},
+		&RedemptionCampaign{},
+		&AffiliateCommission{},
+		&LoyaltyMembership{},
+		&RecurringGrant{},
+		&RecurringGrantRecord{},
+		&QuotaReconcileRun{},
+		&QuotaReconcileItem{},
+		&QuotaReconcileCheckpoint{},
+		&ManagementKey{},
+		&Role{},
 	)
 	if err != nil {
 		return err
@@ -324,6 +343,25 @@ func migrateDBFast() error {
 		{&SubscriptionPreConsumeRecord{}, "SubscriptionPreConsumeRecord"},
 		{&CustomOAuthProvider{}, "CustomOAuthProvider"},
 		{&UserOAuthBinding{}, "UserOAuthBinding"},
+		{&CreditGrant{}, "CreditGrant"},
+		{&PostpaidAccount{}, "PostpaidAccount"},
+		{&PostpaidStatement{}, "PostpaidStatement"},
+		{&UserStatement{}, "UserStatement"},
+		{&ExportJob{}, "ExportJob"},
+		{&SubscriptionAllowance{}, "SubscriptionAllowance"},
+		{&SubscriptionAllowanceUsage{}, "SubscriptionAllowanceUsage"},
+		{&PromoCode{}, "PromoCode"},
+		{&PromoCodeUsage{}, "PromoCodeUsage"},
+		{&RedemptionCampaign{}, "RedemptionCampaign"},
+		{&AffiliateCommission{}, "AffiliateCommission"},
+		{&LoyaltyMembership{}, "LoyaltyMembership"},
+		{&RecurringGrant{}, "RecurringGrant"},
+		{&RecurringGrantRecord{}, "RecurringGrantRecord"},
+		{&QuotaReconcileRun{}, "QuotaReconcileRun"},
+		{&QuotaReconcileItem{}, "QuotaReconcileItem"},
+		{&QuotaReconcileCheckpoint{}, "QuotaReconcileCheckpoint"},
+		{&ManagementKey{}, "ManagementKey"},
+		{&Role{}, "Role"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -363,7 +401,7 @@ func migrateDBFast() error {
 
 func migrateLOGDB() error {
 	var err error
-	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
+	if err = LOG_DB.AutoMigrate(&Log{}, &ManagementKeyAudit{}, &AdminAuditLog{}); err != nil {
 		return err
 	}
 	return nil
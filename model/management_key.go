@@ -0,0 +1,153 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// 管理 API 密钥：用于自动化调用 /api/* 管理接口，以所属用户的身份执行，但只能访问显式授权的权限范围，
+// 可设置有效期和 IP 白名单，每次调用都记录审计日志。与令牌共用哈希密钥，数据库中只保存哈希
+const ManagementKeyPrefix = "mk-"
+
+const (
+	ManagementKeyStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	ManagementKeyStatusDisabled = 2 // also don't use 0
+)
+
+type ManagementKey struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Name         string `json:"name" gorm:"type:varchar(64);default:''"`
+	Key          string `json:"-" gorm:"type:char(48);uniqueIndex"`
+	KeyPrefix    string `json:"key_prefix" gorm:"type:varchar(16);default:''"`
+	Scopes       string `json:"scopes" gorm:"type:varchar(1024);default:''"` // 权限范围，取值同角色权限，逗号分隔，不能为空
+	AllowIps     string `json:"allow_ips" gorm:"type:text"`                  // IP 白名单，每行一个 IP 或 CIDR，为空不限制
+	Status       int    `json:"status" gorm:"default:1"`
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 表示永不过期
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	AccessedTime int64  `json:"accessed_time" gorm:"bigint;default:0"`
+}
+
+// ManagementKeyAudit 管理 API 密钥的调用记录，写入日志库
+type ManagementKeyAudit struct {
+	Id         int    `json:"id"`
+	KeyId      int    `json:"key_id" gorm:"index"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	Scope      string `json:"scope" gorm:"type:varchar(32);default:''"`
+	Method     string `json:"method" gorm:"type:varchar(8)"`
+	Path       string `json:"path" gorm:"type:varchar(255)"`
+	StatusCode int    `json:"status_code"`
+	Ip         string `json:"ip" gorm:"type:varchar(64);default:''"`
+	RequestId  string `json:"request_id" gorm:"type:varchar(64);default:''"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func IsManagementKey(key string) bool {
+	return strings.HasPrefix(key, ManagementKeyPrefix)
+}
+
+func (key *ManagementKey) HasScope(scope string) bool {
+	return slices.Contains(strings.Split(key.Scopes, ","), scope)
+}
+
+func (key *ManagementKey) GetIpLimits() []string {
+	ipLimits := make([]string, 0)
+	for _, ip := range strings.Split(strings.ReplaceAll(key.AllowIps, ",", "\n"), "\n") {
+		ip = strings.TrimSpace(ip)
+		if ip != "" {
+			ipLimits = append(ipLimits, ip)
+		}
+	}
+	return ipLimits
+}
+
+// SetSecret 设置密钥明文，仅保存哈希与展示前缀
+func (key *ManagementKey) SetSecret(secret string) {
+	key.Key = HashTokenKey(secret)
+	key.KeyPrefix = tokenKeyPrefix(ManagementKeyPrefix + secret)
+}
+
+func (key *ManagementKey) Insert() error {
+	return DB.Create(key).Error
+}
+
+// Update 如有新增可修改的字段，请同步更新此处
+func (key *ManagementKey) Update() error {
+	return DB.Model(key).Select("name", "scopes", "allow_ips", "status", "expired_time").Updates(key).Error
+}
+
+func GetManagementKeyByIds(id int, userId int) (*ManagementKey, error) {
+	if id == 0 || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	var key ManagementKey
+	err := DB.First(&key, "id = ? AND user_id = ?", id, userId).Error
+	return &key, err
+}
+
+func GetUserManagementKeys(userId int, pageInfo *common.PageInfo) (keys []*ManagementKey, total int64, err error) {
+	query := DB.Model(&ManagementKey{}).Where("user_id = ?", userId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&keys).Error
+	return keys, total, err
+}
+
+func DeleteManagementKeyById(id int, userId int) error {
+	key, err := GetManagementKeyByIds(id, userId)
+	if err != nil {
+		return err
+	}
+	return DB.Delete(key).Error
+}
+
+// ValidateManagementKey 校验管理 API 密钥明文（含 mk- 前缀），返回有效的密钥
+func ValidateManagementKey(secret string) (*ManagementKey, error) {
+	secret = strings.TrimPrefix(secret, ManagementKeyPrefix)
+	if secret == "" {
+		return nil, errors.New("未提供管理 API 密钥")
+	}
+	var key ManagementKey
+	if err := DB.First(&key, commonKeyCol+" = ?", HashTokenKey(secret)).Error; err != nil {
+		return nil, errors.New("管理 API 密钥无效")
+	}
+	if key.Status != ManagementKeyStatusEnabled {
+		return nil, errors.New("管理 API 密钥已禁用")
+	}
+	now := common.GetTimestamp()
+	if key.ExpiredTime != -1 && key.ExpiredTime < now {
+		return nil, errors.New("管理 API 密钥已过期")
+	}
+	// 访问时间只用于展示，按分钟粒度更新，避免每次调用都写库
+	if now-key.AccessedTime > 60 {
+		keyId := key.Id
+		gopool.Go(func() {
+			if err := DB.Model(&ManagementKey{}).Where("id = ?", keyId).Update("accessed_time", now).Error; err != nil {
+				common.SysLog(fmt.Sprintf("failed to update management key %d accessed time: %s", keyId, err.Error()))
+			}
+		})
+	}
+	return &key, nil
+}
+
+func RecordManagementKeyAudit(audit *ManagementKeyAudit) {
+	audit.CreatedAt = common.GetTimestamp()
+	if err := LOG_DB.Create(audit).Error; err != nil {
+		common.SysLog("failed to record management key audit: " + err.Error())
+	}
+}
+
+func GetManagementKeyAudits(keyId int, userId int, pageInfo *common.PageInfo) (audits []*ManagementKeyAudit, total int64, err error) {
+	query := LOG_DB.Model(&ManagementKeyAudit{}).Where("key_id = ? AND user_id = ?", keyId, userId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&audits).Error
+	return audits, total, err
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePermissions(t *testing.T) {
+	scopes, invalid := NormalizePermissions("logs:read, Channels:WRITE,channels:read,logs:read")
+	assert.Empty(t, invalid)
+	assert.Equal(t, "channels:read,channels:write,logs:read", scopes)
+
+	_, invalid = NormalizePermissions("channels:read,channels:*")
+	assert.Equal(t, "channels:*", invalid)
+}
+
+func TestValidateManagementKey(t *testing.T) {
+	require.NoError(t, DB.AutoMigrate(&ManagementKey{}))
+	t.Cleanup(func() { DB.Exec("DELETE FROM management_keys") })
+	withColumnNames(t)
+
+	secret, err := common.GenerateKey()
+	require.NoError(t, err)
+	key := &ManagementKey{UserId: 1, Name: "ci", Scopes: "channels:read", Status: ManagementKeyStatusEnabled, ExpiredTime: -1}
+	key.SetSecret(secret)
+	require.NoError(t, key.Insert())
+	assert.True(t, IsManagementKey(key.KeyPrefix))
+
+	validated, err := ValidateManagementKey(ManagementKeyPrefix + secret)
+	require.NoError(t, err)
+	assert.Equal(t, key.Id, validated.Id)
+	assert.True(t, validated.HasScope(PermissionChannelsRead))
+	assert.False(t, validated.HasScope(PermissionChannelsWrite))
+
+	_, err = ValidateManagementKey(ManagementKeyPrefix + "wrong" + secret)
+	assert.Error(t, err)
+
+	key.ExpiredTime = common.GetTimestamp() - 1
+	require.NoError(t, key.Update())
+	_, err = ValidateManagementKey(ManagementKeyPrefix + secret)
+	assert.Error(t, err)
+
+	key.ExpiredTime = -1
+	key.Status = ManagementKeyStatusDisabled
+	require.NoError(t, key.Update())
+	_, err = ValidateManagementKey(ManagementKeyPrefix + secret)
+	assert.Error(t, err)
+}
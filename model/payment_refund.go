@@ -0,0 +1,294 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Refund sources
+const (
+	RefundSourceAdmin    = "admin"    // 管理员发起退款
+	RefundSourceProvider = "provider" // 支付平台退款通知
+	RefundSourceDispute  = "dispute"  // 拒付/争议
+)
+
+var ErrPaymentOrderNotFound = errors.New("payment order not found")
+
+// PaymentRefundResult 退款处理结果
+type PaymentRefundResult struct {
+	TradeNo         string  `json:"trade_no"`
+	UserId          int     `json:"user_id"`
+	Money           float64 `json:"money"`
+	Quota           int64   `json:"quota"` // 扣回的额度
+	UserQuota       int64   `json:"user_quota"`
+	Suspended       bool    `json:"suspended"`
+	AlreadyRefunded bool    `json:"already_refunded"`
+
+	// 订阅订单对应的用户订阅，已被立即作废
+	UserSubscriptionId     int    `json:"user_subscription_id"`
+	PaymentProvider        string `json:"payment_provider"`
+	ProviderSubscriptionId string `json:"provider_subscription_id"`
+}
+
+// FindPaymentOrderTradeNo 按本地订单号或支付平台流水号查找本地订单号，找不到时返回空
+func FindPaymentOrderTradeNo(refs ...string) string {
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		var tradeNo string
+		DB.Model(&SubscriptionOrder{}).Where("trade_no = ? OR provider_trade_no = ?", ref, ref).
+			Limit(1).Pluck("trade_no", &tradeNo)
+		if tradeNo != "" {
+			return tradeNo
+		}
+		DB.Model(&TopUp{}).Where("trade_no = ? OR provider_trade_no = ?", ref, ref).
+			Limit(1).Pluck("trade_no", &tradeNo)
+		if tradeNo != "" {
+			return tradeNo
+		}
+	}
+	return ""
+}
+
+// GetPaymentOrderMethod 返回订阅订单或充值订单记录的支付方式，订单不存在时返回 ErrPaymentOrderNotFound
+func GetPaymentOrderMethod(tradeNo string) (string, error) {
+	if order := GetSubscriptionOrderByTradeNo(tradeNo); order != nil {
+		return order.PaymentMethod, nil
+	}
+	if topUp := GetTopUpByTradeNo(tradeNo); topUp != nil {
+		return topUp.PaymentMethod, nil
+	}
+	return "", ErrPaymentOrderNotFound
+}
+
+// topUpGrantedQuota 充值订单完成时为用户增加的额度。
+// 优先使用额度账本中的记录，否则按各支付平台的入账规则计算。
+func topUpGrantedQuota(tx *gorm.DB, topUp *TopUp) int64 {
+	var grant CreditGrant
+	if err := tx.Where("source = ? AND source_ref = ?", CreditSourceTopUp, topUp.TradeNo).
+		Limit(1).Find(&grant).Error; err == nil && grant.Id > 0 {
+		return grant.Amount
+	}
+	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+	switch topUp.PaymentMethod {
+	case "stripe":
+		return decimal.NewFromFloat(topUp.Money).Mul(dQuotaPerUnit).IntPart()
+	case "creem":
+		// Creem 直接使用 Amount 作为充值额度
+		return topUp.Amount
+	default:
+		return decimal.NewFromInt(topUp.Amount).Mul(dQuotaPerUnit).IntPart()
+	}
+}
+
+// RefundPaymentOrder 将已支付的充值或订阅订单标记为已退款：
+// 充值订单扣回已发放的额度（允许余额为负，可配置为负时封禁用户），
+// 订阅订单立即作废对应的用户订阅。重复调用时直接返回 AlreadyRefunded。
+func RefundPaymentOrder(tradeNo string, source string, reason string) (*PaymentRefundResult, error) {
+	if tradeNo == "" {
+		return nil, errors.New("未提供订单号")
+	}
+	refCol := "`trade_no`"
+	if common.UsingPostgreSQL {
+		refCol = `"trade_no"`
+	}
+	reason = strings.TrimSpace(reason)
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	now := common.GetTimestamp()
+	result := &PaymentRefundResult{TradeNo: tradeNo}
+	downgradeGroup := ""
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var order SubscriptionOrder
+		orderQuery := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", tradeNo).Limit(1).Find(&order)
+		if orderQuery.Error != nil {
+			return orderQuery.Error
+		}
+		if orderQuery.RowsAffected > 0 {
+			return refundSubscriptionOrderTx(tx, &order, reason, now, result, &downgradeGroup)
+		}
+		var topUp TopUp
+		topUpQuery := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", tradeNo).Limit(1).Find(&topUp)
+		if topUpQuery.Error != nil {
+			return topUpQuery.Error
+		}
+		if topUpQuery.RowsAffected == 0 {
+			return ErrPaymentOrderNotFound
+		}
+		return refundTopUpTx(tx, &topUp, reason, now, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.AlreadyRefunded {
+		return result, nil
+	}
+
+	_ = invalidateUserCache(result.UserId)
+	if downgradeGroup != "" {
+		_ = UpdateUserGroupCache(result.UserId, downgradeGroup)
+	}
+	content := fmt.Sprintf("订单退款（%s），订单号: %s，退款金额: %.2f", refundSourceName(source), tradeNo, result.Money)
+	if result.Quota > 0 {
+		content += fmt.Sprintf("，扣回额度: %s", logger.FormatQuota(int(result.Quota)))
+	}
+	if result.UserSubscriptionId > 0 {
+		content += fmt.Sprintf("，作废订阅ID: %d", result.UserSubscriptionId)
+	}
+	if result.Suspended {
+		content += "，余额为负，账户已被封禁"
+	}
+	if reason != "" {
+		content += "，原因: " + reason
+	}
+	RecordLog(result.UserId, LogTypeRefund, content)
+	return result, nil
+}
+
+func refundSourceName(source string) string {
+	switch source {
+	case RefundSourceAdmin:
+		return "管理员退款"
+	case RefundSourceDispute:
+		return "拒付"
+	default:
+		return "支付平台退款"
+	}
+}
+
+func refundSubscriptionOrderTx(tx *gorm.DB, order *SubscriptionOrder, reason string, now int64, result *PaymentRefundResult, downgradeGroup *string) error {
+	result.UserId = order.UserId
+	result.Money = order.Money
+	if order.Status == common.TopUpStatusRefunded {
+		result.AlreadyRefunded = true
+		return nil
+	}
+	if order.Status != common.TopUpStatusSuccess {
+		return errors.New("订单未支付，无法退款")
+	}
+	if err := tx.Model(&SubscriptionOrder{}).Where("id = ?", order.Id).Updates(map[string]interface{}{
+		"status":        common.TopUpStatusRefunded,
+		"refund_time":   now,
+		"refund_reason": reason,
+	}).Error; err != nil {
+		return err
+	}
+	// 订阅订单同时写入了一条充值记录用于账单展示
+	if err := tx.Model(&TopUp{}).Where("trade_no = ?", order.TradeNo).Updates(map[string]interface{}{
+		"status":        common.TopUpStatusRefunded,
+		"refund_time":   now,
+		"refund_reason": reason,
+	}).Error; err != nil {
+		return err
+	}
+	if _, err := refundPromoCodeUsageTx(tx, order.TradeNo); err != nil {
+		return err
+	}
+	if err := reverseAffiliateCommissionTx(tx, order.TradeNo, now); err != nil {
+		return err
+	}
+	if order.UserSubscriptionId <= 0 {
+		return nil
+	}
+	sub, target, err := invalidateUserSubscriptionTx(tx, order.UserSubscriptionId, now)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if sub.AutoRenew {
+		if err := tx.Model(&UserSubscription{}).Where("id = ?", sub.Id).Updates(map[string]interface{}{
+			"auto_renew":           false,
+			"cancel_at_period_end": false,
+		}).Error; err != nil {
+			return err
+		}
+		result.PaymentProvider = sub.PaymentProvider
+		result.ProviderSubscriptionId = sub.ProviderSubscriptionId
+	}
+	result.UserSubscriptionId = sub.Id
+	*downgradeGroup = target
+	return nil
+}
+
+func refundTopUpTx(tx *gorm.DB, topUp *TopUp, reason string, now int64, result *PaymentRefundResult) error {
+	result.UserId = topUp.UserId
+	result.Money = topUp.Money
+	if topUp.Status == common.TopUpStatusRefunded {
+		result.AlreadyRefunded = true
+		return nil
+	}
+	if topUp.Status != common.TopUpStatusSuccess {
+		return errors.New("订单未支付，无法退款")
+	}
+	quota := topUpGrantedQuota(tx, topUp)
+	// 优惠码赠送的额度一并扣回
+	bonus, err := refundPromoCodeUsageTx(tx, topUp.TradeNo)
+	if err != nil {
+		return err
+	}
+	quota += bonus
+	if err := reverseAffiliateCommissionTx(tx, topUp.TradeNo, now); err != nil {
+		return err
+	}
+	if err := tx.Model(&TopUp{}).Where("id = ?", topUp.Id).Updates(map[string]interface{}{
+		"status":        common.TopUpStatusRefunded,
+		"refund_time":   now,
+		"refund_reason": reason,
+	}).Error; err != nil {
+		return err
+	}
+	// 到期时已从余额中扣除的部分不再重复扣回，只扣回仍在余额中的和已消耗的额度
+	grantSources := []string{CreditSourceTopUp, CreditSourcePromotion}
+	var forfeited int64
+	if err := tx.Model(&CreditGrant{}).Select("COALESCE(SUM(forfeited), 0)").
+		Where("source IN ? AND source_ref = ?", grantSources, topUp.TradeNo).
+		Scan(&forfeited).Error; err != nil {
+		return err
+	}
+	quota -= forfeited
+	if quota < 0 {
+		quota = 0
+	}
+	// 作废账本中该订单的授予记录，已消耗部分由余额扣回
+	if err := tx.Model(&CreditGrant{}).
+		Where("source IN ? AND source_ref = ? AND status = ?", grantSources, topUp.TradeNo, CreditGrantStatusActive).
+		Updates(map[string]interface{}{
+			"remaining":  0,
+			"status":     CreditGrantStatusExhausted,
+			"updated_at": now,
+		}).Error; err != nil {
+		return err
+	}
+	if quota > 0 {
+		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).
+			Update("quota", gorm.Expr("quota - ?", quota)).Error; err != nil {
+			return err
+		}
+	}
+	result.Quota = quota
+
+	var user User
+	if err := tx.Select("id", "quota", "status").Where("id = ?", topUp.UserId).First(&user).Error; err != nil {
+		return err
+	}
+	result.UserQuota = int64(user.Quota)
+	if user.Quota < 0 && operation_setting.GetPaymentSetting().SuspendOnRefundDebt && user.Status == common.UserStatusEnabled {
+		if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("status", common.UserStatusDisabled).Error; err != nil {
+			return err
+		}
+		result.Suspended = true
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundPaymentOrder_TopUp(t *testing.T) {
+	setupTopUpTables(t)
+	setting := operation_setting.GetPaymentSetting()
+	setting.SuspendOnRefundDebt = true
+	t.Cleanup(func() { setting.SuspendOnRefundDebt = false })
+
+	grant := int(2 * common.QuotaPerUnit)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "refund_user", AffCode: "rf01", Quota: grant / 2,
+		Status: common.UserStatusEnabled}).Error)
+	require.NoError(t, (&TopUp{UserId: 1, Amount: 2, Money: 14, TradeNo: "USR1NOrefund", PaymentMethod: "alipay",
+		Status: common.TopUpStatusSuccess}).Insert())
+	SetPaymentProviderTradeNo("USR1NOrefund", "2024010100009")
+
+	assert.Equal(t, "USR1NOrefund", FindPaymentOrderTradeNo("", "2024010100009"))
+	assert.Equal(t, "", FindPaymentOrderTradeNo("missing"))
+	method, err := GetPaymentOrderMethod("USR1NOrefund")
+	require.NoError(t, err)
+	assert.Equal(t, "alipay", method)
+	_, err = GetPaymentOrderMethod("missing")
+	assert.ErrorIs(t, err, ErrPaymentOrderNotFound)
+
+	result, err := RefundPaymentOrder("USR1NOrefund", RefundSourceProvider, "requested_by_customer")
+	require.NoError(t, err)
+	assert.Equal(t, int64(grant), result.Quota)
+	assert.Equal(t, int64(-grant/2), result.UserQuota)
+	assert.True(t, result.Suspended)
+
+	var user User
+	require.NoError(t, DB.First(&user, 1).Error)
+	assert.Equal(t, -grant/2, user.Quota)
+	assert.Equal(t, common.UserStatusDisabled, user.Status)
+	topUp := GetTopUpByTradeNo("USR1NOrefund")
+	assert.Equal(t, common.TopUpStatusRefunded, topUp.Status)
+	assert.Equal(t, "requested_by_customer", topUp.RefundReason)
+
+	// 重复通知不会再次扣减
+	result, err = RefundPaymentOrder("USR1NOrefund", RefundSourceDispute, "")
+	require.NoError(t, err)
+	assert.True(t, result.AlreadyRefunded)
+	require.NoError(t, DB.First(&user, 1).Error)
+	assert.Equal(t, -grant/2, user.Quota)
+}
+
+func TestRefundPaymentOrder_PendingRejected(t *testing.T) {
+	setupTopUpTables(t)
+	require.NoError(t, (&TopUp{UserId: 1, Amount: 1, TradeNo: "pending-1", Status: common.TopUpStatusPending}).Insert())
+
+	_, err := RefundPaymentOrder("pending-1", RefundSourceAdmin, "")
+	assert.Error(t, err)
+	_, err = RefundPaymentOrder("missing", RefundSourceAdmin, "")
+	assert.ErrorIs(t, err, ErrPaymentOrderNotFound)
+}
+
+func TestRefundPaymentOrder_Subscription(t *testing.T) {
+	_, sub := setupRecurringSubscription(t)
+	require.NoError(t, (&TopUp{UserId: 1, Money: 10, TradeNo: "sub_ref_1", PaymentMethod: "stripe",
+		Status: common.TopUpStatusSuccess}).Insert())
+
+	result, err := RefundPaymentOrder("sub_ref_1", RefundSourceAdmin, "")
+	require.NoError(t, err)
+	assert.Equal(t, sub.Id, result.UserSubscriptionId)
+	assert.Equal(t, SubscriptionProviderStripe, result.PaymentProvider)
+	assert.Equal(t, "sub_stripe_1", result.ProviderSubscriptionId)
+
+	var refreshed UserSubscription
+	require.NoError(t, DB.First(&refreshed, sub.Id).Error)
+	assert.Equal(t, "cancelled", refreshed.Status)
+	assert.False(t, refreshed.AutoRenew)
+	assert.Equal(t, common.TopUpStatusRefunded, GetSubscriptionOrderByTradeNo("sub_ref_1").Status)
+	assert.Equal(t, common.TopUpStatusRefunded, GetTopUpByTradeNo("sub_ref_1").Status)
+}
+
+func TestRefundPaymentOrder_TopUpAfterGrantExpired(t *testing.T) {
+	setupTopUpTables(t)
+	setupCreditLedger(t)
+	// 1000 未记录的余额 + 本单充值 600（已消耗 200 后到期，剩余 400 已被过期任务扣除）
+	insertCreditUser(t, 5, 1600)
+	require.NoError(t, (&TopUp{UserId: 5, Amount: 600, Money: 6, TradeNo: "USR5NOexpired", PaymentMethod: "creem",
+		Status: common.TopUpStatusSuccess}).Insert())
+	require.NoError(t, RecordCreditGrantWithExpiryTx(nil, 5, CreditSourceTopUp, "USR5NOexpired", 600, common.GetTimestamp()+3600))
+	_, err := ConsumeCreditGrants(5, 200)
+	require.NoError(t, err)
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", 5).Update("quota", 1400).Error)
+	require.NoError(t, DB.Model(&CreditGrant{}).Where("user_id = ?", 5).Update("expires_at", common.GetTimestamp()-1).Error)
+	_, err = ExpireDueCreditGrants(10)
+	require.NoError(t, err)
+
+	var user User
+	require.NoError(t, DB.First(&user, 5).Error)
+	require.Equal(t, 1000, user.Quota)
+
+	// 只扣回已消耗的 200，到期作废的 400 不再重复扣除
+	result, err := RefundPaymentOrder("USR5NOexpired", RefundSourceAdmin, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), result.Quota)
+	require.NoError(t, DB.First(&user, 5).Error)
+	assert.Equal(t, 800, user.Quota)
+}
@@ -0,0 +1,492 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"gorm.io/gorm"
+)
+
+// Postpaid billing cycles
+const (
+	PostpaidCycleWeekly  = "weekly"
+	PostpaidCycleMonthly = "monthly"
+)
+
+// Postpaid account status
+const (
+	PostpaidAccountStatusActive    = "active"
+	PostpaidAccountStatusSuspended = "suspended"
+)
+
+// Postpaid statement status
+const (
+	PostpaidStatementStatusUnpaid = "unpaid"
+	PostpaidStatementStatusPaid   = "paid"
+	PostpaidStatementStatusVoid   = "void"
+)
+
+var (
+	ErrPostpaidCreditLimitExceeded = errors.New("postpaid credit limit exceeded")
+	ErrPostpaidAccountSuspended    = errors.New("postpaid account suspended")
+)
+
+// PostpaidAccount 后付费账户。开通后用户额度可以透支到 -CreditLimit，
+// 每个账期结束时按透支额出具账单。
+type PostpaidAccount struct {
+	Id                  int    `json:"id"`
+	UserId              int    `json:"user_id" gorm:"uniqueIndex"`
+	Enabled             bool   `json:"enabled"`
+	CreditLimit         int64  `json:"credit_limit" gorm:"type:bigint;not null;default:0"`
+	BillingCycle        string `json:"billing_cycle" gorm:"type:varchar(16);default:'monthly'"`
+	Status              string `json:"status" gorm:"type:varchar(16);default:'active';index"`
+	CycleStartAt        int64  `json:"cycle_start_at" gorm:"bigint"`
+	NextCycleAt         int64  `json:"next_cycle_at" gorm:"bigint;index"`
+	CycleStartQuota     int64  `json:"cycle_start_quota" gorm:"type:bigint;default:0"`      // 账期开始时的余额
+	CycleStartUsedQuota int64  `json:"cycle_start_used_quota" gorm:"type:bigint;default:0"` // 账期开始时的累计用量
+	SuspendedAt         int64  `json:"suspended_at" gorm:"bigint;default:0"`
+	Remark              string `json:"remark" gorm:"type:varchar(255);default:''"`
+	CreatedAt           int64  `json:"created_at" gorm:"bigint"`
+	UpdatedAt           int64  `json:"updated_at" gorm:"bigint"`
+}
+
+func (a *PostpaidAccount) BeforeCreate(tx *gorm.DB) error {
+	now := common.GetTimestamp()
+	a.CreatedAt = now
+	a.UpdatedAt = now
+	return nil
+}
+
+func (a *PostpaidAccount) BeforeUpdate(tx *gorm.DB) error {
+	a.UpdatedAt = common.GetTimestamp()
+	return nil
+}
+
+// PostpaidStatement 后付费账单
+type PostpaidStatement struct {
+	Id             int     `json:"id"`
+	UserId         int     `json:"user_id" gorm:"index"`
+	AccountId      int     `json:"account_id" gorm:"index"`
+	CycleStart     int64   `json:"cycle_start" gorm:"bigint"`
+	CycleEnd       int64   `json:"cycle_end" gorm:"bigint"`
+	OpeningBalance int64   `json:"opening_balance" gorm:"type:bigint;default:0"`
+	ClosingBalance int64   `json:"closing_balance" gorm:"type:bigint;default:0"`
+	Usage          int64   `json:"usage" gorm:"type:bigint;default:0"`
+	AmountDue      int64   `json:"amount_due" gorm:"type:bigint;default:0"` // 本期应付额度
+	Money          float64 `json:"money" gorm:"default:0"`
+	Status         string  `json:"status" gorm:"type:varchar(16);index"`
+	DueAt          int64   `json:"due_at" gorm:"bigint;index"`
+	PaidAt         int64   `json:"paid_at" gorm:"bigint;default:0"`
+	PaymentMethod  string  `json:"payment_method" gorm:"type:varchar(50);default:''"`
+	TradeNo        string  `json:"trade_no" gorm:"type:varchar(255);index;default:''"`
+	PaymentLink    string  `json:"payment_link" gorm:"type:text"`
+	CreatedAt      int64   `json:"created_at" gorm:"bigint"`
+	UpdatedAt      int64   `json:"updated_at" gorm:"bigint"`
+}
+
+func (s *PostpaidStatement) BeforeCreate(tx *gorm.DB) error {
+	now := common.GetTimestamp()
+	s.CreatedAt = now
+	s.UpdatedAt = now
+	return nil
+}
+
+func (s *PostpaidStatement) BeforeUpdate(tx *gorm.DB) error {
+	s.UpdatedAt = common.GetTimestamp()
+	return nil
+}
+
+func NormalizePostpaidCycle(cycle string) string {
+	switch cycle {
+	case PostpaidCycleWeekly, PostpaidCycleMonthly:
+		return cycle
+	default:
+		return PostpaidCycleMonthly
+	}
+}
+
+// calcPostpaidNextCycleAt 账期与订阅重置周期对齐：周账期为下周一 0 点，月账期为下月 1 日 0 点
+func calcPostpaidNextCycleAt(base time.Time, cycle string) int64 {
+	day := time.Date(base.Year(), base.Month(), base.Day(), 0, 0, 0, 0, base.Location())
+	switch NormalizePostpaidCycle(cycle) {
+	case PostpaidCycleWeekly:
+		weekday := int(base.Weekday())
+		if weekday == 0 {
+			weekday = 7
+		}
+		return day.AddDate(0, 0, 8-weekday).Unix()
+	default:
+		return time.Date(base.Year(), base.Month(), 1, 0, 0, 0, 0, base.Location()).AddDate(0, 1, 0).Unix()
+	}
+}
+
+// quotaToMoney 将额度换算为金额（按 QuotaPerUnit 折算，保留两位小数）
+func quotaToMoney(quota int64) float64 {
+	if common.QuotaPerUnit <= 0 {
+		return 0
+	}
+	return math.Round(float64(quota)/common.QuotaPerUnit*100) / 100
+}
+
+// GetPostpaidAccountByUserId 获取用户的后付费账户，不存在时返回 nil
+func GetPostpaidAccountByUserId(userId int) (*PostpaidAccount, error) {
+	var account PostpaidAccount
+	err := DB.Where("user_id = ?", userId).Limit(1).Find(&account).Error
+	if err != nil {
+		return nil, err
+	}
+	if account.Id == 0 {
+		return nil, nil
+	}
+	return &account, nil
+}
+
+// GetActivePostpaidAccount 获取用户已开通的后付费账户；未启用后付费或未开通时返回 nil
+func GetActivePostpaidAccount(userId int) (*PostpaidAccount, error) {
+	if !operation_setting.IsPostpaidEnabled() {
+		return nil, nil
+	}
+	account, err := GetPostpaidAccountByUserId(userId)
+	if err != nil || account == nil || !account.Enabled {
+		return nil, err
+	}
+	return account, nil
+}
+
+// UpsertPostpaidAccount 开通或更新用户的后付费账户
+func UpsertPostpaidAccount(userId int, enabled bool, creditLimit int64, cycle string, remark string) (*PostpaidAccount, error) {
+	if creditLimit < 0 {
+		return nil, errors.New("信用额度不能为负数")
+	}
+	cycle = NormalizePostpaidCycle(cycle)
+	var account PostpaidAccount
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Select("id", "quota", "used_quota").Where("id = ?", userId).First(&user).Error; err != nil {
+			return err
+		}
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("user_id = ?", userId).Limit(1).Find(&account).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if account.Id == 0 {
+			account = PostpaidAccount{
+				UserId:              userId,
+				Enabled:             enabled,
+				CreditLimit:         creditLimit,
+				BillingCycle:        cycle,
+				Status:              PostpaidAccountStatusActive,
+				CycleStartAt:        now.Unix(),
+				NextCycleAt:         calcPostpaidNextCycleAt(now, cycle),
+				CycleStartQuota:     int64(user.Quota),
+				CycleStartUsedQuota: int64(user.UsedQuota),
+				Remark:              remark,
+			}
+			return tx.Create(&account).Error
+		}
+		updates := map[string]interface{}{
+			"enabled":      enabled,
+			"credit_limit": creditLimit,
+			"remark":       remark,
+		}
+		if account.BillingCycle != cycle {
+			// 账期变更从下一个账期开始生效
+			updates["billing_cycle"] = cycle
+		}
+		if err := tx.Model(&account).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", account.Id).First(&account).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// PreConsumePostpaidQuota 在信用额度内扣减用户额度。
+// 使用条件更新保证并发请求不会透支超过信用额度。
+func PreConsumePostpaidQuota(account *PostpaidAccount, quota int) error {
+	if account == nil {
+		return errors.New("postpaid account is nil")
+	}
+	if account.Status == PostpaidAccountStatusSuspended {
+		return ErrPostpaidAccountSuspended
+	}
+	if quota <= 0 {
+		userQuota, err := GetUserQuota(account.UserId, false)
+		if err != nil {
+			return err
+		}
+		if int64(userQuota) <= -account.CreditLimit {
+			return ErrPostpaidCreditLimitExceeded
+		}
+		return nil
+	}
+	result := DB.Model(&User{}).
+		Where("id = ? AND quota - ? >= ?", account.UserId, quota, -account.CreditLimit).
+		Update("quota", gorm.Expr("quota - ?", quota))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPostpaidCreditLimitExceeded
+	}
+	if err := cacheDecrUserQuota(account.UserId, int64(quota)); err != nil {
+		common.SysLog("failed to decrease user quota cache: " + err.Error())
+	}
+	return nil
+}
+
+// ClosePostpaidCycle 结束账户当前账期并出具账单；账期未到时返回 nil
+func ClosePostpaidCycle(accountId int) (*PostpaidStatement, error) {
+	var statement *PostpaidStatement
+	dueDays := operation_setting.GetPostpaidSetting().PaymentDueDays
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var account PostpaidAccount
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", accountId).First(&account).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if account.NextCycleAt <= 0 || account.NextCycleAt > now.Unix() {
+			return nil
+		}
+		var user User
+		if err := tx.Select("id", "quota", "used_quota").Where("id = ?", account.UserId).First(&user).Error; err != nil {
+			return err
+		}
+		closing := int64(user.Quota)
+		usage := int64(user.UsedQuota) - account.CycleStartUsedQuota
+		if usage < 0 {
+			usage = 0
+		}
+		// 透支额中已由未付账单覆盖的部分不再重复计费
+		var billed int64
+		if err := tx.Model(&PostpaidStatement{}).
+			Select("COALESCE(SUM(amount_due), 0)").
+			Where("user_id = ? AND status = ?", account.UserId, PostpaidStatementStatusUnpaid).
+			Scan(&billed).Error; err != nil {
+			return err
+		}
+		var due int64
+		if closing < 0 {
+			due = -closing - billed
+		}
+		if due < 0 {
+			due = 0
+		}
+		statement = &PostpaidStatement{
+			UserId:         account.UserId,
+			AccountId:      account.Id,
+			CycleStart:     account.CycleStartAt,
+			CycleEnd:       account.NextCycleAt,
+			OpeningBalance: account.CycleStartQuota,
+			ClosingBalance: closing,
+			Usage:          usage,
+			AmountDue:      due,
+			Money:          quotaToMoney(due),
+			Status:         PostpaidStatementStatusUnpaid,
+			DueAt:          now.Unix() + int64(dueDays)*24*3600,
+		}
+		if due == 0 {
+			statement.Status = PostpaidStatementStatusPaid
+			statement.PaidAt = now.Unix()
+		}
+		if err := tx.Create(statement).Error; err != nil {
+			return err
+		}
+		return tx.Model(&account).Updates(map[string]interface{}{
+			"cycle_start_at":         account.NextCycleAt,
+			"next_cycle_at":          calcPostpaidNextCycleAt(now, account.BillingCycle),
+			"cycle_start_quota":      closing,
+			"cycle_start_used_quota": int64(user.UsedQuota),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if statement != nil && statement.AmountDue > 0 {
+		RecordLog(statement.UserId, LogTypeSystem, fmt.Sprintf("后付费账单已出具，账单ID %d，应付额度 %s", statement.Id, logger.LogQuota(int(statement.AmountDue))))
+	}
+	return statement, nil
+}
+
+// GetDuePostpaidAccountIds 获取账期已到的后付费账户
+func GetDuePostpaidAccountIds(limit int) ([]int, error) {
+	var ids []int
+	err := DB.Model(&PostpaidAccount{}).
+		Where("enabled = ? AND next_cycle_at > 0 AND next_cycle_at <= ?", true, common.GetTimestamp()).
+		Order("next_cycle_at asc").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// UpdatePostpaidStatementPayment 记录账单的支付订单和支付链接
+func UpdatePostpaidStatementPayment(statementId int, paymentMethod string, tradeNo string, paymentLink string) error {
+	return DB.Model(&PostpaidStatement{}).Where("id = ?", statementId).Updates(map[string]interface{}{
+		"payment_method": paymentMethod,
+		"trade_no":       tradeNo,
+		"payment_link":   paymentLink,
+		"updated_at":     common.GetTimestamp(),
+	}).Error
+}
+
+func GetPostpaidStatementById(id int) (*PostpaidStatement, error) {
+	var statement PostpaidStatement
+	if err := DB.Where("id = ?", id).First(&statement).Error; err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
+
+func GetUserPostpaidStatements(userId int, pageInfo *common.PageInfo) (statements []*PostpaidStatement, total int64, err error) {
+	query := DB.Model(&PostpaidStatement{}).Where("user_id = ?", userId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&statements).Error; err != nil {
+		return nil, 0, err
+	}
+	return statements, total, nil
+}
+
+// postpaidStatementDueTx 返回充值订单所支付账单的应付额度；订单不关联未支付账单时 ok 为 false。
+// 支付渠道按整数单位或分收款，实付折算额度可能高于应付，入账以账单应付额度为准
+func postpaidStatementDueTx(tx *gorm.DB, tradeNo string) (due int64, ok bool, err error) {
+	if tradeNo == "" {
+		return 0, false, nil
+	}
+	var statement PostpaidStatement
+	if err := tx.Where("trade_no = ? AND status = ?", tradeNo, PostpaidStatementStatusUnpaid).Limit(1).Find(&statement).Error; err != nil {
+		return 0, false, err
+	}
+	if statement.Id == 0 || statement.AmountDue <= 0 {
+		return 0, false, nil
+	}
+	return statement.AmountDue, true, nil
+}
+
+// SettlePostpaidStatementByTradeNo 充值订单完成后，将关联的账单标记为已支付
+func SettlePostpaidStatementByTradeNo(tradeNo string) {
+	if tradeNo == "" {
+		return
+	}
+	var statement PostpaidStatement
+	if err := DB.Where("trade_no = ? AND status = ?", tradeNo, PostpaidStatementStatusUnpaid).Limit(1).Find(&statement).Error; err != nil || statement.Id == 0 {
+		return
+	}
+	result := DB.Model(&PostpaidStatement{}).
+		Where("id = ? AND status = ?", statement.Id, PostpaidStatementStatusUnpaid).
+		Updates(map[string]interface{}{
+			"status":     PostpaidStatementStatusPaid,
+			"paid_at":    common.GetTimestamp(),
+			"updated_at": common.GetTimestamp(),
+		})
+	if result.Error != nil {
+		common.SysLog(fmt.Sprintf("failed to settle postpaid statement %d: %s", statement.Id, result.Error.Error()))
+		return
+	}
+	if result.RowsAffected > 0 {
+		RecordLog(statement.UserId, LogTypeSystem, fmt.Sprintf("后付费账单已支付，账单ID %d", statement.Id))
+		reactivatePostpaidAccount(statement.UserId)
+	}
+}
+
+// AdminMarkPostpaidStatementPaid 管理员确认线下收款：账单标记为已支付并补回对应额度
+func AdminMarkPostpaidStatementPaid(statementId int) (*PostpaidStatement, error) {
+	var statement PostpaidStatement
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", statementId).First(&statement).Error; err != nil {
+			return err
+		}
+		if statement.Status != PostpaidStatementStatusUnpaid {
+			return errors.New("账单状态错误")
+		}
+		statement.Status = PostpaidStatementStatusPaid
+		statement.PaidAt = common.GetTimestamp()
+		statement.PaymentMethod = "manual"
+		if err := tx.Save(&statement).Error; err != nil {
+			return err
+		}
+		if statement.AmountDue > 0 {
+			return tx.Model(&User{}).Where("id = ?", statement.UserId).
+				Update("quota", gorm.Expr("quota + ?", statement.AmountDue)).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if statement.AmountDue > 0 {
+		_ = cacheIncrUserQuota(statement.UserId, statement.AmountDue)
+	}
+	reactivatePostpaidAccount(statement.UserId)
+	return &statement, nil
+}
+
+func postpaidOverdueBefore() int64 {
+	suspendDays := operation_setting.GetPostpaidSetting().SuspendAfterDays
+	if suspendDays < 0 {
+		suspendDays = 0
+	}
+	return common.GetTimestamp() - int64(suspendDays)*24*3600
+}
+
+// reactivatePostpaidAccount 没有逾期账单时恢复被暂停的账户
+func reactivatePostpaidAccount(userId int) {
+	var overdue int64
+	if err := DB.Model(&PostpaidStatement{}).
+		Where("user_id = ? AND status = ? AND due_at <= ?", userId, PostpaidStatementStatusUnpaid, postpaidOverdueBefore()).
+		Count(&overdue).Error; err != nil || overdue > 0 {
+		return
+	}
+	result := DB.Model(&PostpaidAccount{}).
+		Where("user_id = ? AND status = ?", userId, PostpaidAccountStatusSuspended).
+		Updates(map[string]interface{}{
+			"status":       PostpaidAccountStatusActive,
+			"suspended_at": 0,
+			"updated_at":   common.GetTimestamp(),
+		})
+	if result.Error == nil && result.RowsAffected > 0 {
+		RecordLog(userId, LogTypeSystem, "后付费账单已结清，账户恢复使用")
+	}
+}
+
+// SuspendOverduePostpaidAccounts 暂停逾期超过宽限期的后付费账户
+func SuspendOverduePostpaidAccounts(limit int) (int, error) {
+	var userIds []int
+	if err := DB.Model(&PostpaidStatement{}).
+		Where("status = ? AND due_at <= ?", PostpaidStatementStatusUnpaid, postpaidOverdueBefore()).
+		Where("user_id IN (?)", DB.Model(&PostpaidAccount{}).Select("user_id").Where("status = ?", PostpaidAccountStatusActive)).
+		Distinct("user_id").
+		Limit(limit).
+		Pluck("user_id", &userIds).Error; err != nil {
+		return 0, err
+	}
+	suspended := 0
+	now := common.GetTimestamp()
+	for _, userId := range userIds {
+		result := DB.Model(&PostpaidAccount{}).
+			Where("user_id = ? AND status = ?", userId, PostpaidAccountStatusActive).
+			Updates(map[string]interface{}{
+				"status":       PostpaidAccountStatusSuspended,
+				"suspended_at": now,
+				"updated_at":   now,
+			})
+		if result.Error != nil {
+			return suspended, result.Error
+		}
+		if result.RowsAffected > 0 {
+			suspended++
+			RecordLog(userId, LogTypeSystem, "后付费账单逾期未支付，账户已暂停使用")
+		}
+	}
+	return suspended, nil
+}
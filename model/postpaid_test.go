@@ -0,0 +1,91 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupPostpaid(t *testing.T) {
+	t.Helper()
+	require.NoError(t, DB.AutoMigrate(&PostpaidAccount{}, &PostpaidStatement{}))
+	setting := operation_setting.GetPostpaidSetting()
+	prev := *setting
+	setting.Enabled = true
+	t.Cleanup(func() {
+		*setting = prev
+		DB.Exec("DELETE FROM postpaid_accounts")
+		DB.Exec("DELETE FROM postpaid_statements")
+	})
+	truncateTables(t)
+}
+
+func TestPreConsumePostpaidQuota_CreditLimit(t *testing.T) {
+	setupPostpaid(t)
+	require.NoError(t, DB.Create(&User{Id: 11, Username: "postpaid_user", Quota: 100, AffCode: "pp01"}).Error)
+	account, err := UpsertPostpaidAccount(11, true, 500, PostpaidCycleMonthly, "")
+	require.NoError(t, err)
+
+	require.NoError(t, PreConsumePostpaidQuota(account, 550))
+	quota, err := GetUserQuota(11, true)
+	require.NoError(t, err)
+	assert.Equal(t, -450, quota)
+
+	assert.ErrorIs(t, PreConsumePostpaidQuota(account, 100), ErrPostpaidCreditLimitExceeded)
+
+	account.Status = PostpaidAccountStatusSuspended
+	assert.ErrorIs(t, PreConsumePostpaidQuota(account, 1), ErrPostpaidAccountSuspended)
+}
+
+func TestClosePostpaidCycle_StatementAndSuspend(t *testing.T) {
+	setupPostpaid(t)
+	require.NoError(t, DB.Create(&User{Id: 12, Username: "postpaid_user2", Quota: 0, UsedQuota: 1000, AffCode: "pp02"}).Error)
+	account, err := UpsertPostpaidAccount(12, true, 1000, PostpaidCycleWeekly, "")
+	require.NoError(t, err)
+
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", 12).Updates(map[string]interface{}{"quota": -300, "used_quota": 1300}).Error)
+	require.NoError(t, DB.Model(&PostpaidAccount{}).Where("id = ?", account.Id).Update("next_cycle_at", 1).Error)
+
+	statement, err := ClosePostpaidCycle(account.Id)
+	require.NoError(t, err)
+	require.NotNil(t, statement)
+	assert.Equal(t, int64(300), statement.AmountDue)
+	assert.Equal(t, int64(300), statement.Usage)
+	assert.Equal(t, PostpaidStatementStatusUnpaid, statement.Status)
+
+	// 账期已推进，再次关闭不会重复出账
+	again, err := ClosePostpaidCycle(account.Id)
+	require.NoError(t, err)
+	assert.Nil(t, again)
+
+	operation_setting.GetPostpaidSetting().SuspendAfterDays = 0
+	require.NoError(t, DB.Model(&PostpaidStatement{}).Where("id = ?", statement.Id).Update("due_at", 1).Error)
+	n, err := SuspendOverduePostpaidAccounts(10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, DB.Model(&PostpaidStatement{}).Where("id = ?", statement.Id).Update("trade_no", "trade-pp").Error)
+	SettlePostpaidStatementByTradeNo("trade-pp")
+	refreshed, err := GetPostpaidAccountByUserId(12)
+	require.NoError(t, err)
+	assert.Equal(t, PostpaidAccountStatusActive, refreshed.Status)
+}
+
+func TestRechargeEpay_PostpaidStatementCreditsAmountDue(t *testing.T) {
+	setupPostpaid(t)
+	setupTopUpTables(t)
+	require.NoError(t, DB.Create(&User{Id: 13, Username: "postpaid_user3", Quota: -300, AffCode: "pp03"}).Error)
+	require.NoError(t, DB.Create(&PostpaidStatement{UserId: 13, AmountDue: 300, Status: PostpaidStatementStatusUnpaid,
+		TradeNo: "USR13NOpp"}).Error)
+	// 应付额度不足一个充值单位时按一个单位下单，入账仍只补回应付额度
+	require.NoError(t, (&TopUp{UserId: 13, Amount: 1, Money: 0.01, TradeNo: "USR13NOpp", PaymentMethod: "alipay",
+		Status: common.TopUpStatusPending}).Insert())
+
+	require.NoError(t, RechargeEpay("USR13NOpp"))
+	quota, err := GetUserQuota(13, true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, quota)
+}
@@ -0,0 +1,490 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Promo code types
+const (
+	PromoCodeTypeDiscount = "discount" // 按比例减免支付金额
+	PromoCodeTypeBonus    = "bonus"    // 按比例赠送充值额度
+)
+
+// Promo code scopes
+const (
+	PromoCodeScopeAll          = "all"
+	PromoCodeScopeTopUp        = "topup"
+	PromoCodeScopeSubscription = "subscription"
+)
+
+const (
+	PromoCodeStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PromoCodeStatusDisabled = 2 // also don't use 0
+)
+
+// promoCodeReserveSeconds 待支付订单占用优惠码名额的时长，超时未支付的订单不再计入使用次数
+const promoCodeReserveSeconds = 2 * 60 * 60
+
+// PromoCode 优惠码：下单时按比例减免支付金额，或在充值到账时按比例赠送额度
+type PromoCode struct {
+	Id           int     `json:"id"`
+	Code         string  `json:"code" gorm:"type:varchar(64);uniqueIndex"`
+	Name         string  `json:"name" gorm:"type:varchar(128);default:''"`
+	Campaign     string  `json:"campaign" gorm:"type:varchar(64);index;default:''"`
+	Type         string  `json:"type" gorm:"type:varchar(16)"`
+	Percent      float64 `json:"percent"` // 折扣或赠送比例，单位为百分比
+	Scope        string  `json:"scope" gorm:"type:varchar(16);default:'all'"`
+	PlanIds      string  `json:"plan_ids" gorm:"type:varchar(255);default:''"`    // 限定套餐，逗号分隔，空表示不限
+	UserGroups   string  `json:"user_groups" gorm:"type:varchar(255);default:''"` // 限定用户分组，逗号分隔，空表示不限
+	MinMoney     float64 `json:"min_money"`                                       // 订单原价不低于该金额时可用
+	PerUserLimit int     `json:"per_user_limit" gorm:"default:1"`                 // 0 表示不限
+	TotalLimit   int     `json:"total_limit" gorm:"default:0"`                    // 0 表示不限
+	UsedCount    int     `json:"used_count" gorm:"default:0"`
+	StartTime    int64   `json:"start_time" gorm:"bigint;default:0"`
+	EndTime      int64   `json:"end_time" gorm:"bigint;default:0"` // 0 表示不过期
+	Status       int     `json:"status" gorm:"default:1"`
+	CreatedBy    int     `json:"created_by"`
+	CreatedTime  int64   `json:"created_time" gorm:"bigint"`
+}
+
+// PromoCodeUsage 优惠码使用记录，与充值/订阅订单一一对应，状态随订单流转
+type PromoCodeUsage struct {
+	Id            int     `json:"id"`
+	PromoCodeId   int     `json:"promo_code_id" gorm:"index"`
+	Code          string  `json:"code" gorm:"type:varchar(64)"`
+	Campaign      string  `json:"campaign" gorm:"type:varchar(64);index;default:''"`
+	Type          string  `json:"type" gorm:"type:varchar(16)"`
+	Percent       float64 `json:"percent"`
+	UserId        int     `json:"user_id" gorm:"index"`
+	TradeNo       string  `json:"trade_no" gorm:"type:varchar(255);uniqueIndex"`
+	OrderType     string  `json:"order_type" gorm:"type:varchar(16)"`
+	OriginalMoney float64 `json:"original_money"`
+	DiscountMoney float64 `json:"discount_money"`
+	BonusQuota    int64   `json:"bonus_quota" gorm:"type:bigint;default:0"`
+	Status        string  `json:"status" gorm:"type:varchar(16);index"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+	CompleteTime  int64   `json:"complete_time" gorm:"bigint;default:0"`
+}
+
+// PromoQuote 优惠码试算结果
+type PromoQuote struct {
+	PromoCodeId   int     `json:"promo_code_id"`
+	Code          string  `json:"code"`
+	Campaign      string  `json:"campaign"`
+	Type          string  `json:"type"`
+	Percent       float64 `json:"percent"`
+	Scope         string  `json:"scope"`
+	OriginalMoney float64 `json:"original_money"`
+	DiscountMoney float64 `json:"discount_money"`
+	PayMoney      float64 `json:"pay_money"`
+}
+
+// DiscountPercent 需要在支付平台侧抵扣的折扣比例
+func (q *PromoQuote) DiscountPercent() float64 {
+	if q == nil || q.Type != PromoCodeTypeDiscount {
+		return 0
+	}
+	return q.Percent
+}
+
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate 校验管理员提交的优惠码配置
+func (p *PromoCode) Validate() error {
+	p.Code = NormalizePromoCode(p.Code)
+	if p.Code == "" || len(p.Code) > 64 {
+		return errors.New("优惠码长度必须在1-64之间")
+	}
+	switch p.Type {
+	case PromoCodeTypeDiscount:
+		if p.Percent <= 0 || p.Percent >= 100 {
+			return errors.New("折扣比例必须在0-100之间")
+		}
+	case PromoCodeTypeBonus:
+		if p.Percent <= 0 || p.Percent > 1000 {
+			return errors.New("赠送比例必须在0-1000之间")
+		}
+	default:
+		return errors.New("无效的优惠码类型")
+	}
+	if p.Scope == "" {
+		p.Scope = PromoCodeScopeAll
+	}
+	switch p.Scope {
+	case PromoCodeScopeAll, PromoCodeScopeTopUp:
+	case PromoCodeScopeSubscription:
+		if p.Type == PromoCodeTypeBonus {
+			return errors.New("赠送额度优惠码仅适用于充值")
+		}
+	default:
+		return errors.New("无效的适用范围")
+	}
+	if p.PerUserLimit < 0 || p.TotalLimit < 0 || p.MinMoney < 0 {
+		return errors.New("使用限制不能为负数")
+	}
+	if p.EndTime != 0 && p.EndTime <= p.StartTime {
+		return errors.New("结束时间必须晚于开始时间")
+	}
+	p.PlanIds = normalizePromoList(p.PlanIds)
+	p.UserGroups = normalizePromoList(p.UserGroups)
+	for _, id := range splitPromoList(p.PlanIds) {
+		if _, err := strconv.Atoi(id); err != nil {
+			return fmt.Errorf("无效的套餐ID: %s", id)
+		}
+	}
+	return nil
+}
+
+func splitPromoList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func normalizePromoList(value string) string {
+	return strings.Join(splitPromoList(value), ",")
+}
+
+func promoListContains(list string, value string) bool {
+	for _, item := range splitPromoList(list) {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PromoCode) Insert() error {
+	p.CreatedTime = common.GetTimestamp()
+	return DB.Create(p).Error
+}
+
+// Update 更新优惠码配置，不覆盖已使用次数
+func (p *PromoCode) Update() error {
+	return DB.Model(p).Select("name", "campaign", "type", "percent", "scope", "plan_ids", "user_groups",
+		"min_money", "per_user_limit", "total_limit", "start_time", "end_time", "status").Updates(p).Error
+}
+
+func GetPromoCodeById(id int) (*PromoCode, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var promo PromoCode
+	err := DB.First(&promo, "id = ?", id).Error
+	return &promo, err
+}
+
+func GetPromoCodes(keyword string, campaign string, pageInfo *common.PageInfo) (promos []*PromoCode, total int64, err error) {
+	query := DB.Model(&PromoCode{})
+	if keyword != "" {
+		like := "%" + keyword + "%"
+		query = query.Where("code LIKE ? OR name LIKE ?", strings.ToUpper(like), like)
+	}
+	if campaign != "" {
+		query = query.Where("campaign = ?", campaign)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&promos).Error
+	return promos, total, err
+}
+
+// DeletePromoCodeById 删除优惠码，使用记录保留用于统计
+func DeletePromoCodeById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Delete(&PromoCode{}, "id = ?", id).Error
+}
+
+// countPromoCodeUsagesTx 统计已完成和仍在支付有效期内的使用次数
+func countPromoCodeUsagesTx(tx *gorm.DB, promoCodeId int, userId int, now int64) (int64, error) {
+	query := tx.Model(&PromoCodeUsage{}).Where("promo_code_id = ?", promoCodeId).
+		Where("status = ? OR (status = ? AND created_time > ?)",
+			common.TopUpStatusSuccess, common.TopUpStatusPending, now-promoCodeReserveSeconds)
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count, err
+}
+
+func checkPromoCodeTx(tx *gorm.DB, promo *PromoCode, userId int, group string, scope string, planId int, money float64, now int64) error {
+	if promo.Status != PromoCodeStatusEnabled {
+		return errors.New("优惠码已停用")
+	}
+	if promo.StartTime > 0 && now < promo.StartTime {
+		return errors.New("优惠码活动尚未开始")
+	}
+	if promo.EndTime > 0 && now >= promo.EndTime {
+		return errors.New("优惠码已过期")
+	}
+	if promo.Scope != PromoCodeScopeAll && promo.Scope != scope {
+		return errors.New("优惠码不适用于当前订单")
+	}
+	if scope == PromoCodeScopeSubscription {
+		if promo.Type != PromoCodeTypeDiscount {
+			return errors.New("优惠码不适用于当前订单")
+		}
+		if promo.PlanIds != "" && !promoListContains(promo.PlanIds, strconv.Itoa(planId)) {
+			return errors.New("优惠码不适用于该套餐")
+		}
+	}
+	if promo.UserGroups != "" && !promoListContains(promo.UserGroups, group) {
+		return errors.New("当前用户分组不可使用该优惠码")
+	}
+	if money < promo.MinMoney {
+		return fmt.Errorf("订单金额需满 %.2f 才能使用该优惠码", promo.MinMoney)
+	}
+	if promo.TotalLimit > 0 {
+		count, err := countPromoCodeUsagesTx(tx, promo.Id, 0, now)
+		if err != nil {
+			return err
+		}
+		if count >= int64(promo.TotalLimit) {
+			return errors.New("优惠码已被领完")
+		}
+	}
+	if promo.PerUserLimit > 0 {
+		count, err := countPromoCodeUsagesTx(tx, promo.Id, userId, now)
+		if err != nil {
+			return err
+		}
+		if count >= int64(promo.PerUserLimit) {
+			return errors.New("已达到该优惠码使用次数上限")
+		}
+	}
+	return nil
+}
+
+func buildPromoQuote(promo *PromoCode, scope string, money float64) *PromoQuote {
+	quote := &PromoQuote{
+		PromoCodeId:   promo.Id,
+		Code:          promo.Code,
+		Campaign:      promo.Campaign,
+		Type:          promo.Type,
+		Percent:       promo.Percent,
+		Scope:         scope,
+		OriginalMoney: money,
+		PayMoney:      money,
+	}
+	if promo.Type == PromoCodeTypeDiscount {
+		dMoney := decimal.NewFromFloat(money)
+		discount := dMoney.Mul(decimal.NewFromFloat(promo.Percent)).Div(decimal.NewFromInt(100)).Round(2)
+		quote.DiscountMoney = discount.InexactFloat64()
+		quote.PayMoney = dMoney.Sub(discount).InexactFloat64()
+	}
+	return quote
+}
+
+// QuotePromoCode 校验优惠码对当前订单是否可用，并计算优惠后的支付金额。
+// money 为订单原价，scope 为 topup 或 subscription，planId 仅订阅订单需要。
+func QuotePromoCode(code string, userId int, group string, scope string, planId int, money float64) (*PromoQuote, error) {
+	code = NormalizePromoCode(code)
+	if code == "" {
+		return nil, errors.New("优惠码为空")
+	}
+	var promo PromoCode
+	if err := DB.Where("code = ?", code).First(&promo).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("优惠码不存在")
+		}
+		return nil, err
+	}
+	if err := checkPromoCodeTx(DB, &promo, userId, group, scope, planId, money, common.GetTimestamp()); err != nil {
+		return nil, err
+	}
+	quote := buildPromoQuote(&promo, scope, money)
+	if quote.PayMoney < 0.01 {
+		return nil, errors.New("优惠后金额过低")
+	}
+	return quote, nil
+}
+
+// ReservePromoCode 下单时为订单占用一次优惠码名额，加锁后重新校验使用次数避免超发
+func ReservePromoCode(quote *PromoQuote, userId int, group string, planId int, tradeNo string) error {
+	if quote == nil {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var promo PromoCode
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", quote.PromoCodeId).First(&promo).Error; err != nil {
+			return errors.New("优惠码不存在")
+		}
+		now := common.GetTimestamp()
+		if err := checkPromoCodeTx(tx, &promo, userId, group, quote.Scope, planId, quote.OriginalMoney, now); err != nil {
+			return err
+		}
+		usage := &PromoCodeUsage{
+			PromoCodeId:   promo.Id,
+			Code:          promo.Code,
+			Campaign:      promo.Campaign,
+			Type:          promo.Type,
+			Percent:       promo.Percent,
+			UserId:        userId,
+			TradeNo:       tradeNo,
+			OrderType:     quote.Scope,
+			OriginalMoney: quote.OriginalMoney,
+			DiscountMoney: quote.DiscountMoney,
+			Status:        common.TopUpStatusPending,
+			CreatedTime:   now,
+		}
+		return tx.Create(usage).Error
+	})
+}
+
+// ReleasePromoCode 下单失败时释放占用的优惠码名额
+func ReleasePromoCode(tradeNo string) {
+	_ = expirePromoCodeUsageTx(DB, tradeNo)
+}
+
+func expirePromoCodeUsageTx(tx *gorm.DB, tradeNo string) error {
+	return tx.Model(&PromoCodeUsage{}).Where("trade_no = ? AND status = ?", tradeNo, common.TopUpStatusPending).
+		Update("status", common.TopUpStatusExpired).Error
+}
+
+// completePromoCodeUsageTx 订单支付成功时核销优惠码；赠送类优惠码按到账额度发放赠送额度，返回赠送额度
+func completePromoCodeUsageTx(tx *gorm.DB, tradeNo string, grantedQuota int64) (int64, error) {
+	var usage PromoCodeUsage
+	result := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("trade_no = ? AND status = ?", tradeNo, common.TopUpStatusPending).Limit(1).Find(&usage)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return 0, result.Error
+	}
+	var bonus int64
+	if usage.Type == PromoCodeTypeBonus && grantedQuota > 0 {
+		bonus = decimal.NewFromInt(grantedQuota).Mul(decimal.NewFromFloat(usage.Percent)).
+			Div(decimal.NewFromInt(100)).IntPart()
+	}
+	if err := tx.Model(&PromoCodeUsage{}).Where("id = ?", usage.Id).Updates(map[string]interface{}{
+		"status":        common.TopUpStatusSuccess,
+		"bonus_quota":   bonus,
+		"complete_time": common.GetTimestamp(),
+	}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&PromoCode{}).Where("id = ?", usage.PromoCodeId).
+		Update("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+		return 0, err
+	}
+	if bonus > 0 {
+		if err := tx.Model(&User{}).Where("id = ?", usage.UserId).Update("quota", gorm.Expr("quota + ?", bonus)).Error; err != nil {
+			return 0, err
+		}
+		if err := RecordCreditGrantTx(tx, usage.UserId, CreditSourcePromotion, tradeNo, bonus); err != nil {
+			return 0, err
+		}
+	}
+	return bonus, nil
+}
+
+// refundPromoCodeUsageTx 订单退款时标记优惠码使用记录，返回需要一并扣回的赠送额度
+func refundPromoCodeUsageTx(tx *gorm.DB, tradeNo string) (int64, error) {
+	var usage PromoCodeUsage
+	result := tx.Where("trade_no = ? AND status = ?", tradeNo, common.TopUpStatusSuccess).Limit(1).Find(&usage)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return 0, result.Error
+	}
+	if err := tx.Model(&PromoCodeUsage{}).Where("id = ?", usage.Id).
+		Update("status", common.TopUpStatusRefunded).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&PromoCode{}).Where("id = ? AND used_count > 0", usage.PromoCodeId).
+		Update("used_count", gorm.Expr("used_count - 1")).Error; err != nil {
+		return 0, err
+	}
+	return usage.BonusQuota, nil
+}
+
+func promoBonusLogSuffix(bonus int64) string {
+	if bonus <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("，优惠码赠送额度: %d", bonus)
+}
+
+// PromoCodeStat 按活动和优惠码汇总的使用统计，金额和额度只统计已支付订单
+type PromoCodeStat struct {
+	Campaign      string  `json:"campaign"`
+	PromoCodeId   int     `json:"promo_code_id"`
+	Code          string  `json:"code"`
+	Orders        int64   `json:"orders"`
+	Users         int64   `json:"users"`
+	PendingOrders int64   `json:"pending_orders"`
+	Refunded      int64   `json:"refunded"`
+	OriginalMoney float64 `json:"original_money"`
+	DiscountMoney float64 `json:"discount_money"`
+	PaidMoney     float64 `json:"paid_money"`
+	BonusQuota    int64   `json:"bonus_quota"`
+}
+
+// GetPromoCodeStats 统计优惠码使用情况，campaign 为空时统计全部活动
+func GetPromoCodeStats(campaign string, startTime int64, endTime int64) ([]*PromoCodeStat, error) {
+	type row struct {
+		Campaign      string
+		PromoCodeId   int
+		Code          string
+		Status        string
+		Orders        int64
+		Users         int64
+		OriginalMoney float64
+		DiscountMoney float64
+		BonusQuota    int64
+	}
+	query := DB.Model(&PromoCodeUsage{})
+	if campaign != "" {
+		query = query.Where("campaign = ?", campaign)
+	}
+	if startTime > 0 {
+		query = query.Where("created_time >= ?", startTime)
+	}
+	if endTime > 0 {
+		query = query.Where("created_time <= ?", endTime)
+	}
+	var rows []row
+	err := query.Select("campaign, promo_code_id, code, status, count(*) as orders, count(distinct user_id) as users, " +
+		"sum(original_money) as original_money, sum(discount_money) as discount_money, sum(bonus_quota) as bonus_quota").
+		Group("campaign, promo_code_id, code, status").Order("promo_code_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	var stats []*PromoCodeStat
+	index := make(map[int]*PromoCodeStat)
+	for _, r := range rows {
+		stat, ok := index[r.PromoCodeId]
+		if !ok {
+			stat = &PromoCodeStat{Campaign: r.Campaign, PromoCodeId: r.PromoCodeId, Code: r.Code}
+			index[r.PromoCodeId] = stat
+			stats = append(stats, stat)
+		}
+		switch r.Status {
+		case common.TopUpStatusSuccess:
+			stat.Orders = r.Orders
+			stat.Users = r.Users
+			stat.OriginalMoney = r.OriginalMoney
+			stat.DiscountMoney = r.DiscountMoney
+			stat.PaidMoney = decimal.NewFromFloat(r.OriginalMoney).Sub(decimal.NewFromFloat(r.DiscountMoney)).InexactFloat64()
+			stat.BonusQuota = r.BonusQuota
+		case common.TopUpStatusPending:
+			stat.PendingOrders = r.Orders
+		case common.TopUpStatusRefunded:
+			stat.Refunded = r.Orders
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestPromoCode(t *testing.T, promo *PromoCode) *PromoCode {
+	t.Helper()
+	promo.Status = PromoCodeStatusEnabled
+	require.NoError(t, promo.Validate())
+	require.NoError(t, promo.Insert())
+	return promo
+}
+
+func TestPromoCodeValidate(t *testing.T) {
+	assert.Error(t, (&PromoCode{Code: "X", Type: PromoCodeTypeDiscount, Percent: 100}).Validate())
+	assert.Error(t, (&PromoCode{Code: "X", Type: PromoCodeTypeBonus, Percent: 10, Scope: PromoCodeScopeSubscription}).Validate())
+	assert.Error(t, (&PromoCode{Code: "X", Type: PromoCodeTypeDiscount, Percent: 10, PlanIds: "1,pro"}).Validate())
+
+	promo := &PromoCode{Code: " spring20 ", Type: PromoCodeTypeDiscount, Percent: 20, UserGroups: "vip, default ,"}
+	require.NoError(t, promo.Validate())
+	assert.Equal(t, "SPRING20", promo.Code)
+	assert.Equal(t, PromoCodeScopeAll, promo.Scope)
+	assert.Equal(t, "vip,default", promo.UserGroups)
+}
+
+func TestQuotePromoCode_Limits(t *testing.T) {
+	setupTopUpTables(t)
+	createTestPromoCode(t, &PromoCode{Code: "PRO10", Campaign: "launch", Type: PromoCodeTypeDiscount, Percent: 10,
+		Scope: PromoCodeScopeSubscription, PlanIds: "2", UserGroups: "default", PerUserLimit: 1, TotalLimit: 2})
+
+	quote, err := QuotePromoCode("pro10", 1, "default", PromoCodeScopeSubscription, 2, 19.99)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, quote.DiscountMoney)
+	assert.InDelta(t, 17.99, quote.PayMoney, 0.001)
+	assert.Equal(t, 10.0, quote.DiscountPercent())
+
+	_, err = QuotePromoCode("PRO10", 1, "default", PromoCodeScopeTopUp, 0, 19.99)
+	assert.Error(t, err)
+	_, err = QuotePromoCode("PRO10", 1, "default", PromoCodeScopeSubscription, 3, 19.99)
+	assert.Error(t, err)
+	_, err = QuotePromoCode("PRO10", 1, "vip", PromoCodeScopeSubscription, 2, 19.99)
+	assert.Error(t, err)
+
+	require.NoError(t, ReservePromoCode(quote, 1, "default", 2, "sub-1"))
+	// 同一用户已占用名额
+	_, err = QuotePromoCode("PRO10", 1, "default", PromoCodeScopeSubscription, 2, 19.99)
+	assert.Error(t, err)
+	// 订单关闭后释放名额
+	ReleasePromoCode("sub-1")
+	_, err = QuotePromoCode("PRO10", 1, "default", PromoCodeScopeSubscription, 2, 19.99)
+	assert.NoError(t, err)
+
+	require.NoError(t, ReservePromoCode(quote, 2, "default", 2, "sub-2"))
+	require.NoError(t, ReservePromoCode(quote, 3, "default", 2, "sub-3"))
+	assert.Error(t, ReservePromoCode(quote, 4, "default", 2, "sub-4"))
+}
+
+func TestPromoCodeBonus_RechargeAndRefund(t *testing.T) {
+	setupTopUpTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "promo_user", AffCode: "pc01"}).Error)
+	createTestPromoCode(t, &PromoCode{Code: "BONUS20", Campaign: "spring", Type: PromoCodeTypeBonus, Percent: 20,
+		Scope: PromoCodeScopeTopUp, MinMoney: 50})
+
+	_, err := QuotePromoCode("BONUS20", 1, "default", PromoCodeScopeTopUp, 0, 49)
+	assert.Error(t, err)
+	quote, err := QuotePromoCode("BONUS20", 1, "default", PromoCodeScopeTopUp, 0, 70)
+	require.NoError(t, err)
+	assert.Equal(t, 70.0, quote.PayMoney)
+	assert.Equal(t, 0.0, quote.DiscountPercent())
+
+	require.NoError(t, ReservePromoCode(quote, 1, "default", 0, "USR1NObonus"))
+	require.NoError(t, (&TopUp{UserId: 1, Amount: 10, Money: 70, TradeNo: "USR1NObonus", PaymentMethod: "alipay",
+		Status: common.TopUpStatusPending}).Insert())
+	require.NoError(t, RechargeEpay("USR1NObonus"))
+
+	base := int(10 * common.QuotaPerUnit)
+	var user User
+	require.NoError(t, DB.First(&user, 1).Error)
+	assert.Equal(t, base+base/5, user.Quota)
+
+	stats, err := GetPromoCodeStats("spring", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, int64(1), stats[0].Orders)
+	assert.Equal(t, int64(base/5), stats[0].BonusQuota)
+	assert.Equal(t, 70.0, stats[0].PaidMoney)
+
+	result, err := RefundPaymentOrder("USR1NObonus", RefundSourceAdmin, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(base+base/5), result.Quota)
+	require.NoError(t, DB.First(&user, 1).Error)
+	assert.Equal(t, 0, user.Quota)
+
+	var promo PromoCode
+	require.NoError(t, DB.Where("code = ?", "BONUS20").First(&promo).Error)
+	assert.Equal(t, 0, promo.UsedCount)
+}
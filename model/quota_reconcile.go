@@ -0,0 +1,388 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Quota reconcile entity types
+const (
+	QuotaReconcileEntityUser    = "user"
+	QuotaReconcileEntityToken   = "token"
+	QuotaReconcileEntityChannel = "channel"
+)
+
+// quotaReconcileSettleSeconds 消费日志异步写入、批量更新延迟落库，检查点只记录该时长之前的日志
+const quotaReconcileSettleSeconds = 5 * 60
+
+// channelTestTokenName 渠道测试写入的消费日志不计入用户和渠道的已用额度
+const channelTestTokenName = "模型测试"
+
+// QuotaReconcileRun 一次额度对账的记录（审计）
+type QuotaReconcileRun struct {
+	Id            int   `json:"id"`
+	OperatorId    int   `json:"operator_id"` // 0 表示定时任务
+	StartTime     int64 `json:"start_time" gorm:"bigint"`
+	EndTime       int64 `json:"end_time" gorm:"bigint"`
+	Tolerance     int64 `json:"tolerance" gorm:"bigint"`
+	Checked       int   `json:"checked"`
+	Baselined     int   `json:"baselined"` // 首次对账直接以当前计数建立检查点的对象数
+	Discrepancies int   `json:"discrepancies"`
+	Applied       int   `json:"applied"`
+	CreatedAt     int64 `json:"created_at" gorm:"bigint;index"`
+}
+
+// QuotaReconcileItem 对账发现的差异，Diff = 存储的已用额度 - 按日志计算的已用额度
+type QuotaReconcileItem struct {
+	Id            int    `json:"id"`
+	RunId         int    `json:"run_id" gorm:"index"`
+	EntityType    string `json:"entity_type" gorm:"type:varchar(16)"`
+	EntityId      int    `json:"entity_id"`
+	Name          string `json:"name" gorm:"type:varchar(255);default:''"`
+	StoredUsed    int64  `json:"stored_used" gorm:"bigint"`
+	ExpectedUsed  int64  `json:"expected_used" gorm:"bigint"`
+	Diff          int64  `json:"diff" gorm:"bigint"`
+	Applied       bool   `json:"applied"`
+	QuotaAdjusted bool   `json:"quota_adjusted"` // 是否同步修正了用户余额或令牌剩余额度
+	AppliedBy     int    `json:"applied_by"`
+	AppliedAt     int64  `json:"applied_at" gorm:"bigint;default:0"`
+}
+
+// QuotaReconcileCheckpoint 对账检查点：CheckpointTime 之前的日志对应的已用额度，
+// 之后的对账只需累加检查点之后的日志，不受日志清理影响
+type QuotaReconcileCheckpoint struct {
+	Id             int    `json:"id"`
+	EntityType     string `json:"entity_type" gorm:"type:varchar(16);uniqueIndex:idx_quota_reconcile_checkpoint,priority:1"`
+	EntityId       int    `json:"entity_id" gorm:"uniqueIndex:idx_quota_reconcile_checkpoint,priority:2"`
+	CheckpointTime int64  `json:"checkpoint_time" gorm:"bigint"`
+	UsedQuota      int64  `json:"used_quota" gorm:"bigint"`
+}
+
+// QuotaReconcileOptions 对账参数
+type QuotaReconcileOptions struct {
+	StartTime       int64 `json:"start_time"` // 仅检查该时间范围内有消费记录的对象
+	EndTime         int64 `json:"end_time"`
+	Tolerance       int64 `json:"tolerance"`
+	Apply           bool  `json:"apply"`             // 立即修正发现的差异
+	AdjustUserQuota bool  `json:"adjust_user_quota"` // 修正已用额度时同步修正用户余额
+	InitBaseline    bool  `json:"init_baseline"`     // 没有检查点的对象以当前计数建立检查点，不做比对（日志已被清理时使用）
+	OperatorId      int   `json:"-"`
+}
+
+var quotaReconcileEntityTypes = []string{QuotaReconcileEntityUser, QuotaReconcileEntityToken, QuotaReconcileEntityChannel}
+
+func quotaReconcileLogColumn(entityType string) string {
+	switch entityType {
+	case QuotaReconcileEntityToken:
+		return "token_id"
+	case QuotaReconcileEntityChannel:
+		return "channel_id"
+	default:
+		return "user_id"
+	}
+}
+
+// collectQuotaReconcileEntityIds 时间范围内有消费、退款或任务差额补扣记录的对象
+func collectQuotaReconcileEntityIds(entityType string, startTime int64, endTime int64) ([]int, error) {
+	column := quotaReconcileLogColumn(entityType)
+	var ids []int
+	err := LOG_DB.Model(&Log{}).Where("(type IN ? OR (type = ? AND channel_id > 0)) AND created_at >= ? AND created_at <= ? AND "+column+" > 0",
+		[]int{LogTypeConsume, LogTypeRefund}, LogTypeSystem, startTime, endTime).
+		Distinct().Order(column).Pluck(column, &ids).Error
+	return ids, err
+}
+
+// sumQuotaReconcileLogs 按日志计算 (after, upTo] 内的已用额度变化。
+// 用户和渠道的已用额度只随消费和任务差额补扣（带渠道的系统日志）增加；令牌的已用额度在退款时同步减少
+func sumQuotaReconcileLogs(entityType string, entityId int, after int64, upTo int64) (int64, error) {
+	if upTo <= after {
+		return 0, nil
+	}
+	query := LOG_DB.Model(&Log{}).Where(quotaReconcileLogColumn(entityType)+" = ? AND created_at > ? AND created_at <= ?", entityId, after, upTo)
+	var sum int64
+	if entityType == QuotaReconcileEntityToken {
+		err := query.Select("COALESCE(SUM(CASE WHEN type = ? THEN quota WHEN type = ? AND channel_id > 0 THEN quota WHEN type = ? THEN -quota ELSE 0 END), 0)",
+			LogTypeConsume, LogTypeSystem, LogTypeRefund).Scan(&sum).Error
+		return sum, err
+	}
+	err := query.Where("(type = ? OR (type = ? AND channel_id > 0))", LogTypeConsume, LogTypeSystem).
+		Where("NOT (token_id = 0 AND token_name = ?)", channelTestTokenName).
+		Select("COALESCE(SUM(quota), 0)").Scan(&sum).Error
+	return sum, err
+}
+
+// getQuotaReconcileStored 读取存储的已用额度计数和名称
+func getQuotaReconcileStored(entityType string, entityId int) (int64, string, error) {
+	switch entityType {
+	case QuotaReconcileEntityToken:
+		var token Token
+		err := DB.Unscoped().Select("id", "name", "used_quota").Where("id = ?", entityId).First(&token).Error
+		return int64(token.UsedQuota), token.Name, err
+	case QuotaReconcileEntityChannel:
+		var channel Channel
+		err := DB.Select("id", "name", "used_quota").Where("id = ?", entityId).First(&channel).Error
+		return channel.UsedQuota, channel.Name, err
+	default:
+		var user User
+		err := DB.Unscoped().Select("id", "username", "used_quota").Where("id = ?", entityId).First(&user).Error
+		return int64(user.UsedQuota), user.Username, err
+	}
+}
+
+func saveQuotaReconcileCheckpoint(entityType string, entityId int, checkpointTime int64, usedQuota int64) error {
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "entity_type"}, {Name: "entity_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"checkpoint_time", "used_quota"}),
+	}).Create(&QuotaReconcileCheckpoint{
+		EntityType:     entityType,
+		EntityId:       entityId,
+		CheckpointTime: checkpointTime,
+		UsedQuota:      usedQuota,
+	}).Error
+}
+
+// reconcileQuotaEntity 对单个对象对账，返回差异记录（无差异时为 nil）以及是否建立了基线
+func reconcileQuotaEntity(run *QuotaReconcileRun, opts *QuotaReconcileOptions, entityType string, entityId int, now int64) (*QuotaReconcileItem, bool, error) {
+	stored, name, err := getQuotaReconcileStored(entityType, entityId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	cutoff := now - quotaReconcileSettleSeconds
+	var checkpoint QuotaReconcileCheckpoint
+	result := DB.Where("entity_type = ? AND entity_id = ?", entityType, entityId).Limit(1).Find(&checkpoint)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	hasCheckpoint := result.RowsAffected > 0
+	settledFrom := checkpoint.CheckpointTime
+	if settledFrom > cutoff {
+		cutoff = settledFrom
+	}
+	recent, err := sumQuotaReconcileLogs(entityType, entityId, cutoff, now)
+	if err != nil {
+		return nil, false, err
+	}
+	if !hasCheckpoint && opts.InitBaseline {
+		// 信任当前计数，检查点为当前计数扣除尚未结算的近期日志
+		return nil, true, saveQuotaReconcileCheckpoint(entityType, entityId, cutoff, stored-recent)
+	}
+	settled, err := sumQuotaReconcileLogs(entityType, entityId, settledFrom, cutoff)
+	if err != nil {
+		return nil, false, err
+	}
+	expectedSettled := checkpoint.UsedQuota + settled
+	expected := expectedSettled + recent
+	diff := stored - expected
+	if diff <= opts.Tolerance && diff >= -opts.Tolerance {
+		if cutoff > checkpoint.CheckpointTime {
+			return nil, false, saveQuotaReconcileCheckpoint(entityType, entityId, cutoff, expectedSettled)
+		}
+		return nil, false, nil
+	}
+	item := &QuotaReconcileItem{
+		RunId:        run.Id,
+		EntityType:   entityType,
+		EntityId:     entityId,
+		Name:         name,
+		StoredUsed:   stored,
+		ExpectedUsed: expected,
+		Diff:         diff,
+	}
+	return item, false, DB.Create(item).Error
+}
+
+// RunQuotaReconcile 按消费和退款日志重新计算用户、令牌、渠道的已用额度，与存储的计数比对并记录差异
+func RunQuotaReconcile(opts QuotaReconcileOptions) (*QuotaReconcileRun, error) {
+	if !common.LogConsumeEnabled {
+		return nil, errors.New("额度对账依赖消费日志，请先开启消费日志记录")
+	}
+	now := common.GetTimestamp()
+	if opts.EndTime <= 0 || opts.EndTime > now {
+		opts.EndTime = now
+	}
+	if opts.StartTime <= 0 {
+		opts.StartTime = opts.EndTime - 24*60*60
+	}
+	if opts.StartTime >= opts.EndTime {
+		return nil, errors.New("开始时间必须早于结束时间")
+	}
+	if opts.Tolerance < 0 {
+		return nil, errors.New("误差额度不能为负数")
+	}
+	run := &QuotaReconcileRun{
+		OperatorId: opts.OperatorId,
+		StartTime:  opts.StartTime,
+		EndTime:    opts.EndTime,
+		Tolerance:  opts.Tolerance,
+		CreatedAt:  now,
+	}
+	if err := DB.Create(run).Error; err != nil {
+		return nil, err
+	}
+	var runErr error
+	for _, entityType := range quotaReconcileEntityTypes {
+		ids, err := collectQuotaReconcileEntityIds(entityType, opts.StartTime, opts.EndTime)
+		if err != nil {
+			runErr = err
+			break
+		}
+		for _, id := range ids {
+			item, baselined, err := reconcileQuotaEntity(run, &opts, entityType, id, now)
+			if err != nil {
+				runErr = fmt.Errorf("%s #%d: %w", entityType, id, err)
+				break
+			}
+			run.Checked++
+			if baselined {
+				run.Baselined++
+			}
+			if item != nil {
+				run.Discrepancies++
+			}
+		}
+		if runErr != nil {
+			break
+		}
+	}
+	if runErr == nil && opts.Apply && run.Discrepancies > 0 {
+		run.Applied, runErr = ApplyQuotaReconcileItems(run.Id, nil, opts.AdjustUserQuota, opts.OperatorId)
+	}
+	if err := DB.Model(&QuotaReconcileRun{}).Where("id = ?", run.Id).Updates(map[string]interface{}{
+		"checked":       run.Checked,
+		"baselined":     run.Baselined,
+		"discrepancies": run.Discrepancies,
+		"applied":       run.Applied,
+	}).Error; err != nil && runErr == nil {
+		runErr = err
+	}
+	return run, runErr
+}
+
+// applyQuotaReconcileItemTx 按差异修正计数；adjustQuota 时同步修正用户余额或令牌剩余额度
+func applyQuotaReconcileItemTx(tx *gorm.DB, item *QuotaReconcileItem, adjustQuota bool) error {
+	switch item.EntityType {
+	case QuotaReconcileEntityUser:
+		updates := map[string]interface{}{"used_quota": gorm.Expr("used_quota - ?", item.Diff)}
+		if adjustQuota {
+			updates["quota"] = gorm.Expr("quota + ?", item.Diff)
+		}
+		return tx.Model(&User{}).Where("id = ?", item.EntityId).Updates(updates).Error
+	case QuotaReconcileEntityToken:
+		updates := map[string]interface{}{"used_quota": gorm.Expr("used_quota - ?", item.Diff)}
+		if adjustQuota {
+			updates["remain_quota"] = gorm.Expr("remain_quota + ?", item.Diff)
+		}
+		return tx.Model(&Token{}).Where("id = ?", item.EntityId).Updates(updates).Error
+	case QuotaReconcileEntityChannel:
+		return tx.Model(&Channel{}).Where("id = ?", item.EntityId).
+			Update("used_quota", gorm.Expr("used_quota - ?", item.Diff)).Error
+	}
+	return errors.New("无效的对账对象")
+}
+
+// ApplyQuotaReconcileItems 修正对账差异，itemIds 为空时修正该次对账的全部未修正差异，返回修正条数
+func ApplyQuotaReconcileItems(runId int, itemIds []int, adjustUserQuota bool, operatorId int) (int, error) {
+	query := DB.Where("run_id = ? AND applied = ?", runId, false)
+	if len(itemIds) > 0 {
+		query = query.Where("id IN ?", itemIds)
+	}
+	var items []*QuotaReconcileItem
+	if err := query.Order("id").Find(&items).Error; err != nil {
+		return 0, err
+	}
+	applied := 0
+	var applyErr error
+	for _, item := range items {
+		adjustQuota := adjustUserQuota && item.EntityType != QuotaReconcileEntityChannel
+		var tokenKey string
+		itemApplied := false
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			// 以 applied 标记作为乐观锁，避免重复修正
+			result := tx.Model(&QuotaReconcileItem{}).Where("id = ? AND applied = ?", item.Id, false).Updates(map[string]interface{}{
+				"applied":        true,
+				"quota_adjusted": adjustQuota,
+				"applied_by":     operatorId,
+				"applied_at":     common.GetTimestamp(),
+			})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			if item.EntityType == QuotaReconcileEntityToken && adjustQuota {
+				var token Token
+				if err := tx.Unscoped().Select("id", commonKeyCol).Where("id = ?", item.EntityId).First(&token).Error; err != nil {
+					return err
+				}
+				tokenKey = token.Key
+			}
+			itemApplied = true
+			return applyQuotaReconcileItemTx(tx, item, adjustQuota)
+		})
+		if err != nil {
+			applyErr = err
+			break
+		}
+		// 事务提交后才计入，已被其他请求修正的条目不再重复调整缓存
+		if !itemApplied {
+			continue
+		}
+		applied++
+		if !adjustQuota {
+			continue
+		}
+		switch item.EntityType {
+		case QuotaReconcileEntityUser:
+			_ = cacheIncrUserQuota(item.EntityId, item.Diff)
+			RecordLog(item.EntityId, LogTypeManage, fmt.Sprintf("额度对账修正：已用额度修正 %s，余额修正 %s",
+				logger.LogQuota(int(-item.Diff)), logger.LogQuota(int(item.Diff))))
+		case QuotaReconcileEntityToken:
+			if tokenKey != "" && common.RedisEnabled {
+				_ = cacheIncrTokenQuota(tokenKey, item.Diff)
+			}
+		}
+	}
+	if applied > 0 {
+		if err := DB.Model(&QuotaReconcileRun{}).Where("id = ?", runId).
+			Update("applied", gorm.Expr("applied + ?", applied)).Error; err != nil {
+			return applied, err
+		}
+	}
+	return applied, applyErr
+}
+
+func GetQuotaReconcileRuns(pageInfo *common.PageInfo) (runs []*QuotaReconcileRun, total int64, err error) {
+	query := DB.Model(&QuotaReconcileRun{})
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&runs).Error
+	return runs, total, err
+}
+
+func GetQuotaReconcileRunById(id int) (*QuotaReconcileRun, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var run QuotaReconcileRun
+	err := DB.First(&run, "id = ?", id).Error
+	return &run, err
+}
+
+func GetQuotaReconcileItems(runId int, entityType string, pageInfo *common.PageInfo) (items []*QuotaReconcileItem, total int64, err error) {
+	query := DB.Model(&QuotaReconcileItem{}).Where("run_id = ?", runId)
+	if entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&items).Error
+	return items, total, err
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupQuotaReconcileTables(t *testing.T) {
+	t.Helper()
+	require.NoError(t, DB.AutoMigrate(&QuotaReconcileRun{}, &QuotaReconcileItem{}, &QuotaReconcileCheckpoint{}))
+	logConsumeEnabled := common.LogConsumeEnabled
+	common.LogConsumeEnabled = true
+	t.Cleanup(func() {
+		common.LogConsumeEnabled = logConsumeEnabled
+		for _, table := range []string{"quota_reconcile_runs", "quota_reconcile_items", "quota_reconcile_checkpoints", "logs", "channels"} {
+			DB.Exec("DELETE FROM " + table)
+		}
+	})
+	truncateTables(t)
+}
+
+func TestQuotaReconcile_DetectAndApply(t *testing.T) {
+	setupQuotaReconcileTables(t)
+	now := common.GetTimestamp()
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "rec_ok", AffCode: "qr01", Quota: 5000, UsedQuota: 1000}).Error)
+	require.NoError(t, DB.Create(&User{Id: 2, Username: "rec_drift", AffCode: "qr02", Quota: 5000, UsedQuota: 500}).Error)
+	require.NoError(t, DB.Create(&Token{Id: 1, UserId: 1, Key: "reconcile-token", Name: "t1", UsedQuota: 900}).Error)
+	require.NoError(t, DB.Create(&Channel{Id: 1, Name: "c1", Key: "k", UsedQuota: 1800}).Error)
+	for _, log := range []*Log{
+		{UserId: 1, TokenId: 1, ChannelId: 1, Type: LogTypeConsume, Quota: 700, CreatedAt: now - 3600},
+		{UserId: 1, TokenId: 1, ChannelId: 1, Type: LogTypeConsume, Quota: 300, CreatedAt: now - 3000},
+		{UserId: 1, TokenId: 1, ChannelId: 1, Type: LogTypeRefund, Quota: 100, CreatedAt: now - 2000},
+		{UserId: 2, ChannelId: 1, Type: LogTypeConsume, Quota: 800, CreatedAt: now - 3600},
+		// 渠道测试日志不计入
+		{UserId: 1, ChannelId: 1, TokenName: channelTestTokenName, Type: LogTypeConsume, Quota: 50, CreatedAt: now - 3600},
+	} {
+		require.NoError(t, LOG_DB.Create(log).Error)
+	}
+
+	run, err := RunQuotaReconcile(QuotaReconcileOptions{StartTime: now - 7200, OperatorId: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 4, run.Checked)
+	assert.Equal(t, 1, run.Discrepancies)
+
+	items, total, err := GetQuotaReconcileItems(run.Id, "", &common.PageInfo{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), total)
+	assert.Equal(t, QuotaReconcileEntityUser, items[0].EntityType)
+	assert.Equal(t, 2, items[0].EntityId)
+	assert.Equal(t, int64(-300), items[0].Diff)
+
+	applied, err := ApplyQuotaReconcileItems(run.Id, nil, true, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	applied, err = ApplyQuotaReconcileItems(run.Id, nil, true, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	var user User
+	require.NoError(t, DB.First(&user, 2).Error)
+	assert.Equal(t, 800, user.UsedQuota)
+	assert.Equal(t, 4700, user.Quota)
+
+	// 修正后再次对账无差异，并建立检查点
+	run, err = RunQuotaReconcile(QuotaReconcileOptions{StartTime: now - 7200})
+	require.NoError(t, err)
+	assert.Equal(t, 0, run.Discrepancies)
+	var checkpoints int64
+	require.NoError(t, DB.Model(&QuotaReconcileCheckpoint{}).Count(&checkpoints).Error)
+	assert.Equal(t, int64(4), checkpoints)
+}
+
+func TestQuotaReconcile_InitBaseline(t *testing.T) {
+	setupQuotaReconcileTables(t)
+	now := common.GetTimestamp()
+	// 早期日志已被清理，计数大于现存日志
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "rec_pruned", AffCode: "qr03", UsedQuota: 10000}).Error)
+	require.NoError(t, LOG_DB.Create(&Log{UserId: 1, Type: LogTypeConsume, Quota: 200, CreatedAt: now - 3600}).Error)
+
+	run, err := RunQuotaReconcile(QuotaReconcileOptions{StartTime: now - 7200, InitBaseline: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, run.Baselined)
+	assert.Equal(t, 0, run.Discrepancies)
+
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", 1).Update("used_quota", 10400).Error)
+	require.NoError(t, LOG_DB.Create(&Log{UserId: 1, Type: LogTypeConsume, Quota: 300, CreatedAt: now}).Error)
+	run, err = RunQuotaReconcile(QuotaReconcileOptions{StartTime: now - 7200})
+	require.NoError(t, err)
+	require.Equal(t, 1, run.Discrepancies)
+	items, _, err := GetQuotaReconcileItems(run.Id, QuotaReconcileEntityUser, &common.PageInfo{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), items[0].Diff)
+}
+
+func TestQuotaReconcile_ApplyCountsCommittedOnly(t *testing.T) {
+	setupQuotaReconcileTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "rec_apply", AffCode: "qr03", Quota: 5000, UsedQuota: 500}).Error)
+	run := &QuotaReconcileRun{OperatorId: 1}
+	require.NoError(t, DB.Create(run).Error)
+	require.NoError(t, DB.Create(&QuotaReconcileItem{RunId: run.Id, EntityType: QuotaReconcileEntityUser, EntityId: 1, Diff: -100}).Error)
+	// 修正失败的条目回滚，不计入修正条数
+	broken := &QuotaReconcileItem{RunId: run.Id, EntityType: "unknown", EntityId: 1, Diff: -100}
+	require.NoError(t, DB.Create(broken).Error)
+
+	applied, err := ApplyQuotaReconcileItems(run.Id, nil, false, 1)
+	assert.Error(t, err)
+	assert.Equal(t, 1, applied)
+	require.NoError(t, DB.First(broken, broken.Id).Error)
+	assert.False(t, broken.Applied)
+	require.NoError(t, DB.First(run, run.Id).Error)
+	assert.Equal(t, 1, run.Applied)
+}
@@ -0,0 +1,313 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"gorm.io/gorm"
+)
+
+// Recurring grant targets
+const (
+	RecurringGrantTargetGroup = "group"
+	RecurringGrantTargetUsers = "users"
+)
+
+// Recurring grant modes
+const (
+	RecurringGrantModeAccumulate = "accumulate" // 未用完的额度保留，与新发放的额度累加
+	RecurringGrantModeReset      = "reset"      // 未用完的额度在下一次发放时作废
+)
+
+const (
+	RecurringGrantStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	RecurringGrantStatusDisabled = 2 // also don't use 0
+)
+
+const recurringGrantBatchSize = 500
+
+// RecurringGrant 定期赠送额度规则：按日/周/月向指定分组或用户发放额度
+type RecurringGrant struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(128)"`
+	Quota       int64  `json:"quota" gorm:"type:bigint"`
+	TargetType  string `json:"target_type" gorm:"type:varchar(16)"`
+	TargetGroup string `json:"target_group" gorm:"type:varchar(64);default:''"`
+	UserIds     string `json:"user_ids" gorm:"type:text"`        // 逗号分隔的用户ID
+	Schedule    string `json:"schedule" gorm:"type:varchar(16)"` // daily / weekly / monthly
+	ExpireDays  int    `json:"expire_days" gorm:"default:0"`     // 发放额度的有效天数，0 表示不过期（重置模式下最晚在下次发放时作废）
+	Mode        string `json:"mode" gorm:"type:varchar(16);default:'accumulate'"`
+	Status      int    `json:"status" gorm:"default:1"`
+	NextRunTime int64  `json:"next_run_time" gorm:"bigint;index"`
+	LastRunTime int64  `json:"last_run_time" gorm:"bigint;default:0"`
+	CreatedBy   int    `json:"created_by"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// RecurringGrantRecord 定期赠送发放记录，同一规则同一期每个用户只发放一次
+type RecurringGrantRecord struct {
+	Id               int    `json:"id"`
+	RecurringGrantId int    `json:"recurring_grant_id" gorm:"uniqueIndex:idx_recurring_grant_run,priority:1"`
+	UserId           int    `json:"user_id" gorm:"uniqueIndex:idx_recurring_grant_run,priority:2;index"`
+	RunTime          int64  `json:"run_time" gorm:"bigint;uniqueIndex:idx_recurring_grant_run,priority:3"`
+	Name             string `json:"name" gorm:"type:varchar(128)"`
+	Quota            int64  `json:"quota" gorm:"type:bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint;default:0"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+}
+
+// Validate 校验管理员提交的定期赠送配置
+func (g *RecurringGrant) Validate() error {
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		return errors.New("名称不能为空")
+	}
+	if g.Quota <= 0 {
+		return errors.New("额度必须大于0")
+	}
+	switch g.TargetType {
+	case RecurringGrantTargetGroup:
+		g.TargetGroup = strings.TrimSpace(g.TargetGroup)
+		if g.TargetGroup == "" {
+			return errors.New("请指定发放分组")
+		}
+		g.UserIds = ""
+	case RecurringGrantTargetUsers:
+		g.UserIds = normalizePromoList(g.UserIds)
+		if g.UserIds == "" {
+			return errors.New("请指定发放用户")
+		}
+		for _, id := range splitPromoList(g.UserIds) {
+			if v, err := strconv.Atoi(id); err != nil || v <= 0 {
+				return fmt.Errorf("无效的用户ID: %s", id)
+			}
+		}
+		g.TargetGroup = ""
+	default:
+		return errors.New("无效的发放对象")
+	}
+	switch g.Schedule {
+	case SubscriptionResetDaily, SubscriptionResetWeekly, SubscriptionResetMonthly:
+	default:
+		return errors.New("无效的发放周期")
+	}
+	if g.Mode == "" {
+		g.Mode = RecurringGrantModeAccumulate
+	}
+	if g.Mode != RecurringGrantModeAccumulate && g.Mode != RecurringGrantModeReset {
+		return errors.New("无效的额度处理方式")
+	}
+	if g.ExpireDays < 0 {
+		return errors.New("有效天数不能为负数")
+	}
+	// 额度过期和重置依赖额度账本记录每笔授予的剩余额度
+	if (g.ExpireDays > 0 || g.Mode == RecurringGrantModeReset) && !operation_setting.IsCreditLedgerEnabled() {
+		return errors.New("设置有效期或重置模式需要先启用额度账本")
+	}
+	return nil
+}
+
+// nextRecurringGrantRun 计算 base 之后的下一次发放时间，与订阅额度重置周期对齐
+func nextRecurringGrantRun(schedule string, base int64) int64 {
+	return calcNextResetTime(time.Unix(base, 0), &SubscriptionPlan{QuotaResetPeriod: schedule}, 0)
+}
+
+// Insert 创建定期赠送规则，首次发放在创建后立即执行
+func (g *RecurringGrant) Insert() error {
+	now := common.GetTimestamp()
+	g.CreatedTime = now
+	if g.NextRunTime <= 0 {
+		g.NextRunTime = now
+	}
+	return DB.Create(g).Error
+}
+
+// Update 更新定期赠送配置，不修改发放进度；周期变化时重新计算下次发放时间
+func (g *RecurringGrant) Update(scheduleChanged bool) error {
+	fields := []string{"name", "quota", "target_type", "target_group", "user_ids", "schedule", "expire_days", "mode", "status"}
+	if scheduleChanged && g.LastRunTime > 0 {
+		g.NextRunTime = nextRecurringGrantRun(g.Schedule, g.LastRunTime)
+		fields = append(fields, "next_run_time")
+	}
+	return DB.Model(g).Select(fields).Updates(g).Error
+}
+
+func GetRecurringGrantById(id int) (*RecurringGrant, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var grant RecurringGrant
+	err := DB.First(&grant, "id = ?", id).Error
+	return &grant, err
+}
+
+func GetRecurringGrants(pageInfo *common.PageInfo) (grants []*RecurringGrant, total int64, err error) {
+	query := DB.Model(&RecurringGrant{})
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&grants).Error
+	return grants, total, err
+}
+
+// DeleteRecurringGrantById 删除定期赠送规则，已发放的记录和额度保留
+func DeleteRecurringGrantById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Delete(&RecurringGrant{}, "id = ?", id).Error
+}
+
+// GetRecurringGrantRecords 查询发放记录，grantId 或 userId 为 0 时不限
+func GetRecurringGrantRecords(grantId int, userId int, pageInfo *common.PageInfo) (records []*RecurringGrantRecord, total int64, err error) {
+	query := DB.Model(&RecurringGrantRecord{})
+	if grantId > 0 {
+		query = query.Where("recurring_grant_id = ?", grantId)
+	}
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&records).Error
+	return records, total, err
+}
+
+// recurringGrantExpiresAt 计算本期发放额度的到期时间
+func (g *RecurringGrant) recurringGrantExpiresAt(now int64, nextRun int64) int64 {
+	expiresAt := int64(0)
+	if g.ExpireDays > 0 {
+		expiresAt = now + int64(g.ExpireDays)*24*60*60
+	}
+	if g.Mode == RecurringGrantModeReset && nextRun > 0 && (expiresAt == 0 || nextRun < expiresAt) {
+		expiresAt = nextRun
+	}
+	if !operation_setting.IsCreditLedgerEnabled() {
+		return 0
+	}
+	return expiresAt
+}
+
+// grantRecurringCredit 向单个用户发放本期额度，已发放过时返回 false
+func grantRecurringCredit(grant *RecurringGrant, userId int, runTime int64, expiresAt int64) (bool, error) {
+	granted := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var exists int64
+		if err := tx.Model(&RecurringGrantRecord{}).
+			Where("recurring_grant_id = ? AND user_id = ? AND run_time = ?", grant.Id, userId, runTime).
+			Count(&exists).Error; err != nil {
+			return err
+		}
+		if exists > 0 {
+			return nil
+		}
+		if err := tx.Create(&RecurringGrantRecord{
+			RecurringGrantId: grant.Id,
+			UserId:           userId,
+			RunTime:          runTime,
+			Name:             grant.Name,
+			Quota:            grant.Quota,
+			ExpiresAt:        expiresAt,
+			CreatedAt:        common.GetTimestamp(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", userId).
+			Update("quota", gorm.Expr("quota + ?", grant.Quota)).Error; err != nil {
+			return err
+		}
+		granted = true
+		return RecordCreditGrantWithExpiryTx(tx, userId, CreditSourceRecurring, fmt.Sprintf("recurring:%d:%d", grant.Id, runTime), grant.Quota, expiresAt)
+	})
+	if err != nil || !granted {
+		return false, err
+	}
+	_ = cacheIncrUserQuota(userId, grant.Quota)
+	content := fmt.Sprintf("定期赠送「%s」发放 %s", grant.Name, logger.LogQuota(int(grant.Quota)))
+	if expiresAt > 0 {
+		content += fmt.Sprintf("，有效期至 %s", time.Unix(expiresAt, 0).Format("2006-01-02 15:04:05"))
+	}
+	RecordLog(userId, LogTypeSystem, content)
+	return true, nil
+}
+
+// recurringGrantTargetUserIds 分批返回发放对象，afterId 之后的下一批启用状态的用户
+func (g *RecurringGrant) recurringGrantTargetUserIds(afterId int) ([]int, error) {
+	query := DB.Model(&User{}).Where("status = ? AND id > ?", common.UserStatusEnabled, afterId)
+	if g.TargetType == RecurringGrantTargetGroup {
+		query = query.Where(commonGroupCol+" = ?", g.TargetGroup)
+	} else {
+		var ids []int
+		for _, id := range splitPromoList(g.UserIds) {
+			if v, err := strconv.Atoi(id); err == nil {
+				ids = append(ids, v)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		query = query.Where("id IN ?", ids)
+	}
+	var userIds []int
+	err := query.Order("id").Limit(recurringGrantBatchSize).Pluck("id", &userIds).Error
+	return userIds, err
+}
+
+// ExecuteRecurringGrant 执行一期发放。同一期重复执行（多节点或重试）时不会重复发放，
+// 全部发放完成后才推进下次发放时间，中途失败的批次会在下次执行时补发
+func ExecuteRecurringGrant(grant *RecurringGrant, now int64) (int, error) {
+	runTime := grant.NextRunTime
+	nextRun := nextRecurringGrantRun(grant.Schedule, now)
+	expiresAt := grant.recurringGrantExpiresAt(now, nextRun)
+	granted := 0
+	lastId := 0
+	for {
+		userIds, err := grant.recurringGrantTargetUserIds(lastId)
+		if err != nil {
+			return granted, err
+		}
+		for _, userId := range userIds {
+			ok, err := grantRecurringCredit(grant, userId, runTime, expiresAt)
+			if err != nil {
+				return granted, err
+			}
+			if ok {
+				granted++
+			}
+		}
+		if len(userIds) < recurringGrantBatchSize {
+			break
+		}
+		lastId = userIds[len(userIds)-1]
+	}
+	// 以 next_run_time 作为乐观锁，避免多个节点重复推进
+	err := DB.Model(&RecurringGrant{}).Where("id = ? AND next_run_time = ?", grant.Id, runTime).
+		Updates(map[string]interface{}{"next_run_time": nextRun, "last_run_time": runTime}).Error
+	return granted, err
+}
+
+// RunDueRecurringGrants 执行所有到期的定期赠送规则，返回发放的用户次数
+func RunDueRecurringGrants() (int, error) {
+	now := common.GetTimestamp()
+	var grants []*RecurringGrant
+	if err := DB.Where("status = ? AND next_run_time > 0 AND next_run_time <= ?", RecurringGrantStatusEnabled, now).
+		Order("next_run_time").Find(&grants).Error; err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, grant := range grants {
+		n, err := ExecuteRecurringGrant(grant, now)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
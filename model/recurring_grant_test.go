@@ -0,0 +1,93 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRecurringGrantTables(t *testing.T) {
+	t.Helper()
+	withColumnNames(t)
+	require.NoError(t, DB.AutoMigrate(&RecurringGrant{}, &RecurringGrantRecord{}, &CreditGrant{}))
+	t.Cleanup(func() {
+		for _, table := range []string{"recurring_grants", "recurring_grant_records", "credit_grants", "logs"} {
+			DB.Exec("DELETE FROM " + table)
+		}
+	})
+	truncateTables(t)
+}
+
+func TestRecurringGrant_GroupIdempotent(t *testing.T) {
+	setupRecurringGrantTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "edu_a", AffCode: "rg01", Group: "edu", Status: common.UserStatusEnabled}).Error)
+	require.NoError(t, DB.Create(&User{Id: 2, Username: "edu_b", AffCode: "rg02", Group: "edu", Status: common.UserStatusEnabled}).Error)
+	require.NoError(t, DB.Create(&User{Id: 3, Username: "other", AffCode: "rg03", Group: "default", Status: common.UserStatusEnabled}).Error)
+
+	grant := &RecurringGrant{Name: "student", Quota: 2500, TargetType: RecurringGrantTargetGroup, TargetGroup: "edu",
+		Schedule: SubscriptionResetMonthly, Status: RecurringGrantStatusEnabled}
+	require.NoError(t, grant.Validate())
+	require.NoError(t, grant.Insert())
+	runTime := grant.NextRunTime
+
+	granted, err := RunDueRecurringGrants()
+	require.NoError(t, err)
+	assert.Equal(t, 2, granted)
+
+	// 其他节点持有旧的规则快照重复执行同一期，不会重复发放
+	granted, err = ExecuteRecurringGrant(grant, common.GetTimestamp())
+	require.NoError(t, err)
+	assert.Equal(t, 0, granted)
+
+	var users []User
+	require.NoError(t, DB.Order("id").Find(&users).Error)
+	assert.Equal(t, 2500, users[0].Quota)
+	assert.Equal(t, 2500, users[1].Quota)
+	assert.Equal(t, 0, users[2].Quota)
+
+	reloaded, err := GetRecurringGrantById(grant.Id)
+	require.NoError(t, err)
+	assert.Equal(t, runTime, reloaded.LastRunTime)
+	assert.Greater(t, reloaded.NextRunTime, runTime)
+
+	records, total, err := GetRecurringGrantRecords(0, 1, &common.PageInfo{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "student", records[0].Name)
+
+	var logs int64
+	require.NoError(t, LOG_DB.Model(&Log{}).Where("user_id = ? AND type = ?", 1, LogTypeSystem).Count(&logs).Error)
+	assert.Equal(t, int64(1), logs)
+}
+
+func TestRecurringGrant_ResetModeExpiresAtNextRun(t *testing.T) {
+	setupRecurringGrantTables(t)
+	ledger := operation_setting.GetCreditLedgerSetting()
+	enabled := ledger.Enabled
+	t.Cleanup(func() { ledger.Enabled = enabled })
+
+	grant := &RecurringGrant{Name: "weekly", Quota: 1000, TargetType: RecurringGrantTargetUsers, UserIds: " 1, ,",
+		Schedule: SubscriptionResetWeekly, Mode: RecurringGrantModeReset}
+	ledger.Enabled = false
+	assert.Error(t, grant.Validate())
+	ledger.Enabled = true
+	require.NoError(t, grant.Validate())
+	assert.Equal(t, "1", grant.UserIds)
+
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "weekly_user", AffCode: "rg04", Status: common.UserStatusEnabled}).Error)
+	grant.Status = RecurringGrantStatusEnabled
+	require.NoError(t, grant.Insert())
+	granted, err := RunDueRecurringGrants()
+	require.NoError(t, err)
+	assert.Equal(t, 1, granted)
+
+	reloaded, err := GetRecurringGrantById(grant.Id)
+	require.NoError(t, err)
+	var credit CreditGrant
+	require.NoError(t, DB.Where("user_id = ? AND source = ?", 1, CreditSourceRecurring).First(&credit).Error)
+	assert.Equal(t, int64(1000), credit.Remaining)
+	assert.Equal(t, reloaded.NextRunTime, credit.ExpiresAt)
+}
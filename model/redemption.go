@@ -27,6 +27,19 @@ type Redemption struct {
 	UsedUserId   int            `json:"used_user_id"`
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
 	ExpiredTime  int64          `json:"expired_time" gorm:"bigint"` // 过期时间，0 表示不过期
+	CampaignId   int            `json:"campaign_id" gorm:"index;default:0"`
+}
+
+// RedeemResult 兑换结果，活动兑换码可能发放订阅套餐或升级分组而非额度
+type RedeemResult struct {
+	RedemptionId       int    `json:"redemption_id"`
+	CampaignId         int    `json:"campaign_id,omitempty"`
+	GrantType          string `json:"grant_type"`
+	Quota              int    `json:"quota"`
+	PlanId             int    `json:"plan_id,omitempty"`
+	PlanTitle          string `json:"plan_title,omitempty"`
+	UserSubscriptionId int    `json:"user_subscription_id,omitempty"`
+	Group              string `json:"group,omitempty"`
 }
 
 func GetAllRedemptions(startIdx int, num int) (redemptions []*Redemption, total int64, err error) {
@@ -116,20 +129,30 @@ func GetRedemptionById(id int) (*Redemption, error) {
 }
 
 func Redeem(key string, userId int) (quota int, err error) {
+	result, err := RedeemCode(key, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.Quota, nil
+}
+
+// RedeemCode 使用兑换码：普通兑换码发放额度，活动兑换码按活动配置发放
+func RedeemCode(key string, userId int) (*RedeemResult, error) {
 	if key == "" {
-		return 0, errors.New("未提供兑换码")
+		return nil, errors.New("未提供兑换码")
 	}
 	if userId == 0 {
-		return 0, errors.New("无效的 user id")
+		return nil, errors.New("无效的 user id")
 	}
 	redemption := &Redemption{}
+	result := &RedeemResult{GrantType: RedemptionGrantQuota}
 
 	keyCol := "`key`"
 	if common.UsingPostgreSQL {
 		keyCol = `"key"`
 	}
 	common.RandomSleep()
-	err = DB.Transaction(func(tx *gorm.DB) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
 		err := tx.Set("gorm:query_option", "FOR UPDATE").Where(keyCol+" = ?", key).First(redemption).Error
 		if err != nil {
 			return errors.New("无效的兑换码")
@@ -140,7 +163,12 @@ func Redeem(key string, userId int) (quota int, err error) {
 		if redemption.ExpiredTime != 0 && redemption.ExpiredTime < common.GetTimestamp() {
 			return errors.New("该兑换码已过期")
 		}
-		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+		result.RedemptionId = redemption.Id
+		if redemption.CampaignId > 0 {
+			err = applyRedemptionCampaignTx(tx, redemption, userId, result)
+		} else {
+			err = grantRedemptionQuotaTx(tx, redemption, userId, result)
+		}
 		if err != nil {
 			return err
 		}
@@ -152,10 +180,29 @@ func Redeem(key string, userId int) (quota int, err error) {
 	})
 	if err != nil {
 		common.SysError("redemption failed: " + err.Error())
-		return 0, ErrRedeemFailed
+		return nil, ErrRedeemFailed
+	}
+	switch result.GrantType {
+	case RedemptionGrantSubscription:
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码获得订阅套餐 %s，兑换码ID %d", result.PlanTitle, redemption.Id))
+	case RedemptionGrantGroup:
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码升级分组至 %s，兑换码ID %d", result.Group, redemption.Id))
+	default:
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s，兑换码ID %d", logger.LogQuota(redemption.Quota), redemption.Id))
+	}
+	if result.Group != "" {
+		_ = UpdateUserGroupCache(userId, result.Group)
+	}
+	return result, nil
+}
+
+func grantRedemptionQuotaTx(tx *gorm.DB, redemption *Redemption, userId int, result *RedeemResult) error {
+	err := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+	if err != nil {
+		return err
 	}
-	RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s，兑换码ID %d", logger.LogQuota(redemption.Quota), redemption.Id))
-	return redemption.Quota, nil
+	result.Quota = redemption.Quota
+	return RecordCreditGrantTx(tx, userId, CreditSourceRedemption, strconv.Itoa(redemption.Id), int64(redemption.Quota))
 }
 
 func (redemption *Redemption) Insert() error {
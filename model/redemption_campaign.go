@@ -0,0 +1,325 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// Redemption campaign grant types
+const (
+	RedemptionGrantQuota        = "quota"        // 发放额度
+	RedemptionGrantSubscription = "subscription" // 发放订阅套餐
+	RedemptionGrantGroup        = "group"        // 升级用户分组
+)
+
+// RedemptionCampaignMaxBatch 单次批量生成兑换码的上限
+const RedemptionCampaignMaxBatch = 1000
+
+// RedemptionCampaign 兑换码活动：批量生成的兑换码归属于同一活动，共享发放内容、有效期和可用分组
+type RedemptionCampaign struct {
+	Id            int    `json:"id"`
+	Name          string `json:"name" gorm:"type:varchar(64);index"`
+	Partner       string `json:"partner" gorm:"type:varchar(128);default:''"`
+	Description   string `json:"description" gorm:"type:varchar(255);default:''"`
+	GrantType     string `json:"grant_type" gorm:"type:varchar(16);default:'quota'"`
+	Quota         int    `json:"quota"`
+	PlanId        int    `json:"plan_id" gorm:"default:0"`
+	UpgradeGroup  string `json:"upgrade_group" gorm:"type:varchar(64);default:''"`
+	AllowedGroups string `json:"allowed_groups" gorm:"type:varchar(255);default:''"` // 逗号分隔，空表示不限
+	ExpiredTime   int64  `json:"expired_time" gorm:"bigint;default:0"`               // 0 表示不过期
+	Status        int    `json:"status" gorm:"default:1"`
+	CreatedBy     int    `json:"created_by"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+
+	TotalCount    int64 `json:"total_count" gorm:"-:all"`
+	RedeemedCount int64 `json:"redeemed_count" gorm:"-:all"`
+}
+
+// Validate 校验活动配置
+func (campaign *RedemptionCampaign) Validate() error {
+	campaign.Name = strings.TrimSpace(campaign.Name)
+	if campaign.Name == "" || len([]rune(campaign.Name)) > 64 {
+		return errors.New("活动名称长度必须在1-64之间")
+	}
+	switch campaign.GrantType {
+	case "", RedemptionGrantQuota:
+		campaign.GrantType = RedemptionGrantQuota
+		if campaign.Quota <= 0 {
+			return errors.New("兑换额度必须大于0")
+		}
+	case RedemptionGrantSubscription:
+		if campaign.PlanId <= 0 {
+			return errors.New("请选择订阅套餐")
+		}
+		if _, err := GetSubscriptionPlanById(campaign.PlanId); err != nil {
+			return errors.New("订阅套餐不存在")
+		}
+	case RedemptionGrantGroup:
+		campaign.UpgradeGroup = strings.TrimSpace(campaign.UpgradeGroup)
+		if campaign.UpgradeGroup == "" {
+			return errors.New("请填写升级分组")
+		}
+	default:
+		return errors.New("无效的发放类型")
+	}
+	campaign.AllowedGroups = normalizePromoList(campaign.AllowedGroups)
+	return nil
+}
+
+func (campaign *RedemptionCampaign) Insert() error {
+	campaign.CreatedTime = common.GetTimestamp()
+	return DB.Create(campaign).Error
+}
+
+// Update 更新活动信息；发放内容在生成兑换码后不可修改，有效期同步到未使用的兑换码
+func (campaign *RedemptionCampaign) Update() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(campaign).Select("name", "partner", "description", "allowed_groups", "expired_time", "status").
+			Updates(campaign).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Redemption{}).
+			Where("campaign_id = ? AND status = ?", campaign.Id, common.RedemptionCodeStatusEnabled).
+			Update("expired_time", campaign.ExpiredTime).Error
+	})
+}
+
+func GetRedemptionCampaignById(id int) (*RedemptionCampaign, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var campaign RedemptionCampaign
+	err := DB.First(&campaign, "id = ?", id).Error
+	return &campaign, err
+}
+
+func GetRedemptionCampaigns(keyword string, pageInfo *common.PageInfo) (campaigns []*RedemptionCampaign, total int64, err error) {
+	query := DB.Model(&RedemptionCampaign{})
+	if keyword != "" {
+		query = query.Where("name LIKE ? OR partner LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err = query.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&campaigns).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(campaigns) == 0 {
+		return campaigns, total, nil
+	}
+	ids := make([]int, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		ids = append(ids, campaign.Id)
+	}
+	var rows []struct {
+		CampaignId int
+		Status     int
+		Count      int64
+	}
+	if err = DB.Model(&Redemption{}).Select("campaign_id, status, count(*) as count").
+		Where("campaign_id IN ?", ids).Group("campaign_id, status").Scan(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+	index := make(map[int]*RedemptionCampaign, len(campaigns))
+	for _, campaign := range campaigns {
+		index[campaign.Id] = campaign
+	}
+	for _, row := range rows {
+		campaign := index[row.CampaignId]
+		campaign.TotalCount += row.Count
+		if row.Status == common.RedemptionCodeStatusUsed {
+			campaign.RedeemedCount += row.Count
+		}
+	}
+	return campaigns, total, nil
+}
+
+// GenerateCampaignRedemptions 为活动批量生成兑换码，返回生成的兑换码
+func GenerateCampaignRedemptions(campaign *RedemptionCampaign, count int, userId int) ([]string, error) {
+	if count <= 0 || count > RedemptionCampaignMaxBatch {
+		return nil, errors.New("生成数量必须在1-1000之间")
+	}
+	if campaign.Status != common.RedemptionCodeStatusEnabled {
+		return nil, errors.New("活动已停用")
+	}
+	now := common.GetTimestamp()
+	keys := make([]string, 0, count)
+	redemptions := make([]*Redemption, 0, count)
+	for i := 0; i < count; i++ {
+		key := common.GetUUID()
+		keys = append(keys, key)
+		redemptions = append(redemptions, &Redemption{
+			UserId:      userId,
+			Name:        campaign.Name,
+			Key:         key,
+			Status:      common.RedemptionCodeStatusEnabled,
+			Quota:       campaign.Quota,
+			CreatedTime: now,
+			ExpiredTime: campaign.ExpiredTime,
+			CampaignId:  campaign.Id,
+		})
+	}
+	if err := DB.CreateInBatches(redemptions, 100).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetCampaignUnusedRedemptions 活动下尚未使用且未过期的兑换码，用于导出
+func GetCampaignUnusedRedemptions(campaignId int) ([]*Redemption, error) {
+	var redemptions []*Redemption
+	err := DB.Where("campaign_id = ? AND status = ?", campaignId, common.RedemptionCodeStatusEnabled).
+		Where("expired_time = 0 OR expired_time >= ?", common.GetTimestamp()).
+		Order("id").Find(&redemptions).Error
+	return redemptions, err
+}
+
+// DisableCampaignRedemptions 停用活动及其下所有未使用的兑换码
+func DisableCampaignRedemptions(campaignId int) (int64, error) {
+	var affected int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&RedemptionCampaign{}).Where("id = ?", campaignId).
+			Update("status", common.RedemptionCodeStatusDisabled).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Redemption{}).
+			Where("campaign_id = ? AND status = ?", campaignId, common.RedemptionCodeStatusEnabled).
+			Update("status", common.RedemptionCodeStatusDisabled)
+		affected = result.RowsAffected
+		return result.Error
+	})
+	return affected, err
+}
+
+type RedemptionSeriesPoint struct {
+	Time  int64 `json:"time" gorm:"column:bucket_time"`
+	Count int64 `json:"count"`
+}
+
+type RedemptionCampaignStats struct {
+	CampaignId     int                     `json:"campaign_id"`
+	Total          int64                   `json:"total"`
+	Redeemed       int64                   `json:"redeemed"`
+	Available      int64                   `json:"available"`
+	Expired        int64                   `json:"expired"`
+	Disabled       int64                   `json:"disabled"`
+	Users          int64                   `json:"users"`
+	RedemptionRate float64                 `json:"redemption_rate"`
+	Series         []RedemptionSeriesPoint `json:"series"`
+}
+
+// GetRedemptionCampaignStats 统计活动兑换率，并按天或小时汇总兑换次数
+func GetRedemptionCampaignStats(campaignId int, granularity string, startTime int64, endTime int64) (*RedemptionCampaignStats, error) {
+	stats := &RedemptionCampaignStats{CampaignId: campaignId}
+	now := common.GetTimestamp()
+	var rows []struct {
+		Status  int
+		Expired bool
+		Count   int64
+	}
+	err := DB.Model(&Redemption{}).
+		Select("status, (expired_time != 0 AND expired_time < ?) as expired, count(*) as count", now).
+		Where("campaign_id = ?", campaignId).Group("status, expired").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		stats.Total += row.Count
+		switch {
+		case row.Status == common.RedemptionCodeStatusUsed:
+			stats.Redeemed += row.Count
+		case row.Status == common.RedemptionCodeStatusDisabled:
+			stats.Disabled += row.Count
+		case row.Expired:
+			stats.Expired += row.Count
+		default:
+			stats.Available += row.Count
+		}
+	}
+	if stats.Total > 0 {
+		stats.RedemptionRate = float64(stats.Redeemed) / float64(stats.Total)
+	}
+	if err := DB.Model(&Redemption{}).Where("campaign_id = ? AND status = ?", campaignId, common.RedemptionCodeStatusUsed).
+		Distinct("used_user_id").Count(&stats.Users).Error; err != nil {
+		return nil, err
+	}
+
+	bucket := int64(86400)
+	if granularity == "hour" {
+		bucket = 3600
+	}
+	query := DB.Model(&Redemption{}).Where("campaign_id = ? AND status = ?", campaignId, common.RedemptionCodeStatusUsed)
+	if startTime > 0 {
+		query = query.Where("redeemed_time >= ?", startTime)
+	}
+	if endTime > 0 {
+		query = query.Where("redeemed_time <= ?", endTime)
+	}
+	expr := timeBucketExpr("redeemed_time", bucket)
+	err = query.Select(expr + " as bucket_time, count(*) as count").Group(expr).Order("bucket_time").Scan(&stats.Series).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// applyRedemptionCampaignTx 按活动配置发放兑换内容，分组变化记录在 result.Group 中
+func applyRedemptionCampaignTx(tx *gorm.DB, redemption *Redemption, userId int, result *RedeemResult) error {
+	var campaign RedemptionCampaign
+	if err := tx.Where("id = ?", redemption.CampaignId).First(&campaign).Error; err != nil {
+		return errors.New("兑换码所属活动不存在")
+	}
+	if campaign.Status != common.RedemptionCodeStatusEnabled {
+		return errors.New("该兑换码活动已结束")
+	}
+	if campaign.ExpiredTime != 0 && campaign.ExpiredTime < common.GetTimestamp() {
+		return errors.New("该兑换码已过期")
+	}
+	if campaign.AllowedGroups != "" {
+		group, err := getUserGroupByIdTx(tx, userId)
+		if err != nil {
+			return err
+		}
+		if !promoListContains(campaign.AllowedGroups, group) {
+			return errors.New("当前用户分组不可使用该兑换码")
+		}
+	}
+	result.CampaignId = campaign.Id
+	result.GrantType = campaign.GrantType
+	switch campaign.GrantType {
+	case RedemptionGrantSubscription:
+		plan, err := getSubscriptionPlanByIdTx(tx, campaign.PlanId)
+		if err != nil {
+			return errors.New("兑换的订阅套餐不存在")
+		}
+		sub, err := CreateUserSubscriptionFromPlanTx(tx, userId, plan, "redemption")
+		if err != nil {
+			return err
+		}
+		result.PlanId = plan.Id
+		result.PlanTitle = plan.Title
+		result.UserSubscriptionId = sub.Id
+		result.Group = strings.TrimSpace(plan.UpgradeGroup)
+		return nil
+	case RedemptionGrantGroup:
+		if err := tx.Model(&User{}).Where("id = ?", userId).Update("group", campaign.UpgradeGroup).Error; err != nil {
+			return err
+		}
+		result.Group = campaign.UpgradeGroup
+		return nil
+	default:
+		return grantRedemptionQuotaTx(tx, redemption, userId, result)
+	}
+}
+
+func GetRedemptionsByCampaign(campaignId int, startIdx int, num int) (redemptions []*Redemption, total int64, err error) {
+	query := DB.Model(&Redemption{}).Where("campaign_id = ?", campaignId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&redemptions).Error
+	return redemptions, total, err
+}
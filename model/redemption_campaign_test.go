@@ -0,0 +1,86 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRedemptionCampaignTables(t *testing.T) {
+	t.Helper()
+	withColumnNames(t)
+	require.NoError(t, DB.AutoMigrate(&Redemption{}, &RedemptionCampaign{}, &CreditGrant{}))
+	t.Cleanup(func() {
+		for _, table := range []string{"redemptions", "redemption_campaigns", "credit_grants"} {
+			DB.Exec("DELETE FROM " + table)
+		}
+	})
+	truncateTables(t)
+}
+
+func createTestCampaign(t *testing.T, campaign *RedemptionCampaign, count int) []string {
+	t.Helper()
+	campaign.Status = common.RedemptionCodeStatusEnabled
+	require.NoError(t, campaign.Validate())
+	require.NoError(t, campaign.Insert())
+	keys, err := GenerateCampaignRedemptions(campaign, count, 1)
+	require.NoError(t, err)
+	require.Len(t, keys, count)
+	return keys
+}
+
+func TestRedeemCampaignCode_Quota(t *testing.T) {
+	setupRedemptionCampaignTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "camp_user", AffCode: "cp01", Group: "default"}).Error)
+	require.NoError(t, DB.Create(&User{Id: 2, Username: "camp_vip", AffCode: "cp02", Group: "vip"}).Error)
+	campaign := &RedemptionCampaign{Name: "partner-a", Partner: "A", Quota: 500, AllowedGroups: "vip"}
+	keys := createTestCampaign(t, campaign, 3)
+
+	_, err := RedeemCode(keys[0], 1)
+	assert.Error(t, err)
+
+	result, err := RedeemCode(keys[0], 2)
+	require.NoError(t, err)
+	assert.Equal(t, 500, result.Quota)
+	assert.Equal(t, campaign.Id, result.CampaignId)
+	var user User
+	require.NoError(t, DB.First(&user, 2).Error)
+	assert.Equal(t, 500, user.Quota)
+
+	unused, err := GetCampaignUnusedRedemptions(campaign.Id)
+	require.NoError(t, err)
+	assert.Len(t, unused, 2)
+
+	stats, err := GetRedemptionCampaignStats(campaign.Id, "", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, int64(1), stats.Redeemed)
+	assert.Equal(t, int64(2), stats.Available)
+	assert.Equal(t, int64(1), stats.Users)
+	assert.InDelta(t, 1.0/3, stats.RedemptionRate, 0.001)
+	require.Len(t, stats.Series, 1)
+	assert.Equal(t, int64(1), stats.Series[0].Count)
+
+	rows, err := DisableCampaignRedemptions(campaign.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rows)
+	_, err = RedeemCode(keys[1], 2)
+	assert.Error(t, err)
+}
+
+func TestRedeemCampaignCode_Group(t *testing.T) {
+	setupRedemptionCampaignTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "camp_group", AffCode: "cp03", Group: "default"}).Error)
+	keys := createTestCampaign(t, &RedemptionCampaign{Name: "vip-upgrade", GrantType: RedemptionGrantGroup, UpgradeGroup: "vip"}, 1)
+
+	result, err := RedeemCode(keys[0], 1)
+	require.NoError(t, err)
+	assert.Equal(t, RedemptionGrantGroup, result.GrantType)
+	assert.Equal(t, 0, result.Quota)
+	var user User
+	require.NoError(t, DB.First(&user, 1).Error)
+	assert.Equal(t, "vip", user.Group)
+	assert.Equal(t, 0, user.Quota)
+}
@@ -0,0 +1,346 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// 权限，格式为 资源:操作，write 不包含 read。管理后台的路由分组按资源划分，
+// 角色与管理 API 密钥使用同一套权限
+const (
+	PermissionChannelsRead     = "channels:read"
+	PermissionChannelsWrite    = "channels:write"
+	PermissionUsersRead        = "users:read"
+	PermissionUsersWrite       = "users:write"
+	PermissionTokensRead       = "tokens:read"
+	PermissionTokensWrite      = "tokens:write"
+	PermissionLogsRead         = "logs:read"
+	PermissionLogsWrite        = "logs:write"
+	PermissionOptionsRead      = "options:read"
+	PermissionOptionsWrite     = "options:write"
+	PermissionRedemptionsRead  = "redemptions:read"
+	PermissionRedemptionsWrite = "redemptions:write"
+	PermissionModelsRead       = "models:read"
+	PermissionModelsWrite      = "models:write"
+	PermissionBillingRead      = "billing:read"
+	PermissionBillingWrite     = "billing:write"
+)
+
+var Permissions = []string{
+	PermissionChannelsRead,
+	PermissionChannelsWrite,
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionTokensRead,
+	PermissionTokensWrite,
+	PermissionLogsRead,
+	PermissionLogsWrite,
+	PermissionOptionsRead,
+	PermissionOptionsWrite,
+	PermissionRedemptionsRead,
+	PermissionRedemptionsWrite,
+	PermissionModelsRead,
+	PermissionModelsWrite,
+	PermissionBillingRead,
+	PermissionBillingWrite,
+}
+
+// NormalizePermissions 校验并规范化逗号分隔的权限，去重后按固定顺序输出；
+// 存在未知的权限时通过 invalid 返回
+func NormalizePermissions(raw string) (normalized string, invalid string) {
+	selected := make(map[string]bool)
+	for _, permission := range strings.Split(raw, ",") {
+		permission = strings.ToLower(strings.TrimSpace(permission))
+		if permission == "" {
+			continue
+		}
+		if !slices.Contains(Permissions, permission) {
+			return "", permission
+		}
+		selected[permission] = true
+	}
+	permissions := make([]string, 0, len(selected))
+	for _, permission := range Permissions {
+		if selected[permission] {
+			permissions = append(permissions, permission)
+		}
+	}
+	return strings.Join(permissions, ","), ""
+}
+
+// 内置角色与原有的用户等级一一对应，不保存在数据库中
+const (
+	BuiltinRoleRoot  = "root"
+	BuiltinRoleAdmin = "admin"
+	BuiltinRoleUser  = "user"
+)
+
+// Role 自定义角色：拥有管理员等级，但只能访问授权的路由分组，
+// 用于客服、运营等只需部分管理权限的人员
+type Role struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Permissions string `json:"permissions" gorm:"type:varchar(1024);default:''"` // 逗号分隔
+	Builtin     bool   `json:"builtin" gorm:"-"`
+	Level       int    `json:"level" gorm:"-"` // 对应的用户等级
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// builtinAdminPermissions 管理员拥有除系统设置外的全部权限，系统设置仍仅限超级管理员
+var builtinAdminPermissions = slices.DeleteFunc(slices.Clone(Permissions), func(permission string) bool {
+	return permission == PermissionOptionsRead || permission == PermissionOptionsWrite
+})
+
+func BuiltinRoles() []*Role {
+	return []*Role{
+		{Name: BuiltinRoleRoot, Builtin: true, Level: common.RoleRootUser, Permissions: strings.Join(Permissions, ",")},
+		{Name: BuiltinRoleAdmin, Builtin: true, Level: common.RoleAdminUser, Permissions: strings.Join(builtinAdminPermissions, ",")},
+		{Name: BuiltinRoleUser, Builtin: true, Level: common.RoleCommonUser},
+	}
+}
+
+func IsBuiltinRoleName(name string) bool {
+	return name == BuiltinRoleRoot || name == BuiltinRoleAdmin || name == BuiltinRoleUser
+}
+
+func (role *Role) HasPermission(permission string) bool {
+	return slices.Contains(strings.Split(role.Permissions, ","), permission)
+}
+
+// Validate 校验并规范化角色名称与权限
+func (role *Role) Validate() error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" || len(role.Name) > 64 {
+		return errors.New("角色名称不能为空且长度不能超过 64")
+	}
+	if IsBuiltinRoleName(strings.ToLower(role.Name)) {
+		return fmt.Errorf("角色名称 %s 为内置角色保留", role.Name)
+	}
+	if len(role.Description) > 255 {
+		return errors.New("角色描述长度不能超过 255")
+	}
+	permissions, invalid := NormalizePermissions(role.Permissions)
+	if invalid != "" {
+		return fmt.Errorf("无效的权限：%s", invalid)
+	}
+	role.Permissions = permissions
+	return nil
+}
+
+func (role *Role) fill() {
+	role.Level = common.RoleAdminUser
+}
+
+func (role *Role) Insert() error {
+	now := common.GetTimestamp()
+	role.CreatedTime = now
+	role.UpdatedTime = now
+	err := DB.Create(role).Error
+	invalidateRoleCache(role.Id)
+	role.fill()
+	return err
+}
+
+// Update 如有新增可修改的字段，请同步更新此处
+func (role *Role) Update() error {
+	role.UpdatedTime = common.GetTimestamp()
+	err := DB.Model(role).Select("name", "description", "permissions", "updated_time").Updates(role).Error
+	invalidateRoleCache(role.Id)
+	return err
+}
+
+func GetRoleById(id int) (*Role, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var role Role
+	if err := DB.First(&role, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	role.fill()
+	return &role, nil
+}
+
+// GetAllRoles 返回内置角色及全部自定义角色
+func GetAllRoles() ([]*Role, error) {
+	var roles []*Role
+	if err := DB.Order("id asc").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		role.fill()
+	}
+	return append(BuiltinRoles(), roles...), nil
+}
+
+// DeleteRoleById 删除自定义角色，仍有用户使用该角色时拒绝删除
+func DeleteRoleById(id int) error {
+	var count int64
+	if err := DB.Model(&User{}).Where("role_id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("仍有 %d 个用户使用该角色，请先调整这些用户的角色", count)
+	}
+	err := DB.Delete(&Role{}, "id = ?", id).Error
+	invalidateRoleCache(id)
+	return err
+}
+
+// AssignUserRole 为用户分配角色：内置角色 admin、user 直接设置用户等级，自定义角色以管理员等级加角色权限生效。
+// 超级管理员的角色不能通过此方式修改，也不能分配超级管理员角色
+func AssignUserRole(userId int, roleName string) error {
+	user, err := GetUserById(userId, false)
+	if err != nil {
+		return err
+	}
+	if user.Role >= common.RoleRootUser {
+		return errors.New("无法修改超级管理员的角色")
+	}
+	updates := map[string]any{}
+	switch roleName {
+	case BuiltinRoleRoot:
+		return errors.New("无法分配超级管理员角色")
+	case BuiltinRoleAdmin:
+		updates["role"] = common.RoleAdminUser
+		updates["role_id"] = 0
+	case BuiltinRoleUser:
+		updates["role"] = common.RoleCommonUser
+		updates["role_id"] = 0
+	default:
+		var role Role
+		if err := DB.First(&role, "name = ?", roleName).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("角色 %s 不存在", roleName)
+			}
+			return err
+		}
+		updates["role"] = common.RoleAdminUser
+		updates["role_id"] = role.Id
+	}
+	if err := DB.Model(&User{}).Where("id = ?", userId).Updates(updates).Error; err != nil {
+		return err
+	}
+	return invalidateUserCache(userId)
+}
+
+// ClearUserRoleId 取消用户的自定义角色，恢复为与用户等级对应的内置角色
+func ClearUserRoleId(userId int) error {
+	if err := DB.Model(&User{}).Where("id = ?", userId).Update("role_id", 0).Error; err != nil {
+		return err
+	}
+	return invalidateUserCache(userId)
+}
+
+// 未启用 Redis 时自定义角色的权限缓存在内存中，多节点部署时最多延迟 roleCacheSeconds 生效
+const roleCacheSeconds = 60
+
+var (
+	roleCacheLock     sync.RWMutex
+	roleCache         map[int]*Role
+	roleCacheLoadedAt time.Time
+)
+
+func getRoleCacheKey(id int) string {
+	return fmt.Sprintf("role:%d", id)
+}
+
+// invalidateRoleCache 角色修改、删除后清除缓存，启用 Redis 时所有节点立即生效
+func invalidateRoleCache(id int) {
+	roleCacheLock.Lock()
+	roleCache = nil
+	roleCacheLock.Unlock()
+	if common.RedisEnabled && id != 0 {
+		if err := common.RedisDelKey(getRoleCacheKey(id)); err != nil {
+			common.SysLog("failed to delete role cache: " + err.Error())
+		}
+	}
+}
+
+// getCachedRole 读取自定义角色，角色不存在时返回 nil
+func getCachedRole(id int) (*Role, error) {
+	if common.RedisEnabled {
+		return getRedisCachedRole(id)
+	}
+	roleCacheLock.RLock()
+	if roleCache != nil && time.Since(roleCacheLoadedAt) < roleCacheSeconds*time.Second {
+		role := roleCache[id]
+		roleCacheLock.RUnlock()
+		return role, nil
+	}
+	roleCacheLock.RUnlock()
+
+	var roles []*Role
+	if err := DB.Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	cache := make(map[int]*Role, len(roles))
+	for _, role := range roles {
+		role.fill()
+		cache[role.Id] = role
+	}
+	roleCacheLock.Lock()
+	roleCache = cache
+	roleCacheLoadedAt = time.Now()
+	roleCacheLock.Unlock()
+	return cache[id], nil
+}
+
+func getRedisCachedRole(id int) (*Role, error) {
+	var role Role
+	if err := common.RedisHGetObj(getRoleCacheKey(id), &role); err == nil && role.Id == id {
+		role.fill()
+		return &role, nil
+	}
+	if err := DB.First(&role, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	role.fill()
+	if err := common.RedisHSetObj(getRoleCacheKey(id), &role, time.Duration(common.RedisKeyCacheSeconds())*time.Second); err != nil {
+		common.SysLog("failed to update role cache: " + err.Error())
+	}
+	return &role, nil
+}
+
+// UserHasPermission 判断用户是否拥有指定权限。用户等级与角色从用户缓存读取，
+// 调整角色时会清除用户缓存，无需重新登录即可生效
+func UserHasPermission(userId int, permission string) (bool, error) {
+	user, err := GetUserCache(userId)
+	if err != nil {
+		return false, err
+	}
+	if user.Role == common.RoleGuestUser {
+		// 升级前写入的用户缓存不含用户等级，回源数据库
+		var dbUser User
+		if err := DB.Select("id", "role", "role_id").First(&dbUser, "id = ?", userId).Error; err != nil {
+			return false, err
+		}
+		user.Role, user.RoleId = dbUser.Role, dbUser.RoleId
+	}
+	switch {
+	case user.Role >= common.RoleRootUser:
+		return true, nil
+	case user.Role < common.RoleAdminUser:
+		return false, nil
+	case user.RoleId == 0:
+		return slices.Contains(builtinAdminPermissions, permission), nil
+	}
+	role, err := getCachedRole(user.RoleId)
+	if err != nil {
+		return false, err
+	}
+	// 角色已被删除时不授予任何权限
+	return role != nil && role.HasPermission(permission), nil
+}
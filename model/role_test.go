@@ -0,0 +1,80 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserHasPermission(t *testing.T) {
+	truncateTables(t)
+	require.NoError(t, DB.AutoMigrate(&Role{}))
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM roles")
+		invalidateRoleCache(0)
+	})
+
+	users := []*User{
+		{Id: 1, Username: "root", Role: common.RoleRootUser, AffCode: "r1"},
+		{Id: 2, Username: "admin", Role: common.RoleAdminUser, AffCode: "r2"},
+		{Id: 3, Username: "user", Role: common.RoleCommonUser, AffCode: "r3"},
+		{Id: 4, Username: "support", Role: common.RoleCommonUser, AffCode: "r4"},
+	}
+	require.NoError(t, DB.Create(&users).Error)
+
+	support := &Role{Name: "support", Permissions: "users:read,users:write,logs:read"}
+	require.NoError(t, support.Validate())
+	require.NoError(t, support.Insert())
+	require.Error(t, (&Role{Name: "Admin"}).Validate())
+	require.Error(t, (&Role{Name: "ops", Permissions: "channels:keys"}).Validate())
+
+	require.NoError(t, AssignUserRole(4, "support"))
+	assigned, err := GetUserById(4, false)
+	require.NoError(t, err)
+	assert.Equal(t, common.RoleAdminUser, assigned.Role)
+	assert.Equal(t, support.Id, assigned.RoleId)
+	assert.Error(t, AssignUserRole(1, BuiltinRoleUser))
+	assert.Error(t, AssignUserRole(3, BuiltinRoleRoot))
+
+	cases := []struct {
+		userId     int
+		permission string
+		allowed    bool
+	}{
+		{1, PermissionOptionsWrite, true},
+		{2, PermissionChannelsWrite, true},
+		{2, PermissionOptionsWrite, false},
+		{3, PermissionLogsRead, false},
+		{4, PermissionUsersWrite, true},
+		{4, PermissionLogsRead, true},
+		{4, PermissionChannelsRead, false},
+		{4, PermissionOptionsWrite, false},
+	}
+	for _, tc := range cases {
+		allowed, err := UserHasPermission(tc.userId, tc.permission)
+		require.NoError(t, err)
+		assert.Equal(t, tc.allowed, allowed, "user %d %s", tc.userId, tc.permission)
+	}
+
+	// 修改角色权限后立即生效
+	support.Permissions = "users:read,users:write"
+	require.NoError(t, support.Update())
+	allowed, err := UserHasPermission(4, PermissionLogsRead)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	cached, err := GetUserCache(4)
+	require.NoError(t, err)
+	assert.Equal(t, common.RoleAdminUser, cached.Role)
+	assert.Equal(t, support.Id, cached.RoleId)
+
+	// 仍有用户使用的角色不能删除
+	assert.Error(t, DeleteRoleById(support.Id))
+	require.NoError(t, AssignUserRole(4, BuiltinRoleUser))
+	require.NoError(t, DeleteRoleById(support.Id))
+	allowed, err = UserHasPermission(4, PermissionUsersRead)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
@@ -0,0 +1,271 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm/clause"
+)
+
+const StatementPeriodLayout = "2006-01"
+
+// UserStatement 已出具的月度账单记录，用于避免重复发送账单邮件
+type UserStatement struct {
+	Id                int     `json:"id"`
+	UserId            int     `json:"user_id" gorm:"uniqueIndex:idx_user_statement_period,priority:1"`
+	Period            string  `json:"period" gorm:"type:varchar(7);uniqueIndex:idx_user_statement_period,priority:2;index"`
+	Consumed          int64   `json:"consumed" gorm:"type:bigint;default:0"`
+	RequestCount      int64   `json:"request_count" gorm:"type:bigint;default:0"`
+	Refunded          int64   `json:"refunded" gorm:"type:bigint;default:0"`
+	TopUpMoney        float64 `json:"topup_money" gorm:"default:0"`
+	SubscriptionMoney float64 `json:"subscription_money" gorm:"default:0"`
+	EmailedAt         int64   `json:"emailed_at" gorm:"bigint;default:0"`
+	CreatedAt         int64   `json:"created_at" gorm:"bigint"`
+}
+
+// StatementLine 账单明细行（按模型或令牌聚合）
+type StatementLine struct {
+	Name             string `json:"name"`
+	RequestCount     int64  `json:"request_count"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Quota            int64  `json:"quota"`
+}
+
+// StatementPayment 账单周期内的充值或订阅支付记录
+type StatementPayment struct {
+	TradeNo       string  `json:"trade_no"`
+	PaymentMethod string  `json:"payment_method"`
+	Money         float64 `json:"money"`
+	CompleteTime  int64   `json:"complete_time"`
+}
+
+// UserStatementDetail 用户月度账单明细
+type UserStatementDetail struct {
+	UserId            int                `json:"user_id"`
+	Username          string             `json:"username"`
+	Email             string             `json:"email"`
+	Period            string             `json:"period"`
+	PeriodStart       int64              `json:"period_start"`
+	PeriodEnd         int64              `json:"period_end"`
+	Consumed          int64              `json:"consumed"`
+	RequestCount      int64              `json:"request_count"`
+	Refunded          int64              `json:"refunded"`
+	ByModel           []StatementLine    `json:"by_model"`
+	ByToken           []StatementLine    `json:"by_token"`
+	TopUps            []StatementPayment `json:"topups"`
+	Subscriptions     []StatementPayment `json:"subscriptions"`
+	TopUpMoney        float64            `json:"topup_money"`
+	SubscriptionMoney float64            `json:"subscription_money"`
+}
+
+// StatementUsageRow 全平台账单明细行（用户 x 模型 x 令牌）
+type StatementUsageRow struct {
+	UserId           int    `json:"user_id"`
+	Username         string `json:"username"`
+	ModelName        string `json:"model_name"`
+	TokenName        string `json:"token_name"`
+	RequestCount     int64  `json:"request_count"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Quota            int64  `json:"quota"`
+}
+
+// ParseStatementPeriod 解析账单周期（YYYY-MM，服务器时区），返回 [start, end) 时间戳
+func ParseStatementPeriod(period string) (int64, int64, error) {
+	t, err := time.ParseInLocation(StatementPeriodLayout, period, time.Local)
+	if err != nil {
+		return 0, 0, errors.New("账单周期格式错误，应为 YYYY-MM")
+	}
+	return t.Unix(), t.AddDate(0, 1, 0).Unix(), nil
+}
+
+// PreviousStatementPeriod 返回上一个自然月的账单周期
+func PreviousStatementPeriod(now time.Time) string {
+	firstDay := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return firstDay.AddDate(0, -1, 0).Format(StatementPeriodLayout)
+}
+
+func statementLines(userId int, groupCol string, start int64, end int64) ([]StatementLine, error) {
+	var lines []StatementLine
+	err := LOG_DB.Table("logs").
+		Select(groupCol+" as name, count(*) as request_count, COALESCE(sum(prompt_tokens),0) as prompt_tokens, COALESCE(sum(completion_tokens),0) as completion_tokens, COALESCE(sum(quota),0) as quota").
+		Where("user_id = ? AND type = ? AND created_at >= ? AND created_at < ?", userId, LogTypeConsume, start, end).
+		Group(groupCol).
+		Order("quota desc").
+		Scan(&lines).Error
+	return lines, err
+}
+
+// statementLinesFromQuotaData 未记录消费日志时，从 quota_data 按模型汇总（无令牌维度）
+func statementLinesFromQuotaData(userId int, start int64, end int64) ([]StatementLine, error) {
+	var lines []StatementLine
+	err := DB.Table("quota_data").
+		Select("model_name as name, COALESCE(sum(count),0) as request_count, COALESCE(sum(token_used),0) as prompt_tokens, 0 as completion_tokens, COALESCE(sum(quota),0) as quota").
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", userId, start, end).
+		Group("model_name").
+		Order("quota desc").
+		Scan(&lines).Error
+	return lines, err
+}
+
+// BuildUserStatement 汇总用户在账单周期内的消费、退款、充值和订阅支付
+func BuildUserStatement(userId int, period string) (*UserStatementDetail, error) {
+	start, end, err := ParseStatementPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	user, err := GetUserById(userId, false)
+	if err != nil {
+		return nil, err
+	}
+	detail := &UserStatementDetail{
+		UserId:        user.Id,
+		Username:      user.Username,
+		Email:         user.Email,
+		Period:        period,
+		PeriodStart:   start,
+		PeriodEnd:     end,
+		ByModel:       []StatementLine{},
+		ByToken:       []StatementLine{},
+		TopUps:        []StatementPayment{},
+		Subscriptions: []StatementPayment{},
+	}
+
+	if common.LogConsumeEnabled {
+		if detail.ByModel, err = statementLines(userId, "model_name", start, end); err != nil {
+			return nil, err
+		}
+		if detail.ByToken, err = statementLines(userId, "token_name", start, end); err != nil {
+			return nil, err
+		}
+	} else if detail.ByModel, err = statementLinesFromQuotaData(userId, start, end); err != nil {
+		return nil, err
+	}
+	for _, line := range detail.ByModel {
+		detail.Consumed += line.Quota
+		detail.RequestCount += line.RequestCount
+	}
+
+	if err := LOG_DB.Table("logs").
+		Select("COALESCE(sum(quota),0)").
+		Where("user_id = ? AND type = ? AND created_at >= ? AND created_at < ?", userId, LogTypeRefund, start, end).
+		Scan(&detail.Refunded).Error; err != nil {
+		return nil, err
+	}
+
+	if err := DB.Model(&TopUp{}).
+		Select("trade_no, payment_method, money, complete_time").
+		Where("user_id = ? AND status = ? AND complete_time >= ? AND complete_time < ?", userId, common.TopUpStatusSuccess, start, end).
+		Order("complete_time asc").
+		Scan(&detail.TopUps).Error; err != nil {
+		return nil, err
+	}
+	// 订阅支付会同时写入一条充值记录，这里排除以免重复统计
+	subscriptionTradeNos := make(map[string]bool)
+	if err := DB.Model(&SubscriptionOrder{}).
+		Select("trade_no, payment_method, money, complete_time").
+		Where("user_id = ? AND status = ? AND complete_time >= ? AND complete_time < ?", userId, common.TopUpStatusSuccess, start, end).
+		Order("complete_time asc").
+		Scan(&detail.Subscriptions).Error; err != nil {
+		return nil, err
+	}
+	for _, sub := range detail.Subscriptions {
+		subscriptionTradeNos[sub.TradeNo] = true
+		detail.SubscriptionMoney += sub.Money
+	}
+	topUps := detail.TopUps[:0]
+	for _, topUp := range detail.TopUps {
+		if subscriptionTradeNos[topUp.TradeNo] {
+			continue
+		}
+		topUps = append(topUps, topUp)
+		detail.TopUpMoney += topUp.Money
+	}
+	detail.TopUps = topUps
+	return detail, nil
+}
+
+// GetStatementUsageRows 全平台账单周期内按用户、模型、令牌汇总的消费明细
+func GetStatementUsageRows(period string) ([]StatementUsageRow, error) {
+	start, end, err := ParseStatementPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	var rows []StatementUsageRow
+	err = LOG_DB.Table("logs").
+		Select("user_id, username, model_name, token_name, count(*) as request_count, COALESCE(sum(prompt_tokens),0) as prompt_tokens, COALESCE(sum(completion_tokens),0) as completion_tokens, COALESCE(sum(quota),0) as quota").
+		Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeConsume, start, end).
+		Group("user_id, username, model_name, token_name").
+		Order("user_id asc, quota desc").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// GetStatementUserIds 返回账单周期内有消费的用户
+func GetStatementUserIds(period string) ([]int, error) {
+	start, end, err := ParseStatementPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	var userIds []int
+	if common.LogConsumeEnabled {
+		err = LOG_DB.Table("logs").
+			Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeConsume, start, end).
+			Distinct("user_id").
+			Pluck("user_id", &userIds).Error
+	} else {
+		err = DB.Table("quota_data").
+			Where("created_at >= ? AND created_at < ?", start, end).
+			Distinct("user_id").
+			Pluck("user_id", &userIds).Error
+	}
+	return userIds, err
+}
+
+// ClaimUserStatement 记录已出具的账单。返回 false 表示该周期账单已被其他节点或先前的运行处理
+func ClaimUserStatement(detail *UserStatementDetail) (bool, error) {
+	record := &UserStatement{
+		UserId:            detail.UserId,
+		Period:            detail.Period,
+		Consumed:          detail.Consumed,
+		RequestCount:      detail.RequestCount,
+		Refunded:          detail.Refunded,
+		TopUpMoney:        detail.TopUpMoney,
+		SubscriptionMoney: detail.SubscriptionMoney,
+		CreatedAt:         common.GetTimestamp(),
+	}
+	result := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetClaimedStatementUserIds 返回账单周期内已出具账单的用户
+func GetClaimedStatementUserIds(period string) (map[int]bool, error) {
+	var userIds []int
+	if err := DB.Model(&UserStatement{}).Where("period = ?", period).Pluck("user_id", &userIds).Error; err != nil {
+		return nil, err
+	}
+	claimed := make(map[int]bool, len(userIds))
+	for _, id := range userIds {
+		claimed[id] = true
+	}
+	return claimed, nil
+}
+
+func MarkUserStatementEmailed(userId int, period string) error {
+	return DB.Model(&UserStatement{}).
+		Where("user_id = ? AND period = ?", userId, period).
+		Update("emailed_at", common.GetTimestamp()).Error
+}
+
+// GetUserStatementPeriods 获取用户已出具的账单周期
+func GetUserStatementPeriods(userId int) ([]UserStatement, error) {
+	var statements []UserStatement
+	err := DB.Where("user_id = ?", userId).Order("period desc").Limit(36).Find(&statements).Error
+	return statements, err
+}
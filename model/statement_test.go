@@ -0,0 +1,56 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildUserStatement(t *testing.T) {
+	require.NoError(t, DB.AutoMigrate(&TopUp{}, &SubscriptionOrder{}))
+	truncateTables(t)
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM top_ups")
+		DB.Exec("DELETE FROM subscription_orders")
+	})
+
+	require.NoError(t, DB.Create(&User{Id: 21, Username: "statement_user", AffCode: "st01"}).Error)
+	start, end, err := ParseStatementPeriod("2026-03")
+	require.NoError(t, err)
+	inPeriod := start + 3600
+
+	logs := []*Log{
+		{UserId: 21, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "gpt-4o", TokenName: "prod", Quota: 300, PromptTokens: 10, CompletionTokens: 5},
+		{UserId: 21, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "gpt-4o", TokenName: "dev", Quota: 200, PromptTokens: 4, CompletionTokens: 2},
+		{UserId: 21, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "claude", TokenName: "prod", Quota: 100},
+		{UserId: 21, Type: LogTypeRefund, CreatedAt: inPeriod, Quota: 50},
+		{UserId: 21, Type: LogTypeConsume, CreatedAt: end, ModelName: "gpt-4o", Quota: 999},
+	}
+	require.NoError(t, LOG_DB.Create(&logs).Error)
+	require.NoError(t, DB.Create(&TopUp{UserId: 21, TradeNo: "t-1", Money: 10, Status: common.TopUpStatusSuccess, CompleteTime: inPeriod}).Error)
+	require.NoError(t, DB.Create(&TopUp{UserId: 21, TradeNo: "sub-1", Money: 20, Status: common.TopUpStatusSuccess, CompleteTime: inPeriod}).Error)
+	require.NoError(t, DB.Create(&SubscriptionOrder{UserId: 21, TradeNo: "sub-1", Money: 20, Status: common.TopUpStatusSuccess, CompleteTime: inPeriod}).Error)
+
+	detail, err := BuildUserStatement(21, "2026-03")
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), detail.Consumed)
+	assert.Equal(t, int64(3), detail.RequestCount)
+	assert.Equal(t, int64(50), detail.Refunded)
+	require.Len(t, detail.ByModel, 2)
+	assert.Equal(t, "gpt-4o", detail.ByModel[0].Name)
+	assert.Equal(t, int64(500), detail.ByModel[0].Quota)
+	require.Len(t, detail.ByToken, 2)
+	assert.Equal(t, 10.0, detail.TopUpMoney)
+	assert.Equal(t, 20.0, detail.SubscriptionMoney)
+	require.Len(t, detail.TopUps, 1)
+}
+
+func TestPreviousStatementPeriod(t *testing.T) {
+	now := time.Date(2026, 1, 15, 10, 0, 0, 0, time.Local)
+	assert.Equal(t, "2025-12", PreviousStatementPeriod(now))
+	_, _, err := ParseStatementPeriod("2026/01")
+	assert.Error(t, err)
+}
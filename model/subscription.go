@@ -11,6 +11,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/pkg/cachex"
 	"github.com/samber/hot"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -35,6 +36,7 @@ const (
 var (
 	ErrSubscriptionOrderNotFound      = errors.New("subscription order not found")
 	ErrSubscriptionOrderStatusInvalid = errors.New("subscription order status invalid")
+	ErrSubscriptionAllowanceExhausted = errors.New("subscription allowance exhausted")
 )
 
 const (
@@ -205,6 +207,14 @@ type SubscriptionOrder struct {
 	CompleteTime  int64  `json:"complete_time"`
 
 	ProviderPayload string `json:"provider_payload" gorm:"type:text"`
+	ProviderTradeNo string `json:"provider_trade_no" gorm:"type:varchar(255);default:''"`
+	RefundTime      int64  `json:"refund_time" gorm:"default:0"`
+	RefundReason    string `json:"refund_reason" gorm:"type:varchar(255);default:''"`
+
+	// 套餐变更订单：支付完成后替换该订阅（0 表示新购）
+	ChangeFromSubscriptionId int `json:"change_from_subscription_id" gorm:"default:0"`
+	// 订单完成后创建或续期的用户订阅
+	UserSubscriptionId int `json:"user_subscription_id" gorm:"default:0;index"`
 }
 
 func (o *SubscriptionOrder) Insert() error {
@@ -250,6 +260,14 @@ type UserSubscription struct {
 	UpgradeGroup  string `json:"upgrade_group" gorm:"type:varchar(64);default:''"`
 	PrevUserGroup string `json:"prev_user_group" gorm:"type:varchar(64);default:''"`
 
+	// 自动续费：由支付平台（stripe/creem）的订阅对象驱动续期
+	PaymentProvider        string `json:"payment_provider" gorm:"type:varchar(32);default:''"`
+	ProviderSubscriptionId string `json:"provider_subscription_id" gorm:"type:varchar(128);default:'';index"`
+	AutoRenew              bool   `json:"auto_renew"`
+	CancelAtPeriodEnd      bool   `json:"cancel_at_period_end"`
+	RenewalStatus          string `json:"renewal_status" gorm:"type:varchar(16);default:''"` // ''/past_due
+	RenewalFailedAt        int64  `json:"renewal_failed_at" gorm:"type:bigint;default:0"`
+
 	CreatedAt int64 `json:"created_at" gorm:"bigint"`
 	UpdatedAt int64 `json:"updated_at" gorm:"bigint"`
 }
@@ -517,6 +535,7 @@ func CompleteSubscriptionOrder(tradeNo string, providerPayload string) error {
 	var logPlanTitle string
 	var logMoney float64
 	var logPaymentMethod string
+	var logChange bool
 	var upgradeGroup string
 	err := DB.Transaction(func(tx *gorm.DB) error {
 		var order SubscriptionOrder
@@ -537,10 +556,29 @@ func CompleteSubscriptionOrder(tradeNo string, providerPayload string) error {
 			// still allow completion for already purchased orders
 		}
 		upgradeGroup = strings.TrimSpace(plan.UpgradeGroup)
-		_, err = CreateUserSubscriptionFromPlanTx(tx, order.UserId, plan, "order")
-		if err != nil {
-			return err
+		var sub *UserSubscription
+		if order.ChangeFromSubscriptionId > 0 {
+			changed, group, err := applySubscriptionChangeTx(tx, order.UserId, order.ChangeFromSubscriptionId, plan, GetDBTimestamp())
+			if err != nil && !errors.Is(err, ErrSubscriptionChangeInvalid) {
+				return err
+			}
+			if err == nil {
+				sub = changed
+				upgradeGroup = group
+			} else {
+				// 原订阅已失效（过期或被取消），按新购处理，避免已支付订单无法完成
+				common.SysLog(fmt.Sprintf("subscription change order %s: source subscription %d no longer active, creating new subscription", tradeNo, order.ChangeFromSubscriptionId))
+				if sub, err = CreateUserSubscriptionFromPlanTx(tx, order.UserId, plan, "order"); err != nil {
+					return err
+				}
+			}
+		} else {
+			sub, err = CreateUserSubscriptionFromPlanTx(tx, order.UserId, plan, "order")
+			if err != nil {
+				return err
+			}
 		}
+		order.UserSubscriptionId = sub.Id
 		if err := upsertSubscriptionTopUpTx(tx, &order); err != nil {
 			return err
 		}
@@ -552,10 +590,18 @@ func CompleteSubscriptionOrder(tradeNo string, providerPayload string) error {
 		if err := tx.Save(&order).Error; err != nil {
 			return err
 		}
+		if _, err := completePromoCodeUsageTx(tx, order.TradeNo, 0); err != nil {
+			return err
+		}
+		subscriptionQuota := decimal.NewFromFloat(order.Money).Mul(decimal.NewFromFloat(common.QuotaPerUnit)).IntPart()
+		if _, err := accrueAffiliateCommissionTx(tx, order.UserId, AffiliateCommissionSourceSubscription, order.TradeNo, subscriptionQuota); err != nil {
+			return err
+		}
 		logUserId = order.UserId
 		logPlanTitle = plan.Title
 		logMoney = order.Money
 		logPaymentMethod = order.PaymentMethod
+		logChange = order.ChangeFromSubscriptionId > 0
 		return nil
 	})
 	if err != nil {
@@ -566,6 +612,9 @@ func CompleteSubscriptionOrder(tradeNo string, providerPayload string) error {
 	}
 	if logUserId > 0 {
 		msg := fmt.Sprintf("订阅购买成功，套餐: %s，支付金额: %.2f，支付方式: %s", logPlanTitle, logMoney, logPaymentMethod)
+		if logChange {
+			msg = fmt.Sprintf("套餐变更成功，新套餐: %s，补付金额: %.2f，支付方式: %s", logPlanTitle, logMoney, logPaymentMethod)
+		}
 		RecordLog(logUserId, LogTypeTopup, msg)
 	}
 	return nil
@@ -623,7 +672,10 @@ func ExpireSubscriptionOrder(tradeNo string) error {
 		}
 		order.Status = common.TopUpStatusExpired
 		order.CompleteTime = common.GetTimestamp()
-		return tx.Save(&order).Error
+		if err := tx.Save(&order).Error; err != nil {
+			return err
+		}
+		return expirePromoCodeUsageTx(tx, order.TradeNo)
 	})
 }
 
@@ -717,38 +769,18 @@ func AdminInvalidateUserSubscription(userSubscriptionId int) (string, error) {
 		return "", errors.New("invalid userSubscriptionId")
 	}
 	now := common.GetTimestamp()
-	cacheGroup := ""
+	var sub *UserSubscription
 	downgradeGroup := ""
-	var userId int
 	err := DB.Transaction(func(tx *gorm.DB) error {
-		var sub UserSubscription
-		if err := tx.Set("gorm:query_option", "FOR UPDATE").
-			Where("id = ?", userSubscriptionId).First(&sub).Error; err != nil {
-			return err
-		}
-		userId = sub.UserId
-		if err := tx.Model(&sub).Updates(map[string]interface{}{
-			"status":     "cancelled",
-			"end_time":   now,
-			"updated_at": now,
-		}).Error; err != nil {
-			return err
-		}
-		target, err := downgradeUserGroupForSubscriptionTx(tx, &sub, now)
-		if err != nil {
-			return err
-		}
-		if target != "" {
-			cacheGroup = target
-			downgradeGroup = target
-		}
-		return nil
+		var err error
+		sub, downgradeGroup, err = invalidateUserSubscriptionTx(tx, userSubscriptionId, now)
+		return err
 	})
 	if err != nil {
 		return "", err
 	}
-	if cacheGroup != "" && userId > 0 {
-		_ = UpdateUserGroupCache(userId, cacheGroup)
+	if downgradeGroup != "" && sub.UserId > 0 {
+		_ = UpdateUserGroupCache(sub.UserId, downgradeGroup)
 	}
 	if downgradeGroup != "" {
 		return fmt.Sprintf("用户分组将回退到 %s", downgradeGroup), nil
@@ -756,6 +788,27 @@ func AdminInvalidateUserSubscription(userSubscriptionId int) (string, error) {
 	return "", nil
 }
 
+// invalidateUserSubscriptionTx 立即结束订阅并按需回退用户分组，返回订阅和回退后的分组
+func invalidateUserSubscriptionTx(tx *gorm.DB, userSubscriptionId int, now int64) (*UserSubscription, string, error) {
+	var sub UserSubscription
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("id = ?", userSubscriptionId).First(&sub).Error; err != nil {
+		return nil, "", err
+	}
+	if err := tx.Model(&sub).Updates(map[string]interface{}{
+		"status":     "cancelled",
+		"end_time":   now,
+		"updated_at": now,
+	}).Error; err != nil {
+		return nil, "", err
+	}
+	target, err := downgradeUserGroupForSubscriptionTx(tx, &sub, now)
+	if err != nil {
+		return nil, "", err
+	}
+	return &sub, target, nil
+}
+
 // AdminDeleteUserSubscription hard-deletes a user subscription.
 func AdminDeleteUserSubscription(userSubscriptionId int) (string, error) {
 	if userSubscriptionId <= 0 {
@@ -811,8 +864,10 @@ func ExpireDueSubscriptions(limit int) (int, error) {
 		limit = 200
 	}
 	now := GetDBTimestamp()
+	// 自动续费的订阅在到期后保留宽限期，等待支付平台完成扣款或催缴重试
+	graceEnd := now - SubscriptionRenewalGracePeriod
 	var subs []UserSubscription
-	if err := DB.Where("status = ? AND end_time > 0 AND end_time <= ?", "active", now).
+	if err := DB.Where("status = ? AND end_time > 0 AND end_time <= ? AND (auto_renew = ? OR end_time <= ?)", "active", now, false, graceEnd).
 		Order("end_time asc, id asc").
 		Limit(limit).
 		Find(&subs).Error; err != nil {
@@ -832,7 +887,7 @@ func ExpireDueSubscriptions(limit int) (int, error) {
 		cacheGroup := ""
 		err := DB.Transaction(func(tx *gorm.DB) error {
 			res := tx.Model(&UserSubscription{}).
-				Where("user_id = ? AND status = ? AND end_time > 0 AND end_time <= ?", userId, "active", now).
+				Where("user_id = ? AND status = ? AND end_time > 0 AND end_time <= ? AND (auto_renew = ? OR end_time <= ?)", userId, "active", now, false, graceEnd).
 				Updates(map[string]interface{}{
 					"status":     "expired",
 					"updated_at": common.GetTimestamp(),
@@ -900,6 +955,7 @@ type SubscriptionPreConsumeRecord struct {
 	UserSubscriptionId int    `json:"user_subscription_id" gorm:"index"`
 	PreConsumed        int64  `json:"pre_consumed" gorm:"type:bigint;not null;default:0"`
 	Status             string `json:"status" gorm:"type:varchar(32);index"` // consumed/refunded
+	AllowanceUsages    string `json:"allowance_usages" gorm:"type:text"`    // 占用的模型额度规则用量（JSON）
 	CreatedAt          int64  `json:"created_at" gorm:"bigint"`
 	UpdatedAt          int64  `json:"updated_at" gorm:"bigint;index"`
 }
@@ -953,6 +1009,7 @@ func maybeResetUserSubscriptionWithPlanTx(tx *gorm.DB, sub *UserSubscription, pl
 }
 
 // PreConsumeUserSubscription pre-consumes from any active subscription total quota.
+// Subscriptions whose plan has an exhausted allowance rule matching modelName are skipped.
 func PreConsumeUserSubscription(requestId string, userId int, modelName string, quotaType int, amount int64) (*SubscriptionPreConsumeResult, error) {
 	if userId <= 0 {
 		return nil, errors.New("invalid userId")
@@ -999,6 +1056,7 @@ func PreConsumeUserSubscription(requestId string, userId int, modelName string,
 		if len(subs) == 0 {
 			return errors.New("no active subscription")
 		}
+		allowanceExhausted := false
 		for _, candidate := range subs {
 			sub := candidate
 			plan, err := getSubscriptionPlanByIdTx(tx, sub.PlanId)
@@ -1015,6 +1073,14 @@ func PreConsumeUserSubscription(requestId string, userId int, modelName string,
 					continue
 				}
 			}
+			charges, ok, err := consumeSubscriptionAllowancesTx(tx, &sub, modelName, amount, now)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				allowanceExhausted = true
+				continue
+			}
 			record := &SubscriptionPreConsumeRecord{
 				RequestId:          requestId,
 				UserId:             userId,
@@ -1022,6 +1088,13 @@ func PreConsumeUserSubscription(requestId string, userId int, modelName string,
 				PreConsumed:        amount,
 				Status:             "consumed",
 			}
+			if len(charges) > 0 {
+				raw, err := common.Marshal(charges)
+				if err != nil {
+					return err
+				}
+				record.AllowanceUsages = string(raw)
+			}
 			if err := tx.Create(record).Error; err != nil {
 				var dup SubscriptionPreConsumeRecord
 				if err2 := tx.Where("request_id = ?", requestId).First(&dup).Error; err2 == nil {
@@ -1048,6 +1121,9 @@ func PreConsumeUserSubscription(requestId string, userId int, modelName string,
 			returnValue.AmountUsedAfter = sub.AmountUsed
 			return nil
 		}
+		if allowanceExhausted {
+			return fmt.Errorf("%w for model %s", ErrSubscriptionAllowanceExhausted, modelName)
+		}
 		return fmt.Errorf("subscription quota insufficient, need=%d", amount)
 	})
 	if err != nil {
@@ -1077,6 +1153,9 @@ func RefundSubscriptionPreConsume(requestId string) error {
 		if err := PostConsumeUserSubscriptionDelta(record.UserSubscriptionId, -record.PreConsumed); err != nil {
 			return err
 		}
+		if err := refundSubscriptionAllowancesTx(tx, &record); err != nil {
+			return err
+		}
 		record.Status = "refunded"
 		return tx.Save(&record).Error
 	})
@@ -0,0 +1,384 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Subscription allowance limit types
+const (
+	SubscriptionAllowanceRequests = "requests"
+	SubscriptionAllowanceQuota    = "quota"
+)
+
+// SubscriptionAllowance 套餐内按模型或模型系列限定的额度规则。
+// 匹配的请求除占用套餐总额度外，还需满足该规则在重置周期内的上限。
+type SubscriptionAllowance struct {
+	Id     int    `json:"id"`
+	PlanId int    `json:"plan_id" gorm:"index"`
+	Name   string `json:"name" gorm:"type:varchar(128);not null"`
+	// 模型匹配规则，逗号或换行分隔；以 * 结尾表示前缀匹配（模型系列），如 claude-opus-*
+	ModelPatterns string `json:"model_patterns" gorm:"type:text"`
+	LimitType     string `json:"limit_type" gorm:"type:varchar(16);default:'requests'"`
+	LimitAmount   int64  `json:"limit_amount" gorm:"type:bigint;not null;default:0"`
+
+	ResetPeriod        string `json:"reset_period" gorm:"type:varchar(16);default:'daily'"`
+	ResetCustomSeconds int64  `json:"reset_custom_seconds" gorm:"type:bigint;default:0"`
+
+	Enabled   bool  `json:"enabled"`
+	CreatedAt int64 `json:"created_at" gorm:"bigint"`
+	UpdatedAt int64 `json:"updated_at" gorm:"bigint"`
+}
+
+func (a *SubscriptionAllowance) BeforeCreate(tx *gorm.DB) error {
+	now := common.GetTimestamp()
+	a.CreatedAt = now
+	a.UpdatedAt = now
+	return nil
+}
+
+func (a *SubscriptionAllowance) BeforeUpdate(tx *gorm.DB) error {
+	a.UpdatedAt = common.GetTimestamp()
+	return nil
+}
+
+// SubscriptionAllowanceUsage 用户订阅在当前重置周期内对某条额度规则的使用量
+type SubscriptionAllowanceUsage struct {
+	Id                 int   `json:"id"`
+	UserSubscriptionId int   `json:"user_subscription_id" gorm:"uniqueIndex:idx_sub_allowance_usage,priority:1"`
+	AllowanceId        int   `json:"allowance_id" gorm:"uniqueIndex:idx_sub_allowance_usage,priority:2;index"`
+	Used               int64 `json:"used" gorm:"type:bigint;not null;default:0"`
+	LastResetTime      int64 `json:"last_reset_time" gorm:"type:bigint;default:0"`
+	NextResetTime      int64 `json:"next_reset_time" gorm:"type:bigint;default:0"`
+	UpdatedAt          int64 `json:"updated_at" gorm:"bigint"`
+}
+
+// SubscriptionAllowanceStatus 用户可见的额度规则使用情况
+type SubscriptionAllowanceStatus struct {
+	Allowance     SubscriptionAllowance `json:"allowance"`
+	Used          int64                 `json:"used"`
+	NextResetTime int64                 `json:"next_reset_time"`
+}
+
+// subscriptionAllowanceCharge 记录一次预扣占用的规则用量，用于退款和结算
+type subscriptionAllowanceCharge struct {
+	UsageId int   `json:"usage_id"`
+	Amount  int64 `json:"amount"`
+	Quota   bool  `json:"quota,omitempty"`
+}
+
+func splitAllowancePatterns(patterns string) []string {
+	fields := strings.FieldsFunc(patterns, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	result := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// MatchAllowanceModel 判断模型是否命中规则
+func (a *SubscriptionAllowance) MatchAllowanceModel(modelName string) bool {
+	if modelName == "" {
+		return false
+	}
+	for _, pattern := range splitAllowancePatterns(a.ModelPatterns) {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(modelName, prefix) {
+				return true
+			}
+		} else if pattern == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 校验并规范化规则字段
+func (a *SubscriptionAllowance) Validate() error {
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		return errors.New("规则名称不能为空")
+	}
+	if len(splitAllowancePatterns(a.ModelPatterns)) == 0 {
+		return errors.New("模型匹配规则不能为空")
+	}
+	if a.LimitType == "" {
+		a.LimitType = SubscriptionAllowanceRequests
+	}
+	if a.LimitType != SubscriptionAllowanceRequests && a.LimitType != SubscriptionAllowanceQuota {
+		return errors.New("无效的额度类型")
+	}
+	if a.LimitAmount <= 0 {
+		return errors.New("额度上限必须大于0")
+	}
+	a.ResetPeriod = NormalizeResetPeriod(a.ResetPeriod)
+	if a.ResetPeriod == SubscriptionResetCustom && a.ResetCustomSeconds <= 0 {
+		return errors.New("自定义重置周期需大于0秒")
+	}
+	return nil
+}
+
+func (a *SubscriptionAllowance) resetPlan() *SubscriptionPlan {
+	return &SubscriptionPlan{QuotaResetPeriod: a.ResetPeriod, QuotaResetCustomSeconds: a.ResetCustomSeconds}
+}
+
+func GetSubscriptionAllowancesByPlan(planId int) ([]SubscriptionAllowance, error) {
+	var allowances []SubscriptionAllowance
+	err := DB.Where("plan_id = ?", planId).Order("id asc").Find(&allowances).Error
+	return allowances, err
+}
+
+func GetSubscriptionAllowanceById(id int) (*SubscriptionAllowance, error) {
+	var allowance SubscriptionAllowance
+	if err := DB.Where("id = ?", id).First(&allowance).Error; err != nil {
+		return nil, err
+	}
+	return &allowance, nil
+}
+
+func CreateSubscriptionAllowance(allowance *SubscriptionAllowance) error {
+	if _, err := GetSubscriptionPlanById(allowance.PlanId); err != nil {
+		return errors.New("套餐不存在")
+	}
+	if err := allowance.Validate(); err != nil {
+		return err
+	}
+	return DB.Create(allowance).Error
+}
+
+func UpdateSubscriptionAllowance(allowance *SubscriptionAllowance) error {
+	if err := allowance.Validate(); err != nil {
+		return err
+	}
+	return DB.Model(&SubscriptionAllowance{}).Where("id = ?", allowance.Id).Updates(map[string]interface{}{
+		"name":                 allowance.Name,
+		"model_patterns":       allowance.ModelPatterns,
+		"limit_type":           allowance.LimitType,
+		"limit_amount":         allowance.LimitAmount,
+		"reset_period":         allowance.ResetPeriod,
+		"reset_custom_seconds": allowance.ResetCustomSeconds,
+		"enabled":              allowance.Enabled,
+		"updated_at":           common.GetTimestamp(),
+	}).Error
+}
+
+func DeleteSubscriptionAllowance(id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("allowance_id = ?", id).Delete(&SubscriptionAllowanceUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&SubscriptionAllowance{}).Error
+	})
+}
+
+// advanceAllowanceUsage 根据规则的重置周期推进用量窗口，返回是否发生了变化
+func advanceAllowanceUsage(usage *SubscriptionAllowanceUsage, allowance *SubscriptionAllowance, sub *UserSubscription, now int64) bool {
+	if usage.NextResetTime > now || (usage.NextResetTime == 0 && usage.LastResetTime > 0) {
+		return false
+	}
+	plan := allowance.resetPlan()
+	baseUnix := usage.LastResetTime
+	if baseUnix <= 0 {
+		baseUnix = sub.StartTime
+	}
+	base := time.Unix(baseUnix, 0)
+	next := calcNextResetTime(base, plan, sub.EndTime)
+	reset := false
+	for next > 0 && next <= now {
+		reset = true
+		base = time.Unix(next, 0)
+		next = calcNextResetTime(base, plan, sub.EndTime)
+	}
+	if reset {
+		usage.Used = 0
+	}
+	usage.LastResetTime = base.Unix()
+	usage.NextResetTime = next
+	return true
+}
+
+func lockAllowanceUsageTx(tx *gorm.DB, sub *UserSubscription, allowance *SubscriptionAllowance, now int64) (*SubscriptionAllowanceUsage, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&SubscriptionAllowanceUsage{
+		UserSubscriptionId: sub.Id,
+		AllowanceId:        allowance.Id,
+		UpdatedAt:          now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	var usage SubscriptionAllowanceUsage
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("user_subscription_id = ? AND allowance_id = ?", sub.Id, allowance.Id).
+		First(&usage).Error; err != nil {
+		return nil, err
+	}
+	if advanceAllowanceUsage(&usage, allowance, sub, now) {
+		usage.UpdatedAt = now
+		if err := tx.Save(&usage).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &usage, nil
+}
+
+// consumeSubscriptionAllowancesTx 检查并占用订阅中命中模型的额度规则。
+// ok=false 表示某条规则在当前周期内已用尽，此时不会修改任何用量。
+func consumeSubscriptionAllowancesTx(tx *gorm.DB, sub *UserSubscription, modelName string, amount int64, now int64) (charges []subscriptionAllowanceCharge, ok bool, err error) {
+	var allowances []SubscriptionAllowance
+	if err := tx.Where("plan_id = ? AND enabled = ?", sub.PlanId, true).Find(&allowances).Error; err != nil {
+		return nil, false, err
+	}
+	type pending struct {
+		usage  *SubscriptionAllowanceUsage
+		charge subscriptionAllowanceCharge
+	}
+	var pendings []pending
+	for i := range allowances {
+		allowance := &allowances[i]
+		if !allowance.MatchAllowanceModel(modelName) {
+			continue
+		}
+		usage, err := lockAllowanceUsageTx(tx, sub, allowance, now)
+		if err != nil {
+			return nil, false, err
+		}
+		charge := subscriptionAllowanceCharge{UsageId: usage.Id, Amount: 1}
+		if allowance.LimitType == SubscriptionAllowanceQuota {
+			charge.Amount = amount
+			charge.Quota = true
+		}
+		if usage.Used+charge.Amount > allowance.LimitAmount {
+			return nil, false, nil
+		}
+		pendings = append(pendings, pending{usage: usage, charge: charge})
+	}
+	for _, p := range pendings {
+		if err := tx.Model(&SubscriptionAllowanceUsage{}).Where("id = ?", p.usage.Id).Updates(map[string]interface{}{
+			"used":       gorm.Expr("used + ?", p.charge.Amount),
+			"updated_at": now,
+		}).Error; err != nil {
+			return nil, false, err
+		}
+		charges = append(charges, p.charge)
+	}
+	return charges, true, nil
+}
+
+func parseAllowanceCharges(raw string) []subscriptionAllowanceCharge {
+	if raw == "" {
+		return nil
+	}
+	var charges []subscriptionAllowanceCharge
+	if err := common.UnmarshalJsonStr(raw, &charges); err != nil {
+		return nil
+	}
+	return charges
+}
+
+// refundSubscriptionAllowancesTx 退还预扣占用的规则用量；用量窗口已在预扣后重置的不再退还
+func refundSubscriptionAllowancesTx(tx *gorm.DB, record *SubscriptionPreConsumeRecord) error {
+	for _, charge := range parseAllowanceCharges(record.AllowanceUsages) {
+		if charge.Amount <= 0 {
+			continue
+		}
+		if err := tx.Model(&SubscriptionAllowanceUsage{}).
+			Where("id = ? AND last_reset_time <= ?", charge.UsageId, record.CreatedAt).
+			Updates(map[string]interface{}{
+				"used":       gorm.Expr("CASE WHEN used > ? THEN used - ? ELSE 0 END", charge.Amount, charge.Amount),
+				"updated_at": common.GetTimestamp(),
+			}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SettleSubscriptionAllowances 按实际消耗调整额度类规则的用量（正数补扣，负数退还）
+func SettleSubscriptionAllowances(requestId string, delta int64) {
+	if requestId == "" || delta == 0 {
+		return
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var record SubscriptionPreConsumeRecord
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("request_id = ?", requestId).First(&record).Error; err != nil {
+			return err
+		}
+		charges := parseAllowanceCharges(record.AllowanceUsages)
+		changed := false
+		for i := range charges {
+			if !charges[i].Quota {
+				continue
+			}
+			adjust := delta
+			if charges[i].Amount+adjust < 0 {
+				adjust = -charges[i].Amount
+			}
+			if err := tx.Model(&SubscriptionAllowanceUsage{}).
+				Where("id = ? AND last_reset_time <= ?", charges[i].UsageId, record.CreatedAt).
+				Updates(map[string]interface{}{
+					"used":       gorm.Expr("CASE WHEN used + ? > 0 THEN used + ? ELSE 0 END", adjust, adjust),
+					"updated_at": common.GetTimestamp(),
+				}).Error; err != nil {
+				return err
+			}
+			charges[i].Amount += adjust
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		raw, err := common.Marshal(charges)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&record).Update("allowance_usages", string(raw)).Error
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		common.SysLog(fmt.Sprintf("failed to settle subscription allowances (request=%s): %s", requestId, err.Error()))
+	}
+}
+
+// GetSubscriptionAllowanceStatuses 获取订阅各条额度规则在当前周期内的用量
+func GetSubscriptionAllowanceStatuses(sub *UserSubscription) ([]SubscriptionAllowanceStatus, error) {
+	allowances, err := GetSubscriptionAllowancesByPlan(sub.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]SubscriptionAllowanceStatus, 0, len(allowances))
+	if len(allowances) == 0 {
+		return result, nil
+	}
+	var usages []SubscriptionAllowanceUsage
+	if err := DB.Where("user_subscription_id = ?", sub.Id).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	usageMap := make(map[int]SubscriptionAllowanceUsage, len(usages))
+	for _, usage := range usages {
+		usageMap[usage.AllowanceId] = usage
+	}
+	now := common.GetTimestamp()
+	for i := range allowances {
+		if !allowances[i].Enabled {
+			continue
+		}
+		usage := usageMap[allowances[i].Id]
+		// 只读展示：按当前时间推算窗口，不写回数据库
+		advanceAllowanceUsage(&usage, &allowances[i], sub, now)
+		result = append(result, SubscriptionAllowanceStatus{
+			Allowance:     allowances[i],
+			Used:          usage.Used,
+			NextResetTime: usage.NextResetTime,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupSubscriptionTables(t *testing.T) {
+	t.Helper()
+	require.NoError(t, ensureSubscriptionPlanTableSQLite())
+	require.NoError(t, DB.AutoMigrate(&UserSubscription{}, &SubscriptionPreConsumeRecord{},
+		&SubscriptionAllowance{}, &SubscriptionAllowanceUsage{}, &SubscriptionOrder{}, &CreditGrant{},
+		&PromoCodeUsage{}, &AffiliateCommission{}))
+	t.Cleanup(func() {
+		for _, table := range []string{"subscription_plans", "user_subscriptions", "subscription_pre_consume_records",
+			"subscription_allowances", "subscription_allowance_usages", "subscription_orders", "credit_grants",
+			"promo_code_usages", "affiliate_commissions"} {
+			DB.Exec("DELETE FROM " + table)
+		}
+	})
+	truncateTables(t)
+}
+
+func createTestPlan(t *testing.T, id int, price float64, total int64) *SubscriptionPlan {
+	t.Helper()
+	plan := &SubscriptionPlan{
+		Id:            id,
+		Title:         "plan",
+		PriceAmount:   price,
+		Currency:      "USD",
+		DurationUnit:  SubscriptionDurationMonth,
+		DurationValue: 1,
+		Enabled:       true,
+		TotalAmount:   total,
+	}
+	require.NoError(t, DB.Create(plan).Error)
+	InvalidateSubscriptionPlanCache(id)
+	return plan
+}
+
+func TestMatchAllowanceModel(t *testing.T) {
+	allowance := &SubscriptionAllowance{ModelPatterns: "claude-opus-*\ngpt-4o"}
+	assert.True(t, allowance.MatchAllowanceModel("claude-opus-4"))
+	assert.True(t, allowance.MatchAllowanceModel("gpt-4o"))
+	assert.False(t, allowance.MatchAllowanceModel("gpt-4o-mini"))
+	assert.False(t, allowance.MatchAllowanceModel("claude-sonnet-4"))
+}
+
+func TestPreConsumeUserSubscription_Allowance(t *testing.T) {
+	setupSubscriptionTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "sub_user", AffCode: "sa01"}).Error)
+	plan := createTestPlan(t, 1, 10, 0)
+	require.NoError(t, CreateSubscriptionAllowance(&SubscriptionAllowance{
+		PlanId:        plan.Id,
+		Name:          "premium",
+		ModelPatterns: "claude-opus-*",
+		LimitType:     SubscriptionAllowanceRequests,
+		LimitAmount:   2,
+		ResetPeriod:   SubscriptionResetDaily,
+		Enabled:       true,
+	}))
+	_, err := CreateUserSubscriptionFromPlanTx(DB, 1, plan, "order")
+	require.NoError(t, err)
+
+	_, err = PreConsumeUserSubscription("req-1", 1, "claude-opus-4", 0, 100)
+	require.NoError(t, err)
+	_, err = PreConsumeUserSubscription("req-2", 1, "claude-opus-4", 0, 100)
+	require.NoError(t, err)
+	_, err = PreConsumeUserSubscription("req-3", 1, "claude-opus-4", 0, 100)
+	assert.ErrorIs(t, err, ErrSubscriptionAllowanceExhausted)
+
+	// 未命中规则的模型只受总额度限制
+	_, err = PreConsumeUserSubscription("req-4", 1, "gpt-4o", 0, 100)
+	require.NoError(t, err)
+
+	var record SubscriptionPreConsumeRecord
+	require.NoError(t, DB.Where("request_id = ?", "req-1").First(&record).Error)
+	require.NoError(t, DB.Transaction(func(tx *gorm.DB) error {
+		return refundSubscriptionAllowancesTx(tx, &record)
+	}))
+	_, err = PreConsumeUserSubscription("req-5", 1, "claude-opus-4", 0, 100)
+	require.NoError(t, err)
+
+	var usage SubscriptionAllowanceUsage
+	require.NoError(t, DB.First(&usage).Error)
+	assert.Equal(t, int64(2), usage.Used)
+}
+
+func TestSettleSubscriptionAllowances_Quota(t *testing.T) {
+	setupSubscriptionTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "sub_user", AffCode: "sa02"}).Error)
+	plan := createTestPlan(t, 2, 10, 0)
+	require.NoError(t, CreateSubscriptionAllowance(&SubscriptionAllowance{
+		PlanId:        plan.Id,
+		Name:          "premium quota",
+		ModelPatterns: "gpt-4o",
+		LimitType:     SubscriptionAllowanceQuota,
+		LimitAmount:   1000,
+		ResetPeriod:   SubscriptionResetDaily,
+		Enabled:       true,
+	}))
+	_, err := CreateUserSubscriptionFromPlanTx(DB, 1, plan, "order")
+	require.NoError(t, err)
+
+	_, err = PreConsumeUserSubscription("req-q1", 1, "gpt-4o", 0, 600)
+	require.NoError(t, err)
+	SettleSubscriptionAllowances("req-q1", -400)
+	_, err = PreConsumeUserSubscription("req-q2", 1, "gpt-4o", 0, 700)
+	require.NoError(t, err)
+	_, err = PreConsumeUserSubscription("req-q3", 1, "gpt-4o", 0, 200)
+	assert.ErrorIs(t, err, ErrSubscriptionAllowanceExhausted)
+}
+
+func TestQuoteSubscriptionChange(t *testing.T) {
+	setupSubscriptionTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "sub_user", AffCode: "sa03"}).Error)
+	basic := createTestPlan(t, 3, 10, 0)
+	pro := createTestPlan(t, 4, 30, 0)
+	sub, err := CreateUserSubscriptionFromPlanTx(DB, 1, basic, "order")
+	require.NoError(t, err)
+	require.NoError(t, DB.Create(&SubscriptionOrder{UserId: 1, PlanId: basic.Id, Money: 10, TradeNo: "SUB1NOchg",
+		Status: common.TopUpStatusSuccess, CompleteTime: 1, UserSubscriptionId: sub.Id}).Error)
+
+	// 剩余一半时间
+	now := GetDBTimestamp()
+	require.NoError(t, DB.Model(sub).Updates(map[string]interface{}{
+		"start_time": now - 1000,
+		"end_time":   now + 1000,
+	}).Error)
+
+	quote, err := QuoteSubscriptionChange(1, sub.Id, pro.Id)
+	require.NoError(t, err)
+	assert.InDelta(t, 5, quote.Credit, 0.05)
+	assert.InDelta(t, 25, quote.AmountDue, 0.05)
+	assert.Zero(t, quote.RefundQuota)
+
+	// 降级：剩余价值超出新套餐价格的部分退回钱包
+	downgrade := createTestPlan(t, 5, 2, 0)
+	quote, err = QuoteSubscriptionChange(1, sub.Id, downgrade.Id)
+	require.NoError(t, err)
+	assert.Zero(t, quote.AmountDue)
+	assert.InDelta(t, 3*common.QuotaPerUnit, float64(quote.RefundQuota), common.QuotaPerUnit*0.05)
+
+	// 按订单实付金额（含优惠）折算，而不是套餐标价
+	require.NoError(t, DB.Model(&SubscriptionOrder{}).Where("trade_no = ?", "SUB1NOchg").Update("money", 6).Error)
+	quote, err = QuoteSubscriptionChange(1, sub.Id, pro.Id)
+	require.NoError(t, err)
+	assert.InDelta(t, 3, quote.Credit, 0.05)
+
+	// 管理员赠送、兑换码兑换的订阅没有实际支付，不参与折算
+	for _, source := range []string{"admin", "redemption"} {
+		require.NoError(t, DB.Model(sub).Update("source", source).Error)
+		quote, err = QuoteSubscriptionChange(1, sub.Id, pro.Id)
+		require.NoError(t, err)
+		assert.Zero(t, quote.Credit)
+		assert.InDelta(t, 30, quote.AmountDue, 0.001)
+	}
+
+	// 目标套餐已达购买上限时不能变更
+	require.NoError(t, DB.Model(&SubscriptionPlan{}).Where("id = ?", pro.Id).Update("max_purchase_per_user", 1).Error)
+	InvalidateSubscriptionPlanCache(pro.Id)
+	_, err = CreateUserSubscriptionFromPlanTx(DB, 1, pro, "admin")
+	require.NoError(t, err)
+	_, err = QuoteSubscriptionChange(1, sub.Id, pro.Id)
+	assert.ErrorContains(t, err, "购买上限")
+
+	_, err = QuoteSubscriptionChange(1, sub.Id, basic.Id)
+	assert.Error(t, err)
+}
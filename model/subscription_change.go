@@ -0,0 +1,228 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"gorm.io/gorm"
+)
+
+const SubscriptionSourceChange = "change"
+
+var ErrSubscriptionChangeInvalid = errors.New("当前订阅无法变更套餐")
+
+// SubscriptionChangeQuote 套餐变更报价：原订阅按实付金额的剩余比例折算抵扣，新套餐从变更时刻起重新计算周期
+type SubscriptionChangeQuote struct {
+	FromSubscriptionId int     `json:"from_subscription_id"`
+	FromPlanId         int     `json:"from_plan_id"`
+	ToPlanId           int     `json:"to_plan_id"`
+	RemainingRatio     float64 `json:"remaining_ratio"`
+	Credit             float64 `json:"credit"`       // 原订阅剩余价值
+	NewPrice           float64 `json:"new_price"`    // 新套餐价格
+	AmountDue          float64 `json:"amount_due"`   // 需补付金额
+	RefundQuota        int64   `json:"refund_quota"` // 剩余价值超过新套餐价格时退回钱包的额度
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// subscriptionRemainingRatio 按剩余时间计算订阅剩余比例；
+// 不重置额度的套餐同时考虑剩余额度，取两者较小值，避免用完额度后降级套取退款
+func subscriptionRemainingRatio(sub *UserSubscription, plan *SubscriptionPlan, now int64) float64 {
+	if sub.EndTime <= now || sub.EndTime <= sub.StartTime {
+		return 0
+	}
+	ratio := float64(sub.EndTime-now) / float64(sub.EndTime-sub.StartTime)
+	if sub.AmountTotal > 0 && NormalizeResetPeriod(plan.QuotaResetPeriod) == SubscriptionResetNever {
+		quotaRatio := float64(sub.AmountTotal-sub.AmountUsed) / float64(sub.AmountTotal)
+		if quotaRatio < ratio {
+			ratio = quotaRatio
+		}
+	}
+	if ratio < 0 {
+		return 0
+	}
+	if ratio > 1 {
+		return 1
+	}
+	return ratio
+}
+
+// subscriptionPaidAmount 返回订阅当前周期的实际支付金额，用于折算剩余价值：
+// 购买的订阅取关联的最近一笔成功订单（含续费、已扣除优惠）；变更得到的订阅由原订阅抵扣加补付凑足目标套餐价格；
+// 管理员赠送、兑换码等来源没有实际支付，按 0 计算
+func subscriptionPaidAmount(sub *UserSubscription, plan *SubscriptionPlan) (float64, error) {
+	switch sub.Source {
+	case "order":
+		var order SubscriptionOrder
+		if err := DB.Where("user_subscription_id = ? AND status = ?", sub.Id, common.TopUpStatusSuccess).
+			Order("complete_time desc, id desc").Limit(1).Find(&order).Error; err != nil {
+			return 0, err
+		}
+		return order.Money, nil
+	case SubscriptionSourceChange:
+		return plan.PriceAmount, nil
+	default:
+		return 0, nil
+	}
+}
+
+func quoteSubscriptionChange(sub *UserSubscription, toPlan *SubscriptionPlan, now int64) (*SubscriptionChangeQuote, error) {
+	if sub.Status != "active" || sub.EndTime <= now {
+		return nil, ErrSubscriptionChangeInvalid
+	}
+	if sub.PlanId == toPlan.Id {
+		return nil, errors.New("不能变更为当前套餐")
+	}
+	// 原订阅仍会被支付平台自动扣款，需先取消自动续费
+	if sub.AutoRenew && !sub.CancelAtPeriodEnd {
+		return nil, errors.New("请先取消当前订阅的自动续费")
+	}
+	// 变更会创建目标套餐的新订阅，需在付款前检查购买上限，避免支付完成后无法开通
+	if toPlan.MaxPurchasePerUser > 0 {
+		count, err := CountUserSubscriptionsByPlan(sub.UserId, toPlan.Id)
+		if err != nil {
+			return nil, err
+		}
+		if count >= int64(toPlan.MaxPurchasePerUser) {
+			return nil, errors.New("已达到该套餐购买上限")
+		}
+	}
+	fromPlan, err := getSubscriptionPlanByIdTx(nil, sub.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	paid, err := subscriptionPaidAmount(sub, fromPlan)
+	if err != nil {
+		return nil, err
+	}
+	quote := &SubscriptionChangeQuote{
+		FromSubscriptionId: sub.Id,
+		FromPlanId:         sub.PlanId,
+		ToPlanId:           toPlan.Id,
+		RemainingRatio:     subscriptionRemainingRatio(sub, fromPlan, now),
+		NewPrice:           toPlan.PriceAmount,
+	}
+	quote.Credit = roundMoney(paid * quote.RemainingRatio)
+	due := roundMoney(quote.NewPrice - quote.Credit)
+	if due > 0 {
+		quote.AmountDue = due
+	} else {
+		quote.RefundQuota = int64(-due * common.QuotaPerUnit)
+	}
+	return quote, nil
+}
+
+// QuoteSubscriptionChange 计算用户将订阅变更为目标套餐所需补付或退回的金额
+func QuoteSubscriptionChange(userId int, subscriptionId int, toPlanId int) (*SubscriptionChangeQuote, error) {
+	var sub UserSubscription
+	if err := DB.Where("id = ? AND user_id = ?", subscriptionId, userId).First(&sub).Error; err != nil {
+		return nil, errors.New("订阅不存在")
+	}
+	toPlan, err := GetSubscriptionPlanById(toPlanId)
+	if err != nil {
+		return nil, err
+	}
+	if !toPlan.Enabled {
+		return nil, errors.New("套餐未启用")
+	}
+	return quoteSubscriptionChange(&sub, toPlan, GetDBTimestamp())
+}
+
+// applySubscriptionChangeTx 结束原订阅（按原订阅规则回退分组）并从目标套餐创建新订阅。
+// 返回需要刷新缓存的用户分组（为空表示无变化）。
+func applySubscriptionChangeTx(tx *gorm.DB, userId int, fromSubscriptionId int, toPlan *SubscriptionPlan, now int64) (*UserSubscription, string, error) {
+	var sub UserSubscription
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("id = ? AND user_id = ?", fromSubscriptionId, userId).First(&sub).Error; err != nil {
+		return nil, "", ErrSubscriptionChangeInvalid
+	}
+	if sub.Status != "active" || sub.EndTime <= now {
+		return nil, "", ErrSubscriptionChangeInvalid
+	}
+	if err := tx.Model(&sub).Updates(map[string]interface{}{
+		"status":     "cancelled",
+		"end_time":   now,
+		"updated_at": common.GetTimestamp(),
+	}).Error; err != nil {
+		return nil, "", err
+	}
+	cacheGroup, err := downgradeUserGroupForSubscriptionTx(tx, &sub, now)
+	if err != nil {
+		return nil, "", err
+	}
+	newSub, err := CreateUserSubscriptionFromPlanTx(tx, userId, toPlan, SubscriptionSourceChange)
+	if err != nil {
+		return nil, "", err
+	}
+	if upgradeGroup := strings.TrimSpace(toPlan.UpgradeGroup); upgradeGroup != "" {
+		cacheGroup = upgradeGroup
+	}
+	return newSub, cacheGroup, nil
+}
+
+// ChangeUserSubscriptionWithBalance 立即变更套餐：补付金额从钱包余额扣除，剩余价值超出部分退回钱包
+func ChangeUserSubscriptionWithBalance(userId int, subscriptionId int, toPlanId int) (*SubscriptionChangeQuote, error) {
+	quote, err := QuoteSubscriptionChange(userId, subscriptionId, toPlanId)
+	if err != nil {
+		return nil, err
+	}
+	toPlan, err := GetSubscriptionPlanById(toPlanId)
+	if err != nil {
+		return nil, err
+	}
+	dueQuota := int64(math.Ceil(quote.AmountDue * common.QuotaPerUnit))
+	var cacheGroup string
+	now := GetDBTimestamp()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if dueQuota > 0 {
+			result := tx.Model(&User{}).Where("id = ? AND quota >= ?", userId, dueQuota).
+				Update("quota", gorm.Expr("quota - ?", dueQuota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errors.New("余额不足，无法补付套餐差价")
+			}
+			if _, err := ConsumeCreditGrantsTx(tx, userId, dueQuota); err != nil {
+				return err
+			}
+		}
+		_, group, err := applySubscriptionChangeTx(tx, userId, subscriptionId, toPlan, now)
+		if err != nil {
+			return err
+		}
+		cacheGroup = group
+		if quote.RefundQuota > 0 {
+			if err := tx.Model(&User{}).Where("id = ?", userId).
+				Update("quota", gorm.Expr("quota + ?", quote.RefundQuota)).Error; err != nil {
+				return err
+			}
+			return RecordCreditGrantTx(tx, userId, CreditSourceSubscription, fmt.Sprintf("change:%d", subscriptionId), quote.RefundQuota)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dueQuota > 0 {
+		_ = cacheDecrUserQuota(userId, dueQuota)
+		if _, err := ConsumeCreditGrants(userId, dueQuota); err != nil {
+			common.SysLog(fmt.Sprintf("failed to consume credit grants for subscription change (userId=%d): %s", userId, err.Error()))
+		}
+	}
+	if quote.RefundQuota > 0 {
+		_ = cacheIncrUserQuota(userId, quote.RefundQuota)
+	}
+	if cacheGroup != "" {
+		_ = UpdateUserGroupCache(userId, cacheGroup)
+	}
+	RecordLog(userId, LogTypeTopup, fmt.Sprintf("套餐变更为 %s，原订阅抵扣 %.2f，余额补付 %s，退回额度 %s",
+		toPlan.Title, quote.Credit, logger.LogQuota(int(dueQuota)), logger.LogQuota(int(quote.RefundQuota))))
+	return quote, nil
+}
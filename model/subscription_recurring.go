@@ -0,0 +1,264 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// Recurring subscription providers
+const (
+	SubscriptionProviderStripe = "stripe"
+	SubscriptionProviderCreem  = "creem"
+)
+
+const SubscriptionRenewalStatusPastDue = "past_due"
+
+// SubscriptionRenewalGracePeriod 自动续费订阅到期后的宽限期（秒），期间不会被过期任务回收分组
+var SubscriptionRenewalGracePeriod int64 = 3 * 24 * 3600
+
+var ErrRecurringSubscriptionNotFound = errors.New("recurring subscription not found")
+
+// BindSubscriptionOrderRecurring 将支付平台的订阅对象绑定到订单创建的用户订阅，开启自动续费
+func BindSubscriptionOrderRecurring(tradeNo string, provider string, providerSubscriptionId string) error {
+	providerSubscriptionId = strings.TrimSpace(providerSubscriptionId)
+	if tradeNo == "" || providerSubscriptionId == "" {
+		return nil
+	}
+	order := GetSubscriptionOrderByTradeNo(tradeNo)
+	if order == nil {
+		return ErrSubscriptionOrderNotFound
+	}
+	if order.UserSubscriptionId <= 0 {
+		return errors.New("subscription order has no user subscription")
+	}
+	return DB.Model(&UserSubscription{}).Where("id = ?", order.UserSubscriptionId).Updates(map[string]interface{}{
+		"payment_provider":         provider,
+		"provider_subscription_id": providerSubscriptionId,
+		"auto_renew":               true,
+		"cancel_at_period_end":     false,
+		"updated_at":               common.GetTimestamp(),
+	}).Error
+}
+
+func getRecurringSubscriptionTx(tx *gorm.DB, provider string, providerSubscriptionId string, forUpdate bool) (*UserSubscription, error) {
+	if providerSubscriptionId == "" {
+		return nil, ErrRecurringSubscriptionNotFound
+	}
+	query := tx
+	if forUpdate {
+		query = query.Set("gorm:query_option", "FOR UPDATE")
+	}
+	var sub UserSubscription
+	if err := query.Where("payment_provider = ? AND provider_subscription_id = ?", provider, providerSubscriptionId).
+		Order("id desc").First(&sub).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRecurringSubscriptionNotFound
+		}
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// GetRecurringSubscription 按支付平台订阅ID查找用户订阅
+func GetRecurringSubscription(provider string, providerSubscriptionId string) (*UserSubscription, error) {
+	return getRecurringSubscriptionTx(DB, provider, providerSubscriptionId, false)
+}
+
+// RenewRecurringSubscription 处理支付平台的续费成功通知（幂等，以 paymentRef 作为续费订单号）。
+// periodEnd 为平台返回的本期结束时间；若该时间已被当前订阅覆盖（如首期扣款），则不重复续期。
+func RenewRecurringSubscription(provider string, providerSubscriptionId string, paymentRef string, money float64, periodEnd int64, payload string) error {
+	if paymentRef == "" {
+		return errors.New("payment reference is empty")
+	}
+	var (
+		renewed      bool
+		userId       int
+		planTitle    string
+		cacheGroup   string
+		newEndTime   int64
+		renewedMoney float64
+	)
+	now := GetDBTimestamp()
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		sub, err := getRecurringSubscriptionTx(tx, provider, providerSubscriptionId, true)
+		if err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&SubscriptionOrder{}).Where("trade_no = ?", paymentRef).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		if periodEnd > 0 && periodEnd <= sub.EndTime+86400 {
+			return nil
+		}
+		plan, err := getSubscriptionPlanByIdTx(tx, sub.PlanId)
+		if err != nil {
+			return err
+		}
+		base := sub.EndTime
+		if base < now {
+			base = now
+		}
+		endTime := periodEnd
+		if endTime <= base {
+			if endTime, err = calcPlanEndTime(time.Unix(base, 0), plan); err != nil {
+				return err
+			}
+		}
+		resetBase := time.Unix(now, 0)
+		nextReset := calcNextResetTime(resetBase, plan, endTime)
+		lastReset := int64(0)
+		if nextReset > 0 {
+			lastReset = now
+		}
+		updates := map[string]interface{}{
+			"status":            "active",
+			"end_time":          endTime,
+			"amount_total":      plan.TotalAmount,
+			"amount_used":       0,
+			"last_reset_time":   lastReset,
+			"next_reset_time":   nextReset,
+			"renewal_status":    "",
+			"renewal_failed_at": 0,
+			"updated_at":        common.GetTimestamp(),
+		}
+		// 宽限期后已过期的订阅恢复时，重新升级分组
+		if sub.Status != "active" {
+			if upgradeGroup := strings.TrimSpace(sub.UpgradeGroup); upgradeGroup != "" {
+				currentGroup, err := getUserGroupByIdTx(tx, sub.UserId)
+				if err != nil {
+					return err
+				}
+				if currentGroup != upgradeGroup {
+					if err := tx.Model(&User{}).Where("id = ?", sub.UserId).Update("group", upgradeGroup).Error; err != nil {
+						return err
+					}
+					updates["prev_user_group"] = currentGroup
+					cacheGroup = upgradeGroup
+				}
+			}
+		}
+		if err := tx.Model(&UserSubscription{}).Where("id = ?", sub.Id).Updates(updates).Error; err != nil {
+			return err
+		}
+		order := &SubscriptionOrder{
+			UserId:             sub.UserId,
+			PlanId:             sub.PlanId,
+			Money:              money,
+			TradeNo:            paymentRef,
+			PaymentMethod:      provider,
+			Status:             common.TopUpStatusSuccess,
+			CreateTime:         common.GetTimestamp(),
+			CompleteTime:       common.GetTimestamp(),
+			ProviderPayload:    payload,
+			UserSubscriptionId: sub.Id,
+		}
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+		if err := upsertSubscriptionTopUpTx(tx, order); err != nil {
+			return err
+		}
+		renewed = true
+		userId = sub.UserId
+		planTitle = plan.Title
+		newEndTime = endTime
+		renewedMoney = money
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if cacheGroup != "" {
+		_ = UpdateUserGroupCache(userId, cacheGroup)
+	}
+	if renewed {
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("订阅自动续费成功，套餐: %s，支付金额: %.2f，支付方式: %s，有效期至: %s",
+			planTitle, renewedMoney, provider, time.Unix(newEndTime, 0).Format("2006-01-02 15:04:05")))
+	}
+	return nil
+}
+
+// MarkRecurringSubscriptionPastDue 记录续费扣款失败，返回订阅以便通知用户；重复通知时 firstFailure 为 false
+func MarkRecurringSubscriptionPastDue(provider string, providerSubscriptionId string) (sub *UserSubscription, firstFailure bool, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		sub, err = getRecurringSubscriptionTx(tx, provider, providerSubscriptionId, true)
+		if err != nil {
+			return err
+		}
+		if sub.RenewalStatus == SubscriptionRenewalStatusPastDue {
+			return nil
+		}
+		firstFailure = true
+		sub.RenewalStatus = SubscriptionRenewalStatusPastDue
+		sub.RenewalFailedAt = common.GetTimestamp()
+		return tx.Model(&UserSubscription{}).Where("id = ?", sub.Id).Updates(map[string]interface{}{
+			"renewal_status":    sub.RenewalStatus,
+			"renewal_failed_at": sub.RenewalFailedAt,
+			"updated_at":        common.GetTimestamp(),
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if firstFailure {
+		RecordLog(sub.UserId, LogTypeSystem, fmt.Sprintf("订阅自动续费扣款失败（订阅ID: %d），支付平台将自动重试，请检查支付方式", sub.Id))
+	}
+	return sub, firstFailure, nil
+}
+
+// SetRecurringSubscriptionCancelAtPeriodEnd 同步“到期后取消”状态
+func SetRecurringSubscriptionCancelAtPeriodEnd(provider string, providerSubscriptionId string, cancel bool) error {
+	sub, err := GetRecurringSubscription(provider, providerSubscriptionId)
+	if err != nil {
+		return err
+	}
+	if sub.CancelAtPeriodEnd == cancel {
+		return nil
+	}
+	return DB.Model(&UserSubscription{}).Where("id = ?", sub.Id).Updates(map[string]interface{}{
+		"cancel_at_period_end": cancel,
+		"updated_at":           common.GetTimestamp(),
+	}).Error
+}
+
+// EndRecurringSubscription 支付平台订阅已终止（取消生效或催缴失败），停止自动续费；
+// 已支付的当期仍然有效，到期后由过期任务正常回收
+func EndRecurringSubscription(provider string, providerSubscriptionId string) error {
+	sub, err := GetRecurringSubscription(provider, providerSubscriptionId)
+	if err != nil {
+		return err
+	}
+	if !sub.AutoRenew {
+		return nil
+	}
+	if err := DB.Model(&UserSubscription{}).Where("id = ?", sub.Id).Updates(map[string]interface{}{
+		"auto_renew":           false,
+		"cancel_at_period_end": false,
+		"updated_at":           common.GetTimestamp(),
+	}).Error; err != nil {
+		return err
+	}
+	RecordLog(sub.UserId, LogTypeSystem, fmt.Sprintf("订阅自动续费已终止（订阅ID: %d）", sub.Id))
+	return nil
+}
+
+// GetUserRecurringSubscription 获取用户自己的自动续费订阅
+func GetUserRecurringSubscription(userId int, subscriptionId int) (*UserSubscription, error) {
+	var sub UserSubscription
+	if err := DB.Where("id = ? AND user_id = ?", subscriptionId, userId).First(&sub).Error; err != nil {
+		return nil, errors.New("订阅不存在")
+	}
+	if !sub.AutoRenew || sub.ProviderSubscriptionId == "" {
+		return nil, errors.New("该订阅未开启自动续费")
+	}
+	return &sub, nil
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRecurringSubscription(t *testing.T) (*SubscriptionPlan, *UserSubscription) {
+	t.Helper()
+	setupSubscriptionTables(t)
+	require.NoError(t, DB.AutoMigrate(&TopUp{}))
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM top_ups")
+	})
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "sub_user", AffCode: "sr01", Group: "default"}).Error)
+	plan := createTestPlan(t, 1, 10, 1000)
+	sub, err := CreateUserSubscriptionFromPlanTx(DB, 1, plan, "order")
+	require.NoError(t, err)
+	require.NoError(t, (&SubscriptionOrder{
+		UserId:             1,
+		PlanId:             plan.Id,
+		Money:              10,
+		TradeNo:            "sub_ref_1",
+		PaymentMethod:      "stripe",
+		Status:             common.TopUpStatusSuccess,
+		UserSubscriptionId: sub.Id,
+	}).Insert())
+	require.NoError(t, BindSubscriptionOrderRecurring("sub_ref_1", SubscriptionProviderStripe, "sub_stripe_1"))
+	require.NoError(t, DB.First(sub, sub.Id).Error)
+	return plan, sub
+}
+
+func TestRenewRecurringSubscription(t *testing.T) {
+	_, sub := setupRecurringSubscription(t)
+	assert.True(t, sub.AutoRenew)
+	assert.Equal(t, "sub_stripe_1", sub.ProviderSubscriptionId)
+
+	// 首期扣款的周期已被当前订阅覆盖，不重复续期
+	require.NoError(t, RenewRecurringSubscription(SubscriptionProviderStripe, "sub_stripe_1", "in_1", 10, sub.EndTime, ""))
+	var count int64
+	require.NoError(t, DB.Model(&SubscriptionOrder{}).Where("trade_no = ?", "in_1").Count(&count).Error)
+	assert.Zero(t, count)
+
+	require.NoError(t, DB.Model(sub).Updates(map[string]interface{}{"amount_used": 800, "renewal_status": SubscriptionRenewalStatusPastDue}).Error)
+	periodEnd := sub.EndTime + 30*86400
+	require.NoError(t, RenewRecurringSubscription(SubscriptionProviderStripe, "sub_stripe_1", "in_2", 10, periodEnd, ""))
+	// 重复通知幂等
+	require.NoError(t, RenewRecurringSubscription(SubscriptionProviderStripe, "sub_stripe_1", "in_2", 10, periodEnd+30*86400, ""))
+
+	var renewed UserSubscription
+	require.NoError(t, DB.First(&renewed, sub.Id).Error)
+	assert.Equal(t, periodEnd, renewed.EndTime)
+	assert.Zero(t, renewed.AmountUsed)
+	assert.Empty(t, renewed.RenewalStatus)
+
+	order := GetSubscriptionOrderByTradeNo("in_2")
+	require.NotNil(t, order)
+	assert.Equal(t, sub.Id, order.UserSubscriptionId)
+	assert.Equal(t, common.TopUpStatusSuccess, order.Status)
+
+	err := RenewRecurringSubscription(SubscriptionProviderStripe, "sub_unknown", "in_3", 10, periodEnd, "")
+	assert.ErrorIs(t, err, ErrRecurringSubscriptionNotFound)
+}
+
+func TestRecurringSubscriptionDunning(t *testing.T) {
+	_, sub := setupRecurringSubscription(t)
+
+	got, first, err := MarkRecurringSubscriptionPastDue(SubscriptionProviderStripe, "sub_stripe_1")
+	require.NoError(t, err)
+	assert.True(t, first)
+	assert.Equal(t, sub.Id, got.Id)
+	_, first, err = MarkRecurringSubscriptionPastDue(SubscriptionProviderStripe, "sub_stripe_1")
+	require.NoError(t, err)
+	assert.False(t, first)
+
+	// 宽限期内不过期，超过宽限期后过期
+	now := GetDBTimestamp()
+	require.NoError(t, DB.Model(sub).Update("end_time", now-60).Error)
+	expired, err := ExpireDueSubscriptions(10)
+	require.NoError(t, err)
+	assert.Zero(t, expired)
+
+	require.NoError(t, DB.Model(sub).Update("end_time", now-SubscriptionRenewalGracePeriod-60).Error)
+	expired, err = ExpireDueSubscriptions(10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	// 催缴重试成功后恢复订阅
+	require.NoError(t, RenewRecurringSubscription(SubscriptionProviderStripe, "sub_stripe_1", "in_retry", 10, now+30*86400, ""))
+	var renewed UserSubscription
+	require.NoError(t, DB.First(&renewed, sub.Id).Error)
+	assert.Equal(t, "active", renewed.Status)
+	assert.Equal(t, now+30*86400, renewed.EndTime)
+
+	require.NoError(t, SetRecurringSubscriptionCancelAtPeriodEnd(SubscriptionProviderStripe, "sub_stripe_1", true))
+	require.NoError(t, EndRecurringSubscription(SubscriptionProviderStripe, "sub_stripe_1"))
+	require.NoError(t, DB.First(&renewed, sub.Id).Error)
+	assert.False(t, renewed.AutoRenew)
+	assert.False(t, renewed.CancelAtPeriodEnd)
+	_, err = GetUserRecurringSubscription(1, sub.Id)
+	assert.Error(t, err)
+}
@@ -12,23 +12,32 @@ import (
 )
 
 type Token struct {
-	Id                 int            `json:"id"`
-	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status             int            `json:"status" gorm:"default:1"`
-	Name               string         `json:"name" gorm:"index" `
-	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
-	AccessedTime       int64          `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime        int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota        int            `json:"remain_quota" gorm:"default:0"`
-	UnlimitedQuota     bool           `json:"unlimited_quota"`
-	ModelLimitsEnabled bool           `json:"model_limits_enabled"`
-	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
-	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
-	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
-	Group              string         `json:"group" gorm:"default:''"`
-	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
-	DeletedAt          gorm.DeletedAt `gorm:"index"`
+	Id                     int            `json:"id"`
+	UserId                 int            `json:"user_id" gorm:"index"`
+	Key                    string         `json:"-" gorm:"type:char(48);uniqueIndex"` // 令牌明文的哈希，见 HashTokenKey
+	KeyPrefix              string         `json:"key_prefix" gorm:"type:varchar(16);default:''"`
+	Status                 int            `json:"status" gorm:"default:1"`
+	Name                   string         `json:"name" gorm:"index" `
+	CreatedTime            int64          `json:"created_time" gorm:"bigint"`
+	AccessedTime           int64          `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime            int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota            int            `json:"remain_quota" gorm:"default:0"`
+	UnlimitedQuota         bool           `json:"unlimited_quota"`
+	ModelLimitsEnabled     bool           `json:"model_limits_enabled"`
+	ModelLimits            string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
+	AllowIps               *string        `json:"allow_ips" gorm:"default:''"`
+	UsedQuota              int            `json:"used_quota" gorm:"default:0"` // used quota
+	Group                  string         `json:"group" gorm:"default:''"`
+	CrossGroupRetry        bool           `json:"cross_group_retry"`                           // 跨分组重试，仅auto分组有效
+	Scopes                 string         `json:"scopes" gorm:"type:varchar(255);default:''"`  // 权限范围，逗号分隔，为空不限制
+	RpmLimit               int            `json:"rpm_limit" gorm:"default:0"`                  // 每分钟请求数限制，0 为不限制
+	TpmLimit               int            `json:"tpm_limit" gorm:"default:0"`                  // 每分钟 token 数限制，0 为不限制
+	ConcurrencyLimit       int            `json:"concurrency_limit" gorm:"default:0"`          // 最大并发请求数，0 为不限制
+	Labels                 string         `json:"labels" gorm:"type:varchar(1024);default:''"` // 标签，key=value 逗号分隔
+	AllowRequestLabels     bool           `json:"allow_request_labels"`                        // 允许请求通过 X-New-Api-Labels 追加标签
+	PreviousKey            string         `json:"-" gorm:"type:varchar(48);index;default:''"`  // 轮换前的 key，宽限期内仍然有效
+	PreviousKeyExpiredTime int64          `json:"previous_key_expired_time" gorm:"bigint;default:0"`
+	DeletedAt              gorm.DeletedAt `gorm:"index"`
 }
 
 func (token *Token) Clean() {
@@ -112,9 +121,8 @@ func SearchUserTokens(userId int, keyword string, token string, offset int, limi
 		offset = 0
 	}
 
-	if token != "" {
-		token = strings.TrimPrefix(token, "sk-")
-	}
+	// 用户可能粘贴带 sk- 前缀或首尾空白的完整令牌，先规范化再判断是否为完整 key
+	token = strings.TrimPrefix(strings.TrimSpace(token), "sk-")
 
 	// 超量用户（令牌数超过上限）只允许精确搜索，禁止模糊搜索
 	maxTokens := operation_setting.GetMaxUserTokens()
@@ -141,11 +149,16 @@ func SearchUserTokens(userId int, keyword string, token string, offset int, limi
 		baseQuery = baseQuery.Where("name LIKE ? ESCAPE '!'", keywordPattern)
 	}
 	if token != "" {
-		tokenPattern, err := sanitizeLikePattern(token)
-		if err != nil {
-			return nil, 0, err
+		// 只保存了哈希和展示前缀：完整 key 精确匹配，否则按前缀匹配
+		if len(token) == 48 && !strings.Contains(token, "%") {
+			baseQuery = baseQuery.Where(commonKeyCol+" = ?", HashTokenKey(token))
+		} else {
+			tokenPattern, err := sanitizeLikePattern(token)
+			if err != nil {
+				return nil, 0, err
+			}
+			baseQuery = baseQuery.Where("key_prefix LIKE ? ESCAPE '!'", tokenPattern)
 		}
-		baseQuery = baseQuery.Where(commonKeyCol+" LIKE ? ESCAPE '!'", tokenPattern)
 	}
 
 	// 先查匹配总数（用于分页，受 maxTokens 上限保护，避免全表 COUNT）
@@ -168,42 +181,9 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	if key == "" {
 		return nil, errors.New("未提供令牌")
 	}
-	token, err = GetTokenByKey(key, false)
+	token, err = GetTokenBySecret(key)
 	if err == nil {
-		if token.Status == common.TokenStatusExhausted {
-			keyPrefix := key[:3]
-			keySuffix := key[len(key)-3:]
-			return token, errors.New("该令牌额度已用尽 TokenStatusExhausted[sk-" + keyPrefix + "***" + keySuffix + "]")
-		} else if token.Status == common.TokenStatusExpired {
-			return token, errors.New("该令牌已过期")
-		}
-		if token.Status != common.TokenStatusEnabled {
-			return token, errors.New("该令牌状态不可用")
-		}
-		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
-			if !common.RedisEnabled {
-				token.Status = common.TokenStatusExpired
-				err := token.SelectUpdate()
-				if err != nil {
-					common.SysLog("failed to update token status" + err.Error())
-				}
-			}
-			return token, errors.New("该令牌已过期")
-		}
-		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
-			if !common.RedisEnabled {
-				// in this case, we can make sure the token is exhausted
-				token.Status = common.TokenStatusExhausted
-				err := token.SelectUpdate()
-				if err != nil {
-					common.SysLog("failed to update token status" + err.Error())
-				}
-			}
-			keyPrefix := key[:3]
-			keySuffix := key[len(key)-3:]
-			return token, errors.New(fmt.Sprintf("[sk-%s***%s] 该令牌额度已用尽 !token.UnlimitedQuota && token.RemainQuota = %d", keyPrefix, keySuffix, token.RemainQuota))
-		}
-		return token, nil
+		return token, validateTokenStatus(token, key)
 	}
 	common.SysLog("ValidateUserToken: failed to get token: " + err.Error())
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -213,6 +193,44 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	}
 }
 
+// validateTokenStatus 检查令牌状态、过期时间和额度，key 仅用于错误信息中的脱敏展示
+func validateTokenStatus(token *Token, key string) error {
+	if token.Status == common.TokenStatusExhausted {
+		keyPrefix := key[:3]
+		keySuffix := key[len(key)-3:]
+		return errors.New("该令牌额度已用尽 TokenStatusExhausted[sk-" + keyPrefix + "***" + keySuffix + "]")
+	} else if token.Status == common.TokenStatusExpired {
+		return errors.New("该令牌已过期")
+	}
+	if token.Status != common.TokenStatusEnabled {
+		return errors.New("该令牌状态不可用")
+	}
+	if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
+		if !common.RedisEnabled {
+			token.Status = common.TokenStatusExpired
+			err := token.SelectUpdate()
+			if err != nil {
+				common.SysLog("failed to update token status" + err.Error())
+			}
+		}
+		return errors.New("该令牌已过期")
+	}
+	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
+		if !common.RedisEnabled {
+			// in this case, we can make sure the token is exhausted
+			token.Status = common.TokenStatusExhausted
+			err := token.SelectUpdate()
+			if err != nil {
+				common.SysLog("failed to update token status" + err.Error())
+			}
+		}
+		keyPrefix := key[:3]
+		keySuffix := key[len(key)-3:]
+		return errors.New(fmt.Sprintf("[sk-%s***%s] 该令牌额度已用尽 !token.UnlimitedQuota && token.RemainQuota = %d", keyPrefix, keySuffix, token.RemainQuota))
+	}
+	return nil
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -283,7 +301,8 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry", "scopes",
+		"rpm_limit", "tpm_limit", "concurrency_limit", "labels", "allow_request_labels").Updates(token).Error
 	return err
 }
 
@@ -63,3 +63,29 @@ func cacheGetTokenByKey(key string) (*Token, error) {
 	token.Key = key
 	return &token, nil
 }
+
+// cacheSetTokenKeyById 缓存令牌 id 到 key 的映射，供只持有令牌 id 的调用方（如临时令牌）复用令牌缓存
+func cacheSetTokenKeyById(id int, key string) error {
+	return common.RedisSet(fmt.Sprintf("token_id:%d", id), key, time.Duration(common.RedisKeyCacheSeconds())*time.Second)
+}
+
+// getTokenByIdCached 先通过 id 到 key 的映射读取令牌缓存，未命中或映射已过时（令牌已轮换）时回源数据库
+func getTokenByIdCached(id int) (*Token, error) {
+	if common.RedisEnabled {
+		if key, err := common.RedisGet(fmt.Sprintf("token_id:%d", id)); err == nil && key != "" {
+			if token, err := GetTokenByKey(key, false); err == nil && token.Id == id {
+				return token, nil
+			}
+		}
+	}
+	token, err := GetTokenById(id)
+	if err != nil {
+		return nil, err
+	}
+	if common.RedisEnabled {
+		if err := cacheSetTokenKeyById(id, token.Key); err != nil {
+			common.SysLog("failed to cache token key by id: " + err.Error())
+		}
+	}
+	return token, nil
+}
@@ -0,0 +1,267 @@
+package model
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 临时令牌：由普通令牌签发的短期凭证，供浏览器、移动端直接调用，无需下发长期的 sk- 密钥。
+// 凭证本身携带签名，校验时只读取父令牌缓存；用量计入父令牌，独立预算通过缓存原子预扣。
+const EphemeralTokenPrefix = "ek_"
+
+// ErrEphemeralTokenDisabled 未配置 EPHEMERAL_TOKEN_SECRET 时不能签发临时令牌
+var ErrEphemeralTokenDisabled = errors.New("未配置 EPHEMERAL_TOKEN_SECRET，临时令牌功能不可用")
+
+// ErrEphemeralTokenQuotaExceeded 临时令牌的预算不足以支付本次请求
+var ErrEphemeralTokenQuotaExceeded = errors.New("该临时令牌额度已用尽")
+
+// EphemeralTokenClaims 临时令牌携带的声明，只能收窄父令牌的模型、权限范围和额度
+type EphemeralTokenClaims struct {
+	Id        string   `json:"jti"`
+	TokenId   int      `json:"tid"`
+	UserId    int      `json:"uid"`
+	ExpiresAt int64    `json:"exp"`
+	Models    []string `json:"models,omitempty"`
+	Scopes    string   `json:"scopes,omitempty"`
+	Quota     int      `json:"quota,omitempty"` // 临时令牌的预算，0 表示沿用父令牌额度
+}
+
+type EphemeralTokenRequest struct {
+	TTLMinutes int      `json:"ttl_minutes"`
+	Models     []string `json:"models"`
+	Scopes     string   `json:"scopes"`
+	Quota      int      `json:"quota"`
+}
+
+func IsEphemeralTokenKey(key string) bool {
+	return strings.HasPrefix(key, EphemeralTokenPrefix)
+}
+
+// EphemeralTokenEnabled 签名密钥只来自环境变量，不与保存在数据库中的任何密钥关联
+func EphemeralTokenEnabled() bool {
+	return common.EphemeralTokenSecret != ""
+}
+
+func signEphemeralPayload(payload string) string {
+	mac := hmac.New(sha256.New, []byte(common.EphemeralTokenSecret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MintEphemeralToken 以 parent 为父令牌签发临时令牌，maxTTLMinutes 为允许的最长有效期
+func MintEphemeralToken(parent *Token, req EphemeralTokenRequest, maxTTLMinutes int) (string, *EphemeralTokenClaims, error) {
+	if !EphemeralTokenEnabled() {
+		return "", nil, ErrEphemeralTokenDisabled
+	}
+	if req.TTLMinutes <= 0 || req.TTLMinutes > maxTTLMinutes {
+		return "", nil, fmt.Errorf("有效期必须在 1-%d 分钟之间", maxTTLMinutes)
+	}
+	if req.Quota < 0 {
+		return "", nil, errors.New("额度不能为负数")
+	}
+	if req.Quota > 0 && !parent.UnlimitedQuota && req.Quota > parent.RemainQuota {
+		return "", nil, errors.New("额度不能超过父令牌的剩余额度")
+	}
+	models := make([]string, 0, len(req.Models))
+	for _, modelName := range req.Models {
+		modelName = strings.TrimSpace(modelName)
+		if modelName == "" {
+			continue
+		}
+		if parent.ModelLimitsEnabled && !parent.GetModelLimitsMap()[modelName] {
+			return "", nil, fmt.Errorf("父令牌无权访问模型 %s", modelName)
+		}
+		models = append(models, modelName)
+	}
+	scopes, invalidScope := NormalizeTokenScopes(req.Scopes)
+	if invalidScope != "" {
+		return "", nil, fmt.Errorf("无效的令牌权限范围：%s", invalidScope)
+	}
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope != "" && !parent.HasScope(scope) {
+			return "", nil, fmt.Errorf("父令牌没有 %s 权限", scope)
+		}
+	}
+
+	claims := &EphemeralTokenClaims{
+		Id:        common.GetUUID(),
+		TokenId:   parent.Id,
+		UserId:    parent.UserId,
+		ExpiresAt: common.GetTimestamp() + int64(req.TTLMinutes)*60,
+		Models:    models,
+		Scopes:    scopes,
+		Quota:     req.Quota,
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return EphemeralTokenPrefix + payload + "." + signEphemeralPayload(payload), claims, nil
+}
+
+func parseEphemeralToken(key string) (*EphemeralTokenClaims, error) {
+	payload, signature, ok := strings.Cut(strings.TrimPrefix(key, EphemeralTokenPrefix), ".")
+	if !ok || !EphemeralTokenEnabled() || !hmac.Equal([]byte(signature), []byte(signEphemeralPayload(payload))) {
+		return nil, errors.New("无效的令牌")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("无效的令牌")
+	}
+	var claims EphemeralTokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errors.New("无效的令牌")
+	}
+	if claims.ExpiresAt <= common.GetTimestamp() {
+		return nil, errors.New("该令牌已过期")
+	}
+	return &claims, nil
+}
+
+// ValidateEphemeralToken 校验临时令牌签名与父令牌状态，返回按声明收窄后的父令牌副本
+func ValidateEphemeralToken(key string) (*Token, *EphemeralTokenClaims, error) {
+	claims, err := parseEphemeralToken(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	parent, err := getTokenByIdCached(claims.TokenId)
+	if err != nil || parent.UserId != claims.UserId {
+		return nil, nil, errors.New("无效的令牌")
+	}
+	if err := validateTokenStatus(parent, parent.KeyPrefix); err != nil {
+		return parent, nil, err
+	}
+	if claims.Quota > 0 && GetEphemeralTokenSpent(claims.Id) >= int64(claims.Quota) {
+		return parent, nil, ErrEphemeralTokenQuotaExceeded
+	}
+	token := *parent
+	if len(claims.Models) > 0 {
+		token.ModelLimitsEnabled = true
+		token.ModelLimits = strings.Join(claims.Models, ",")
+	}
+	if claims.Scopes != "" {
+		token.Scopes = claims.Scopes
+	}
+	return &token, claims, nil
+}
+
+// 未启用 Redis 时临时令牌的已用额度保存在内存中，仅对当前节点有效
+var (
+	ephemeralSpentMutex     sync.Mutex
+	ephemeralSpent          = make(map[string]*ephemeralSpentEntry)
+	ephemeralSpentLastSweep int64
+)
+
+type ephemeralSpentEntry struct {
+	quota     int64
+	expiresAt int64
+}
+
+func ephemeralSpentKey(id string) string {
+	return "ephemeralTokenSpent:" + id
+}
+
+func GetEphemeralTokenSpent(id string) int64 {
+	if common.RedisEnabled {
+		spent, err := common.RDB.Get(context.Background(), ephemeralSpentKey(id)).Int64()
+		if err != nil {
+			return 0
+		}
+		return spent
+	}
+	ephemeralSpentMutex.Lock()
+	defer ephemeralSpentMutex.Unlock()
+	if entry, ok := ephemeralSpent[id]; ok {
+		return entry.quota
+	}
+	return 0
+}
+
+// expireEphemeralSpentKey 已用额度记录随临时令牌一同过期
+func expireEphemeralSpentKey(ctx context.Context, key string, claims *EphemeralTokenClaims) {
+	if claims.ExpiresAt > 0 {
+		common.RDB.ExpireAt(ctx, key, time.Unix(claims.ExpiresAt, 0))
+	}
+}
+
+// ReserveEphemeralTokenQuota 预扣临时令牌预算，累计后超出预算时撤销本次预扣并返回错误，
+// 并发请求之间不会超额使用
+func ReserveEphemeralTokenQuota(claims *EphemeralTokenClaims, quota int) error {
+	if claims.Quota <= 0 || quota <= 0 {
+		return nil
+	}
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := ephemeralSpentKey(claims.Id)
+		spent, err := common.RDB.IncrBy(ctx, key, int64(quota)).Result()
+		if err != nil {
+			return err
+		}
+		expireEphemeralSpentKey(ctx, key, claims)
+		if spent > int64(claims.Quota) {
+			if err := common.RDB.DecrBy(ctx, key, int64(quota)).Err(); err != nil {
+				common.SysLog("failed to release ephemeral token quota: " + err.Error())
+			}
+			return ErrEphemeralTokenQuotaExceeded
+		}
+		return nil
+	}
+	ephemeralSpentMutex.Lock()
+	defer ephemeralSpentMutex.Unlock()
+	entry := getEphemeralSpentEntryLocked(claims)
+	if entry.quota+int64(quota) > int64(claims.Quota) {
+		return ErrEphemeralTokenQuotaExceeded
+	}
+	entry.quota += int64(quota)
+	return nil
+}
+
+// AddEphemeralTokenSpent 按结算差额调整临时令牌的已用额度，quota 为负数时退还预扣，记录在临时令牌过期后自动清理
+func AddEphemeralTokenSpent(claims *EphemeralTokenClaims, quota int) {
+	if claims.Quota <= 0 || quota == 0 {
+		return
+	}
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := ephemeralSpentKey(claims.Id)
+		if err := common.RDB.IncrBy(ctx, key, int64(quota)).Err(); err != nil {
+			common.SysLog("failed to record ephemeral token spent: " + err.Error())
+			return
+		}
+		expireEphemeralSpentKey(ctx, key, claims)
+		return
+	}
+	ephemeralSpentMutex.Lock()
+	defer ephemeralSpentMutex.Unlock()
+	getEphemeralSpentEntryLocked(claims).quota += int64(quota)
+}
+
+// getEphemeralSpentEntryLocked 调用方需持有 ephemeralSpentMutex
+func getEphemeralSpentEntryLocked(claims *EphemeralTokenClaims) *ephemeralSpentEntry {
+	now := common.GetTimestamp()
+	if now-ephemeralSpentLastSweep > 60 {
+		for id, entry := range ephemeralSpent {
+			if entry.expiresAt <= now {
+				delete(ephemeralSpent, id)
+			}
+		}
+		ephemeralSpentLastSweep = now
+	}
+	entry, ok := ephemeralSpent[claims.Id]
+	if !ok {
+		entry = &ephemeralSpentEntry{expiresAt: claims.ExpiresAt}
+		ephemeralSpent[claims.Id] = entry
+	}
+	return entry
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createEphemeralParentToken(t *testing.T) *Token {
+	t.Helper()
+	truncateTables(t)
+	originSecret := common.EphemeralTokenSecret
+	t.Cleanup(func() { common.EphemeralTokenSecret = originSecret })
+	common.EphemeralTokenSecret = "ephemeral-test-secret"
+	secret, err := common.GenerateKey()
+	require.NoError(t, err)
+	parent := &Token{UserId: 1, Name: "server", Status: common.TokenStatusEnabled, ExpiredTime: -1, RemainQuota: 5000,
+		ModelLimitsEnabled: true, ModelLimits: "gpt-4o,gpt-4o-mini", Scopes: "chat,realtime"}
+	parent.SetSecret(secret)
+	require.NoError(t, parent.Insert())
+	return parent
+}
+
+func TestEphemeralToken_NarrowsParent(t *testing.T) {
+	parent := createEphemeralParentToken(t)
+
+	_, _, err := MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 10, Models: []string{"o3"}}, 60)
+	assert.Error(t, err)
+	_, _, err = MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 10, Scopes: "images"}, 60)
+	assert.Error(t, err)
+	_, _, err = MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 10, Quota: 6000}, 60)
+	assert.Error(t, err)
+	_, _, err = MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 120}, 60)
+	assert.Error(t, err)
+
+	key, claims, err := MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 10, Models: []string{"gpt-4o-mini"}, Scopes: "realtime"}, 60)
+	require.NoError(t, err)
+	assert.True(t, IsEphemeralTokenKey(key))
+
+	token, validated, err := ValidateEphemeralToken(key)
+	require.NoError(t, err)
+	assert.Equal(t, claims.Id, validated.Id)
+	assert.Equal(t, parent.Id, token.Id)
+	assert.Equal(t, parent.Key, token.Key)
+	assert.Equal(t, map[string]bool{"gpt-4o-mini": true}, token.GetModelLimitsMap())
+	assert.True(t, token.HasScope(TokenScopeRealtime))
+	assert.False(t, token.HasScope(TokenScopeChat))
+
+	// 篡改载荷后签名失效
+	_, _, err = ValidateEphemeralToken(key[:len(EphemeralTokenPrefix)+4] + "x" + key[len(EphemeralTokenPrefix)+5:])
+	assert.Error(t, err)
+
+	// 父令牌被禁用后临时令牌随之失效
+	require.NoError(t, DB.Model(&Token{}).Where("id = ?", parent.Id).Update("status", common.TokenStatusDisabled).Error)
+	_, _, err = ValidateEphemeralToken(key)
+	assert.Error(t, err)
+}
+
+func TestEphemeralToken_Budget(t *testing.T) {
+	parent := createEphemeralParentToken(t)
+	key, claims, err := MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 5, Quota: 100}, 60)
+	require.NoError(t, err)
+
+	AddEphemeralTokenSpent(claims, 60)
+	_, _, err = ValidateEphemeralToken(key)
+	require.NoError(t, err)
+
+	AddEphemeralTokenSpent(claims, 40)
+	assert.Equal(t, int64(100), GetEphemeralTokenSpent(claims.Id))
+	_, _, err = ValidateEphemeralToken(key)
+	assert.Error(t, err)
+}
+
+func TestEphemeralToken_RequiresDedicatedSecret(t *testing.T) {
+	parent := createEphemeralParentToken(t)
+	key, _, err := MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 5}, 60)
+	require.NoError(t, err)
+
+	// 签名密钥与数据库中的令牌哈希密钥无关，未配置时既不能签发也不能校验
+	common.EphemeralTokenSecret = ""
+	_, _, err = MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 5}, 60)
+	assert.ErrorIs(t, err, ErrEphemeralTokenDisabled)
+	_, _, err = ValidateEphemeralToken(key)
+	assert.Error(t, err)
+
+	// 更换密钥后旧的临时令牌失效
+	common.EphemeralTokenSecret = "rotated-secret"
+	_, _, err = ValidateEphemeralToken(key)
+	assert.Error(t, err)
+}
+
+func TestEphemeralToken_ConcurrentReserve(t *testing.T) {
+	parent := createEphemeralParentToken(t)
+	_, claims, err := MintEphemeralToken(parent, EphemeralTokenRequest{TTLMinutes: 5, Quota: 100}, 60)
+	require.NoError(t, err)
+
+	var reserved atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ReserveEphemeralTokenQuota(claims, 30) == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), reserved.Load())
+	assert.Equal(t, int64(90), GetEphemeralTokenSpent(claims.Id))
+
+	// 结算时退还多预扣的部分后可以继续使用
+	AddEphemeralTokenSpent(claims, -50)
+	assert.NoError(t, ReserveEphemeralTokenQuota(claims, 60))
+	assert.ErrorIs(t, ReserveEphemeralTokenQuota(claims, 1), ErrEphemeralTokenQuotaExceeded)
+}
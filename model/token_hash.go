@@ -0,0 +1,214 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/cachex"
+	"github.com/samber/hot"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// tokenHashSecretOptionKey 未配置 TOKEN_HASH_SECRET 时，自动生成的哈希密钥保存在 options 表中
+	tokenHashSecretOptionKey = "TokenHashSecret"
+	// tokenKeyPrefixLength 令牌明文中保留用于展示的前缀长度
+	tokenKeyPrefixLength = 8
+	tokenKeyMigrateBatch = 500
+
+	tokenPreviousKeyMissNamespace = "new-api:token_previous_key_miss:v1"
+	tokenPreviousKeyMissTTL       = time.Minute
+	tokenPreviousKeyMissCapacity  = 10000
+)
+
+var (
+	tokenPreviousKeyMissCacheOnce sync.Once
+	tokenPreviousKeyMissCache     *cachex.HybridCache[bool]
+)
+
+// getTokenPreviousKeyMissCache 记录查不到旧 key 的哈希，避免无效令牌的每次请求都查询 previous_key。
+// 轮换只会把当前 key 写入 previous_key，已经查不到的哈希不会因轮换重新生效，因此无需主动失效
+func getTokenPreviousKeyMissCache() *cachex.HybridCache[bool] {
+	tokenPreviousKeyMissCacheOnce.Do(func() {
+		tokenPreviousKeyMissCache = cachex.NewHybridCache[bool](cachex.HybridCacheConfig[bool]{
+			Namespace: cachex.Namespace(tokenPreviousKeyMissNamespace),
+			Redis:     common.RDB,
+			RedisEnabled: func() bool {
+				return common.RedisEnabled && common.RDB != nil
+			},
+			RedisCodec: cachex.JSONCodec[bool]{},
+			Memory: func() *hot.HotCache[string, bool] {
+				return hot.NewHotCache[string, bool](hot.LRU, tokenPreviousKeyMissCapacity).
+					WithTTL(tokenPreviousKeyMissTTL).
+					WithJanitor().
+					Build()
+			},
+		})
+	})
+	return tokenPreviousKeyMissCache
+}
+
+// tokenHashSecret 令牌 key 的哈希密钥，修改后所有已有令牌都将失效
+var tokenHashSecret []byte
+
+// HashTokenKey 计算令牌明文的带密钥哈希，截断为 48 位十六进制以沿用 tokens.key 的 char(48) 列
+func HashTokenKey(key string) string {
+	return common.GenerateHMACWithKey(tokenHashSecret, key)[:48]
+}
+
+func tokenKeyPrefix(key string) string {
+	if len(key) <= tokenKeyPrefixLength {
+		return key
+	}
+	return key[:tokenKeyPrefixLength]
+}
+
+// SetSecret 设置令牌明文，仅保存哈希与展示前缀
+func (token *Token) SetSecret(secret string) {
+	token.Key = HashTokenKey(secret)
+	token.KeyPrefix = tokenKeyPrefix(secret)
+}
+
+// InitTokenKeyHash 加载令牌哈希密钥，主节点同时将明文存储的旧令牌迁移为哈希存储
+func InitTokenKeyHash() error {
+	if secret := os.Getenv("TOKEN_HASH_SECRET"); secret != "" {
+		tokenHashSecret = []byte(secret)
+	} else {
+		common.SysError("WARNING: TOKEN_HASH_SECRET is not set, the token hash secret is generated and stored in the database. " +
+			"Anyone who can read the database can brute-force token keys offline; set TOKEN_HASH_SECRET to the value stored in the " +
+			"options table (key " + tokenHashSecretOptionKey + ") to keep existing tokens valid, then remove it from the database")
+		secret, err := loadOrCreateTokenHashSecret()
+		if err != nil {
+			return err
+		}
+		tokenHashSecret = []byte(secret)
+	}
+	if !common.IsMasterNode {
+		return nil
+	}
+	return migrateTokenKeyHash()
+}
+
+// loadOrCreateTokenHashSecret 多个节点同时启动时以先写入者为准
+func loadOrCreateTokenHashSecret() (string, error) {
+	secret, err := common.GenerateRandomCharsKey(48)
+	if err != nil {
+		return "", err
+	}
+	err = DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&Option{Key: tokenHashSecretOptionKey, Value: secret}).Error
+	if err != nil {
+		return "", err
+	}
+	var option Option
+	if err := DB.Where(commonKeyCol+" = ?", tokenHashSecretOptionKey).First(&option).Error; err != nil {
+		return "", err
+	}
+	if option.Value == "" {
+		return "", errors.New("token hash secret is empty")
+	}
+	return option.Value, nil
+}
+
+// migrateTokenKeyHash 将明文存储的令牌（key_prefix 为空）转为哈希存储，仅保留前缀用于展示
+func migrateTokenKeyHash() error {
+	lastId := 0
+	migrated := 0
+	for {
+		var tokens []Token
+		err := DB.Unscoped().Select("id", commonKeyCol).Where("id > ? AND key_prefix = ?", lastId, "").
+			Order("id").Limit(tokenKeyMigrateBatch).Find(&tokens).Error
+		if err != nil {
+			return err
+		}
+		if len(tokens) == 0 {
+			break
+		}
+		for _, token := range tokens {
+			lastId = token.Id
+			if token.Key == "" {
+				continue
+			}
+			result := DB.Unscoped().Model(&Token{}).Where("id = ? AND key_prefix = ?", token.Id, "").Updates(map[string]any{
+				"key":        HashTokenKey(token.Key),
+				"key_prefix": tokenKeyPrefix(token.Key),
+			})
+			if result.Error != nil {
+				return fmt.Errorf("failed to migrate token %d: %w", token.Id, result.Error)
+			}
+			migrated += int(result.RowsAffected)
+		}
+	}
+	if migrated > 0 {
+		common.SysLog(fmt.Sprintf("migrated %d plaintext token keys to hashed storage", migrated))
+	}
+	return nil
+}
+
+// GetTokenBySecret 通过用户提交的令牌明文查找令牌；轮换后的旧 key 在宽限期内仍然有效
+func GetTokenBySecret(secret string) (*Token, error) {
+	hashed := HashTokenKey(secret)
+	token, err := GetTokenByKey(hashed, false)
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return token, err
+	}
+	missCache := getTokenPreviousKeyMissCache()
+	if _, found, _ := missCache.Get(hashed); found {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var previous Token
+	err = DB.Where("previous_key = ? AND previous_key_expired_time > ?", hashed, common.GetTimestamp()).First(&previous).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if cacheErr := missCache.SetWithTTL(hashed, true, tokenPreviousKeyMissTTL); cacheErr != nil {
+				common.SysLog("failed to cache token previous key miss: " + cacheErr.Error())
+			}
+		}
+		return nil, err
+	}
+	return &previous, nil
+}
+
+// Rotate 为令牌生成新的 key，旧 key 在 graceSeconds 内仍可使用；额度、限制和历史记录保持不变。
+// 宽限期内再次轮换时，更早的旧 key 立即失效。返回新的令牌明文
+func (token *Token) Rotate(graceSeconds int64) (string, error) {
+	secret, err := common.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	oldKey := token.Key
+	previousKey := ""
+	previousExpiredTime := int64(0)
+	if graceSeconds > 0 {
+		previousKey = oldKey
+		previousExpiredTime = common.GetTimestamp() + graceSeconds
+	}
+	var rotated Token
+	rotated.SetSecret(secret)
+	result := DB.Model(&Token{}).Where("id = ? AND "+commonKeyCol+" = ?", token.Id, oldKey).Updates(map[string]any{
+		"key":                       rotated.Key,
+		"key_prefix":                rotated.KeyPrefix,
+		"previous_key":              previousKey,
+		"previous_key_expired_time": previousExpiredTime,
+	})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", errors.New("令牌已被轮换，请刷新后重试")
+	}
+	token.Key = rotated.Key
+	token.KeyPrefix = rotated.KeyPrefix
+	token.PreviousKey = previousKey
+	token.PreviousKeyExpiredTime = previousExpiredTime
+	if common.RedisEnabled {
+		if err := cacheDeleteToken(oldKey); err != nil {
+			common.SysLog("failed to delete rotated token cache: " + err.Error())
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestTokenKeyHash_MigratePlaintext(t *testing.T) {
+	truncateTables(t)
+	withColumnNames(t)
+	require.NoError(t, DB.AutoMigrate(&Option{}))
+	t.Cleanup(func() { DB.Exec("DELETE FROM options") })
+
+	require.NoError(t, InitTokenKeyHash())
+	secret := tokenHashSecret
+	// 已存在的密钥在重启后保持不变
+	require.NoError(t, InitTokenKeyHash())
+	assert.Equal(t, secret, tokenHashSecret)
+
+	plaintext := "legacyPlaintextKey0123456789abcdefghijklmnopqrst"
+	require.NoError(t, DB.Create(&Token{Id: 1, UserId: 1, Key: plaintext, Name: "legacy", Status: common.TokenStatusEnabled,
+		UnlimitedQuota: true, ExpiredTime: -1}).Error)
+	require.NoError(t, migrateTokenKeyHash())
+	require.NoError(t, migrateTokenKeyHash())
+
+	var stored Token
+	require.NoError(t, DB.First(&stored, 1).Error)
+	assert.Equal(t, HashTokenKey(plaintext), stored.Key)
+	assert.Equal(t, "legacyPl", stored.KeyPrefix)
+
+	token, err := ValidateUserToken(plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, 1, token.Id)
+	_, err = GetTokenByKey(plaintext, true)
+	assert.Error(t, err)
+}
+
+func TestTokenKeyHash_RotateWithGrace(t *testing.T) {
+	truncateTables(t)
+	withColumnNames(t)
+	oldSecret, err := common.GenerateKey()
+	require.NoError(t, err)
+	token := &Token{UserId: 1, Name: "rotate", Status: common.TokenStatusEnabled, RemainQuota: 1000, ExpiredTime: -1, UsedQuota: 300}
+	token.SetSecret(oldSecret)
+	require.NoError(t, token.Insert())
+
+	newSecret, err := token.Rotate(3600)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldSecret, newSecret)
+
+	byNew, err := GetTokenBySecret(newSecret)
+	require.NoError(t, err)
+	assert.Equal(t, token.Id, byNew.Id)
+	assert.Equal(t, 1000, byNew.RemainQuota)
+	assert.Equal(t, 300, byNew.UsedQuota)
+
+	byOld, err := GetTokenBySecret(oldSecret)
+	require.NoError(t, err)
+	assert.Equal(t, token.Id, byOld.Id)
+	assert.Equal(t, byNew.Key, byOld.Key)
+
+	// 不保留宽限期时旧 key 立即失效
+	_, err = token.Rotate(0)
+	require.NoError(t, err)
+	_, err = GetTokenBySecret(newSecret)
+	assert.Error(t, err)
+	_, err = GetTokenBySecret(oldSecret)
+	assert.Error(t, err)
+
+	// 使用过期的快照再次轮换会失败
+	stale := *byNew
+	_, err = stale.Rotate(3600)
+	assert.Error(t, err)
+}
+
+func TestTokenKeyHash_PreviousKeyMissCached(t *testing.T) {
+	truncateTables(t)
+	withColumnNames(t)
+	secret, err := common.GenerateKey()
+	require.NoError(t, err)
+	_, err = GetTokenBySecret(secret)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// 已确认不存在的旧 key 在缓存有效期内不再查询 previous_key
+	token := &Token{UserId: 1, Name: "miss", Status: common.TokenStatusEnabled, ExpiredTime: -1,
+		PreviousKey: HashTokenKey(secret), PreviousKeyExpiredTime: common.GetTimestamp() + 3600}
+	token.SetSecret("anotherSecret")
+	require.NoError(t, token.Insert())
+	_, err = GetTokenBySecret(secret)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestSearchUserTokens_FullKeyWithPrefix(t *testing.T) {
+	truncateTables(t)
+	withColumnNames(t)
+	secret, err := common.GenerateKey()
+	require.NoError(t, err)
+	token := &Token{UserId: 1, Name: "search", Status: common.TokenStatusEnabled, ExpiredTime: -1}
+	token.SetSecret(secret)
+	require.NoError(t, token.Insert())
+
+	for _, query := range []string{secret, "sk-" + secret, " sk-" + secret + " ", "sk-" + secret[:8]} {
+		tokens, total, err := SearchUserTokens(1, "", query, 0, 10)
+		require.NoError(t, err, query)
+		assert.EqualValues(t, 1, total, query)
+		require.Len(t, tokens, 1, query)
+		assert.Equal(t, token.Id, tokens[0].Id)
+	}
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// 令牌标签：以 key=value 形式标注项目、团队、环境等维度，逗号分隔，随每条日志保存，用于按标签统计用量与费用
+const (
+	maxTokenLabels           = 8
+	maxTokenLabelValueLength = 64
+)
+
+var tokenLabelKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+// ParseTokenLabels 解析逗号分隔的 key=value 标签，key 统一转为小写；
+// 存在格式错误、重复或超出数量限制的标签时通过 invalid 返回
+func ParseTokenLabels(raw string) (labels map[string]string, invalid string) {
+	labels = make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if !ok || !tokenLabelKeyPattern.MatchString(key) || value == "" || len(value) > maxTokenLabelValueLength ||
+			strings.ContainsAny(value, "=\"\r\n") {
+			return nil, item
+		}
+		if _, exists := labels[key]; exists || len(labels) >= maxTokenLabels {
+			return nil, item
+		}
+		labels[key] = value
+	}
+	return labels, ""
+}
+
+// FormatTokenLabels 按 key 排序输出，保证相同的标签组合在日志中只有一种写法
+func FormatTokenLabels(labels map[string]string) string {
+	keys := slices.Sorted(maps.Keys(labels))
+	items := make([]string, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, key+"="+labels[key])
+	}
+	return strings.Join(items, ",")
+}
+
+// NormalizeTokenLabels 校验并规范化令牌标签
+func NormalizeTokenLabels(raw string) (normalized string, invalid string) {
+	labels, invalid := ParseTokenLabels(raw)
+	if invalid != "" {
+		return "", invalid
+	}
+	return FormatTokenLabels(labels), ""
+}
+
+func (token *Token) GetLabels() map[string]string {
+	labels, _ := ParseTokenLabels(token.Labels)
+	if labels == nil {
+		return make(map[string]string)
+	}
+	return labels
+}
+
+// MergeRequestLabels 合并请求头中携带的标签，令牌自身的标签优先，请求无法覆盖
+func (token *Token) MergeRequestLabels(raw string) (merged string, invalid string) {
+	requestLabels, invalid := ParseTokenLabels(raw)
+	if invalid != "" {
+		return "", invalid
+	}
+	labels := token.GetLabels()
+	for key, value := range requestLabels {
+		if _, exists := labels[key]; exists {
+			continue
+		}
+		if len(labels) >= maxTokenLabels {
+			return "", key + "=" + value
+		}
+		labels[key] = value
+	}
+	return FormatTokenLabels(labels), ""
+}
+
+// LabelUsage 按标签汇总的用量
+type LabelUsage struct {
+	LabelKey   string `json:"label_key"`
+	LabelValue string `json:"label_value"`
+	Count      int    `json:"count"`
+	Quota      int    `json:"quota"`
+	TokenUsed  int    `json:"token_used"`
+}
+
+type labelUsageRow struct {
+	Labels    string
+	Count     int
+	Quota     int
+	TokenUsed int
+}
+
+// GetLabelUsage 按标签汇总消费日志，userId 为 0 时统计全部用户；labelKey 为空时返回所有标签。
+// 数据来源于消费日志，未开启消费日志时无数据
+func GetLabelUsage(userId int, username string, labelKey string, startTimestamp int64, endTimestamp int64) ([]*LabelUsage, error) {
+	var rows []labelUsageRow
+	tx := LOG_DB.Model(&Log{}).Select("labels, count(*) as count, sum(quota) as quota, sum(prompt_tokens) + sum(completion_tokens) as token_used").
+		Where("type = ? AND labels <> ?", LogTypeConsume, "")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if username != "" {
+		tx = tx.Where("username = ?", username)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if err := tx.Group("labels").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	// 先在数据库中按标签组合聚合，再拆分到单个标签
+	usage := make(map[string]*LabelUsage)
+	for _, row := range rows {
+		labels, _ := ParseTokenLabels(row.Labels)
+		for key, value := range labels {
+			if labelKey != "" && key != labelKey {
+				continue
+			}
+			id := key + "=" + value
+			item, ok := usage[id]
+			if !ok {
+				item = &LabelUsage{LabelKey: key, LabelValue: value}
+				usage[id] = item
+			}
+			item.Count += row.Count
+			item.Quota += row.Quota
+			item.TokenUsed += row.TokenUsed
+		}
+	}
+	result := slices.Collect(maps.Values(usage))
+	slices.SortFunc(result, func(a, b *LabelUsage) int {
+		if a.LabelKey != b.LabelKey {
+			return strings.Compare(a.LabelKey, b.LabelKey)
+		}
+		if a.Quota != b.Quota {
+			return b.Quota - a.Quota
+		}
+		return strings.Compare(a.LabelValue, b.LabelValue)
+	})
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTokenLabels(t *testing.T) {
+	normalized, invalid := NormalizeTokenLabels(" Team=search , project=alpha,,env=prod")
+	assert.Empty(t, invalid)
+	assert.Equal(t, "env=prod,project=alpha,team=search", normalized)
+
+	for _, raw := range []string{"project", "project=", "=alpha", "pro ject=alpha", "project=a,project=b", "project=a=b"} {
+		_, invalid = NormalizeTokenLabels(raw)
+		assert.NotEmpty(t, invalid, raw)
+	}
+}
+
+func TestTokenMergeRequestLabels(t *testing.T) {
+	token := &Token{Labels: "project=alpha"}
+	merged, invalid := token.MergeRequestLabels("project=beta,feature=search")
+	assert.Empty(t, invalid)
+	// 令牌自身的标签不能被请求覆盖
+	assert.Equal(t, "feature=search,project=alpha", merged)
+
+	_, invalid = token.MergeRequestLabels("feature")
+	assert.Equal(t, "feature", invalid)
+}
+
+func TestGetLabelUsage(t *testing.T) {
+	truncateTables(t)
+	logs := []*Log{
+		{UserId: 1, Username: "alice", Type: LogTypeConsume, CreatedAt: 100, Quota: 10, PromptTokens: 5, CompletionTokens: 5, Labels: "env=prod,project=alpha"},
+		{UserId: 1, Username: "alice", Type: LogTypeConsume, CreatedAt: 200, Quota: 20, PromptTokens: 10, CompletionTokens: 0, Labels: "env=dev,project=alpha"},
+		{UserId: 2, Username: "bob", Type: LogTypeConsume, CreatedAt: 300, Quota: 40, PromptTokens: 1, CompletionTokens: 1, Labels: "project=beta"},
+		{UserId: 2, Username: "bob", Type: LogTypeConsume, CreatedAt: 300, Quota: 80},
+		{UserId: 2, Username: "bob", Type: LogTypeError, CreatedAt: 300, Labels: "project=beta"},
+	}
+	require.NoError(t, LOG_DB.Create(&logs).Error)
+
+	usage, err := GetLabelUsage(0, "", "project", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	assert.Equal(t, LabelUsage{LabelKey: "project", LabelValue: "beta", Count: 1, Quota: 40, TokenUsed: 2}, *usage[0])
+	assert.Equal(t, LabelUsage{LabelKey: "project", LabelValue: "alpha", Count: 2, Quota: 30, TokenUsed: 20}, *usage[1])
+
+	usage, err = GetLabelUsage(1, "", "", 150, 0)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	assert.Equal(t, LabelUsage{LabelKey: "env", LabelValue: "dev", Count: 1, Quota: 20, TokenUsed: 10}, *usage[0])
+	assert.Equal(t, LabelUsage{LabelKey: "project", LabelValue: "alpha", Count: 1, Quota: 20, TokenUsed: 10}, *usage[1])
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"slices"
+	"strings"
+)
+
+// 令牌权限范围，Scopes 为空表示不限制
+const (
+	TokenScopeChat       = "chat"       // 对话补全、Claude Messages、Responses、Gemini generateContent
+	TokenScopeEmbeddings = "embeddings" // 向量
+	TokenScopeImages     = "images"     // 图像生成、编辑及 Midjourney
+	TokenScopeAudio      = "audio"      // 语音合成与识别
+	TokenScopeRealtime   = "realtime"   // Realtime WebSocket
+	TokenScopeRerank     = "rerank"     // 重排序
+	TokenScopeTask       = "task"       // 视频、音乐等异步任务
+	TokenScopeRead       = "read"       // 用量及日志查询
+)
+
+var TokenScopes = []string{
+	TokenScopeChat,
+	TokenScopeEmbeddings,
+	TokenScopeImages,
+	TokenScopeAudio,
+	TokenScopeRealtime,
+	TokenScopeRerank,
+	TokenScopeTask,
+	TokenScopeRead,
+}
+
+// NormalizeTokenScopes 校验并规范化逗号分隔的权限范围，去重后按固定顺序输出；
+// 存在未知的权限范围时通过 invalid 返回
+func NormalizeTokenScopes(raw string) (normalized string, invalid string) {
+	selected := make(map[string]bool)
+	for _, scope := range strings.Split(raw, ",") {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" {
+			continue
+		}
+		if !slices.Contains(TokenScopes, scope) {
+			return "", scope
+		}
+		selected[scope] = true
+	}
+	scopes := make([]string, 0, len(selected))
+	for _, scope := range TokenScopes {
+		if selected[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, ","), ""
+}
+
+func (token *Token) GetScopes() []string {
+	scopes := make([]string, 0)
+	for _, scope := range strings.Split(token.Scopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// HasScope 未设置权限范围的令牌拥有全部权限
+func (token *Token) HasScope(scope string) bool {
+	scopes := token.GetScopes()
+	if len(scopes) == 0 {
+		return true
+	}
+	return slices.Contains(scopes, scope)
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTokenScopes(t *testing.T) {
+	scopes, invalid := NormalizeTokenScopes(" Read, chat,,chat ")
+	assert.Empty(t, invalid)
+	assert.Equal(t, "chat,read", scopes)
+
+	_, invalid = NormalizeTokenScopes("chat,admin")
+	assert.Equal(t, "admin", invalid)
+}
+
+func TestTokenHasScope(t *testing.T) {
+	token := &Token{}
+	assert.True(t, token.HasScope(TokenScopeImages))
+
+	token.Scopes = "embeddings"
+	assert.True(t, token.HasScope(TokenScopeEmbeddings))
+	assert.False(t, token.HasScope(TokenScopeChat))
+	assert.False(t, token.HasScope(TokenScopeRead))
+}
@@ -21,6 +21,10 @@ type TopUp struct {
 	CreateTime    int64   `json:"create_time"`
 	CompleteTime  int64   `json:"complete_time"`
 	Status        string  `json:"status"`
+	// 支付平台侧的流水号（易支付订单号、Stripe PaymentIntent 等）
+	ProviderTradeNo string `json:"provider_trade_no" gorm:"type:varchar(255);default:''"`
+	RefundTime      int64  `json:"refund_time" gorm:"default:0"`
+	RefundReason    string `json:"refund_reason" gorm:"type:varchar(255);default:''"`
 }
 
 func (topUp *TopUp) Insert() error {
@@ -61,6 +65,7 @@ func Recharge(referenceId string, customerId string) (err error) {
 	}
 
 	var quota float64
+	var bonus int64
 	topUp := &TopUp{}
 
 	refCol := "`trade_no`"
@@ -86,12 +91,24 @@ func Recharge(referenceId string, customerId string) (err error) {
 		}
 
 		quota = topUp.Money * common.QuotaPerUnit
+		if due, ok, err := postpaidStatementDueTx(tx, topUp.TradeNo); err != nil {
+			return err
+		} else if ok {
+			quota = float64(due)
+		}
 		err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(map[string]interface{}{"stripe_customer": customerId, "quota": gorm.Expr("quota + ?", quota)}).Error
 		if err != nil {
 			return err
 		}
 
-		return nil
+		if err := RecordCreditGrantTx(tx, topUp.UserId, CreditSourceTopUp, topUp.TradeNo, int64(quota)); err != nil {
+			return err
+		}
+		if bonus, err = completePromoCodeUsageTx(tx, topUp.TradeNo, int64(quota)); err != nil {
+			return err
+		}
+		_, err = accrueAffiliateCommissionTx(tx, topUp.UserId, AffiliateCommissionSourceTopUp, topUp.TradeNo, int64(quota))
+		return err
 	})
 
 	if err != nil {
@@ -99,11 +116,114 @@ func Recharge(referenceId string, customerId string) (err error) {
 		return errors.New("充值失败，请稍后重试")
 	}
 
-	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%d", logger.FormatQuota(int(quota)), topUp.Amount))
+	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%d", logger.FormatQuota(int(quota)), topUp.Amount)+promoBonusLogSuffix(bonus))
+	SettlePostpaidStatementByTradeNo(topUp.TradeNo)
+	refreshLoyaltyTier(topUp.UserId)
+
+	return nil
+}
 
+// RechargeEpay 完成易支付充值订单（幂等）：Amount 为充值数量，按 QuotaPerUnit 折算额度
+func RechargeEpay(tradeNo string) error {
+	if tradeNo == "" {
+		return errors.New("未提供支付单号")
+	}
+
+	refCol := "`trade_no`"
+	if common.UsingPostgreSQL {
+		refCol = `"trade_no"`
+	}
+
+	var quotaToAdd int
+	var bonus int64
+	completed := false
+	topUp := &TopUp{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", tradeNo).First(topUp).Error; err != nil {
+			return errors.New("充值订单不存在")
+		}
+		// 易支付会重复通知，已完成的订单直接返回
+		if topUp.Status == common.TopUpStatusSuccess {
+			return nil
+		}
+		if topUp.Status != common.TopUpStatusPending {
+			return errors.New("充值订单状态错误")
+		}
+		topUp.CompleteTime = common.GetTimestamp()
+		topUp.Status = common.TopUpStatusSuccess
+		if err := tx.Save(topUp).Error; err != nil {
+			return err
+		}
+		dAmount := decimal.NewFromInt(topUp.Amount)
+		dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+		quotaToAdd = int(dAmount.Mul(dQuotaPerUnit).IntPart())
+		if due, ok, err := postpaidStatementDueTx(tx, topUp.TradeNo); err != nil {
+			return err
+		} else if ok {
+			quotaToAdd = int(due)
+		}
+		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", quotaToAdd)).Error; err != nil {
+			return err
+		}
+		completed = true
+		if err := RecordCreditGrantTx(tx, topUp.UserId, CreditSourceTopUp, topUp.TradeNo, int64(quotaToAdd)); err != nil {
+			return err
+		}
+		var err error
+		if bonus, err = completePromoCodeUsageTx(tx, topUp.TradeNo, int64(quotaToAdd)); err != nil {
+			return err
+		}
+		_, err = accrueAffiliateCommissionTx(tx, topUp.UserId, AffiliateCommissionSourceTopUp, topUp.TradeNo, int64(quotaToAdd))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return nil
+	}
+	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", logger.LogQuota(quotaToAdd), topUp.Money)+promoBonusLogSuffix(bonus))
+	SettlePostpaidStatementByTradeNo(topUp.TradeNo)
+	refreshLoyaltyTier(topUp.UserId)
 	return nil
 }
 
+// SetPaymentProviderTradeNo 记录支付平台侧的流水号，用于退款和对账
+func SetPaymentProviderTradeNo(tradeNo string, providerTradeNo string) {
+	if tradeNo == "" || providerTradeNo == "" {
+		return
+	}
+	_ = DB.Model(&TopUp{}).Where("trade_no = ? AND provider_trade_no = ?", tradeNo, "").
+		Update("provider_trade_no", providerTradeNo).Error
+	_ = DB.Model(&SubscriptionOrder{}).Where("trade_no = ? AND provider_trade_no = ?", tradeNo, "").
+		Update("provider_trade_no", providerTradeNo).Error
+}
+
+// ExpireTopUp 将待支付的充值订单标记为过期（支付平台关闭订单或管理员驳回人工转账）
+func ExpireTopUp(tradeNo string) error {
+	if tradeNo == "" {
+		return errors.New("未提供订单号")
+	}
+	refCol := "`trade_no`"
+	if common.UsingPostgreSQL {
+		refCol = `"trade_no"`
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		topUp := &TopUp{}
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", tradeNo).First(topUp).Error; err != nil {
+			return errors.New("充值订单不存在")
+		}
+		if topUp.Status != common.TopUpStatusPending {
+			return errors.New("充值订单状态错误")
+		}
+		topUp.Status = common.TopUpStatusExpired
+		if err := tx.Save(topUp).Error; err != nil {
+			return err
+		}
+		return expirePromoCodeUsageTx(tx, topUp.TradeNo)
+	})
+}
+
 func GetUserTopUps(userId int, pageInfo *common.PageInfo) (topups []*TopUp, total int64, err error) {
 	// Start transaction
 	tx := DB.Begin()
@@ -248,6 +368,7 @@ func ManualCompleteTopUp(tradeNo string) error {
 
 	var userId int
 	var quotaToAdd int
+	var bonus int64
 	var payMoney float64
 
 	err := DB.Transaction(func(tx *gorm.DB) error {
@@ -277,6 +398,11 @@ func ManualCompleteTopUp(tradeNo string) error {
 			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
 			quotaToAdd = int(dAmount.Mul(dQuotaPerUnit).IntPart())
 		}
+		if due, ok, err := postpaidStatementDueTx(tx, topUp.TradeNo); err != nil {
+			return err
+		} else if ok {
+			quotaToAdd = int(due)
+		}
 		if quotaToAdd <= 0 {
 			return errors.New("无效的充值额度")
 		}
@@ -292,6 +418,16 @@ func ManualCompleteTopUp(tradeNo string) error {
 		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", quotaToAdd)).Error; err != nil {
 			return err
 		}
+		if err := RecordCreditGrantTx(tx, topUp.UserId, CreditSourceTopUp, topUp.TradeNo, int64(quotaToAdd)); err != nil {
+			return err
+		}
+		var err error
+		if bonus, err = completePromoCodeUsageTx(tx, topUp.TradeNo, int64(quotaToAdd)); err != nil {
+			return err
+		}
+		if _, err = accrueAffiliateCommissionTx(tx, topUp.UserId, AffiliateCommissionSourceTopUp, topUp.TradeNo, int64(quotaToAdd)); err != nil {
+			return err
+		}
 
 		userId = topUp.UserId
 		payMoney = topUp.Money
@@ -303,7 +439,9 @@ func ManualCompleteTopUp(tradeNo string) error {
 	}
 
 	// 事务外记录日志，避免阻塞
-	RecordLog(userId, LogTypeTopup, fmt.Sprintf("管理员补单成功，充值金额: %v，支付金额：%f", logger.FormatQuota(quotaToAdd), payMoney))
+	RecordLog(userId, LogTypeTopup, fmt.Sprintf("管理员补单成功，充值金额: %v，支付金额：%f", logger.FormatQuota(quotaToAdd), payMoney)+promoBonusLogSuffix(bonus))
+	SettlePostpaidStatementByTradeNo(tradeNo)
+	refreshLoyaltyTier(userId)
 	return nil
 }
 func RechargeCreem(referenceId string, customerEmail string, customerName string) (err error) {
@@ -312,6 +450,7 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 	}
 
 	var quota int64
+	var bonus int64
 	topUp := &TopUp{}
 
 	refCol := "`trade_no`"
@@ -338,6 +477,11 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 
 		// Creem 直接使用 Amount 作为充值额度（整数）
 		quota = topUp.Amount
+		if due, ok, err := postpaidStatementDueTx(tx, topUp.TradeNo); err != nil {
+			return err
+		} else if ok {
+			quota = due
+		}
 
 		// 构建更新字段，优先使用邮箱，如果邮箱为空则使用用户名
 		updateFields := map[string]interface{}{
@@ -364,7 +508,14 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 			return err
 		}
 
-		return nil
+		if err := RecordCreditGrantTx(tx, topUp.UserId, CreditSourceTopUp, topUp.TradeNo, quota); err != nil {
+			return err
+		}
+		if bonus, err = completePromoCodeUsageTx(tx, topUp.TradeNo, quota); err != nil {
+			return err
+		}
+		_, err = accrueAffiliateCommissionTx(tx, topUp.UserId, AffiliateCommissionSourceTopUp, topUp.TradeNo, quota)
+		return err
 	})
 
 	if err != nil {
@@ -372,7 +523,9 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 		return errors.New("充值失败，请稍后重试")
 	}
 
-	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用Creem充值成功，充值额度: %v，支付金额：%.2f", quota, topUp.Money))
+	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用Creem充值成功，充值额度: %v，支付金额：%.2f", quota, topUp.Money)+promoBonusLogSuffix(bonus))
+	SettlePostpaidStatementByTradeNo(topUp.TradeNo)
+	refreshLoyaltyTier(topUp.UserId)
 
 	return nil
 }
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTopUpTables(t *testing.T) {
+	t.Helper()
+	require.NoError(t, DB.AutoMigrate(&TopUp{}, &SubscriptionOrder{}, &CreditGrant{}, &PostpaidStatement{},
+		&PromoCode{}, &PromoCodeUsage{}, &AffiliateCommission{}))
+	t.Cleanup(func() {
+		for _, table := range []string{"top_ups", "subscription_orders", "credit_grants", "postpaid_statements",
+			"promo_codes", "promo_code_usages", "affiliate_commissions"} {
+			DB.Exec("DELETE FROM " + table)
+		}
+	})
+	truncateTables(t)
+}
+
+func TestRechargeEpay_Idempotent(t *testing.T) {
+	setupTopUpTables(t)
+	require.NoError(t, DB.Create(&User{Id: 1, Username: "epay_user", AffCode: "ep01"}).Error)
+	require.NoError(t, (&TopUp{UserId: 1, Amount: 2, Money: 14, TradeNo: "USR1NOabc", PaymentMethod: "alipay",
+		Status: common.TopUpStatusPending}).Insert())
+
+	require.NoError(t, RechargeEpay("USR1NOabc"))
+	require.NoError(t, RechargeEpay("USR1NOabc"))
+
+	var user User
+	require.NoError(t, DB.First(&user, 1).Error)
+	assert.Equal(t, int(2*common.QuotaPerUnit), user.Quota)
+	assert.Equal(t, common.TopUpStatusSuccess, GetTopUpByTradeNo("USR1NOabc").Status)
+
+	SetPaymentProviderTradeNo("USR1NOabc", "2024010100001")
+	SetPaymentProviderTradeNo("USR1NOabc", "2024010100002")
+	assert.Equal(t, "2024010100001", GetTopUpByTradeNo("USR1NOabc").ProviderTradeNo)
+}
+
+func TestExpireTopUp_OnlyPending(t *testing.T) {
+	setupTopUpTables(t)
+	require.NoError(t, (&TopUp{UserId: 1, Amount: 1, TradeNo: "manual-1", Status: common.TopUpStatusPending}).Insert())
+	require.NoError(t, (&TopUp{UserId: 1, Amount: 1, TradeNo: "manual-2", Status: common.TopUpStatusSuccess}).Insert())
+
+	require.NoError(t, ExpireTopUp("manual-1"))
+	assert.Equal(t, common.TopUpStatusExpired, GetTopUpByTradeNo("manual-1").Status)
+	assert.Error(t, ExpireTopUp("manual-2"))
+	assert.Error(t, ExpireTopUp("missing"))
+}
@@ -26,14 +26,16 @@ type User struct {
 	Password         string         `json:"password" gorm:"not null;" validate:"min=8,max=20"`
 	OriginalPassword string         `json:"original_password" gorm:"-:all"` // this field is only for Password change verification, don't save it to database!
 	DisplayName      string         `json:"display_name" gorm:"index" validate:"max=20"`
-	Role             int            `json:"role" gorm:"type:int;default:1"`   // admin, common
-	Status           int            `json:"status" gorm:"type:int;default:1"` // enabled, disabled
+	Role             int            `json:"role" gorm:"type:int;default:1"`          // admin, common
+	RoleId           int            `json:"role_id" gorm:"type:int;default:0;index"` // 自定义角色，0 表示使用与 Role 对应的内置角色
+	Status           int            `json:"status" gorm:"type:int;default:1"`        // enabled, disabled
 	Email            string         `json:"email" gorm:"index" validate:"max=50"`
 	GitHubId         string         `json:"github_id" gorm:"column:github_id;index"`
 	DiscordId        string         `json:"discord_id" gorm:"column:discord_id;index"`
 	OidcId           string         `json:"oidc_id" gorm:"column:oidc_id;index"`
 	WeChatId         string         `json:"wechat_id" gorm:"column:wechat_id;index"`
 	TelegramId       string         `json:"telegram_id" gorm:"column:telegram_id;index"`
+	LdapId           string         `json:"ldap_id" gorm:"column:ldap_id;index"`
 	VerificationCode string         `json:"verification_code" gorm:"-:all"`                                    // this field is only for Email verification, don't save it to database!
 	AccessToken      *string        `json:"access_token" gorm:"type:char(32);column:access_token;uniqueIndex"` // this token is for system management
 	Quota            int            `json:"quota" gorm:"type:int;default:0"`
@@ -45,6 +47,7 @@ type User struct {
 	AffQuota         int            `json:"aff_quota" gorm:"type:int;default:0;column:aff_quota"`           // 邀请剩余额度
 	AffHistoryQuota  int            `json:"aff_history_quota" gorm:"type:int;default:0;column:aff_history"` // 邀请历史额度
 	InviterId        int            `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	InvitedTime      int64          `json:"invited_time" gorm:"bigint;default:0"` // 建立邀请关系的时间，用于计算返佣期限
 	DeletedAt        gorm.DeletedAt `gorm:"index"`
 	LinuxDOId        string         `json:"linux_do_id" gorm:"column:linux_do_id;index"`
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
@@ -61,6 +64,8 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+		Role:     user.Role,
+		RoleId:   user.RoleId,
 	}
 	return cache
 }
@@ -339,6 +344,15 @@ func inviteUser(inviterId int) (err error) {
 	return DB.Save(user).Error
 }
 
+// bindInviter 记录邀请关系，供邀请返佣使用
+func (user *User) bindInviter(inviterId int) {
+	if inviterId <= 0 {
+		return
+	}
+	user.InviterId = inviterId
+	user.InvitedTime = common.GetTimestamp()
+}
+
 func (user *User) TransferAffQuotaToQuota(quota int) error {
 	// 检查quota是否小于最小额度
 	if float64(quota) < common.QuotaPerUnit {
@@ -372,6 +386,10 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 		return err
 	}
 
+	if err := RecordCreditGrantTx(tx, user.Id, CreditSourceAffiliate, "", int64(quota)); err != nil {
+		return err
+	}
+
 	// 提交事务
 	return tx.Commit().Error
 }
@@ -387,6 +405,7 @@ func (user *User) Insert(inviterId int) error {
 	user.Quota = common.QuotaForNewUser
 	//user.SetAccessToken(common.GetUUID())
 	user.AffCode = common.GetRandomString(4)
+	user.bindInviter(inviterId)
 
 	// 初始化用户设置，包括默认的边栏配置
 	if user.Setting == "" {
@@ -416,11 +435,13 @@ func (user *User) Insert(inviterId int) error {
 	}
 
 	if common.QuotaForNewUser > 0 {
+		RecordCreditGrant(user.Id, CreditSourcePromotion, "new_user", int64(common.QuotaForNewUser))
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", logger.LogQuota(common.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
 			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true)
+			RecordCreditGrant(user.Id, CreditSourcePromotion, "invitee", int64(common.QuotaForInvitee))
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", logger.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -445,6 +466,7 @@ func (user *User) InsertWithTx(tx *gorm.DB, inviterId int) error {
 	}
 	user.Quota = common.QuotaForNewUser
 	user.AffCode = common.GetRandomString(4)
+	user.bindInviter(inviterId)
 
 	// 初始化用户设置
 	if user.Setting == "" {
@@ -477,11 +499,13 @@ func (user *User) FinalizeOAuthUserCreation(inviterId int) {
 	}
 
 	if common.QuotaForNewUser > 0 {
+		RecordCreditGrant(user.Id, CreditSourcePromotion, "new_user", int64(common.QuotaForNewUser))
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", logger.LogQuota(common.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
 			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true)
+			RecordCreditGrant(user.Id, CreditSourcePromotion, "invitee", int64(common.QuotaForInvitee))
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", logger.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -552,6 +576,7 @@ func (user *User) ClearBinding(bindingType string) error {
 		"wechat":   "wechat_id",
 		"telegram": "telegram_id",
 		"linuxdo":  "linux_do_id",
+		"ldap":     "ldap_id",
 	}
 
 	column, ok := bindingColumnMap[bindingType]
@@ -657,6 +682,14 @@ func (user *User) FillUserByOidcId() error {
 	return nil
 }
 
+func (user *User) FillUserByLdapId() error {
+	if user.LdapId == "" {
+		return errors.New("ldap id 为空！")
+	}
+	DB.Where(User{LdapId: user.LdapId}).First(user)
+	return nil
+}
+
 func (user *User) FillUserByWeChatId() error {
 	if user.WeChatId == "" {
 		return errors.New("WeChat id 为空！")
@@ -696,6 +729,10 @@ func IsOidcIdAlreadyTaken(oidcId string) bool {
 	return DB.Where("oidc_id = ?", oidcId).Find(&User{}).RowsAffected == 1
 }
 
+func IsLdapIdAlreadyTaken(ldapId string) bool {
+	return DB.Unscoped().Where("ldap_id = ?", ldapId).Find(&User{}).RowsAffected == 1
+}
+
 func IsTelegramIdAlreadyTaken(telegramId string) bool {
 	return DB.Unscoped().Where("telegram_id = ?", telegramId).Find(&User{}).RowsAffected == 1
 }
@@ -951,6 +988,15 @@ func UpdateUserUsedQuotaAndRequestCount(id int, quota int) {
 	updateUserUsedQuotaAndRequestCount(id, quota, 1)
 }
 
+// UpdateUserUsedQuota 只增加已用额度，不计请求次数（如异步任务差额补扣）
+func UpdateUserUsedQuota(id int, quota int) {
+	if common.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeUsedQuota, id, quota)
+		return
+	}
+	updateUserUsedQuota(id, quota)
+}
+
 func updateUserUsedQuotaAndRequestCount(id int, quota int, count int) {
 	err := DB.Model(&User{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
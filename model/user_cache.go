@@ -22,6 +22,8 @@ type UserBase struct {
 	Status   int    `json:"status"`
 	Username string `json:"username"`
 	Setting  string `json:"setting"`
+	Role     int    `json:"role"`
+	RoleId   int    `json:"role_id"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -107,6 +109,8 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+		Role:     user.Role,
+		RoleId:   user.RoleId,
 	}
 
 	return userCache, nil
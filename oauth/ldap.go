@@ -0,0 +1,281 @@
+package oauth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func init() {
+	Register("ldap", &LDAPProvider{})
+}
+
+// LDAPProvider implements username/password login against LDAP / Active Directory
+type LDAPProvider struct{}
+
+// ldapConn is the subset of *ldap.Conn used by the provider, replaced in tests
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+var dialLDAP = func(settings *system_setting.LDAPSettings) (ldapConn, error) {
+	timeout := time.Duration(settings.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+	if u, err := url.Parse(settings.Url); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	conn, err := ldap.DialURL(settings.Url, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if settings.StartTLS && strings.HasPrefix(strings.ToLower(settings.Url), "ldap://") {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (p *LDAPProvider) GetName() string {
+	return "LDAP"
+}
+
+func (p *LDAPProvider) IsEnabled() bool {
+	settings := system_setting.GetLDAPSettings()
+	return settings.Enabled && settings.Url != ""
+}
+
+// ExchangeToken is not supported, LDAP logins go through Authenticate
+func (p *LDAPProvider) ExchangeToken(ctx context.Context, code string, c *gin.Context) (*OAuthToken, error) {
+	return nil, NewOAuthError(i18n.MsgOAuthUnknownProvider, nil)
+}
+
+// GetUserInfo is not supported, LDAP logins go through Authenticate
+func (p *LDAPProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*OAuthUser, error) {
+	return nil, NewOAuthError(i18n.MsgOAuthUnknownProvider, nil)
+}
+
+func (p *LDAPProvider) AutoCreateUser() bool {
+	return system_setting.GetLDAPSettings().AutoCreateUser
+}
+
+// Authenticate looks up the user entry (as the service account if configured), binds as the user
+// to verify the password and collects the groups the user belongs to
+func (p *LDAPProvider) Authenticate(ctx context.Context, username string, password string) (*OAuthUser, error) {
+	settings := system_setting.GetLDAPSettings()
+	username = strings.TrimSpace(username)
+	// Most directories treat an empty password as an unauthenticated bind that always succeeds
+	if username == "" || password == "" {
+		return nil, NewOAuthError(i18n.MsgLDAPInvalidCredentials, nil)
+	}
+
+	conn, err := dialLDAP(settings)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("[LDAP] dial error: %s", err.Error()))
+		return nil, NewOAuthErrorWithRaw(i18n.MsgOAuthConnectFailed, map[string]any{"Provider": "LDAP"}, err.Error())
+	}
+	defer conn.Close()
+
+	if err := bindLDAPServiceAccount(conn, settings); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("[LDAP] service account bind error: %s", err.Error()))
+		return nil, NewOAuthErrorWithRaw(i18n.MsgOAuthConnectFailed, map[string]any{"Provider": "LDAP"}, err.Error())
+	}
+	entry, err := findLDAPUser(conn, settings, username)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("[LDAP] find user %s failed: %s", username, err.Error()))
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, NewOAuthError(i18n.MsgLDAPInvalidCredentials, nil)
+		}
+		logger.LogError(ctx, fmt.Sprintf("[LDAP] user bind error: %s", err.Error()))
+		return nil, NewOAuthErrorWithRaw(i18n.MsgOAuthConnectFailed, map[string]any{"Provider": "LDAP"}, err.Error())
+	}
+
+	var groups []string
+	if settings.GroupAttribute != "" {
+		groups = entry.GetEqualFoldAttributeValues(settings.GroupAttribute)
+	}
+	if settings.GroupSearchFilter != "" {
+		// Regular users may not be allowed to read group entries, search as the service account if configured
+		if err := bindLDAPServiceAccount(conn, settings); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("[LDAP] service account bind error: %s", err.Error()))
+			return nil, NewOAuthErrorWithRaw(i18n.MsgOAuthConnectFailed, map[string]any{"Provider": "LDAP"}, err.Error())
+		}
+		groupDNs, err := searchLDAPGroups(conn, settings, entry.DN)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("[LDAP] group search error: %s", err.Error()))
+			return nil, NewOAuthErrorWithRaw(i18n.MsgOAuthGetUserErr, nil, err.Error())
+		}
+		groups = append(groups, groupDNs...)
+	}
+
+	user := &OAuthUser{
+		ProviderUserID: ldapEntryId(entry, settings.IdAttribute),
+		Username:       ldapAttribute(entry, settings.UsernameAttribute),
+		DisplayName:    ldapAttribute(entry, settings.DisplayNameAttribute),
+		Email:          ldapAttribute(entry, settings.EmailAttribute),
+		Extra: map[string]any{
+			"dn":     entry.DN,
+			"groups": groups,
+		},
+	}
+	if user.Username == "" {
+		user.Username = username
+	}
+	logger.LogDebug(ctx, "[LDAP] Authenticate success: dn=%s, groups=%d", entry.DN, len(groups))
+	return user, nil
+}
+
+func bindLDAPServiceAccount(conn ldapConn, settings *system_setting.LDAPSettings) error {
+	if settings.BindDN == "" {
+		return nil
+	}
+	return conn.Bind(settings.BindDN, settings.BindPassword)
+}
+
+func findLDAPUser(conn ldapConn, settings *system_setting.LDAPSettings, username string) (*ldap.Entry, error) {
+	attributes := make([]string, 0, 5)
+	for _, attribute := range []string{settings.IdAttribute, settings.UsernameAttribute, settings.EmailAttribute,
+		settings.DisplayNameAttribute, settings.GroupAttribute} {
+		if attribute != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	request := ldap.NewSearchRequest(
+		settings.SearchBase, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, settings.TimeoutSeconds, false,
+		strings.ReplaceAll(settings.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		attributes, nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, NewOAuthError(i18n.MsgLDAPMultipleUsers, nil)
+		}
+		return nil, NewOAuthErrorWithRaw(i18n.MsgOAuthGetUserErr, nil, err.Error())
+	}
+	switch len(result.Entries) {
+	case 0:
+		// Same message as a wrong password so usernames cannot be probed
+		return nil, NewOAuthError(i18n.MsgLDAPInvalidCredentials, nil)
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, NewOAuthError(i18n.MsgLDAPMultipleUsers, nil)
+	}
+}
+
+func searchLDAPGroups(conn ldapConn, settings *system_setting.LDAPSettings, userDN string) ([]string, error) {
+	base := settings.GroupSearchBase
+	if base == "" {
+		base = settings.SearchBase
+	}
+	request := ldap.NewSearchRequest(
+		base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, settings.TimeoutSeconds, false,
+		strings.ReplaceAll(settings.GroupSearchFilter, "{dn}", ldap.EscapeFilter(userDN)),
+		[]string{"1.1"}, nil, // 1.1: no attributes, only DNs are needed
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+func ldapAttribute(entry *ldap.Entry, attribute string) string {
+	if attribute == "" {
+		return ""
+	}
+	return strings.TrimSpace(entry.GetEqualFoldAttributeValue(attribute))
+}
+
+// ldapEntryId returns the stable unique id of the entry. Binary values such as the
+// AD objectGUID are hex encoded; the lowercased DN is used when the attribute is missing
+func ldapEntryId(entry *ldap.Entry, attribute string) string {
+	if attribute != "" {
+		for _, attr := range entry.Attributes {
+			if !strings.EqualFold(attr.Name, attribute) || len(attr.ByteValues) == 0 || len(attr.ByteValues[0]) == 0 {
+				continue
+			}
+			raw := attr.ByteValues[0]
+			if utf8.Valid(raw) && strings.IndexFunc(string(raw), func(r rune) bool { return !unicode.IsPrint(r) }) == -1 {
+				return string(raw)
+			}
+			return hex.EncodeToString(raw)
+		}
+	}
+	return strings.ToLower(entry.DN)
+}
+
+// MatchLDAPGroupMapping returns the value of the first mapping matching any of the groups.
+// A mapping matches a group by full DN or by the CN of its first RDN, case-insensitively
+func MatchLDAPGroupMapping(mappings []system_setting.LDAPGroupMapping, groups []string) (string, bool) {
+	for _, mapping := range mappings {
+		want := strings.TrimSpace(mapping.Group)
+		if want == "" {
+			continue
+		}
+		for _, group := range groups {
+			if strings.EqualFold(want, group) || strings.EqualFold(want, ldapGroupCN(group)) {
+				return mapping.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+func ldapGroupCN(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 {
+		return ""
+	}
+	for _, attribute := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attribute.Type, "cn") {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+func (p *LDAPProvider) IsUserIDTaken(providerUserID string) bool {
+	return model.IsLdapIdAlreadyTaken(providerUserID)
+}
+
+func (p *LDAPProvider) FillUserByProviderID(user *model.User, providerUserID string) error {
+	user.LdapId = providerUserID
+	return user.FillUserByLdapId()
+}
+
+func (p *LDAPProvider) SetProviderUserID(user *model.User, providerUserID string) {
+	user.LdapId = providerUserID
+}
+
+func (p *LDAPProvider) GetProviderPrefix() string {
+	return "ldap_"
+}
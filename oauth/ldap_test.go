@@ -0,0 +1,171 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLDAPConn is an in-memory directory: passwords by DN and entries returned for every user search
+type fakeLDAPConn struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+	groups    []*ldap.Entry
+	filters   []string
+}
+
+func (f *fakeLDAPConn) Bind(username, password string) error {
+	if want, ok := f.passwords[username]; ok && want == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (f *fakeLDAPConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	f.filters = append(f.filters, request.Filter)
+	if len(request.Attributes) == 1 && request.Attributes[0] == "1.1" {
+		return &ldap.SearchResult{Entries: f.groups}, nil
+	}
+	return &ldap.SearchResult{Entries: f.entries}, nil
+}
+
+func (f *fakeLDAPConn) Close() error {
+	return nil
+}
+
+func withLDAPSettings(t *testing.T, update func(settings *system_setting.LDAPSettings)) {
+	settings := system_setting.GetLDAPSettings()
+	origin := *settings
+	t.Cleanup(func() { *settings = origin })
+	settings.Enabled = true
+	settings.Url = "ldap://127.0.0.1:389"
+	settings.BindDN = "cn=admin,dc=example,dc=org"
+	settings.BindPassword = "admin"
+	settings.SearchBase = "dc=example,dc=org"
+	if update != nil {
+		update(settings)
+	}
+}
+
+func withFakeLDAPConn(t *testing.T, conn *fakeLDAPConn) {
+	origin := dialLDAP
+	t.Cleanup(func() { dialLDAP = origin })
+	dialLDAP = func(settings *system_setting.LDAPSettings) (ldapConn, error) {
+		return conn, nil
+	}
+}
+
+func assertOAuthErrorKey(t *testing.T, err error, key string) {
+	var oauthErr *OAuthError
+	require.ErrorAs(t, err, &oauthErr)
+	assert.Equal(t, key, oauthErr.MsgKey)
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	withLDAPSettings(t, func(settings *system_setting.LDAPSettings) {
+		settings.GroupSearchFilter = "(member={dn})"
+	})
+	userDN := "uid=alice,ou=people,dc=example,dc=org"
+	conn := &fakeLDAPConn{
+		passwords: map[string]string{"cn=admin,dc=example,dc=org": "admin", userDN: "secret"},
+		entries: []*ldap.Entry{ldap.NewEntry(userDN, map[string][]string{
+			"entryUUID": {"0b7c6c2e-4f9a-4c1e-9d43-6a4c7a9a1f10"},
+			"uid":       {"alice"},
+			"mail":      {"alice@example.org"},
+			"cn":        {"Alice"},
+			"memberOf":  {"cn=developers,ou=groups,dc=example,dc=org"},
+		})},
+		groups: []*ldap.Entry{ldap.NewEntry("cn=admins,ou=groups,dc=example,dc=org", nil)},
+	}
+	withFakeLDAPConn(t, conn)
+	provider := &LDAPProvider{}
+
+	user, err := provider.Authenticate(context.Background(), "alice*", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "0b7c6c2e-4f9a-4c1e-9d43-6a4c7a9a1f10", user.ProviderUserID)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, "alice@example.org", user.Email)
+	assert.Equal(t, "Alice", user.DisplayName)
+	assert.Equal(t, []string{"cn=developers,ou=groups,dc=example,dc=org", "cn=admins,ou=groups,dc=example,dc=org"}, user.Extra["groups"])
+	// The login name and the user DN are escaped in filters
+	assert.Equal(t, `(&(objectClass=person)(uid=alice\2a))`, conn.filters[0])
+	assert.Equal(t, `(member=uid=alice,ou=people,dc=example,dc=org)`, conn.filters[1])
+
+	_, err = provider.Authenticate(context.Background(), "alice", "wrong")
+	assertOAuthErrorKey(t, err, i18n.MsgLDAPInvalidCredentials)
+
+	// An empty password is an unauthenticated bind that succeeds on most directories
+	_, err = provider.Authenticate(context.Background(), "alice", "")
+	assertOAuthErrorKey(t, err, i18n.MsgLDAPInvalidCredentials)
+
+	conn.entries = nil
+	_, err = provider.Authenticate(context.Background(), "bob", "secret")
+	assertOAuthErrorKey(t, err, i18n.MsgLDAPInvalidCredentials)
+
+	conn.entries = []*ldap.Entry{ldap.NewEntry("uid=a,dc=example,dc=org", nil), ldap.NewEntry("uid=b,dc=example,dc=org", nil)}
+	_, err = provider.Authenticate(context.Background(), "a", "secret")
+	assertOAuthErrorKey(t, err, i18n.MsgLDAPMultipleUsers)
+}
+
+func TestLDAPEntryId(t *testing.T) {
+	entry := &ldap.Entry{DN: "CN=Alice,OU=Users,DC=corp,DC=local", Attributes: []*ldap.EntryAttribute{
+		{Name: "objectGUID", ByteValues: [][]byte{{0x01, 0x02, 0xab, 0xff}}},
+		{Name: "uid", ByteValues: [][]byte{[]byte("alice")}},
+	}}
+	assert.Equal(t, "0102abff", ldapEntryId(entry, "objectguid"))
+	assert.Equal(t, "alice", ldapEntryId(entry, "uid"))
+	assert.Equal(t, "cn=alice,ou=users,dc=corp,dc=local", ldapEntryId(entry, "entryUUID"))
+	assert.Equal(t, "cn=alice,ou=users,dc=corp,dc=local", ldapEntryId(entry, ""))
+}
+
+func TestMatchLDAPGroupMapping(t *testing.T) {
+	mappings := []system_setting.LDAPGroupMapping{
+		{Group: "CN=Gateway Admins,OU=Groups,DC=corp,DC=local", Value: "admin"},
+		{Group: "developers", Value: "vip"},
+	}
+	value, ok := MatchLDAPGroupMapping(mappings, []string{"cn=developers,ou=groups,dc=corp,dc=local", "cn=gateway admins,ou=groups,dc=corp,dc=local"})
+	assert.True(t, ok)
+	assert.Equal(t, "admin", value)
+
+	value, ok = MatchLDAPGroupMapping(mappings, []string{"CN=Developers,OU=Groups,DC=corp,DC=local"})
+	assert.True(t, ok)
+	assert.Equal(t, "vip", value)
+
+	_, ok = MatchLDAPGroupMapping(mappings, []string{"cn=sales,ou=groups,dc=corp,dc=local", "not a dn"})
+	assert.False(t, ok)
+}
+
+// TestLDAPAuthenticateOpenLDAP runs against a real directory when LDAP_TEST_URL is set, e.g. a bitnami/openldap container:
+//
+//	docker run -p 1389:1389 -e LDAP_ADMIN_PASSWORD=admin -e LDAP_USERS=alice -e LDAP_PASSWORDS=secret bitnami/openldap
+//	LDAP_TEST_URL=ldap://127.0.0.1:1389 LDAP_TEST_BIND_DN=cn=admin,dc=example,dc=org LDAP_TEST_BIND_PASSWORD=admin \
+//	LDAP_TEST_SEARCH_BASE=dc=example,dc=org LDAP_TEST_USERNAME=alice LDAP_TEST_PASSWORD=secret go test ./oauth -run OpenLDAP
+func TestLDAPAuthenticateOpenLDAP(t *testing.T) {
+	ldapURL := os.Getenv("LDAP_TEST_URL")
+	if ldapURL == "" {
+		t.Skip("LDAP_TEST_URL not set")
+	}
+	withLDAPSettings(t, func(settings *system_setting.LDAPSettings) {
+		settings.Url = ldapURL
+		settings.BindDN = os.Getenv("LDAP_TEST_BIND_DN")
+		settings.BindPassword = os.Getenv("LDAP_TEST_BIND_PASSWORD")
+		settings.SearchBase = os.Getenv("LDAP_TEST_SEARCH_BASE")
+	})
+	provider := &LDAPProvider{}
+	username, password := os.Getenv("LDAP_TEST_USERNAME"), os.Getenv("LDAP_TEST_PASSWORD")
+
+	user, err := provider.Authenticate(context.Background(), username, password)
+	require.NoError(t, err)
+	assert.NotEmpty(t, user.ProviderUserID)
+	assert.Equal(t, username, user.Username)
+
+	_, err = provider.Authenticate(context.Background(), username, password+"-wrong")
+	assertOAuthErrorKey(t, err, i18n.MsgLDAPInvalidCredentials)
+}
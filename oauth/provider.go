@@ -34,3 +34,30 @@ type Provider interface {
 	// GetProviderPrefix returns the prefix for auto-generated usernames (e.g., "github_")
 	GetProviderPrefix() string
 }
+
+// BindingProvider defines providers configured in the database (custom OAuth and SAML)
+// whose accounts are bound through the user_oauth_bindings table instead of a user column
+type BindingProvider interface {
+	Provider
+
+	// GetProviderId returns the provider ID for binding purposes
+	GetProviderId() int
+}
+
+// AutoCreateProvider defines providers that can provision users just in time
+type AutoCreateProvider interface {
+	// AutoCreateUser returns whether unknown users are created on first login,
+	// regardless of whether self-registration is enabled
+	AutoCreateUser() bool
+}
+
+// PasswordProvider defines providers that authenticate with username and password directly
+// (e.g., LDAP) instead of the OAuth authorization code flow. The returned OAuthUser goes
+// through the same find-or-create logic as OAuth logins
+type PasswordProvider interface {
+	Provider
+	AutoCreateProvider
+
+	// Authenticate verifies the credentials and returns the user information
+	Authenticate(ctx context.Context, username string, password string) (*OAuthUser, error)
+}
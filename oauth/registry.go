@@ -71,6 +71,21 @@ func GetEnabledCustomProviders() []*GenericOAuthProvider {
 	return result
 }
 
+// GetEnabledSAMLProviders returns all enabled SAML providers
+func GetEnabledSAMLProviders() []*SAMLProvider {
+	mu.RLock()
+	defer mu.RUnlock()
+	var result []*SAMLProvider
+	for name, provider := range providers {
+		if customProviderSlugs[name] {
+			if sp, ok := provider.(*SAMLProvider); ok && sp.IsEnabled() {
+				result = append(result, sp)
+			}
+		}
+	}
+	return result
+}
+
 // IsProviderRegistered checks if a provider is registered
 func IsProviderRegistered(name string) bool {
 	mu.RLock()
@@ -105,8 +120,7 @@ func LoadCustomProviders() error {
 
 	// Register each custom provider
 	for _, config := range customProviders {
-		provider := NewGenericOAuthProvider(config)
-		RegisterCustom(config.Slug, provider)
+		RegisterCustom(config.Slug, newCustomProvider(config))
 		common.SysLog("Loaded custom OAuth provider: " + config.Name + " (" + config.Slug + ")")
 	}
 
@@ -119,9 +133,17 @@ func ReloadCustomProviders() error {
 	return LoadCustomProviders()
 }
 
+// newCustomProvider creates the provider for a custom provider config according to its protocol
+func newCustomProvider(config *model.CustomOAuthProvider) Provider {
+	if config.IsSAML() {
+		return NewSAMLProvider(config)
+	}
+	return NewGenericOAuthProvider(config)
+}
+
 // RegisterOrUpdateCustomProvider registers or updates a single custom provider
 func RegisterOrUpdateCustomProvider(config *model.CustomOAuthProvider) {
-	provider := NewGenericOAuthProvider(config)
+	provider := newCustomProvider(config)
 	mu.Lock()
 	defer mu.Unlock()
 	providers[config.Slug] = provider
@@ -0,0 +1,559 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/crewjam/saml"
+	"github.com/gin-gonic/gin"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// samlRequestTTL is how long an AuthnRequest waits for the IdP response
+	samlRequestTTL = 10 * time.Minute
+	// samlTicketTTL is how long the frontend has to exchange a validated assertion
+	samlTicketTTL = 5 * time.Minute
+)
+
+// SAMLProvider implements SAML 2.0 single sign-on for an identity provider stored in custom_oauth_providers.
+//
+// The browser flow is: the login endpoint redirects to the IdP with a signed AuthnRequest, the IdP posts
+// the assertion to the ACS endpoint, which validates it and redirects to the frontend OAuth callback with
+// a one-time ticket as the code. The regular OAuth callback then exchanges the ticket, so state checking,
+// account binding and registration work the same as for OAuth providers. The session cookie is SameSite
+// strict and is not sent with the cross-site ACS post, hence the ticket
+type SAMLProvider struct {
+	config *model.CustomOAuthProvider
+}
+
+// samlPendingRequest is an AuthnRequest waiting for the IdP response, keyed by RelayState
+type samlPendingRequest struct {
+	Slug      string `json:"slug"`
+	RequestId string `json:"request_id"`
+	State     string `json:"state"` // OAuth state of the frontend, checked by the OAuth callback
+}
+
+// samlTicket is a validated assertion waiting to be exchanged by the OAuth callback
+type samlTicket struct {
+	Slug string     `json:"slug"`
+	User *OAuthUser `json:"user"`
+}
+
+// NewSAMLProvider creates a new SAML provider from config
+func NewSAMLProvider(config *model.CustomOAuthProvider) *SAMLProvider {
+	return &SAMLProvider{config: config}
+}
+
+func (p *SAMLProvider) GetName() string {
+	return p.config.Name
+}
+
+func (p *SAMLProvider) IsEnabled() bool {
+	return p.config.Enabled
+}
+
+func (p *SAMLProvider) GetConfig() *model.CustomOAuthProvider {
+	return p.config
+}
+
+func (p *SAMLProvider) AutoCreateUser() bool {
+	return p.config.AutoCreateUser
+}
+
+// MetadataURL returns the SP metadata URL, which is also used as the SP entity ID
+func (p *SAMLProvider) MetadataURL() string {
+	return fmt.Sprintf("%s/api/saml/%s/metadata", strings.TrimRight(system_setting.ServerAddress, "/"), p.config.Slug)
+}
+
+// AcsURL returns the assertion consumer service URL the IdP posts responses to
+func (p *SAMLProvider) AcsURL() string {
+	return fmt.Sprintf("%s/api/saml/%s/acs", strings.TrimRight(system_setting.ServerAddress, "/"), p.config.Slug)
+}
+
+// serviceProvider builds the SAML service provider from the current configuration
+func (p *SAMLProvider) serviceProvider() (*saml.ServiceProvider, error) {
+	cert, key, err := ParseSAMLKeyPair(p.config.SamlSpCertificate, p.config.SamlSpPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	idpMetadata, err := p.idpMetadata()
+	if err != nil {
+		return nil, err
+	}
+	metadataURL, err := url.Parse(p.MetadataURL())
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(p.AcsURL())
+	if err != nil {
+		return nil, err
+	}
+	// Transient NameIDs change on every login and cannot identify users, let the IdP choose by default
+	nameIdFormat := saml.UnspecifiedNameIDFormat
+	if p.config.SamlNameIdFormat != "" {
+		nameIdFormat = saml.NameIDFormat(p.config.SamlNameIdFormat)
+	}
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: nameIdFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+	}, nil
+}
+
+// idpMetadata returns the IdP metadata, either parsed from the configured XML or built from
+// the entity ID, SSO URL and certificate
+func (p *SAMLProvider) idpMetadata() (*saml.EntityDescriptor, error) {
+	if raw := strings.TrimSpace(p.config.SamlIdpMetadata); raw != "" {
+		return parseSAMLIdPMetadata([]byte(raw))
+	}
+	certData, err := samlCertificateData(p.config.SamlIdpCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IdP certificate: %w", err)
+	}
+	return &saml.EntityDescriptor{
+		EntityID: p.config.SamlIdpEntityId,
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{
+			SSODescriptor: saml.SSODescriptor{
+				RoleDescriptor: saml.RoleDescriptor{
+					ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+					KeyDescriptors: []saml.KeyDescriptor{{
+						Use: "signing",
+						KeyInfo: saml.KeyInfo{X509Data: saml.X509Data{
+							X509Certificates: []saml.X509Certificate{{Data: certData}},
+						}},
+					}},
+				},
+			},
+			SingleSignOnServices: []saml.Endpoint{{Binding: saml.HTTPRedirectBinding, Location: p.config.SamlIdpSsoUrl}},
+		}},
+	}, nil
+}
+
+// Metadata returns the SP metadata XML to be imported into the IdP
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	sp, err := p.serviceProvider()
+	if err != nil {
+		return nil, err
+	}
+	return xml.MarshalIndent(sp.Metadata(), "", "  ")
+}
+
+// MakeAuthnRequestURL returns the IdP URL carrying a signed AuthnRequest. The state is the
+// frontend OAuth state and is handed back to the OAuth callback after the ACS step
+func (p *SAMLProvider) MakeAuthnRequestURL(ctx context.Context, state string) (string, error) {
+	sp, err := p.serviceProvider()
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("[SAML-%s] invalid configuration: %s", p.config.Slug, err.Error()))
+		return "", NewOAuthErrorWithRaw(i18n.MsgSAMLConfigInvalid, map[string]any{"Provider": p.config.Name}, err.Error())
+	}
+	request, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", err
+	}
+	// RelayState is not escaped by the library, keep it alphanumeric
+	relayState := common.GetRandomString(32)
+	if err := saveSAMLState("saml_request:"+relayState, samlPendingRequest{
+		Slug:      p.config.Slug,
+		RequestId: request.ID,
+		State:     state,
+	}, samlRequestTTL); err != nil {
+		return "", err
+	}
+	redirectURL, err := request.Redirect(relayState, sp)
+	if err != nil {
+		return "", err
+	}
+	logger.LogDebug(ctx, "[SAML-%s] AuthnRequest created: id=%s", p.config.Slug, request.ID)
+	return redirectURL.String(), nil
+}
+
+// HandleResponse validates the IdP response posted to the ACS endpoint and returns the frontend
+// OAuth callback URL carrying a one-time ticket. Only responses to requests made by
+// MakeAuthnRequestURL are accepted, IdP-initiated logins are rejected
+func (p *SAMLProvider) HandleResponse(ctx context.Context, r *http.Request) (string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", NewOAuthErrorWithRaw(i18n.MsgSAMLResponseInvalid, nil, err.Error())
+	}
+	var pending samlPendingRequest
+	if !takeSAMLState("saml_request:"+r.PostForm.Get("RelayState"), &pending) || pending.Slug != p.config.Slug {
+		return "", NewOAuthError(i18n.MsgOAuthStateInvalid, nil)
+	}
+	sp, err := p.serviceProvider()
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("[SAML-%s] invalid configuration: %s", p.config.Slug, err.Error()))
+		return "", NewOAuthErrorWithRaw(i18n.MsgSAMLConfigInvalid, map[string]any{"Provider": p.config.Name}, err.Error())
+	}
+	assertion, err := sp.ParseResponse(r, []string{pending.RequestId})
+	if err != nil {
+		// The library hides the reason behind a generic message, log the private error for troubleshooting
+		detail := err.Error()
+		var invalidErr *saml.InvalidResponseError
+		if errors.As(err, &invalidErr) && invalidErr.PrivateErr != nil {
+			detail = invalidErr.PrivateErr.Error()
+		}
+		logger.LogWarn(ctx, fmt.Sprintf("[SAML-%s] invalid response: %s", p.config.Slug, detail))
+		return "", NewOAuthErrorWithRaw(i18n.MsgSAMLResponseInvalid, nil, detail)
+	}
+	user, err := p.userFromAssertion(ctx, assertion)
+	if err != nil {
+		return "", err
+	}
+
+	ticket := common.GetRandomString(32)
+	if err := saveSAMLState("saml_ticket:"+ticket, samlTicket{Slug: p.config.Slug, User: user}, samlTicketTTL); err != nil {
+		return "", err
+	}
+	callback := url.Values{}
+	callback.Set("code", ticket)
+	callback.Set("state", pending.State)
+	return fmt.Sprintf("%s/oauth/%s?%s", strings.TrimRight(system_setting.ServerAddress, "/"), p.config.Slug, callback.Encode()), nil
+}
+
+// userFromAssertion maps the assertion subject and attributes to the OAuth user and applies the access policy
+func (p *SAMLProvider) userFromAssertion(ctx context.Context, assertion *saml.Assertion) (*OAuthUser, error) {
+	attributes := samlAssertionAttributes(assertion)
+	body, err := common.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+	bodyStr := string(body)
+
+	userId := samlAttribute(attributes, p.config.UserIdField)
+	if userId == "" {
+		logger.LogError(ctx, fmt.Sprintf("[SAML-%s] empty user ID (field: %s)", p.config.Slug, p.config.UserIdField))
+		return nil, NewOAuthError(i18n.MsgOAuthUserInfoEmpty, map[string]any{"Provider": p.config.Name})
+	}
+
+	policyRaw := strings.TrimSpace(p.config.AccessPolicy)
+	if policyRaw != "" {
+		policy, err := parseAccessPolicy(policyRaw)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("[SAML-%s] invalid access policy: %s", p.config.Slug, err.Error()))
+			return nil, NewOAuthErrorWithRaw(i18n.MsgOAuthGetUserErr, nil, "invalid access policy configuration")
+		}
+		allowed, failure := evaluateAccessPolicy(bodyStr, policy)
+		if !allowed {
+			message := renderAccessDeniedMessage(p.config.AccessDeniedMessage, p.config.Name, bodyStr, failure)
+			logger.LogWarn(ctx, fmt.Sprintf("[SAML-%s] access denied by policy: field=%s op=%s expected=%v current=%v",
+				p.config.Slug, failure.Field, failure.Op, failure.Expected, failure.Current))
+			return nil, &AccessDeniedError{Message: message}
+		}
+	}
+
+	user := &OAuthUser{
+		ProviderUserID: userId,
+		Username:       samlAttribute(attributes, p.config.UsernameField),
+		DisplayName:    samlAttribute(attributes, p.config.DisplayNameField),
+		Email:          samlAttribute(attributes, p.config.EmailField),
+		Extra: map[string]any{
+			"provider": p.config.Slug,
+		},
+	}
+	logger.LogDebug(ctx, "[SAML-%s] assertion accepted: id=%s, username=%s, email=%s", p.config.Slug, user.ProviderUserID, user.Username, user.Email)
+	return user, nil
+}
+
+// samlAssertionAttributes collects the NameID and attributes of the assertion. Attributes are keyed by
+// both Name and FriendlyName; single values are strings and multiple values are arrays
+func samlAssertionAttributes(assertion *saml.Assertion) map[string]any {
+	attributes := make(map[string]any)
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		attributes[model.SAMLNameIdField] = strings.TrimSpace(assertion.Subject.NameID.Value)
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := make([]string, 0, len(attribute.Values))
+			for _, value := range attribute.Values {
+				if value.NameID != nil {
+					values = append(values, strings.TrimSpace(value.NameID.Value))
+				} else {
+					values = append(values, strings.TrimSpace(value.Value))
+				}
+			}
+			var item any = values
+			if len(values) == 1 {
+				item = values[0]
+			}
+			for _, name := range []string{attribute.Name, attribute.FriendlyName} {
+				if _, exists := attributes[name]; name != "" && !exists {
+					attributes[name] = item
+				}
+			}
+		}
+	}
+	return attributes
+}
+
+// samlAttribute returns the first value of the attribute, matching the name exactly first and then case-insensitively
+func samlAttribute(attributes map[string]any, name string) string {
+	if name == "" {
+		return ""
+	}
+	value, ok := attributes[name]
+	if !ok {
+		for key, item := range attributes {
+			if strings.EqualFold(key, name) {
+				value, ok = item, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// ExchangeToken accepts the ticket issued by the ACS endpoint, the ticket is consumed by GetUserInfo
+func (p *SAMLProvider) ExchangeToken(ctx context.Context, code string, c *gin.Context) (*OAuthToken, error) {
+	if code == "" {
+		return nil, NewOAuthError(i18n.MsgOAuthInvalidCode, nil)
+	}
+	return &OAuthToken{AccessToken: code, TokenType: "SAML"}, nil
+}
+
+// GetUserInfo returns the user of a validated assertion, each ticket can only be used once
+func (p *SAMLProvider) GetUserInfo(ctx context.Context, token *OAuthToken) (*OAuthUser, error) {
+	var ticket samlTicket
+	if !takeSAMLState("saml_ticket:"+token.AccessToken, &ticket) || ticket.Slug != p.config.Slug || ticket.User == nil {
+		return nil, NewOAuthError(i18n.MsgOAuthInvalidCode, nil)
+	}
+	return ticket.User, nil
+}
+
+func (p *SAMLProvider) IsUserIDTaken(providerUserID string) bool {
+	return model.IsProviderUserIdTaken(p.config.Id, providerUserID)
+}
+
+func (p *SAMLProvider) FillUserByProviderID(user *model.User, providerUserID string) error {
+	foundUser, err := model.GetUserByOAuthBinding(p.config.Id, providerUserID)
+	if err != nil {
+		return err
+	}
+	*user = *foundUser
+	return nil
+}
+
+func (p *SAMLProvider) SetProviderUserID(user *model.User, providerUserID string) {
+	// Stored in the user_oauth_bindings table by the OAuth controller
+}
+
+func (p *SAMLProvider) GetProviderPrefix() string {
+	return p.config.Slug + "_"
+}
+
+// GetProviderId returns the provider ID for binding purposes
+func (p *SAMLProvider) GetProviderId() int {
+	return p.config.Id
+}
+
+// ValidateSAMLConfig checks that the SP key pair and the IdP metadata of config can be used for login
+func ValidateSAMLConfig(config *model.CustomOAuthProvider) error {
+	sp, err := NewSAMLProvider(config).serviceProvider()
+	if err != nil {
+		return err
+	}
+	if sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return errors.New("IdP has no SSO endpoint with the HTTP-Redirect binding")
+	}
+	for _, descriptor := range sp.IDPMetadata.IDPSSODescriptors {
+		for _, keyDescriptor := range descriptor.KeyDescriptors {
+			if (keyDescriptor.Use == "" || keyDescriptor.Use == "signing") && len(keyDescriptor.KeyInfo.X509Data.X509Certificates) > 0 {
+				return nil
+			}
+		}
+	}
+	return errors.New("IdP has no signing certificate")
+}
+
+// parseSAMLIdPMetadata parses an EntityDescriptor, or the first IdP in an EntitiesDescriptor
+func parseSAMLIdPMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err == nil && len(entity.IDPSSODescriptors) > 0 {
+		return &entity, nil
+	}
+	var entities saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &entities); err != nil {
+		return nil, fmt.Errorf("invalid IdP metadata: %w", err)
+	}
+	for i := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("invalid IdP metadata: no IDPSSODescriptor found")
+}
+
+// samlCertificateData returns the base64 DER of a PEM or bare base64 certificate
+func samlCertificateData(certificate string) (string, error) {
+	certificate = strings.TrimSpace(certificate)
+	if certificate == "" {
+		return "", errors.New("certificate is empty")
+	}
+	var der []byte
+	if block, _ := pem.Decode([]byte(certificate)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certificate), ""))
+		if err != nil {
+			return "", err
+		}
+		der = decoded
+	}
+	if _, err := x509.ParseCertificate(der); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// ParseSAMLKeyPair parses the PEM encoded SP certificate and RSA private key (PKCS#1 or PKCS#8)
+func ParseSAMLKeyPair(certificatePEM string, privateKeyPEM string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode([]byte(certificatePEM))
+	if certBlock == nil {
+		return nil, nil, errors.New("invalid SP certificate: PEM block not found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid SP certificate: %w", err)
+	}
+	keyBlock, _ := pem.Decode([]byte(privateKeyPEM))
+	if keyBlock == nil {
+		return nil, nil, errors.New("invalid SP private key: PEM block not found")
+	}
+	var key *rsa.PrivateKey
+	if key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+		if pkcs8Err != nil {
+			return nil, nil, fmt.Errorf("invalid SP private key: %w", pkcs8Err)
+		}
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, nil, errors.New("invalid SP private key: only RSA keys are supported")
+		}
+	}
+	if publicKey, ok := cert.PublicKey.(*rsa.PublicKey); !ok || !publicKey.Equal(&key.PublicKey) {
+		return nil, nil, errors.New("SP certificate does not match the private key")
+	}
+	return cert, key, nil
+}
+
+// GenerateSAMLKeyPair generates a self-signed SP certificate and RSA private key in PEM format
+func GenerateSAMLKeyPair(commonName string) (certificatePEM string, privateKeyPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certificatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	privateKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}))
+	return certificatePEM, privateKeyPEM, nil
+}
+
+// samlStateStore holds pending requests and tickets when Redis is disabled.
+// Multi-node deployments need Redis since the IdP response may reach another node
+var samlStateStore sync.Map
+
+type samlStateEntry struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+func saveSAMLState(key string, value any, ttl time.Duration) error {
+	data, err := common.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		return common.RedisSet(key, string(data), ttl)
+	}
+	// Drop expired entries of abandoned logins on write, there are only a few at a time
+	now := time.Now()
+	samlStateStore.Range(func(k, v any) bool {
+		if entry, ok := v.(samlStateEntry); ok && now.After(entry.ExpiresAt) {
+			samlStateStore.Delete(k)
+		}
+		return true
+	})
+	samlStateStore.Store(key, samlStateEntry{Value: string(data), ExpiresAt: now.Add(ttl)})
+	return nil
+}
+
+// takeSAMLState loads and deletes the entry, returns false if it does not exist, has expired or was taken concurrently
+func takeSAMLState(key string, value any) bool {
+	var data string
+	if common.RedisEnabled {
+		var err error
+		if data, err = common.RedisGet(key); err != nil {
+			return false
+		}
+		// Only the caller that actually deletes the key may use it
+		deleted, err := common.RDB.Del(context.Background(), key).Result()
+		if err != nil || deleted == 0 {
+			return false
+		}
+	} else {
+		v, ok := samlStateStore.LoadAndDelete(key)
+		if !ok {
+			return false
+		}
+		entry := v.(samlStateEntry)
+		if time.Now().After(entry.ExpiresAt) {
+			return false
+		}
+		data = entry.Value
+	}
+	return common.Unmarshal([]byte(data), value) == nil
+}
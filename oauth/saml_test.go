@@ -0,0 +1,243 @@
+package oauth
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIdPEntityId = "https://idp.example.com/metadata"
+
+// testSAMLIdP is an embedded identity provider signing responses with its own key pair
+type testSAMLIdP struct {
+	idp            *saml.IdentityProvider
+	certificatePEM string
+}
+
+func newTestSAMLIdP(t *testing.T) *testSAMLIdP {
+	certificatePEM, privateKeyPEM, err := GenerateSAMLKeyPair("idp.example.com")
+	require.NoError(t, err)
+	cert, key, err := ParseSAMLKeyPair(certificatePEM, privateKeyPEM)
+	require.NoError(t, err)
+	metadataURL, _ := url.Parse(testIdPEntityId)
+	return &testSAMLIdP{
+		idp: &saml.IdentityProvider{
+			Key:             key,
+			Certificate:     cert,
+			MetadataURL:     *metadataURL,
+			SignatureMethod: dsig.RSASHA256SignatureMethod,
+		},
+		certificatePEM: certificatePEM,
+	}
+}
+
+// respond answers the AuthnRequest carried by the redirect URL, as the IdP would after the user signs in
+func (i *testSAMLIdP) respond(t *testing.T, provider *SAMLProvider, redirectURL string, session *saml.Session) url.Values {
+	parsed, err := url.Parse(redirectURL)
+	require.NoError(t, err)
+	compressed, err := base64.StdEncoding.DecodeString(parsed.Query().Get("SAMLRequest"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	require.NoError(t, err)
+	var request saml.AuthnRequest
+	require.NoError(t, xml.Unmarshal(raw, &request))
+
+	idpRequest := &saml.IdpAuthnRequest{
+		IDP:                     i.idp,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, redirectURL, nil),
+		Request:                 request,
+		ServiceProviderMetadata: &saml.EntityDescriptor{EntityID: provider.MetadataURL()},
+		SPSSODescriptor:         &saml.SPSSODescriptor{},
+		ACSEndpoint:             &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: request.AssertionConsumerServiceURL},
+		Now:                     saml.TimeNow(),
+	}
+	require.NoError(t, saml.DefaultAssertionMaker{}.MakeAssertion(idpRequest, session))
+	form, err := idpRequest.PostBinding()
+	require.NoError(t, err)
+	return url.Values{"SAMLResponse": {form.SAMLResponse}, "RelayState": {parsed.Query().Get("RelayState")}}
+}
+
+func postACS(provider *SAMLProvider, form url.Values) (string, error) {
+	request := httptest.NewRequest(http.MethodPost, "/api/saml/"+provider.config.Slug+"/acs", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return provider.HandleResponse(context.Background(), request)
+}
+
+func newTestSAMLProvider(t *testing.T, idp *testSAMLIdP) *SAMLProvider {
+	originAddress, originRedisEnabled := system_setting.ServerAddress, common.RedisEnabled
+	t.Cleanup(func() { system_setting.ServerAddress, common.RedisEnabled = originAddress, originRedisEnabled })
+	system_setting.ServerAddress = "https://gateway.example.com/"
+	common.RedisEnabled = false
+
+	spCertificate, spPrivateKey, err := GenerateSAMLKeyPair("corp")
+	require.NoError(t, err)
+	config := &model.CustomOAuthProvider{
+		Id:                 7,
+		Name:               "Corp SSO",
+		Slug:               "corp",
+		Enabled:            true,
+		Protocol:           model.CustomOAuthProtocolSAML,
+		SamlIdpEntityId:    testIdPEntityId,
+		SamlIdpSsoUrl:      "https://idp.example.com/sso",
+		SamlIdpCertificate: idp.certificatePEM,
+		SamlSpCertificate:  spCertificate,
+		SamlSpPrivateKey:   spPrivateKey,
+		UserIdField:        model.SAMLNameIdField,
+		UsernameField:      "uid",
+		DisplayNameField:   "cn",
+		EmailField:         "mail",
+	}
+	require.NoError(t, ValidateSAMLConfig(config))
+	return NewSAMLProvider(config)
+}
+
+var testSAMLSession = &saml.Session{
+	ID:             "session-1",
+	NameID:         "00u1alice",
+	NameIDFormat:   string(saml.PersistentNameIDFormat),
+	UserName:       "alice",
+	UserEmail:      "alice@corp.example",
+	UserCommonName: "Alice Liddell",
+	Groups:         []string{"engineering", "oncall"},
+}
+
+func TestSAMLLoginFlow(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp)
+	assert.Equal(t, "https://gateway.example.com/api/saml/corp/acs", provider.AcsURL())
+
+	redirectURL, err := provider.MakeAuthnRequestURL(context.Background(), "frontend-state")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(redirectURL, "https://idp.example.com/sso?SAMLRequest="))
+
+	// The AuthnRequest is signed with the SP key over the raw query
+	parsed, _ := url.Parse(redirectURL)
+	signedPart, encodedSignature, found := strings.Cut(parsed.RawQuery, "&Signature=")
+	require.True(t, found)
+	signature, err := url.QueryUnescape(encodedSignature)
+	require.NoError(t, err)
+	rawSignature, err := base64.StdEncoding.DecodeString(signature)
+	require.NoError(t, err)
+	spCert, _, err := ParseSAMLKeyPair(provider.config.SamlSpCertificate, provider.config.SamlSpPrivateKey)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(signedPart))
+	assert.NoError(t, rsa.VerifyPKCS1v15(spCert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], rawSignature))
+
+	form := idp.respond(t, provider, redirectURL, testSAMLSession)
+	callbackURL, err := postACS(provider, form)
+	require.NoError(t, err)
+	callback, err := url.Parse(callbackURL)
+	require.NoError(t, err)
+	assert.Equal(t, "/oauth/corp", callback.Path)
+	assert.Equal(t, "frontend-state", callback.Query().Get("state"))
+
+	token, err := provider.ExchangeToken(context.Background(), callback.Query().Get("code"), nil)
+	require.NoError(t, err)
+	user, err := provider.GetUserInfo(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "00u1alice", user.ProviderUserID)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, "Alice Liddell", user.DisplayName)
+	assert.Equal(t, "alice@corp.example", user.Email)
+
+	// Tickets and requests can only be used once
+	_, err = provider.GetUserInfo(context.Background(), token)
+	assertOAuthErrorKey(t, err, i18n.MsgOAuthInvalidCode)
+	_, err = postACS(provider, form)
+	assertOAuthErrorKey(t, err, i18n.MsgOAuthStateInvalid)
+}
+
+func TestSAMLRejectsInvalidResponses(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp)
+
+	// Signed by another IdP
+	redirectURL, err := provider.MakeAuthnRequestURL(context.Background(), "state")
+	require.NoError(t, err)
+	_, err = postACS(provider, newTestSAMLIdP(t).respond(t, provider, redirectURL, testSAMLSession))
+	assertOAuthErrorKey(t, err, i18n.MsgSAMLResponseInvalid)
+
+	// Tampered after signing
+	redirectURL, err = provider.MakeAuthnRequestURL(context.Background(), "state")
+	require.NoError(t, err)
+	form := idp.respond(t, provider, redirectURL, testSAMLSession)
+	raw, _ := base64.StdEncoding.DecodeString(form.Get("SAMLResponse"))
+	form.Set("SAMLResponse", base64.StdEncoding.EncodeToString(bytes.ReplaceAll(raw, []byte("alice@corp.example"), []byte("admin@corp.example"))))
+	_, err = postACS(provider, form)
+	assertOAuthErrorKey(t, err, i18n.MsgSAMLResponseInvalid)
+
+	// Unsolicited (IdP-initiated) responses have no pending request
+	form = idp.respond(t, provider, redirectURL, testSAMLSession)
+	form.Set("RelayState", "unknown")
+	_, err = postACS(provider, form)
+	assertOAuthErrorKey(t, err, i18n.MsgOAuthStateInvalid)
+}
+
+func TestSAMLAccessPolicy(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp)
+	provider.config.AccessPolicy = `{"logic":"and","conditions":[{"field":"eduPersonAffiliation","op":"contains","value":"finance"}]}`
+	provider.config.AccessDeniedMessage = "finance only"
+
+	redirectURL, err := provider.MakeAuthnRequestURL(context.Background(), "state")
+	require.NoError(t, err)
+	_, err = postACS(provider, idp.respond(t, provider, redirectURL, testSAMLSession))
+	var deniedErr *AccessDeniedError
+	require.ErrorAs(t, err, &deniedErr)
+	assert.Equal(t, "finance only", deniedErr.Message)
+
+	provider.config.AccessPolicy = `{"logic":"and","conditions":[{"field":"eduPersonAffiliation","op":"contains","value":"engineering"}]}`
+	redirectURL, err = provider.MakeAuthnRequestURL(context.Background(), "state")
+	require.NoError(t, err)
+	_, err = postACS(provider, idp.respond(t, provider, redirectURL, testSAMLSession))
+	assert.NoError(t, err)
+}
+
+func TestSAMLIdPMetadataAndKeyPair(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	provider := newTestSAMLProvider(t, idp)
+
+	// The IdP can also be configured from its metadata XML
+	idpMetadata, err := xml.Marshal(idp.idp.Metadata())
+	require.NoError(t, err)
+	config := *provider.config
+	config.SamlIdpMetadata = string(idpMetadata)
+	config.SamlIdpEntityId, config.SamlIdpSsoUrl, config.SamlIdpCertificate = "", "", ""
+	assert.Error(t, ValidateSAMLConfig(&config), "the test IdP metadata has no SSO endpoint")
+	metadataURL, _ := url.Parse("https://idp.example.com/sso")
+	idp.idp.SSOURL = *metadataURL
+	idpMetadata, err = xml.Marshal(idp.idp.Metadata())
+	require.NoError(t, err)
+	config.SamlIdpMetadata = string(idpMetadata)
+	assert.NoError(t, ValidateSAMLConfig(&config))
+
+	spMetadata, err := provider.Metadata()
+	require.NoError(t, err)
+	assert.Contains(t, string(spMetadata), `entityID="https://gateway.example.com/api/saml/corp/metadata"`)
+	assert.Contains(t, string(spMetadata), `AuthnRequestsSigned="true"`)
+
+	_, otherKey, err := GenerateSAMLKeyPair("other")
+	require.NoError(t, err)
+	_, _, err = ParseSAMLKeyPair(provider.config.SamlSpCertificate, otherKey)
+	assert.Error(t, err)
+}
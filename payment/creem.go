@@ -0,0 +1,420 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ProviderCreem        = "creem"
+	CreemSignatureHeader = "creem-signature"
+)
+
+func init() {
+	Register(ProviderCreem, &CreemProvider{})
+}
+
+// CreemProvider Creem 按产品收款，充值产品为一次性付款，订阅产品由 Creem 自动续费
+type CreemProvider struct{}
+
+var (
+	errCreemSignature = errors.New("creem webhook signature verify failed")
+	errCreemPayload   = errors.New("creem webhook payload invalid")
+)
+
+type CreemProduct struct {
+	ProductId string  `json:"productId"`
+	Name      string  `json:"name"`
+	Price     float64 `json:"price"`
+	Currency  string  `json:"currency"`
+	Quota     int64   `json:"quota"`
+	Units     int64   `json:"-"` // 购买数量，仅用于后付费账单支付
+}
+
+// GetCreemProducts 解析后台配置的 Creem 充值产品列表
+func GetCreemProducts() ([]CreemProduct, error) {
+	var products []CreemProduct
+	if err := json.Unmarshal([]byte(setting.CreemProducts), &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// 生成HMAC-SHA256签名
+func generateCreemSignature(payload string, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 验证Creem webhook签名
+func verifyCreemSignature(payload string, signature string, secret string) bool {
+	if secret == "" {
+		log.Printf("Creem webhook secret not set")
+		if setting.CreemTestMode {
+			log.Printf("Skip Creem webhook sign verify in test mode")
+			return true
+		}
+		return false
+	}
+
+	expectedSignature := generateCreemSignature(payload, secret)
+	return hmac.Equal([]byte(signature), []byte(expectedSignature))
+}
+
+// CreemWebhookEvent 匹配实际的webhook数据格式
+type CreemWebhookEvent struct {
+	Id        string `json:"id"`
+	EventType string `json:"eventType"`
+	CreatedAt int64  `json:"created_at"`
+	Object    struct {
+		Id        string `json:"id"`
+		Object    string `json:"object"`
+		RequestId string `json:"request_id"`
+		Order     struct {
+			Object      string `json:"object"`
+			Id          string `json:"id"`
+			Customer    string `json:"customer"`
+			Product     string `json:"product"`
+			Amount      int    `json:"amount"`
+			Currency    string `json:"currency"`
+			SubTotal    int    `json:"sub_total"`
+			TaxAmount   int    `json:"tax_amount"`
+			AmountDue   int    `json:"amount_due"`
+			AmountPaid  int    `json:"amount_paid"`
+			Status      string `json:"status"`
+			Type        string `json:"type"`
+			Transaction string `json:"transaction"`
+			CreatedAt   string `json:"created_at"`
+			UpdatedAt   string `json:"updated_at"`
+			Mode        string `json:"mode"`
+		} `json:"order"`
+		Product struct {
+			Id                string  `json:"id"`
+			Object            string  `json:"object"`
+			Name              string  `json:"name"`
+			Description       string  `json:"description"`
+			Price             int     `json:"price"`
+			Currency          string  `json:"currency"`
+			BillingType       string  `json:"billing_type"`
+			BillingPeriod     string  `json:"billing_period"`
+			Status            string  `json:"status"`
+			TaxMode           string  `json:"tax_mode"`
+			TaxCategory       string  `json:"tax_category"`
+			DefaultSuccessUrl *string `json:"default_success_url"`
+			CreatedAt         string  `json:"created_at"`
+			UpdatedAt         string  `json:"updated_at"`
+			Mode              string  `json:"mode"`
+		} `json:"product"`
+		Units    int `json:"units"`
+		Customer struct {
+			Id        string `json:"id"`
+			Object    string `json:"object"`
+			Email     string `json:"email"`
+			Name      string `json:"name"`
+			Country   string `json:"country"`
+			CreatedAt string `json:"created_at"`
+			UpdatedAt string `json:"updated_at"`
+			Mode      string `json:"mode"`
+		} `json:"customer"`
+		Status   string            `json:"status"`
+		Metadata map[string]string `json:"metadata"`
+		Mode     string            `json:"mode"`
+		// 订阅相关字段：checkout.completed 中为订阅对象（或ID），subscription.* 事件中 Object 即订阅本身
+		Subscription         json.RawMessage `json:"subscription"`
+		LastTransactionId    string          `json:"last_transaction_id"`
+		CurrentPeriodEndDate string          `json:"current_period_end_date"`
+		// 退款和争议事件中关联的结账信息
+		Checkout struct {
+			Id        string `json:"id"`
+			RequestId string `json:"request_id"`
+		} `json:"checkout"`
+		RefundAmount int    `json:"refund_amount"`
+		Amount       int    `json:"amount"`
+		Reason       string `json:"reason"`
+	} `json:"object"`
+}
+
+type creemCheckoutRequest struct {
+	ProductId string `json:"product_id"`
+	RequestId string `json:"request_id"`
+	Customer  struct {
+		Email string `json:"email"`
+	} `json:"customer"`
+	Units    int64             `json:"units,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type creemCheckoutResponse struct {
+	CheckoutUrl string `json:"checkout_url"`
+	Id          string `json:"id"`
+}
+
+func creemApiBase() string {
+	// 根据测试模式选择 API 端点
+	if setting.CreemTestMode {
+		return "https://test-api.creem.io/v1"
+	}
+	return "https://api.creem.io/v1"
+}
+
+func creemPost(path string, data any) ([]byte, error) {
+	if setting.CreemApiKey == "" {
+		return nil, fmt.Errorf("未配置Creem API密钥")
+	}
+	jsonData, err := common.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求数据失败: %v", err)
+	}
+	req, err := http.NewRequest("POST", creemApiBase()+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建HTTP请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", setting.CreemApiKey)
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送HTTP请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+	log.Printf("Creem API resp - path: %s, status code: %d, resp: %s", path, resp.StatusCode, string(body))
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("Creem API http status %d ", resp.StatusCode)
+	}
+	return body, nil
+}
+
+func (p *CreemProvider) Name() string {
+	return ProviderCreem
+}
+
+func (p *CreemProvider) IsEnabled() bool {
+	return setting.CreemApiKey != "" && (setting.CreemWebhookSecret != "" || setting.CreemTestMode)
+}
+
+// CreateCheckout 创建 Creem 结账链接，ProductId 为必填；用户邮箱会在支付页面预填充
+func (p *CreemProvider) CreateCheckout(req *CheckoutRequest) (*CheckoutResult, error) {
+	if req.ProductId == "" {
+		return nil, errors.New("请选择产品")
+	}
+	if req.DiscountPercent > 0 {
+		return nil, errors.New("Creem 暂不支持使用优惠码")
+	}
+	requestData := creemCheckoutRequest{
+		ProductId: req.ProductId,
+		RequestId: req.TradeNo, // 这个作为订单ID传递给Creem
+		Metadata:  req.Metadata,
+	}
+	if req.Units > 1 {
+		requestData.Units = req.Units
+	}
+	if req.User != nil {
+		requestData.Customer.Email = req.User.Email
+		if requestData.Metadata == nil {
+			requestData.Metadata = map[string]string{}
+		}
+		requestData.Metadata["username"] = req.User.Username
+	}
+	if requestData.Metadata != nil {
+		requestData.Metadata["reference_id"] = req.TradeNo
+		requestData.Metadata["product_name"] = req.Title
+	}
+
+	log.Printf("发送Creem支付请求 - 产品ID: %s, 订单号: %s", req.ProductId, req.TradeNo)
+	body, err := creemPost("/checkouts", requestData)
+	if err != nil {
+		return nil, err
+	}
+	var checkoutResp creemCheckoutResponse
+	if err := common.Unmarshal(body, &checkoutResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if checkoutResp.CheckoutUrl == "" {
+		return nil, fmt.Errorf("Creem API resp no checkout url ")
+	}
+	log.Printf("Creem 支付链接创建成功 - 订单号: %s, 支付链接: %s", req.TradeNo, checkoutResp.CheckoutUrl)
+	return &CheckoutResult{URL: checkoutResp.CheckoutUrl}, nil
+}
+
+func (p *CreemProvider) ParseWebhook(c *gin.Context) ([]*Event, error) {
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("读取Creem Webhook请求body失败: %v", err)
+		return nil, errCreemPayload
+	}
+
+	signature := c.GetHeader(CreemSignatureHeader)
+	// 打印关键信息（避免输出完整敏感payload）
+	log.Printf("Creem Webhook - URI: %s", c.Request.RequestURI)
+	if setting.CreemTestMode {
+		log.Printf("Creem Webhook - Signature: %s , Body: %s", signature, bodyBytes)
+	} else if signature == "" {
+		log.Printf("Creem Webhook缺少签名头")
+		return nil, errCreemSignature
+	}
+	if !verifyCreemSignature(string(bodyBytes), signature, setting.CreemWebhookSecret) {
+		log.Printf("Creem Webhook签名验证失败")
+		return nil, errCreemSignature
+	}
+
+	var webhookEvent CreemWebhookEvent
+	if err := common.Unmarshal(bodyBytes, &webhookEvent); err != nil {
+		log.Printf("解析Creem Webhook参数失败: %v", err)
+		return nil, errCreemPayload
+	}
+	log.Printf("Creem Webhook解析成功 - EventType: %s, EventId: %s", webhookEvent.EventType, webhookEvent.Id)
+
+	object := &webhookEvent.Object
+	switch webhookEvent.EventType {
+	case "checkout.completed":
+		if object.Order.Status != "paid" {
+			log.Printf("订单状态不是已支付: %s, 跳过处理", object.Order.Status)
+			return nil, nil
+		}
+		// 引用ID即创建订单时传递的request_id
+		if object.RequestId == "" {
+			log.Println("Creem Webhook缺少request_id字段")
+			return nil, errCreemPayload
+		}
+		return []*Event{{
+			Type:           EventPaid,
+			TradeNo:        object.RequestId,
+			PaymentRef:     object.Order.Id,
+			Money:          float64(object.Order.AmountPaid) / 100,
+			Payload:        common.GetJsonString(webhookEvent),
+			CustomerId:     object.Customer.Id,
+			CustomerEmail:  object.Customer.Email,
+			CustomerName:   object.Customer.Name,
+			SubscriptionId: creemSubscriptionId(object.Subscription),
+		}}, nil
+	case "subscription.paid":
+		paymentRef := object.LastTransactionId
+		if paymentRef == "" {
+			paymentRef = webhookEvent.Id
+		}
+		var periodEnd int64
+		if t, err := time.Parse(time.RFC3339, object.CurrentPeriodEndDate); err == nil {
+			periodEnd = t.Unix()
+		}
+		return []*Event{{
+			Type:           EventRenewed,
+			TradeNo:        paymentRef,
+			PaymentRef:     paymentRef,
+			Money:          float64(object.Product.Price) / 100,
+			Payload:        common.GetJsonString(webhookEvent),
+			SubscriptionId: object.Id,
+			PeriodEnd:      periodEnd,
+		}}, nil
+	case "subscription.past_due":
+		return []*Event{{Type: EventRenewalFailed, SubscriptionId: object.Id}}, nil
+	case "subscription.scheduled_cancel":
+		return []*Event{{Type: EventSubscriptionUpdated, SubscriptionId: object.Id, CancelAtPeriodEnd: true}}, nil
+	case "subscription.canceled", "subscription.expired":
+		return []*Event{{Type: EventSubscriptionEnded, SubscriptionId: object.Id}}, nil
+	case "refund.created":
+		return []*Event{{
+			Type:       EventRefunded,
+			TradeNo:    object.Checkout.RequestId,
+			PaymentRef: object.Order.Id,
+			Money:      float64(object.RefundAmount) / 100,
+			Payload:    common.GetJsonString(webhookEvent),
+			Reason:     "Creem 退款",
+		}}, nil
+	case "dispute.created":
+		return []*Event{{
+			Type:       EventDisputed,
+			TradeNo:    object.Checkout.RequestId,
+			PaymentRef: object.Order.Id,
+			Money:      float64(object.Amount) / 100,
+			Payload:    common.GetJsonString(webhookEvent),
+			Reason:     "Creem 拒付",
+		}}, nil
+	default:
+		log.Printf("忽略Creem Webhook事件类型: %s", webhookEvent.EventType)
+		return nil, nil
+	}
+}
+
+// creemSubscriptionId 解析 checkout.completed 中的订阅字段，兼容对象和字符串两种格式
+func creemSubscriptionId(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var id string
+	if err := common.Unmarshal(raw, &id); err == nil {
+		return id
+	}
+	var obj struct {
+		Id string `json:"id"`
+	}
+	if err := common.Unmarshal(raw, &obj); err == nil {
+		return obj.Id
+	}
+	return ""
+}
+
+func (p *CreemProvider) WriteWebhookResponse(c *gin.Context, err error) {
+	switch {
+	case err == nil:
+		c.Status(http.StatusOK)
+	case errors.Is(err, errCreemSignature):
+		c.AbortWithStatus(http.StatusUnauthorized)
+	case errors.Is(err, errCreemPayload):
+		c.AbortWithStatus(http.StatusBadRequest)
+	default:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+func (p *CreemProvider) FulfillTopUp(event *Event) error {
+	topUp := model.GetTopUpByTradeNo(event.TradeNo)
+	if topUp == nil {
+		return errors.New("充值订单不存在")
+	}
+	if topUp.Status != common.TopUpStatusPending {
+		log.Printf("Creem充值订单状态错误: %s, 当前状态: %s", event.TradeNo, topUp.Status)
+		return nil // 已处理过的订单，返回成功避免重复处理
+	}
+	if event.CustomerEmail == "" {
+		log.Printf("警告：Creem回调中客户邮箱为空 - 订单号: %s", event.TradeNo)
+	}
+	return model.RechargeCreem(event.TradeNo, event.CustomerEmail, event.CustomerName)
+}
+
+// Refund Creem 暂未开放退款 API，需在 Creem 后台操作
+func (p *CreemProvider) Refund(req *RefundRequest) error {
+	return ErrNotSupported
+}
+
+func (p *CreemProvider) QueryStatus(tradeNo string, paymentRef string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// CancelSubscription 请求 Creem 在当前周期结束后取消订阅
+func (p *CreemProvider) CancelSubscription(subscriptionId string) error {
+	_, err := creemPost("/subscriptions/"+url.PathEscape(subscriptionId)+"/cancel", map[string]string{"mode": "scheduled"})
+	return err
+}
@@ -0,0 +1,221 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Calcium-Ion/go-epay/epay"
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+const ProviderEpay = "epay"
+
+func init() {
+	Register(ProviderEpay, &EpayProvider{})
+}
+
+// EpayProvider 易支付（彩虹易支付兼容接口）
+type EpayProvider struct{}
+
+// GetEpayClient 根据当前配置创建易支付客户端，未配置时返回 nil
+func GetEpayClient() *epay.Client {
+	if operation_setting.PayAddress == "" || operation_setting.EpayId == "" || operation_setting.EpayKey == "" {
+		return nil
+	}
+	withUrl, err := epay.NewClient(&epay.Config{
+		PartnerID: operation_setting.EpayId,
+		Key:       operation_setting.EpayKey,
+	}, operation_setting.PayAddress)
+	if err != nil {
+		return nil
+	}
+	return withUrl
+}
+
+func (p *EpayProvider) Name() string {
+	return ProviderEpay
+}
+
+func (p *EpayProvider) IsEnabled() bool {
+	return operation_setting.PayAddress != "" && operation_setting.EpayId != "" && operation_setting.EpayKey != ""
+}
+
+func (p *EpayProvider) CreateCheckout(req *CheckoutRequest) (*CheckoutResult, error) {
+	if req.Recurring {
+		return nil, ErrNotSupported
+	}
+	if !operation_setting.ContainsPayMethod(req.Method) {
+		return nil, errors.New("支付方式不存在")
+	}
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("当前管理员未配置支付信息")
+	}
+	callBackAddress := service.GetCallbackAddress()
+	notifyPath := "/api/user/epay/notify"
+	returnURL := req.SuccessURL
+	if req.Kind == OrderKindSubscription {
+		notifyPath = "/api/subscription/epay/notify"
+		if returnURL == "" {
+			returnURL = callBackAddress + "/api/subscription/epay/return"
+		}
+	}
+	if returnURL == "" {
+		returnURL = system_setting.ServerAddress + "/console/log"
+	}
+	notifyUrl, err := url.Parse(callBackAddress + notifyPath)
+	if err != nil {
+		return nil, errors.New("回调地址配置错误")
+	}
+	returnUrl, err := url.Parse(returnURL)
+	if err != nil {
+		return nil, errors.New("回调地址配置错误")
+	}
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           req.Method,
+		ServiceTradeNo: req.TradeNo,
+		Name:           req.Title,
+		Money:          strconv.FormatFloat(req.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CheckoutResult{URL: uri, Params: params}, nil
+}
+
+func (p *EpayProvider) ParseWebhook(c *gin.Context) ([]*Event, error) {
+	var params map[string]string
+	if c.Request.Method == "POST" {
+		// POST 请求：从 POST body 解析参数
+		if err := c.Request.ParseForm(); err != nil {
+			return nil, err
+		}
+		params = lo.Reduce(lo.Keys(c.Request.PostForm), func(r map[string]string, t string, i int) map[string]string {
+			r[t] = c.Request.PostForm.Get(t)
+			return r
+		}, map[string]string{})
+	} else {
+		// GET 请求：从 URL Query 解析参数
+		params = lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
+			r[t] = c.Request.URL.Query().Get(t)
+			return r
+		}, map[string]string{})
+	}
+	if len(params) == 0 {
+		return nil, errors.New("易支付回调参数为空")
+	}
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("易支付未配置")
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil || !verifyInfo.VerifyStatus {
+		return nil, errors.New("易支付回调签名验证失败")
+	}
+	if verifyInfo.TradeStatus != epay.StatusTradeSuccess {
+		log.Printf("易支付异常回调: %v", verifyInfo)
+		return nil, nil
+	}
+	money, _ := strconv.ParseFloat(verifyInfo.Money, 64)
+	return []*Event{{
+		Type:       EventPaid,
+		TradeNo:    verifyInfo.ServiceTradeNo,
+		PaymentRef: verifyInfo.TradeNo,
+		Money:      money,
+		Payload:    common.GetJsonString(verifyInfo),
+	}}, nil
+}
+
+func (p *EpayProvider) WriteWebhookResponse(c *gin.Context, err error) {
+	if err != nil {
+		_, _ = c.Writer.Write([]byte("fail"))
+		return
+	}
+	_, _ = c.Writer.Write([]byte("success"))
+}
+
+func (p *EpayProvider) FulfillTopUp(event *Event) error {
+	return model.RechargeEpay(event.TradeNo)
+}
+
+type epayApiResponse struct {
+	Code   int    `json:"code"`
+	Msg    string `json:"msg"`
+	Status int    `json:"status"`
+}
+
+// epayApi 调用易支付商户 API（api.php）
+func epayApi(act string, values url.Values) (*epayApiResponse, error) {
+	if !(&EpayProvider{}).IsEnabled() {
+		return nil, errors.New("易支付未配置")
+	}
+	values.Set("act", act)
+	values.Set("pid", operation_setting.EpayId)
+	values.Set("key", operation_setting.EpayKey)
+	apiUrl := strings.TrimSuffix(operation_setting.PayAddress, "/") + "/api.php"
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(apiUrl, values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result epayApiResponse
+	if err := common.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("解析易支付响应失败: %v", err)
+	}
+	return &result, nil
+}
+
+func (p *EpayProvider) Refund(req *RefundRequest) error {
+	values := url.Values{}
+	values.Set("out_trade_no", req.TradeNo)
+	values.Set("money", strconv.FormatFloat(req.Money, 'f', 2, 64))
+	result, err := epayApi("refund", values)
+	if err != nil {
+		return err
+	}
+	if result.Code != 1 {
+		return fmt.Errorf("易支付退款失败: %s", result.Msg)
+	}
+	return nil
+}
+
+func (p *EpayProvider) QueryStatus(tradeNo string, paymentRef string) (string, error) {
+	values := url.Values{}
+	values.Set("out_trade_no", tradeNo)
+	result, err := epayApi("order", values)
+	if err != nil {
+		return "", err
+	}
+	if result.Code != 1 {
+		return "", fmt.Errorf("易支付查询失败: %s", result.Msg)
+	}
+	if result.Status == 1 {
+		return StatusSuccess, nil
+	}
+	return StatusPending, nil
+}
+
+func (p *EpayProvider) CancelSubscription(subscriptionId string) error {
+	return ErrNotSupported
+}
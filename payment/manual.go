@@ -0,0 +1,82 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+)
+
+const ProviderManual = "manual"
+
+func init() {
+	Register(ProviderManual, &ManualProvider{})
+}
+
+// ManualProvider 人工转账（银行转账等线下收款），由管理员在后台确认到账后完成订单
+type ManualProvider struct{}
+
+func (p *ManualProvider) Name() string {
+	return ProviderManual
+}
+
+func (p *ManualProvider) IsEnabled() bool {
+	return operation_setting.GetManualPaymentSetting().Enabled
+}
+
+// CreateCheckout 不跳转支付页，返回付款说明，订单保持待支付直到管理员确认
+func (p *ManualProvider) CreateCheckout(req *CheckoutRequest) (*CheckoutResult, error) {
+	if !p.IsEnabled() {
+		return nil, errors.New("管理员未开启人工转账")
+	}
+	instructions := strings.NewReplacer(
+		"{trade_no}", req.TradeNo,
+		"{money}", strconv.FormatFloat(req.Money, 'f', 2, 64),
+	).Replace(operation_setting.GetManualPaymentSetting().Instructions)
+	return &CheckoutResult{Instructions: instructions}, nil
+}
+
+func (p *ManualProvider) ParseWebhook(c *gin.Context) ([]*Event, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *ManualProvider) WriteWebhookResponse(c *gin.Context, err error) {
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (p *ManualProvider) FulfillTopUp(event *Event) error {
+	return model.ManualCompleteTopUp(event.TradeNo)
+}
+
+// Refund 线下退款，由管理员自行转账，这里只做记录
+func (p *ManualProvider) Refund(req *RefundRequest) error {
+	return nil
+}
+
+func (p *ManualProvider) QueryStatus(tradeNo string, paymentRef string) (string, error) {
+	status := ""
+	if topUp := model.GetTopUpByTradeNo(tradeNo); topUp != nil {
+		status = topUp.Status
+	} else if order := model.GetSubscriptionOrderByTradeNo(tradeNo); order != nil {
+		status = order.Status
+	} else {
+		return "", errors.New("订单不存在")
+	}
+	switch status {
+	case common.TopUpStatusSuccess:
+		return StatusSuccess, nil
+	case common.TopUpStatusExpired:
+		return StatusExpired, nil
+	default:
+		return StatusPending, nil
+	}
+}
+
+func (p *ManualProvider) CancelSubscription(subscriptionId string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,135 @@
+package payment
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+)
+
+// Order kinds
+const (
+	OrderKindTopUp        = "topup"
+	OrderKindSubscription = "subscription"
+)
+
+// Webhook event types
+const (
+	EventPaid                = "paid"                 // 订单支付成功
+	EventExpired             = "expired"              // 订单过期/关闭
+	EventRenewed             = "renewed"              // 订阅自动续费成功
+	EventRenewalFailed       = "renewal_failed"       // 订阅续费扣款失败（催缴中）
+	EventSubscriptionUpdated = "subscription_updated" // 订阅状态变化（如设置到期取消）
+	EventSubscriptionEnded   = "subscription_ended"   // 平台订阅已终止
+	EventRefunded            = "refunded"             // 支付平台已退款
+	EventDisputed            = "disputed"             // 用户发起拒付/争议
+)
+
+// Payment status returned by QueryStatus
+const (
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusExpired = "expired"
+)
+
+var ErrNotSupported = errors.New("该支付方式不支持此操作")
+
+// CheckoutRequest 创建支付所需的订单信息，订单记录由调用方在本地创建
+type CheckoutRequest struct {
+	Kind    string
+	TradeNo string
+	Title   string
+	Money   float64 // 实际支付金额
+	Units   int64   // 购买数量（Stripe 按价格ID计价）
+	Method  string  // 支付方式子类型，如易支付的 alipay / wxpay
+	// 平台商品：Stripe 价格ID 或 Creem 产品ID；为空时使用默认充值商品
+	ProductId string
+	Recurring bool
+	User      *model.User
+	// 优惠码折扣比例（百分比）。按平台商品计价的平台需在结账时抵扣，Money 已是折后金额
+	DiscountPercent float64
+	PromoCode       string
+
+	SuccessURL string
+	CancelURL  string
+	Metadata   map[string]string
+}
+
+// CheckoutResult 支付跳转信息
+type CheckoutResult struct {
+	URL          string
+	Params       map[string]string // 需要以表单提交的参数（易支付）
+	Instructions string            // 线下支付说明（人工转账）
+}
+
+// Link 将表单参数编码到 URL，便于以链接形式发送
+func (r *CheckoutResult) Link() string {
+	if len(r.Params) == 0 {
+		return r.URL
+	}
+	query := url.Values{}
+	for k, v := range r.Params {
+		query.Set(k, v)
+	}
+	return r.URL + "?" + query.Encode()
+}
+
+// Event 统一的支付回调事件
+type Event struct {
+	Type    string
+	TradeNo string // 本地订单号
+	// 平台侧的支付流水号，用于退款和查询
+	PaymentRef string
+	Money      float64
+	Payload    string
+	Reason     string // 退款或拒付原因
+
+	CustomerId    string
+	CustomerEmail string
+	CustomerName  string
+
+	// 订阅相关
+	SubscriptionId    string
+	PeriodEnd         int64
+	CancelAtPeriodEnd bool
+}
+
+// RefundRequest 退款请求
+type RefundRequest struct {
+	TradeNo    string
+	PaymentRef string
+	Money      float64 // 退款金额
+	Reason     string
+}
+
+// Provider 支付平台接口，充值和订阅共用
+type Provider interface {
+	// Name 返回支付平台标识，如 epay / stripe / creem
+	Name() string
+
+	// IsEnabled 是否已完成配置
+	IsEnabled() bool
+
+	// CreateCheckout 在支付平台创建支付，返回跳转信息
+	CreateCheckout(req *CheckoutRequest) (*CheckoutResult, error)
+
+	// ParseWebhook 验证回调签名并解析为统一事件；不关心的事件返回空列表
+	ParseWebhook(c *gin.Context) ([]*Event, error)
+
+	// WriteWebhookResponse 按平台要求响应回调
+	WriteWebhookResponse(c *gin.Context, err error)
+
+	// FulfillTopUp 完成充值订单并为用户增加额度
+	FulfillTopUp(event *Event) error
+
+	// Refund 向支付平台发起退款
+	Refund(req *RefundRequest) error
+
+	// QueryStatus 查询订单在支付平台的支付状态
+	QueryStatus(tradeNo string, paymentRef string) (string, error)
+
+	// CancelSubscription 在当前周期结束后取消平台订阅
+	CancelSubscription(subscriptionId string) error
+}
@@ -0,0 +1,43 @@
+package payment
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckoutResultLink(t *testing.T) {
+	result := &CheckoutResult{URL: "https://pay.example.com/submit.php"}
+	assert.Equal(t, "https://pay.example.com/submit.php", result.Link())
+
+	result.Params = map[string]string{"out_trade_no": "USR1NOabc", "money": "7.00"}
+	link, err := url.Parse(result.Link())
+	require.NoError(t, err)
+	assert.Equal(t, "USR1NOabc", link.Query().Get("out_trade_no"))
+	assert.Equal(t, "7.00", link.Query().Get("money"))
+}
+
+func TestManualProviderCheckout(t *testing.T) {
+	setting := operation_setting.GetManualPaymentSetting()
+	prev := *setting
+	t.Cleanup(func() { *setting = prev })
+
+	provider := GetProvider(ProviderManual)
+	require.NotNil(t, provider)
+
+	setting.Enabled = false
+	assert.NotContains(t, GetEnabledProviders(), provider)
+	_, err := provider.CreateCheckout(&CheckoutRequest{TradeNo: "USR1NOabc", Money: 10})
+	assert.Error(t, err)
+
+	setting.Enabled = true
+	setting.Instructions = "转账 {money} 元，备注订单号 {trade_no}"
+	assert.Contains(t, GetEnabledProviders(), provider)
+	result, err := provider.CreateCheckout(&CheckoutRequest{TradeNo: "USR1NOabc", Money: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "转账 10.00 元，备注订单号 USR1NOabc", result.Instructions)
+	assert.Empty(t, result.URL)
+}
@@ -0,0 +1,41 @@
+package payment
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	providers = make(map[string]Provider)
+	mu        sync.RWMutex
+)
+
+// Register registers a payment provider with the given name
+func Register(name string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[name] = provider
+}
+
+// GetProvider returns the payment provider for the given name
+func GetProvider(name string) Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return providers[name]
+}
+
+// GetEnabledProviders returns all configured payment providers sorted by name
+func GetEnabledProviders() []Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make([]Provider, 0, len(providers))
+	for _, provider := range providers {
+		if provider.IsEnabled() {
+			result = append(result, provider)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result
+}
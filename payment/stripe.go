@@ -0,0 +1,400 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/coupon"
+	"github.com/stripe/stripe-go/v81/paymentintent"
+	"github.com/stripe/stripe-go/v81/refund"
+	"github.com/stripe/stripe-go/v81/subscription"
+	"github.com/stripe/stripe-go/v81/webhook"
+)
+
+const ProviderStripe = "stripe"
+
+func init() {
+	Register(ProviderStripe, &StripeProvider{})
+}
+
+// StripeProvider Stripe Checkout，支持一次性支付和订阅模式
+type StripeProvider struct{}
+
+var errStripeWebhookStatus = errors.New("stripe webhook verify failed")
+
+func (p *StripeProvider) Name() string {
+	return ProviderStripe
+}
+
+func stripeKeyValid() bool {
+	return strings.HasPrefix(setting.StripeApiSecret, "sk_") || strings.HasPrefix(setting.StripeApiSecret, "rk_")
+}
+
+func (p *StripeProvider) IsEnabled() bool {
+	return stripeKeyValid() && setting.StripeWebhookSecret != ""
+}
+
+// CreateCheckout 创建 Stripe Checkout 会话。
+// 充值使用默认的 StripePriceId 按数量计价；订阅使用套餐配置的价格ID并以订阅模式创建。
+func (p *StripeProvider) CreateCheckout(req *CheckoutRequest) (*CheckoutResult, error) {
+	if !stripeKeyValid() {
+		return nil, fmt.Errorf("无效的Stripe API密钥")
+	}
+	stripe.Key = setting.StripeApiSecret
+
+	successURL := req.SuccessURL
+	cancelURL := req.CancelURL
+	if successURL == "" {
+		successURL = system_setting.ServerAddress + "/console/log"
+	}
+	if cancelURL == "" {
+		cancelURL = system_setting.ServerAddress + "/console/topup"
+	}
+	priceId := req.ProductId
+	if priceId == "" {
+		priceId = setting.StripePriceId
+	}
+	quantity := req.Units
+	if quantity <= 0 {
+		quantity = 1
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(req.TradeNo),
+		SuccessURL:        stripe.String(successURL),
+		CancelURL:         stripe.String(cancelURL),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(priceId),
+				Quantity: stripe.Int64(quantity),
+			},
+		},
+	}
+	if req.Recurring {
+		params.Mode = stripe.String(string(stripe.CheckoutSessionModeSubscription))
+	} else {
+		params.Mode = stripe.String(string(stripe.CheckoutSessionModePayment))
+	}
+	if req.DiscountPercent > 0 {
+		// 优惠码折扣通过一次性优惠券抵扣，订阅模式仅作用于首期账单
+		couponId, err := createStripeCoupon(req)
+		if err != nil {
+			return nil, err
+		}
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(couponId)}}
+	} else if !req.Recurring {
+		params.AllowPromotionCodes = stripe.Bool(setting.StripePromotionCodesEnabled)
+	}
+
+	var customerId, email string
+	if req.User != nil {
+		customerId = req.User.StripeCustomer
+		email = req.User.Email
+	}
+	if customerId == "" {
+		if email != "" {
+			params.CustomerEmail = stripe.String(email)
+		}
+		params.CustomerCreation = stripe.String(string(stripe.CheckoutSessionCustomerCreationAlways))
+	} else {
+		params.Customer = stripe.String(customerId)
+	}
+
+	result, err := session.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckoutResult{URL: result.URL}, nil
+}
+
+func createStripeCoupon(req *CheckoutRequest) (string, error) {
+	params := &stripe.CouponParams{
+		PercentOff:     stripe.Float64(req.DiscountPercent),
+		Duration:       stripe.String(string(stripe.CouponDurationOnce)),
+		MaxRedemptions: stripe.Int64(1),
+		RedeemBy:       stripe.Int64(time.Now().Add(24 * time.Hour).Unix()),
+	}
+	if req.PromoCode != "" {
+		params.Name = stripe.String(req.PromoCode)
+	}
+	result, err := coupon.New(params)
+	if err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+func (p *StripeProvider) ParseWebhook(c *gin.Context) ([]*Event, error) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, errStripeWebhookStatus
+	}
+	signature := c.GetHeader("Stripe-Signature")
+	event, err := webhook.ConstructEventWithOptions(payload, signature, setting.StripeWebhookSecret, webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		log.Printf("Stripe Webhook验签失败: %v\n", err)
+		return nil, errStripeWebhookStatus
+	}
+
+	switch event.Type {
+	case stripe.EventTypeCheckoutSessionCompleted:
+		return stripeSessionCompleted(event), nil
+	case stripe.EventTypeCheckoutSessionExpired:
+		return stripeSessionExpired(event), nil
+	case stripe.EventTypeInvoicePaid:
+		return stripeInvoicePaid(event), nil
+	case stripe.EventTypeInvoicePaymentFailed:
+		return stripeInvoicePaymentFailed(event), nil
+	case stripe.EventTypeCustomerSubscriptionUpdated:
+		return stripeSubscriptionUpdated(event), nil
+	case stripe.EventTypeCustomerSubscriptionDeleted:
+		return []*Event{{Type: EventSubscriptionEnded, SubscriptionId: event.GetObjectValue("id")}}, nil
+	case stripe.EventTypeChargeRefunded:
+		return stripeChargeRefunded(event), nil
+	case stripe.EventTypeChargeDisputeCreated:
+		return stripeDisputeCreated(event), nil
+	default:
+		log.Printf("不支持的Stripe Webhook事件类型: %s\n", event.Type)
+		return nil, nil
+	}
+}
+
+func stripeSessionCompleted(event stripe.Event) []*Event {
+	referenceId := event.GetObjectValue("client_reference_id")
+	status := event.GetObjectValue("status")
+	if "complete" != status {
+		log.Println("错误的Stripe Checkout完成状态:", status, ",", referenceId)
+		return nil
+	}
+	var checkout stripe.CheckoutSession
+	if err := common.Unmarshal(event.Data.Raw, &checkout); err != nil {
+		log.Println("解析Stripe Checkout失败:", err.Error())
+		return nil
+	}
+	paymentRef := ""
+	if checkout.PaymentIntent != nil {
+		paymentRef = checkout.PaymentIntent.ID
+	} else if checkout.Invoice != nil {
+		paymentRef = checkout.Invoice.ID
+	}
+	subscriptionId := ""
+	if checkout.Subscription != nil {
+		subscriptionId = checkout.Subscription.ID
+	}
+	payload := map[string]any{
+		"customer":     event.GetObjectValue("customer"),
+		"amount_total": event.GetObjectValue("amount_total"),
+		"currency":     strings.ToUpper(event.GetObjectValue("currency")),
+		"event_type":   string(event.Type),
+	}
+	return []*Event{{
+		Type:           EventPaid,
+		TradeNo:        referenceId,
+		PaymentRef:     paymentRef,
+		Money:          float64(checkout.AmountTotal) / 100,
+		Payload:        common.GetJsonString(payload),
+		CustomerId:     event.GetObjectValue("customer"),
+		SubscriptionId: subscriptionId,
+	}}
+}
+
+func stripeSessionExpired(event stripe.Event) []*Event {
+	referenceId := event.GetObjectValue("client_reference_id")
+	status := event.GetObjectValue("status")
+	if "expired" != status {
+		log.Println("错误的Stripe Checkout过期状态:", status, ",", referenceId)
+		return nil
+	}
+	if len(referenceId) == 0 {
+		log.Println("未提供支付单号")
+		return nil
+	}
+	return []*Event{{Type: EventExpired, TradeNo: referenceId}}
+}
+
+func parseStripeInvoice(event stripe.Event) *stripe.Invoice {
+	var invoice stripe.Invoice
+	if err := common.Unmarshal(event.Data.Raw, &invoice); err != nil {
+		log.Println("解析Stripe账单失败:", err.Error())
+		return nil
+	}
+	if invoice.Subscription == nil || invoice.Subscription.ID == "" {
+		return nil
+	}
+	return &invoice
+}
+
+func stripeInvoicePaid(event stripe.Event) []*Event {
+	invoice := parseStripeInvoice(event)
+	// 首期账单由 checkout.session.completed 处理
+	if invoice == nil || invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate {
+		return nil
+	}
+	var periodEnd int64
+	if invoice.Lines != nil {
+		for _, line := range invoice.Lines.Data {
+			if line.Period != nil && line.Period.End > periodEnd {
+				periodEnd = line.Period.End
+			}
+		}
+	}
+	payload := map[string]any{
+		"invoice":        invoice.ID,
+		"subscription":   invoice.Subscription.ID,
+		"amount_paid":    invoice.AmountPaid,
+		"currency":       strings.ToUpper(string(invoice.Currency)),
+		"billing_reason": string(invoice.BillingReason),
+		"event_type":     string(event.Type),
+	}
+	paymentRef := invoice.ID
+	if invoice.PaymentIntent != nil && invoice.PaymentIntent.ID != "" {
+		paymentRef = invoice.PaymentIntent.ID
+	}
+	return []*Event{{
+		Type:           EventRenewed,
+		TradeNo:        invoice.ID, // 续费订单以账单ID作为订单号
+		PaymentRef:     paymentRef,
+		Money:          float64(invoice.AmountPaid) / 100,
+		Payload:        common.GetJsonString(payload),
+		SubscriptionId: invoice.Subscription.ID,
+		PeriodEnd:      periodEnd,
+	}}
+}
+
+func stripeInvoicePaymentFailed(event stripe.Event) []*Event {
+	invoice := parseStripeInvoice(event)
+	if invoice == nil {
+		return nil
+	}
+	return []*Event{{Type: EventRenewalFailed, SubscriptionId: invoice.Subscription.ID}}
+}
+
+func stripeSubscriptionUpdated(event stripe.Event) []*Event {
+	var sub stripe.Subscription
+	if err := common.Unmarshal(event.Data.Raw, &sub); err != nil {
+		log.Println("解析Stripe订阅失败:", err.Error())
+		return nil
+	}
+	return []*Event{{
+		Type:              EventSubscriptionUpdated,
+		SubscriptionId:    sub.ID,
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+	}}
+}
+
+// stripeChargeRefunded 全额退款时撤销订单；部分退款只记录日志，由管理员处理
+func stripeChargeRefunded(event stripe.Event) []*Event {
+	var charge stripe.Charge
+	if err := common.Unmarshal(event.Data.Raw, &charge); err != nil {
+		log.Println("解析Stripe支付失败:", err.Error())
+		return nil
+	}
+	if !charge.Refunded {
+		log.Printf("Stripe部分退款，需人工处理: %s, 退款金额: %.2f", charge.ID, float64(charge.AmountRefunded)/100)
+		return nil
+	}
+	return []*Event{stripeChargeEvent(EventRefunded, &charge, float64(charge.AmountRefunded)/100, "Stripe 退款")}
+}
+
+func stripeDisputeCreated(event stripe.Event) []*Event {
+	var dispute stripe.Dispute
+	if err := common.Unmarshal(event.Data.Raw, &dispute); err != nil {
+		log.Println("解析Stripe争议失败:", err.Error())
+		return nil
+	}
+	charge := dispute.Charge
+	if charge == nil {
+		charge = &stripe.Charge{}
+	}
+	if charge.PaymentIntent == nil && dispute.PaymentIntent != nil {
+		charge.PaymentIntent = dispute.PaymentIntent
+	}
+	return []*Event{stripeChargeEvent(EventDisputed, charge, float64(dispute.Amount)/100,
+		fmt.Sprintf("Stripe 拒付: %s", dispute.Reason))}
+}
+
+func stripeChargeEvent(eventType string, charge *stripe.Charge, money float64, reason string) *Event {
+	event := &Event{
+		Type:   eventType,
+		Money:  money,
+		Reason: reason,
+	}
+	// 订阅账单的扣款以账单ID关联订单，一次性支付以 PaymentIntent 关联
+	if charge.Invoice != nil {
+		event.TradeNo = charge.Invoice.ID
+	}
+	if charge.PaymentIntent != nil {
+		event.PaymentRef = charge.PaymentIntent.ID
+	}
+	return event
+}
+
+func (p *StripeProvider) WriteWebhookResponse(c *gin.Context, err error) {
+	if errors.Is(err, errStripeWebhookStatus) {
+		c.AbortWithStatus(400)
+		return
+	}
+	// 业务处理失败已记录日志，返回 200 避免 Stripe 无限重试
+	c.Status(200)
+}
+
+func (p *StripeProvider) FulfillTopUp(event *Event) error {
+	return model.Recharge(event.TradeNo, event.CustomerId)
+}
+
+func (p *StripeProvider) Refund(req *RefundRequest) error {
+	if !strings.HasPrefix(req.PaymentRef, "pi_") {
+		return errors.New("缺少 Stripe 支付凭证，请在 Stripe 后台退款")
+	}
+	stripe.Key = setting.StripeApiSecret
+	params := &stripe.RefundParams{PaymentIntent: stripe.String(req.PaymentRef)}
+	if req.Money > 0 {
+		params.Amount = stripe.Int64(int64(req.Money*100 + 0.5))
+	}
+	if req.Reason != "" {
+		params.AddMetadata("reason", req.Reason)
+	}
+	_, err := refund.New(params)
+	return err
+}
+
+func (p *StripeProvider) QueryStatus(tradeNo string, paymentRef string) (string, error) {
+	if !strings.HasPrefix(paymentRef, "pi_") {
+		return "", ErrNotSupported
+	}
+	stripe.Key = setting.StripeApiSecret
+	intent, err := paymentintent.Get(paymentRef, nil)
+	if err != nil {
+		return "", err
+	}
+	switch intent.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		return StatusSuccess, nil
+	case stripe.PaymentIntentStatusCanceled:
+		return StatusFailed, nil
+	default:
+		return StatusPending, nil
+	}
+}
+
+// CancelSubscription 请求 Stripe 在当前周期结束后取消订阅
+func (p *StripeProvider) CancelSubscription(subscriptionId string) error {
+	stripe.Key = setting.StripeApiSecret
+	_, err := subscription.Update(subscriptionId, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	})
+	return err
+}
@@ -93,6 +93,10 @@ type RelayInfo struct {
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	isFirstResponse   bool
+	// 临时令牌的预算与父令牌额度同步预扣、结算；EphemeralTokenQuota 为 0 表示不单独限制预算
+	EphemeralTokenId        string
+	EphemeralTokenQuota     int
+	EphemeralTokenExpiresAt int64
 	//SendLastReasoningResponse bool
 	IsStream               bool
 	IsGeminiBatchEmbedding bool
@@ -458,6 +462,9 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 		TokenGroup:     tokenGroup,
 
+		EphemeralTokenId:    common.GetContextKeyString(c, constant.ContextKeyTokenEphemeralId),
+		EphemeralTokenQuota: common.GetContextKeyInt(c, constant.ContextKeyTokenEphemeralQuota),
+
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
 		RequestURLPath:  c.Request.URL.String(),
@@ -489,6 +496,9 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 	if ok {
 		info.UserSetting = userSetting
 	}
+	if expiresAt, ok := common.GetContextKeyType[int64](c, constant.ContextKeyTokenEphemeralExpires); ok {
+		info.EphemeralTokenExpiresAt = expiresAt
+	}
 
 	return info
 }
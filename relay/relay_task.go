@@ -134,6 +134,21 @@ func ResolveOriginTask(c *gin.Context, info *relaycommon.RelayInfo) *dto.TaskErr
 	return nil
 }
 
+// taskPromptText 从适配器校验后存入 context 的任务请求中取出提示词，用于预估 TPM
+func taskPromptText(c *gin.Context) string {
+	v, ok := c.Get("task_request")
+	if !ok {
+		return ""
+	}
+	switch req := v.(type) {
+	case relaycommon.TaskSubmitReq:
+		return req.Prompt
+	case *dto.SunoSubmitReq:
+		return req.GptDescriptionPrompt + req.Prompt
+	}
+	return ""
+}
+
 // RelayTaskSubmit 完成 task 提交的全部流程（每次尝试调用一次）：
 // 刷新渠道元数据 → 确定 platform/adaptor → 验证请求 →
 // 估算计费(EstimateBilling) → 计算价格 → 预扣费（仅首次）→
@@ -196,6 +211,16 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (*TaskSubmitRe
 		}
 	}
 
+	// 6.5 按提示词预估 token 数预占令牌 TPM（仅首次 — 重试时已预占，跳过）
+	if _, reserved := common.GetContextKey(c, constant.ContextKeyTokenTpmReserved); !reserved {
+		tokens := max(service.CountTextToken(taskPromptText(c), modelName), 1)
+		if apiErr := service.ReserveTokenTPM(c, tokens); apiErr != nil {
+			taskErr := service.TaskErrorFromAPIError(apiErr)
+			taskErr.LocalError = true
+			return nil, taskErr
+		}
+	}
+
 	// 7. 预扣费（仅首次 — 重试时 info.Billing 已存在，跳过）
 	if info.Billing == nil && !info.PriceData.FreeModel {
 		info.ForcePreConsume = true
@@ -23,7 +23,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/status", controller.GetStatus)
 		apiRouter.GET("/uptime/status", controller.GetUptimeKumaStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
-		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+		apiRouter.GET("/status/test", middleware.PermissionAuth("logs"), controller.TestStatus)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/user-agreement", controller.GetUserAgreement)
 		apiRouter.GET("/privacy-policy", controller.GetPrivacyPolicy)
@@ -44,10 +44,16 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/oauth/telegram/bind", middleware.CriticalRateLimit(), controller.TelegramBind)
 		// Standard OAuth providers (GitHub, Discord, OIDC, LinuxDO) - unified route
 		apiRouter.GET("/oauth/:provider", middleware.CriticalRateLimit(), controller.HandleOAuth)
+		// SAML 2.0 SP endpoints, login completes through the OAuth callback above
+		apiRouter.GET("/saml/:slug/metadata", controller.SAMLMetadata)
+		apiRouter.GET("/saml/:slug/login", middleware.CriticalRateLimit(), controller.SAMLLogin)
+		apiRouter.POST("/saml/:slug/acs", middleware.CriticalRateLimit(), controller.SAMLAssertionConsumerService)
 		apiRouter.GET("/ratio_config", middleware.CriticalRateLimit(), controller.GetRatioConfig)
 
 		apiRouter.POST("/stripe/webhook", controller.StripeWebhook)
 		apiRouter.POST("/creem/webhook", controller.CreemWebhook)
+		apiRouter.POST("/payment/:provider/webhook", controller.PaymentWebhook)
+		apiRouter.GET("/payment/:provider/webhook", controller.PaymentWebhook)
 
 		// Universal secure verification routes
 		apiRouter.POST("/verify", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.UniversalVerify)
@@ -57,6 +63,7 @@ func SetApiRouter(router *gin.Engine) {
 			userRoute.POST("/register", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Login)
 			userRoute.POST("/login/2fa", middleware.CriticalRateLimit(), controller.Verify2FALogin)
+			userRoute.POST("/login/ldap", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.LDAPLogin)
 			userRoute.POST("/passkey/login/begin", middleware.CriticalRateLimit(), controller.PasskeyLoginBegin)
 			userRoute.POST("/passkey/login/finish", middleware.CriticalRateLimit(), controller.PasskeyLoginFinish)
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
@@ -89,7 +96,14 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.POST("/stripe/pay", middleware.CriticalRateLimit(), controller.RequestStripePay)
 				selfRoute.POST("/stripe/amount", controller.RequestStripeAmount)
 				selfRoute.POST("/creem/pay", middleware.CriticalRateLimit(), controller.RequestCreemPay)
+				selfRoute.POST("/pay/:provider", middleware.CriticalRateLimit(), controller.RequestPaymentTopUp)
+				selfRoute.POST("/promo_code/check", middleware.CriticalRateLimit(), controller.CheckPromoCode)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
+				selfRoute.GET("/aff/commissions", controller.GetAffiliateCommissions)
+				selfRoute.GET("/self/credit_grants", controller.GetSelfCreditGrants)
+				selfRoute.GET("/self/recurring_grants", controller.GetSelfRecurringGrantRecords)
+				selfRoute.GET("/self/postpaid", controller.GetSelfPostpaid)
+				selfRoute.POST("/self/postpaid/statements/:id/pay", middleware.CriticalRateLimit(), controller.RequestPostpaidStatementPay)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
 
 				// 2FA routes
@@ -109,15 +123,20 @@ func SetApiRouter(router *gin.Engine) {
 			}
 
 			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth())
+			adminRoute.Use(middleware.PermissionAuth("users"))
 			{
 				adminRoute.GET("/", controller.GetAllUsers)
-				adminRoute.GET("/topup", controller.GetAllTopUps)
-				adminRoute.POST("/topup/complete", controller.AdminCompleteTopUp)
 				adminRoute.GET("/search", controller.SearchUsers)
+				adminRoute.GET("/aff/payout", controller.GetAffiliatePayoutReport)
 				adminRoute.GET("/:id/oauth/bindings", controller.GetUserOAuthBindingsByAdmin)
 				adminRoute.DELETE("/:id/oauth/bindings/:provider_id", controller.UnbindCustomOAuthByAdmin)
 				adminRoute.DELETE("/:id/bindings/:binding_type", controller.AdminClearUserBinding)
+				adminRoute.GET("/:id/credit_grants", controller.GetUserCreditGrants)
+				adminRoute.POST("/:id/credit_grants", controller.AdminGrantCredit)
+				adminRoute.GET("/:id/postpaid", controller.GetUserPostpaid)
+				adminRoute.PUT("/:id/postpaid", controller.UpdateUserPostpaid)
+				adminRoute.GET("/:id/statement", controller.GetUserStatement)
+				adminRoute.POST("/postpaid/statements/:id/paid", controller.AdminMarkPostpaidStatementPaid)
 				adminRoute.GET("/:id", controller.GetUser)
 				adminRoute.POST("/", controller.CreateUser)
 				adminRoute.POST("/manage", controller.ManageUser)
@@ -129,6 +148,18 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.GET("/2fa/stats", controller.Admin2FAStats)
 				adminRoute.DELETE("/:id/2fa", controller.AdminDisable2FA)
 			}
+
+			// 充值订单的补单、确认收款和退款涉及资金，按计费权限授权
+			paymentRoute := userRoute.Group("/topup")
+			paymentRoute.Use(middleware.PermissionAuth("billing"))
+			{
+				paymentRoute.GET("", controller.GetAllTopUps)
+				paymentRoute.POST("/complete", controller.AdminCompleteTopUp)
+				paymentRoute.POST("/manual/confirm", controller.AdminConfirmManualPayment)
+				paymentRoute.POST("/manual/reject", controller.AdminRejectManualPayment)
+				paymentRoute.GET("/payment_status", controller.AdminQueryPaymentStatus)
+				paymentRoute.POST("/refund", controller.AdminRefundPayment)
+			}
 		}
 
 		// Subscription billing (plans, purchase, admin management)
@@ -138,12 +169,16 @@ func SetApiRouter(router *gin.Engine) {
 			subscriptionRoute.GET("/plans", controller.GetSubscriptionPlans)
 			subscriptionRoute.GET("/self", controller.GetSubscriptionSelf)
 			subscriptionRoute.PUT("/self/preference", controller.UpdateSubscriptionPreference)
+			subscriptionRoute.GET("/self/change/quote", controller.GetSubscriptionChangeQuote)
+			subscriptionRoute.POST("/self/change", middleware.CriticalRateLimit(), controller.ChangeSubscription)
+			subscriptionRoute.POST("/self/:id/cancel", middleware.CriticalRateLimit(), controller.CancelSubscriptionAutoRenew)
 			subscriptionRoute.POST("/epay/pay", middleware.CriticalRateLimit(), controller.SubscriptionRequestEpay)
 			subscriptionRoute.POST("/stripe/pay", middleware.CriticalRateLimit(), controller.SubscriptionRequestStripePay)
 			subscriptionRoute.POST("/creem/pay", middleware.CriticalRateLimit(), controller.SubscriptionRequestCreemPay)
+			subscriptionRoute.POST("/pay/:provider", middleware.CriticalRateLimit(), controller.SubscriptionRequestPay)
 		}
 		subscriptionAdminRoute := apiRouter.Group("/subscription/admin")
-		subscriptionAdminRoute.Use(middleware.AdminAuth())
+		subscriptionAdminRoute.Use(middleware.PermissionAuth("billing"))
 		{
 			subscriptionAdminRoute.GET("/plans", controller.AdminListSubscriptionPlans)
 			subscriptionAdminRoute.POST("/plans", controller.AdminCreateSubscriptionPlan)
@@ -151,6 +186,12 @@ func SetApiRouter(router *gin.Engine) {
 			subscriptionAdminRoute.PATCH("/plans/:id", controller.AdminUpdateSubscriptionPlanStatus)
 			subscriptionAdminRoute.POST("/bind", controller.AdminBindSubscription)
 
+			// Plan allowance rules (admin)
+			subscriptionAdminRoute.GET("/plans/:id/allowances", controller.AdminListSubscriptionAllowances)
+			subscriptionAdminRoute.POST("/plans/:id/allowances", controller.AdminCreateSubscriptionAllowance)
+			subscriptionAdminRoute.PUT("/allowances/:id", controller.AdminUpdateSubscriptionAllowance)
+			subscriptionAdminRoute.DELETE("/allowances/:id", controller.AdminDeleteSubscriptionAllowance)
+
 			// User subscription management (admin)
 			subscriptionAdminRoute.GET("/users/:id/subscriptions", controller.AdminListUserSubscriptions)
 			subscriptionAdminRoute.POST("/users/:id/subscriptions", controller.AdminCreateUserSubscription)
@@ -164,7 +205,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/subscription/epay/return", controller.SubscriptionEpayReturn)
 		apiRouter.POST("/subscription/epay/return", controller.SubscriptionEpayReturn)
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.RootAuth())
+		optionRoute.Use(middleware.PermissionAuth("options"))
 		{
 			optionRoute.GET("/", controller.GetOptions)
 			optionRoute.PUT("/", controller.UpdateOption)
@@ -174,9 +215,9 @@ func SetApiRouter(router *gin.Engine) {
 			optionRoute.POST("/migrate_console_setting", controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
 		}
 
-		// Custom OAuth provider management (root only)
+		// Custom OAuth provider management
 		customOAuthRoute := apiRouter.Group("/custom-oauth-provider")
-		customOAuthRoute.Use(middleware.RootAuth())
+		customOAuthRoute.Use(middleware.PermissionAuth("options"))
 		{
 			customOAuthRoute.POST("/discovery", controller.FetchCustomOAuthDiscovery)
 			customOAuthRoute.GET("/", controller.GetCustomOAuthProviders)
@@ -185,8 +226,35 @@ func SetApiRouter(router *gin.Engine) {
 			customOAuthRoute.PUT("/:id", controller.UpdateCustomOAuthProvider)
 			customOAuthRoute.DELETE("/:id", controller.DeleteCustomOAuthProvider)
 		}
+		// SAML provider management
+		samlRoute := apiRouter.Group("/saml-provider")
+		samlRoute.Use(middleware.PermissionAuth("options"))
+		{
+			samlRoute.GET("/", controller.GetSAMLProviders)
+			samlRoute.GET("/:id", controller.GetSAMLProvider)
+			samlRoute.POST("/", controller.CreateSAMLProvider)
+			samlRoute.PUT("/:id", controller.UpdateSAMLProvider)
+			samlRoute.DELETE("/:id", controller.DeleteSAMLProvider)
+		}
+		// Roles and permissions (root only)
+		roleRoute := apiRouter.Group("/role")
+		roleRoute.Use(middleware.RootAuth())
+		{
+			roleRoute.GET("/", controller.GetRoles)
+			roleRoute.GET("/permissions", controller.GetPermissions)
+			roleRoute.POST("/", controller.AddRole)
+			roleRoute.PUT("/", controller.UpdateRole)
+			roleRoute.DELETE("/:id", controller.DeleteRole)
+			roleRoute.POST("/assign", controller.AssignRole)
+		}
+		// Admin audit logs
+		adminAuditRoute := apiRouter.Group("/admin_audit")
+		adminAuditRoute.Use(middleware.PermissionAuth("logs"))
+		{
+			adminAuditRoute.GET("/", controller.GetAdminAuditLogs)
+		}
 		performanceRoute := apiRouter.Group("/performance")
-		performanceRoute.Use(middleware.RootAuth())
+		performanceRoute.Use(middleware.PermissionAuth("options"))
 		{
 			performanceRoute.GET("/stats", controller.GetPerformanceStats)
 			performanceRoute.DELETE("/disk_cache", controller.ClearDiskCache)
@@ -194,13 +262,13 @@ func SetApiRouter(router *gin.Engine) {
 			performanceRoute.POST("/gc", controller.ForceGC)
 		}
 		ratioSyncRoute := apiRouter.Group("/ratio_sync")
-		ratioSyncRoute.Use(middleware.RootAuth())
+		ratioSyncRoute.Use(middleware.PermissionAuth("options"))
 		{
 			ratioSyncRoute.GET("/channels", controller.GetSyncableChannels)
 			ratioSyncRoute.POST("/fetch", controller.FetchUpstreamRatios)
 		}
 		channelRoute := apiRouter.Group("/channel")
-		channelRoute.Use(middleware.AdminAuth())
+		channelRoute.Use(middleware.PermissionAuth("channels"))
 		{
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
@@ -247,9 +315,21 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
+			tokenRoute.POST("/:id/rotate", controller.RotateToken)
 			tokenRoute.POST("/batch", controller.DeleteTokenBatch)
 		}
 
+		managementKeyRoute := apiRouter.Group("/management_key")
+		managementKeyRoute.Use(middleware.UserAuth())
+		{
+			managementKeyRoute.GET("/", controller.GetManagementKeys)
+			managementKeyRoute.GET("/scopes", controller.GetManagementScopes)
+			managementKeyRoute.POST("/", controller.AddManagementKey)
+			managementKeyRoute.PUT("/", controller.UpdateManagementKey)
+			managementKeyRoute.DELETE("/:id", controller.DeleteManagementKey)
+			managementKeyRoute.GET("/:id/audit", controller.GetManagementKeyAudits)
+		}
+
 		usageRoute := apiRouter.Group("/usage")
 		usageRoute.Use(middleware.CORS(), middleware.CriticalRateLimit())
 		{
@@ -261,42 +341,92 @@ func SetApiRouter(router *gin.Engine) {
 		}
 
 		redemptionRoute := apiRouter.Group("/redemption")
-		redemptionRoute.Use(middleware.AdminAuth())
+		redemptionRoute.Use(middleware.PermissionAuth("redemptions"))
 		{
 			redemptionRoute.GET("/", controller.GetAllRedemptions)
 			redemptionRoute.GET("/search", controller.SearchRedemptions)
+			redemptionRoute.GET("/campaign", controller.GetRedemptionCampaigns)
+			redemptionRoute.GET("/campaign/:id", controller.GetRedemptionCampaign)
+			redemptionRoute.POST("/campaign", controller.AddRedemptionCampaign)
+			redemptionRoute.PUT("/campaign", controller.UpdateRedemptionCampaign)
+			redemptionRoute.POST("/campaign/:id/generate", controller.GenerateCampaignRedemptions)
+			redemptionRoute.GET("/campaign/:id/export", controller.ExportCampaignRedemptions)
+			redemptionRoute.POST("/campaign/:id/disable", controller.DisableRedemptionCampaign)
+			redemptionRoute.GET("/campaign/:id/stats", controller.GetRedemptionCampaignStats)
 			redemptionRoute.GET("/:id", controller.GetRedemption)
 			redemptionRoute.POST("/", controller.AddRedemption)
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/invalid", controller.DeleteInvalidRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		promoCodeRoute := apiRouter.Group("/promo_code")
+		promoCodeRoute.Use(middleware.PermissionAuth("redemptions"))
+		{
+			promoCodeRoute.GET("/", controller.GetPromoCodes)
+			promoCodeRoute.GET("/stats", controller.GetPromoCodeStats)
+			promoCodeRoute.GET("/:id", controller.GetPromoCode)
+			promoCodeRoute.POST("/", controller.AddPromoCode)
+			promoCodeRoute.PUT("/", controller.UpdatePromoCode)
+			promoCodeRoute.DELETE("/:id", controller.DeletePromoCode)
+		}
+		recurringGrantRoute := apiRouter.Group("/recurring_grant")
+		recurringGrantRoute.Use(middleware.PermissionAuth("billing"))
+		{
+			recurringGrantRoute.GET("/", controller.GetRecurringGrants)
+			recurringGrantRoute.GET("/records", controller.GetRecurringGrantRecords)
+			recurringGrantRoute.GET("/:id", controller.GetRecurringGrant)
+			recurringGrantRoute.GET("/:id/records", controller.GetRecurringGrantRecords)
+			recurringGrantRoute.POST("/", controller.AddRecurringGrant)
+			recurringGrantRoute.PUT("/", controller.UpdateRecurringGrant)
+			recurringGrantRoute.DELETE("/:id", controller.DeleteRecurringGrant)
+		}
+		quotaReconcileRoute := apiRouter.Group("/quota_reconcile")
+		quotaReconcileRoute.Use(middleware.RootAuth())
+		{
+			quotaReconcileRoute.GET("/", controller.GetQuotaReconcileRuns)
+			quotaReconcileRoute.POST("/", controller.RunQuotaReconcile)
+			quotaReconcileRoute.GET("/:id", controller.GetQuotaReconcileRun)
+			quotaReconcileRoute.POST("/:id/apply", controller.ApplyQuotaReconcile)
+		}
 		logRoute := apiRouter.Group("/log")
-		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
-		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/", middleware.PermissionAuth("logs"), controller.GetAllLogs)
+		logRoute.DELETE("/", middleware.PermissionAuth("logs"), controller.DeleteHistoryLogs)
+		logRoute.GET("/stat", middleware.PermissionAuth("logs"), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-		logRoute.GET("/channel_affinity_usage_cache", middleware.AdminAuth(), controller.GetChannelAffinityUsageCacheStats)
-		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/channel_affinity_usage_cache", middleware.PermissionAuth("logs"), controller.GetChannelAffinityUsageCacheStats)
+		logRoute.GET("/search", middleware.PermissionAuth("logs"), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), middleware.SearchRateLimit(), controller.SearchUserLogs)
+		logRoute.GET("/statement", middleware.PermissionAuth("logs"), controller.GetStatementUsage)
+		logRoute.GET("/margin", middleware.PermissionAuth("logs"), controller.GetMarginReport)
+		logRoute.GET("/self/statement", middleware.UserAuth(), controller.GetSelfStatement)
+		logRoute.GET("/export", middleware.PermissionAuth("logs"), controller.ExportLogs)
+		logRoute.POST("/export/jobs", middleware.PermissionAuth("logs"), controller.CreateExportJob)
+		logRoute.GET("/export/jobs", middleware.PermissionAuth("logs"), controller.GetExportJobs)
+		logRoute.GET("/export/jobs/:id/download", middleware.PermissionAuth("logs"), controller.DownloadExportJob)
+		logRoute.GET("/self/export", middleware.UserAuth(), middleware.SearchRateLimit(), controller.ExportSelfLogs)
+		logRoute.POST("/self/export/jobs", middleware.UserAuth(), middleware.SearchRateLimit(), controller.CreateSelfExportJob)
+		logRoute.GET("/self/export/jobs", middleware.UserAuth(), controller.GetSelfExportJobs)
+		logRoute.GET("/self/export/jobs/:id/download", middleware.UserAuth(), controller.DownloadSelfExportJob)
 
 		dataRoute := apiRouter.Group("/data")
-		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
+		dataRoute.GET("/", middleware.PermissionAuth("logs"), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
+		dataRoute.GET("/labels", middleware.PermissionAuth("logs"), controller.GetAllLabelUsage)
+		dataRoute.GET("/self/labels", middleware.UserAuth(), controller.GetUserLabelUsage)
 
 		logRoute.Use(middleware.CORS(), middleware.CriticalRateLimit())
 		{
 			logRoute.GET("/token", middleware.TokenAuthReadOnly(), controller.GetLogByKey)
 		}
 		groupRoute := apiRouter.Group("/group")
-		groupRoute.Use(middleware.AdminAuth())
+		groupRoute.Use(middleware.PermissionAuth("models"))
 		{
 			groupRoute.GET("/", controller.GetGroups)
 		}
 
 		prefillGroupRoute := apiRouter.Group("/prefill_group")
-		prefillGroupRoute.Use(middleware.AdminAuth())
+		prefillGroupRoute.Use(middleware.PermissionAuth("models"))
 		{
 			prefillGroupRoute.GET("/", controller.GetPrefillGroups)
 			prefillGroupRoute.POST("/", controller.CreatePrefillGroup)
@@ -306,16 +436,16 @@ func SetApiRouter(router *gin.Engine) {
 
 		mjRoute := apiRouter.Group("/mj")
 		mjRoute.GET("/self", middleware.UserAuth(), controller.GetUserMidjourney)
-		mjRoute.GET("/", middleware.AdminAuth(), controller.GetAllMidjourney)
+		mjRoute.GET("/", middleware.PermissionAuth("logs"), controller.GetAllMidjourney)
 
 		taskRoute := apiRouter.Group("/task")
 		{
 			taskRoute.GET("/self", middleware.UserAuth(), controller.GetUserTask)
-			taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
+			taskRoute.GET("/", middleware.PermissionAuth("logs"), controller.GetAllTask)
 		}
 
 		vendorRoute := apiRouter.Group("/vendors")
-		vendorRoute.Use(middleware.AdminAuth())
+		vendorRoute.Use(middleware.PermissionAuth("models"))
 		{
 			vendorRoute.GET("/", controller.GetAllVendors)
 			vendorRoute.GET("/search", controller.SearchVendors)
@@ -326,7 +456,7 @@ func SetApiRouter(router *gin.Engine) {
 		}
 
 		modelsRoute := apiRouter.Group("/models")
-		modelsRoute.Use(middleware.AdminAuth())
+		modelsRoute.Use(middleware.PermissionAuth("models"))
 		{
 			modelsRoute.GET("/sync_upstream/preview", controller.SyncUpstreamPreview)
 			modelsRoute.POST("/sync_upstream", controller.SyncUpstreamModels)
@@ -341,7 +471,7 @@ func SetApiRouter(router *gin.Engine) {
 
 		// Deployments (model deployment management)
 		deploymentsRoute := apiRouter.Group("/deployments")
-		deploymentsRoute.Use(middleware.AdminAuth())
+		deploymentsRoute.Use(middleware.PermissionAuth("models"))
 		{
 			deploymentsRoute.GET("/settings", controller.GetModelDeploymentSettings)
 			deploymentsRoute.POST("/settings/test-connection", controller.TestIoNetConnection)
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	adminAuditCleanupTickInterval = 6 * time.Hour
+	adminAuditCleanupBatchSize    = 1000
+)
+
+var (
+	adminAuditCleanupOnce    sync.Once
+	adminAuditCleanupRunning atomic.Bool
+)
+
+// StartAdminAuditCleanupTask 按 ADMIN_AUDIT_RETENTION_DAYS 定期清理过期的审计日志，仅在主节点执行
+func StartAdminAuditCleanupTask() {
+	adminAuditCleanupOnce.Do(func() {
+		if !common.IsMasterNode || constant.AdminAuditRetentionDays <= 0 {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("admin audit cleanup task started: tick=%s, retention=%dd", adminAuditCleanupTickInterval, constant.AdminAuditRetentionDays))
+			ticker := time.NewTicker(adminAuditCleanupTickInterval)
+			defer ticker.Stop()
+
+			runAdminAuditCleanupOnce()
+			for range ticker.C {
+				runAdminAuditCleanupOnce()
+			}
+		})
+	})
+}
+
+func runAdminAuditCleanupOnce() {
+	if !adminAuditCleanupRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer adminAuditCleanupRunning.Store(false)
+
+	ctx := context.Background()
+	before := time.Now().AddDate(0, 0, -constant.AdminAuditRetentionDays).Unix()
+	removed, err := model.DeleteOldAdminAuditLogs(ctx, before, adminAuditCleanupBatchSize)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("admin audit cleanup: delete expired logs error: %v", err))
+		return
+	}
+	if removed > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("admin audit cleanup: removed %d expired logs", removed))
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	affiliateCommissionTickInterval = 1 * time.Hour
+	// 每次补结算最近几个已结束的窗口，节点停机或日志延迟写入时不会漏算
+	affiliateCommissionLookbackWindows = 3
+)
+
+var (
+	affiliateCommissionOnce    sync.Once
+	affiliateCommissionRunning atomic.Bool
+)
+
+// StartAffiliateCommissionTask 按消耗返佣时定期结算已结束窗口内被邀请用户的消耗
+func StartAffiliateCommissionTask() {
+	affiliateCommissionOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("affiliate commission task started: tick=%s", affiliateCommissionTickInterval))
+			ticker := time.NewTicker(affiliateCommissionTickInterval)
+			defer ticker.Stop()
+
+			runAffiliateCommissionOnce()
+			for range ticker.C {
+				runAffiliateCommissionOnce()
+			}
+		})
+	})
+}
+
+func runAffiliateCommissionOnce() {
+	if !operation_setting.IsAffiliateCommissionEnabled(operation_setting.AffiliateBasisConsumption) {
+		return
+	}
+	if !affiliateCommissionRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer affiliateCommissionRunning.Store(false)
+
+	ctx := context.Background()
+	currentStart, currentEnd := model.AffiliateConsumptionWindow(common.GetTimestamp())
+	window := currentEnd - currentStart
+	totalSettled := 0
+	for i := affiliateCommissionLookbackWindows; i >= 1; i-- {
+		start := currentStart - int64(i)*window
+		n, err := model.SettleAffiliateConsumptionCommissions(start, start+window)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("affiliate commission task failed: window=%d err=%v", start, err))
+			return
+		}
+		totalSettled += n
+	}
+	if common.DebugEnabled && totalSettled > 0 {
+		logger.LogDebug(ctx, "affiliate commission settlement: settled_count=%d", totalSettled)
+	}
+}
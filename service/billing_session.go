@@ -1,6 +1,7 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -67,6 +68,7 @@ func (s *BillingSession) Settle(actualQuota int) error {
 			common.SysLog(fmt.Sprintf("error adjusting token quota after funding settled (userId=%d, tokenId=%d, delta=%d): %s",
 				s.relayInfo.UserId, s.relayInfo.TokenId, delta, tokenErr.Error()))
 		}
+		adjustEphemeralTokenSpent(s.relayInfo, delta)
 	}
 	// 3) 更新 relayInfo 上的订阅 PostDelta（用于日志）
 	if s.funding.Source() == BillingSourceSubscription {
@@ -98,6 +100,7 @@ func (s *BillingSession) Refund(c *gin.Context) {
 	isPlayground := s.relayInfo.IsPlayground
 	tokenConsumed := s.tokenConsumed
 	funding := s.funding
+	ephemeral := ephemeralTokenClaims(s.relayInfo)
 
 	gopool.Go(func() {
 		// 1) 退还资金来源
@@ -109,6 +112,9 @@ func (s *BillingSession) Refund(c *gin.Context) {
 			if err := model.IncreaseTokenQuota(tokenId, tokenKey, tokenConsumed); err != nil {
 				common.SysLog("error refunding token quota: " + err.Error())
 			}
+			if ephemeral != nil {
+				model.AddEphemeralTokenSpent(ephemeral, -tokenConsumed)
+			}
 		}
 	})
 }
@@ -173,11 +179,20 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 				common.SysLog(fmt.Sprintf("error rolling back token quota (userId=%d, tokenId=%d, amount=%d, fundingErr=%s): %s",
 					s.relayInfo.UserId, s.relayInfo.TokenId, s.tokenConsumed, err.Error(), rollbackErr.Error()))
 			}
+			adjustEphemeralTokenSpent(s.relayInfo, -s.tokenConsumed)
 			s.tokenConsumed = 0
 		}
+		if errors.Is(err, model.ErrPostpaidCreditLimitExceeded) {
+			return types.NewErrorWithStatusCode(fmt.Errorf("后付费信用额度不足, 需要预扣费额度: %s", logger.FormatQuota(effectiveQuota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+		if errors.Is(err, model.ErrPostpaidAccountSuspended) {
+			return types.NewErrorWithStatusCode(fmt.Errorf("后付费账户存在逾期账单，已暂停使用"), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
 		// TODO: model 层应定义哨兵错误（如 ErrNoActiveSubscription），用 errors.Is 替代字符串匹配
 		errMsg := err.Error()
-		if strings.Contains(errMsg, "no active subscription") || strings.Contains(errMsg, "subscription quota insufficient") {
+		// 模型限额用尽与订阅额度不足同样处理，subscription_first 时回退到钱包
+		if errors.Is(err, model.ErrSubscriptionAllowanceExhausted) ||
+			strings.Contains(errMsg, "no active subscription") || strings.Contains(errMsg, "subscription quota insufficient") {
 			return types.NewErrorWithStatusCode(fmt.Errorf("订阅额度不足或未配置订阅: %s", errMsg), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 		}
 		return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
@@ -197,6 +212,10 @@ func (s *BillingSession) shouldTrust(c *gin.Context) bool {
 	if s.relayInfo.ForcePreConsume {
 		return false
 	}
+	// 带独立预算的临时令牌依赖预扣来防止并发请求超出预算
+	if s.relayInfo.EphemeralTokenQuota > 0 {
+		return false
+	}
 
 	trustQuota := common.GetTrustQuota()
 	if trustQuota <= 0 {
@@ -222,6 +241,9 @@ func (s *BillingSession) shouldTrust(c *gin.Context) bool {
 		// 2. SubscriptionFunding.PreConsume 忽略参数，始终用 s.amount 预扣
 		// 3. 若信任旁路将 effectiveQuota 设为 0，会导致 preConsumedQuota 与实际订阅预扣不一致
 		return false
+	case BillingSourcePostpaid:
+		// 后付费依赖预扣时的条件扣减来保证不超出信用额度，不能启用信任旁路
+		return false
 	default:
 		return false
 	}
@@ -265,6 +287,22 @@ func NewBillingSession(c *gin.Context, relayInfo *relaycommon.RelayInfo, preCons
 		if err != nil {
 			return nil, types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
 		}
+		// 后付费账户允许额度透支到信用额度，预扣时由 PostpaidFunding 做条件扣减
+		postpaidAccount, err := model.GetActivePostpaidAccount(relayInfo.UserId)
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+		}
+		if postpaidAccount != nil {
+			relayInfo.UserQuota = userQuota
+			session := &BillingSession{
+				relayInfo: relayInfo,
+				funding:   &PostpaidFunding{account: postpaidAccount},
+			}
+			if apiErr := session.preConsume(c, preConsumedQuota); apiErr != nil {
+				return nil, apiErr
+			}
+			return session, nil
+		}
 		if userQuota <= 0 {
 			return nil, types.NewErrorWithStatusCode(
 				fmt.Errorf("用户额度不足, 剩余额度: %s", logger.FormatQuota(userQuota)),
@@ -281,7 +319,7 @@ func NewBillingSession(c *gin.Context, relayInfo *relaycommon.RelayInfo, preCons
 
 		session := &BillingSession{
 			relayInfo: relayInfo,
-			funding:   &WalletFunding{userId: relayInfo.UserId},
+			funding:   newWalletFundingSource(relayInfo.UserId),
 		}
 		if apiErr := session.preConsume(c, preConsumedQuota); apiErr != nil {
 			return nil, apiErr
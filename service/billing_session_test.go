@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSubscriptionAllowanceTables(t *testing.T) {
+	t.Helper()
+	require.NoError(t, model.DB.AutoMigrate(&model.SubscriptionPlan{}, &model.SubscriptionPreConsumeRecord{},
+		&model.SubscriptionAllowance{}, &model.SubscriptionAllowanceUsage{}))
+	t.Cleanup(func() {
+		for _, table := range []string{"subscription_plans", "subscription_pre_consume_records",
+			"subscription_allowances", "subscription_allowance_usages"} {
+			model.DB.Exec("DELETE FROM " + table)
+		}
+	})
+	truncate(t)
+}
+
+func TestNewBillingSession_AllowanceExhaustedFallsBackToWallet(t *testing.T) {
+	setupSubscriptionAllowanceTables(t)
+	const userID, planID = 50, 50
+	seedUser(t, userID, 10000)
+	require.NoError(t, model.DB.Create(&model.SubscriptionPlan{Id: planID, Title: "plan", PriceAmount: 10, Currency: "USD",
+		DurationUnit: model.SubscriptionDurationMonth, DurationValue: 1, Enabled: true}).Error)
+	model.InvalidateSubscriptionPlanCache(planID)
+	require.NoError(t, model.CreateSubscriptionAllowance(&model.SubscriptionAllowance{
+		PlanId:        planID,
+		Name:          "premium",
+		ModelPatterns: "claude-opus-*",
+		LimitType:     model.SubscriptionAllowanceRequests,
+		LimitAmount:   1,
+		ResetPeriod:   model.SubscriptionResetDaily,
+		Enabled:       true,
+	}))
+	seedSubscription(t, 50, userID, 0, 0)
+	require.NoError(t, model.DB.Model(&model.UserSubscription{}).Where("id = ?", 50).Update("plan_id", planID).Error)
+
+	_, err := model.PreConsumeUserSubscription("req-allowance-1", userID, "claude-opus-4", 0, 100)
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	relayInfo := &relaycommon.RelayInfo{
+		UserId:          userID,
+		RequestId:       "req-allowance-2",
+		OriginModelName: "claude-opus-4",
+		IsPlayground:    true,
+		UserSetting:     dto.UserSetting{BillingPreference: "subscription_first"},
+	}
+	// 模型限额用尽时 subscription_first 回退到钱包
+	session, apiErr := NewBillingSession(c, relayInfo, 100)
+	require.Nil(t, apiErr)
+	assert.Equal(t, BillingSourceWallet, session.funding.Source())
+	assert.Equal(t, 10000-100, getUserQuota(t, userID))
+}
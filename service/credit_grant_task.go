@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	creditGrantExpireTickInterval = 5 * time.Minute
+	creditGrantExpireBatchSize    = 300
+)
+
+var (
+	creditGrantExpireOnce    sync.Once
+	creditGrantExpireRunning atomic.Bool
+)
+
+func StartCreditGrantExpireTask() {
+	creditGrantExpireOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("credit grant expire task started: tick=%s", creditGrantExpireTickInterval))
+			ticker := time.NewTicker(creditGrantExpireTickInterval)
+			defer ticker.Stop()
+
+			runCreditGrantExpireOnce()
+			for range ticker.C {
+				runCreditGrantExpireOnce()
+			}
+		})
+	})
+}
+
+func runCreditGrantExpireOnce() {
+	if !operation_setting.IsCreditLedgerEnabled() {
+		return
+	}
+	if !creditGrantExpireRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer creditGrantExpireRunning.Store(false)
+
+	ctx := context.Background()
+	totalExpired := 0
+	for {
+		n, err := model.ExpireDueCreditGrants(creditGrantExpireBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("credit grant expire task failed: %v", err))
+			return
+		}
+		if n == 0 {
+			break
+		}
+		totalExpired += n
+		if n < creditGrantExpireBatchSize {
+			break
+		}
+	}
+	if common.DebugEnabled && totalExpired > 0 {
+		logger.LogDebug(ctx, "credit grant maintenance: expired_count=%d", totalExpired)
+	}
+}
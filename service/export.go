@@ -0,0 +1,535 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Export formats
+const (
+	ExportFormatCSV     = "csv"
+	ExportFormatJSONL   = "jsonl"
+	ExportFormatParquet = "parquet"
+)
+
+// Export scopes
+const (
+	ExportScopeAdmin = "admin"
+	ExportScopeUser  = "user"
+)
+
+const (
+	exportBatchSize = 1000
+	// 同步流式导出允许的最大时间跨度，超过时需创建异步导出任务
+	ExportSyncMaxRange = int64(7 * 24 * 3600)
+	// 单个用户同时进行中的导出任务上限
+	ExportMaxRunningJobsPerUser = 2
+	// 超过该时长仍未完成的任务视为已中断
+	exportJobStaleAfter = 6 * time.Hour
+)
+
+func IsValidExportFormat(format string) bool {
+	switch format {
+	case ExportFormatCSV, ExportFormatJSONL, ExportFormatParquet:
+		return true
+	}
+	return false
+}
+
+// IsValidExportKind 审计日志只允许管理员范围导出
+func IsValidExportKind(kind string, scope string) bool {
+	switch kind {
+	case model.ExportKindLogs, model.ExportKindQuotaData:
+		return true
+	case model.ExportKindAdminAudit:
+		return scope == ExportScopeAdmin
+	}
+	return false
+}
+
+func ExportContentType(format string) string {
+	switch format {
+	case ExportFormatCSV:
+		return "text/csv; charset=utf-8"
+	case ExportFormatJSONL:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func ExportFileName(kind string, format string) string {
+	return fmt.Sprintf("%s-%s.%s", kind, time.Now().Format("20060102150405"), format)
+}
+
+// LogExportRow 日志导出行
+type LogExportRow struct {
+	Id               int    `json:"id" parquet:"id"`
+	CreatedAt        int64  `json:"created_at" parquet:"created_at"`
+	Type             int    `json:"type" parquet:"type"`
+	UserId           int    `json:"user_id" parquet:"user_id"`
+	Username         string `json:"username" parquet:"username"`
+	TokenId          int    `json:"token_id" parquet:"token_id"`
+	TokenName        string `json:"token_name" parquet:"token_name"`
+	ModelName        string `json:"model_name" parquet:"model_name"`
+	Quota            int    `json:"quota" parquet:"quota"`
+	PromptTokens     int    `json:"prompt_tokens" parquet:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens" parquet:"completion_tokens"`
+	UseTime          int    `json:"use_time" parquet:"use_time"`
+	IsStream         bool   `json:"is_stream" parquet:"is_stream"`
+	ChannelId        int    `json:"channel" parquet:"channel"`
+	ChannelName      string `json:"channel_name" parquet:"channel_name"`
+	Group            string `json:"group" parquet:"group"`
+	Ip               string `json:"ip" parquet:"ip"`
+	RequestId        string `json:"request_id" parquet:"request_id"`
+	Content          string `json:"content" parquet:"content"`
+	Other            string `json:"other" parquet:"other"`
+	UpstreamCost     int    `json:"upstream_cost" parquet:"upstream_cost"`
+}
+
+var logExportHeader = []string{"id", "created_at", "type", "user_id", "username", "token_id", "token_name", "model_name", "quota",
+	"prompt_tokens", "completion_tokens", "use_time", "is_stream", "channel", "channel_name", "group", "ip", "request_id", "content", "other", "upstream_cost"}
+
+func (r LogExportRow) csvRecord() []string {
+	return []string{
+		strconv.Itoa(r.Id), strconv.FormatInt(r.CreatedAt, 10), strconv.Itoa(r.Type), strconv.Itoa(r.UserId), r.Username,
+		strconv.Itoa(r.TokenId), r.TokenName, r.ModelName, strconv.Itoa(r.Quota), strconv.Itoa(r.PromptTokens),
+		strconv.Itoa(r.CompletionTokens), strconv.Itoa(r.UseTime), strconv.FormatBool(r.IsStream), strconv.Itoa(r.ChannelId),
+		r.ChannelName, r.Group, r.Ip, r.RequestId, r.Content, r.Other, strconv.Itoa(r.UpstreamCost),
+	}
+}
+
+// AdminAuditExportRow 审计日志导出行
+type AdminAuditExportRow struct {
+	Id              int    `json:"id" parquet:"id"`
+	CreatedAt       int64  `json:"created_at" parquet:"created_at"`
+	UserId          int    `json:"user_id" parquet:"user_id"`
+	Username        string `json:"username" parquet:"username"`
+	ManagementKeyId int    `json:"management_key_id" parquet:"management_key_id"`
+	Action          string `json:"action" parquet:"action"`
+	TargetType      string `json:"target_type" parquet:"target_type"`
+	TargetId        string `json:"target_id" parquet:"target_id"`
+	Ip              string `json:"ip" parquet:"ip"`
+	RequestId       string `json:"request_id" parquet:"request_id"`
+	StatusCode      int    `json:"status_code" parquet:"status_code"`
+	Changes         string `json:"changes" parquet:"changes"`
+}
+
+var adminAuditExportHeader = []string{"id", "created_at", "user_id", "username", "management_key_id", "action", "target_type",
+	"target_id", "ip", "request_id", "status_code", "changes"}
+
+func (r AdminAuditExportRow) csvRecord() []string {
+	return []string{
+		strconv.Itoa(r.Id), strconv.FormatInt(r.CreatedAt, 10), strconv.Itoa(r.UserId), r.Username, strconv.Itoa(r.ManagementKeyId),
+		r.Action, r.TargetType, r.TargetId, r.Ip, r.RequestId, strconv.Itoa(r.StatusCode), r.Changes,
+	}
+}
+
+// QuotaDataExportRow 用量统计导出行
+type QuotaDataExportRow struct {
+	Id        int    `json:"id" parquet:"id"`
+	UserId    int    `json:"user_id" parquet:"user_id"`
+	Username  string `json:"username" parquet:"username"`
+	ModelName string `json:"model_name" parquet:"model_name"`
+	CreatedAt int64  `json:"created_at" parquet:"created_at"`
+	TokenUsed int    `json:"token_used" parquet:"token_used"`
+	Count     int    `json:"count" parquet:"count"`
+	Quota     int    `json:"quota" parquet:"quota"`
+}
+
+var quotaDataExportHeader = []string{"id", "user_id", "username", "model_name", "created_at", "token_used", "count", "quota"}
+
+func (r QuotaDataExportRow) csvRecord() []string {
+	return []string{
+		strconv.Itoa(r.Id), strconv.Itoa(r.UserId), r.Username, r.ModelName, strconv.FormatInt(r.CreatedAt, 10),
+		strconv.Itoa(r.TokenUsed), strconv.Itoa(r.Count), strconv.Itoa(r.Quota),
+	}
+}
+
+type exportRow interface {
+	csvRecord() []string
+}
+
+type exportRowWriter[T exportRow] interface {
+	Write(rows []T) error
+	Close() error
+}
+
+type csvExportWriter[T exportRow] struct {
+	w *csv.Writer
+}
+
+func (cw *csvExportWriter[T]) Write(rows []T) error {
+	for _, row := range rows {
+		if err := cw.w.Write(row.csvRecord()); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvExportWriter[T]) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type jsonlExportWriter[T exportRow] struct {
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+func (jw *jsonlExportWriter[T]) Write(rows []T) error {
+	for _, row := range rows {
+		// json.Encoder 每条记录后自动追加换行
+		if err := jw.enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return jw.bw.Flush()
+}
+
+func (jw *jsonlExportWriter[T]) Close() error {
+	return jw.bw.Flush()
+}
+
+type parquetExportWriter[T exportRow] struct {
+	w *parquet.GenericWriter[T]
+}
+
+func (pw *parquetExportWriter[T]) Write(rows []T) error {
+	_, err := pw.w.Write(rows)
+	return err
+}
+
+func (pw *parquetExportWriter[T]) Close() error {
+	return pw.w.Close()
+}
+
+func newExportRowWriter[T exportRow](w io.Writer, format string, header []string) (exportRowWriter[T], error) {
+	switch format {
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter[T]{w: cw}, nil
+	case ExportFormatJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlExportWriter[T]{bw: bw, enc: json.NewEncoder(bw)}, nil
+	case ExportFormatParquet:
+		return &parquetExportWriter[T]{w: parquet.NewGenericWriter[T](w)}, nil
+	}
+	return nil, errors.New("不支持的导出格式")
+}
+
+// WriteExport 按筛选条件遍历数据并写入 w，返回导出的行数。
+// 用户范围导出会去除管理员字段并对日志 id 重新编号。
+func WriteExport(w io.Writer, kind string, format string, scope string, filter model.ExportFilter) (int64, error) {
+	if scope == ExportScopeUser && filter.UserId == 0 {
+		return 0, errors.New("用户导出缺少用户ID")
+	}
+	var rowCount int64
+	switch kind {
+	case model.ExportKindLogs:
+		writer, err := newExportRowWriter[LogExportRow](w, format, logExportHeader)
+		if err != nil {
+			return 0, err
+		}
+		rows := make([]LogExportRow, 0, exportBatchSize)
+		err = model.IterateLogs(filter, exportBatchSize, func(logs []*model.Log) error {
+			rows = rows[:0]
+			for _, log := range logs {
+				if scope == ExportScopeUser {
+					model.SanitizeUserExportLog(log)
+					log.Id = int(rowCount) + len(rows) + 1
+				}
+				rows = append(rows, LogExportRow{
+					Id:               log.Id,
+					CreatedAt:        log.CreatedAt,
+					Type:             log.Type,
+					UserId:           log.UserId,
+					Username:         log.Username,
+					TokenId:          log.TokenId,
+					TokenName:        log.TokenName,
+					ModelName:        log.ModelName,
+					Quota:            log.Quota,
+					PromptTokens:     log.PromptTokens,
+					CompletionTokens: log.CompletionTokens,
+					UseTime:          log.UseTime,
+					IsStream:         log.IsStream,
+					ChannelId:        log.ChannelId,
+					ChannelName:      log.ChannelName,
+					Group:            log.Group,
+					Ip:               log.Ip,
+					RequestId:        log.RequestId,
+					Content:          log.Content,
+					Other:            log.Other,
+					UpstreamCost:     log.UpstreamCost,
+				})
+			}
+			rowCount += int64(len(rows))
+			return writer.Write(rows)
+		})
+		if err != nil {
+			return rowCount, err
+		}
+		return rowCount, writer.Close()
+	case model.ExportKindQuotaData:
+		writer, err := newExportRowWriter[QuotaDataExportRow](w, format, quotaDataExportHeader)
+		if err != nil {
+			return 0, err
+		}
+		rows := make([]QuotaDataExportRow, 0, exportBatchSize)
+		err = model.IterateQuotaData(filter, exportBatchSize, func(data []*model.QuotaData) error {
+			rows = rows[:0]
+			for _, d := range data {
+				rows = append(rows, QuotaDataExportRow{
+					Id:        d.Id,
+					UserId:    d.UserID,
+					Username:  d.Username,
+					ModelName: d.ModelName,
+					CreatedAt: d.CreatedAt,
+					TokenUsed: d.TokenUsed,
+					Count:     d.Count,
+					Quota:     d.Quota,
+				})
+			}
+			rowCount += int64(len(rows))
+			return writer.Write(rows)
+		})
+		if err != nil {
+			return rowCount, err
+		}
+		return rowCount, writer.Close()
+	case model.ExportKindAdminAudit:
+		if scope != ExportScopeAdmin {
+			return 0, errors.New("不支持的导出类型")
+		}
+		writer, err := newExportRowWriter[AdminAuditExportRow](w, format, adminAuditExportHeader)
+		if err != nil {
+			return 0, err
+		}
+		rows := make([]AdminAuditExportRow, 0, exportBatchSize)
+		err = model.IterateAdminAuditLogs(filter, exportBatchSize, func(logs []*model.AdminAuditLog) error {
+			rows = rows[:0]
+			for _, log := range logs {
+				rows = append(rows, AdminAuditExportRow{
+					Id:              log.Id,
+					CreatedAt:       log.CreatedAt,
+					UserId:          log.UserId,
+					Username:        log.Username,
+					ManagementKeyId: log.ManagementKeyId,
+					Action:          log.Action,
+					TargetType:      log.TargetType,
+					TargetId:        log.TargetId,
+					Ip:              log.Ip,
+					RequestId:       log.RequestId,
+					StatusCode:      log.StatusCode,
+					Changes:         log.Changes,
+				})
+			}
+			rowCount += int64(len(rows))
+			return writer.Write(rows)
+		})
+		if err != nil {
+			return rowCount, err
+		}
+		return rowCount, writer.Close()
+	}
+	return 0, errors.New("不支持的导出类型")
+}
+
+// CreateExportJob 创建异步导出任务，在当前节点后台执行并将文件写入 constant.ExportDir
+func CreateExportJob(userId int, scope string, kind string, format string, filter model.ExportFilter) (*model.ExportJob, error) {
+	if !IsValidExportKind(kind, scope) {
+		return nil, errors.New("不支持的导出类型")
+	}
+	if !IsValidExportFormat(format) {
+		return nil, errors.New("不支持的导出格式")
+	}
+	running, err := model.CountRunningExportJobs(userId)
+	if err != nil {
+		return nil, err
+	}
+	if running >= ExportMaxRunningJobsPerUser {
+		return nil, errors.New("进行中的导出任务过多，请稍后再试")
+	}
+	filterJson, err := common.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	job := &model.ExportJob{
+		UserId:   userId,
+		Scope:    scope,
+		Kind:     kind,
+		Format:   format,
+		Filter:   string(filterJson),
+		Status:   model.ExportJobStatusPending,
+		FileName: ExportFileName(kind, format),
+	}
+	if err := job.Insert(); err != nil {
+		return nil, err
+	}
+	gopool.Go(func() {
+		runExportJob(job, filter)
+	})
+	return job, nil
+}
+
+func runExportJob(job *model.ExportJob, filter model.ExportFilter) {
+	ctx := context.Background()
+	fail := func(err error) {
+		logger.LogWarn(ctx, fmt.Sprintf("export job %d failed: %v", job.Id, err))
+		_ = model.UpdateExportJob(job.Id, map[string]interface{}{
+			"status":       model.ExportJobStatusFailed,
+			"error":        err.Error(),
+			"completed_at": common.GetTimestamp(),
+		})
+	}
+	if err := model.UpdateExportJob(job.Id, map[string]interface{}{"status": model.ExportJobStatusRunning}); err != nil {
+		fail(err)
+		return
+	}
+	if err := os.MkdirAll(constant.ExportDir, 0o750); err != nil {
+		fail(err)
+		return
+	}
+	filePath := filepath.Join(constant.ExportDir, fmt.Sprintf("export_%d_%s.%s", job.Id, common.GetRandomString(8), job.Format))
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		fail(err)
+		return
+	}
+	rowCount, err := WriteExport(file, job.Kind, job.Format, job.Scope, filter)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(filePath)
+		fail(err)
+		return
+	}
+	var fileSize int64
+	if info, err := os.Stat(filePath); err == nil {
+		fileSize = info.Size()
+	}
+	now := common.GetTimestamp()
+	if err := model.UpdateExportJob(job.Id, map[string]interface{}{
+		"status":       model.ExportJobStatusCompleted,
+		"row_count":    rowCount,
+		"file_size":    fileSize,
+		"file_path":    filePath,
+		"completed_at": now,
+		"expires_at":   now + int64(constant.ExportFileRetentionHours)*3600,
+	}); err != nil {
+		_ = os.Remove(filePath)
+		fail(err)
+		return
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("export job %d completed: kind=%s, format=%s, rows=%d, size=%d", job.Id, job.Kind, job.Format, rowCount, fileSize))
+}
+
+const exportCleanupTickInterval = 30 * time.Minute
+
+var (
+	exportCleanupOnce    sync.Once
+	exportCleanupRunning atomic.Bool
+)
+
+// StartExportCleanupTask 定期清理过期的导出文件。
+// 导出文件保存在各节点本地，因此每个节点都会清理自己的导出目录，任务状态仅由主节点维护。
+func StartExportCleanupTask() {
+	exportCleanupOnce.Do(func() {
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("export cleanup task started: tick=%s, retention=%dh", exportCleanupTickInterval, constant.ExportFileRetentionHours))
+			ticker := time.NewTicker(exportCleanupTickInterval)
+			defer ticker.Stop()
+
+			runExportCleanupOnce()
+			for range ticker.C {
+				runExportCleanupOnce()
+			}
+		})
+	})
+}
+
+func runExportCleanupOnce() {
+	if !exportCleanupRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer exportCleanupRunning.Store(false)
+
+	ctx := context.Background()
+	removed := sweepExportDir(time.Now().Add(-time.Duration(constant.ExportFileRetentionHours) * time.Hour))
+	if removed > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("export cleanup: removed %d expired files", removed))
+	}
+	if !common.IsMasterNode {
+		return
+	}
+	if err := model.FailStaleExportJobs(time.Now().Add(-exportJobStaleAfter).Unix()); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("export cleanup: fail stale jobs error: %v", err))
+	}
+	for {
+		jobs, err := model.GetExpiredExportJobs(100)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("export cleanup: query expired jobs error: %v", err))
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+		for _, job := range jobs {
+			if job.FilePath != "" {
+				_ = os.Remove(job.FilePath)
+			}
+			if err := model.UpdateExportJob(job.Id, map[string]interface{}{"status": model.ExportJobStatusExpired}); err != nil {
+				logger.LogWarn(ctx, fmt.Sprintf("export cleanup: update job %d error: %v", job.Id, err))
+				return
+			}
+		}
+	}
+}
+
+func sweepExportDir(before time.Time) int {
+	entries, err := os.ReadDir(constant.ExportDir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "export_") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(before) {
+			continue
+		}
+		if os.Remove(filepath.Join(constant.ExportDir, entry.Name())) == nil {
+			removed++
+		}
+	}
+	return removed
+}
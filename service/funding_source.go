@@ -1,9 +1,12 @@
 package service
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 )
 
 // ---------------------------------------------------------------------------
@@ -63,6 +66,157 @@ func (w *WalletFunding) Refund() error {
 	return model.IncreaseUserQuota(w.userId, w.consumed, false)
 }
 
+// ---------------------------------------------------------------------------
+// LedgerFunding — 启用额度账本时的钱包资金来源实现
+// ---------------------------------------------------------------------------
+
+// LedgerFunding 在扣减 User.Quota 的同时按到期时间先后消耗授予额度，
+// 并记录消耗明细以便退款时原路返还。账本操作失败不影响扣费本身。
+type LedgerFunding struct {
+	userId      int
+	consumed    int
+	allocations []model.CreditGrantAllocation
+}
+
+func newWalletFundingSource(userId int) FundingSource {
+	if operation_setting.IsCreditLedgerEnabled() {
+		return &LedgerFunding{userId: userId}
+	}
+	return &WalletFunding{userId: userId}
+}
+
+func (l *LedgerFunding) Source() string { return BillingSourceWallet }
+
+func (l *LedgerFunding) PreConsume(amount int) error {
+	if amount <= 0 {
+		return nil
+	}
+	if err := model.DecreaseUserQuota(l.userId, amount); err != nil {
+		return err
+	}
+	l.consumed = amount
+	l.consumeGrants(int64(amount))
+	return nil
+}
+
+func (l *LedgerFunding) Settle(delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	if delta > 0 {
+		if err := model.DecreaseUserQuota(l.userId, delta); err != nil {
+			return err
+		}
+		l.consumeGrants(int64(delta))
+		return nil
+	}
+	forfeited := l.restoreGrants(int64(-delta))
+	if refund := int64(-delta) - forfeited; refund > 0 {
+		return model.IncreaseUserQuota(l.userId, int(refund), false)
+	}
+	return nil
+}
+
+func (l *LedgerFunding) Refund() error {
+	if l.consumed <= 0 {
+		return nil
+	}
+	// 已过期授予记录上的额度已被过期任务从余额中扣除，不再返还
+	forfeited := l.restoreGrants(int64(l.consumed))
+	refund := int64(l.consumed) - forfeited
+	if refund <= 0 {
+		return nil
+	}
+	return model.IncreaseUserQuota(l.userId, int(refund), false)
+}
+
+func (l *LedgerFunding) consumeGrants(amount int64) {
+	allocations, err := model.ConsumeCreditGrants(l.userId, amount)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to consume credit grants for user %d: %s", l.userId, err.Error()))
+		return
+	}
+	l.allocations = append(l.allocations, allocations...)
+}
+
+// restoreGrants 从最近的消耗开始返还授予额度，返回因授予记录已过期而作废的额度
+func (l *LedgerFunding) restoreGrants(amount int64) int64 {
+	restored, forfeited, err := model.RestoreCreditGrants(l.allocations, amount)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to restore credit grants for user %d: %s", l.userId, err.Error()))
+		return 0
+	}
+	for _, r := range restored {
+		for i := len(l.allocations) - 1; i >= 0; i-- {
+			if l.allocations[i].GrantId == r.GrantId && l.allocations[i].Amount > 0 {
+				l.allocations[i].Amount -= r.Amount
+				break
+			}
+		}
+	}
+	return forfeited
+}
+
+// AdjustWalletQuota 直接调整用户钱包额度，delta > 0 表示扣费，delta < 0 表示退还。
+// 不经过 BillingSession 的钱包扣费（实时语音、Midjourney、违规扣费、异步任务补扣等）都必须走这里：
+// 启用额度账本时扣费同时消耗授予额度，否则到期任务会把用户已经花掉的授予额度从余额中再扣一次。
+// 这些路径没有保存消耗明细，退还的额度不回到授予记录，计入永不过期的余额。
+func AdjustWalletQuota(userId int, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	if delta < 0 {
+		return model.IncreaseUserQuota(userId, -delta, false)
+	}
+	if err := model.DecreaseUserQuota(userId, delta); err != nil {
+		return err
+	}
+	if _, err := model.ConsumeCreditGrants(userId, int64(delta)); err != nil {
+		common.SysLog(fmt.Sprintf("failed to consume credit grants for user %d: %s", userId, err.Error()))
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// PostpaidFunding — 后付费资金来源实现
+// ---------------------------------------------------------------------------
+
+// PostpaidFunding 允许用户额度透支到 -CreditLimit，透支部分在账期结束时出账。
+// 预扣时在信用额度内做条件扣减；结算补扣不再校验信用额度（用量已经发生）。
+type PostpaidFunding struct {
+	account  *model.PostpaidAccount
+	consumed int
+}
+
+func (p *PostpaidFunding) Source() string { return BillingSourcePostpaid }
+
+func (p *PostpaidFunding) PreConsume(amount int) error {
+	if err := model.PreConsumePostpaidQuota(p.account, amount); err != nil {
+		return err
+	}
+	if amount > 0 {
+		p.consumed = amount
+	}
+	return nil
+}
+
+func (p *PostpaidFunding) Settle(delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	if delta > 0 {
+		return model.DecreaseUserQuota(p.account.UserId, delta)
+	}
+	return model.IncreaseUserQuota(p.account.UserId, -delta, false)
+}
+
+func (p *PostpaidFunding) Refund() error {
+	if p.consumed <= 0 {
+		return nil
+	}
+	return model.IncreaseUserQuota(p.account.UserId, p.consumed, false)
+}
+
 // ---------------------------------------------------------------------------
 // SubscriptionFunding — 订阅资金来源实现
 // ---------------------------------------------------------------------------
@@ -105,7 +259,11 @@ func (s *SubscriptionFunding) Settle(delta int) error {
 	if delta == 0 {
 		return nil
 	}
-	return model.PostConsumeUserSubscriptionDelta(s.subscriptionId, int64(delta))
+	if err := model.PostConsumeUserSubscriptionDelta(s.subscriptionId, int64(delta)); err != nil {
+		return err
+	}
+	model.SettleSubscriptionAllowances(s.requestId, int64(delta))
+	return nil
 }
 
 func (s *SubscriptionFunding) Refund() error {
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func enableCreditLedger(t *testing.T) {
+	t.Helper()
+	setting := operation_setting.GetCreditLedgerSetting()
+	prev := *setting
+	setting.Enabled = true
+	t.Cleanup(func() { *setting = prev })
+}
+
+// seedExpiringGrant 给用户记录一条即将到期的授予额度（额度已包含在 seedUser 的余额中）
+func seedExpiringGrant(t *testing.T, userId int, amount int64) int {
+	t.Helper()
+	grant := &model.CreditGrant{
+		UserId:    userId,
+		Source:    model.CreditSourcePromotion,
+		Amount:    amount,
+		Remaining: amount,
+		ExpiresAt: common.GetTimestamp() + 3600,
+		Status:    model.CreditGrantStatusActive,
+	}
+	require.NoError(t, model.DB.Create(grant).Error)
+	return grant.Id
+}
+
+func expireGrantNow(t *testing.T, grantId int) {
+	t.Helper()
+	require.NoError(t, model.DB.Model(&model.CreditGrant{}).Where("id = ?", grantId).
+		Update("expires_at", common.GetTimestamp()-1).Error)
+	_, err := model.ExpireDueCreditGrants(10)
+	require.NoError(t, err)
+}
+
+func TestPostConsumeQuota_WalletConsumesCreditGrants(t *testing.T) {
+	truncate(t)
+	enableCreditLedger(t)
+
+	// 1000 永不过期的充值余额 + 500 即将到期的赠送额度
+	const userID, tokenID = 30, 30
+	seedUser(t, userID, 1500)
+	seedToken(t, tokenID, userID, "sk-realtime", 5000)
+	grantId := seedExpiringGrant(t, userID, 500)
+
+	relayInfo := &relaycommon.RelayInfo{UserId: userID, TokenId: tokenID, TokenKey: "sk-realtime"}
+	require.NoError(t, PostConsumeQuota(relayInfo, 300, 0, false))
+	assert.Equal(t, 1200, getUserQuota(t, userID))
+
+	// 到期只作废赠送额度剩余的 200，充值余额不受影响
+	expireGrantNow(t, grantId)
+	assert.Equal(t, 1000, getUserQuota(t, userID))
+}
+
+func TestRecalculateTaskQuota_WalletConsumesCreditGrants(t *testing.T) {
+	truncate(t)
+	enableCreditLedger(t)
+	ctx := context.Background()
+
+	const userID, tokenID, channelID = 31, 31, 31
+	seedUser(t, userID, 1500)
+	seedToken(t, tokenID, userID, "sk-task-ledger", 5000)
+	seedChannel(t, channelID)
+	grantId := seedExpiringGrant(t, userID, 500)
+
+	task := makeTask(userID, channelID, 0, tokenID, BillingSourceWallet, 0)
+	RecalculateTaskQuota(ctx, task, 400, "adaptor adjustment")
+	assert.Equal(t, 1100, getUserQuota(t, userID))
+
+	expireGrantNow(t, grantId)
+	assert.Equal(t, 1000, getUserQuota(t, userID))
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const loyaltyTierTickInterval = 1 * time.Hour
+
+var (
+	loyaltyTierOnce    sync.Once
+	loyaltyTierRunning atomic.Bool
+)
+
+// StartLoyaltyTierTask 定期重新评估会员等级，处理统计周期滑出和退款导致的降级
+func StartLoyaltyTierTask() {
+	loyaltyTierOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("loyalty tier task started: tick=%s", loyaltyTierTickInterval))
+			ticker := time.NewTicker(loyaltyTierTickInterval)
+			defer ticker.Stop()
+
+			runLoyaltyTierOnce()
+			for range ticker.C {
+				runLoyaltyTierOnce()
+			}
+		})
+	})
+}
+
+func runLoyaltyTierOnce() {
+	if !operation_setting.IsLoyaltyEnabled() {
+		return
+	}
+	if !loyaltyTierRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer loyaltyTierRunning.Store(false)
+
+	ctx := context.Background()
+	changed, err := model.EvaluateLoyaltyTiers()
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("loyalty tier task failed: %v", err))
+		return
+	}
+	if common.DebugEnabled && changed > 0 {
+		logger.LogDebug(ctx, "loyalty tier evaluation: changed_count=%d", changed)
+	}
+}
+
+// NotifyLoyaltyTierChanged 通知用户会员等级分组调整
+func NotifyLoyaltyTierChanged(change *model.LoyaltyTierChange) {
+	if change == nil || change.UserId <= 0 {
+		return
+	}
+	gopool.Go(func() {
+		user, err := model.GetUserById(change.UserId, false)
+		if err != nil {
+			return
+		}
+		title := "会员等级已降级"
+		content := "由于近期累计充值金额变化，您的用户分组已由 {{value}} 调整为 {{value}}。"
+		if change.Upgrade {
+			title = "会员等级已升级"
+			content = "感谢您的支持！您的近期累计充值已达到新的会员等级，用户分组已由 {{value}} 升级为 {{value}}。"
+		}
+		notify := dto.NewNotify(dto.NotifyTypeLoyaltyTier, title, content, []interface{}{change.FromGroup, change.ToGroup})
+		if err := NotifyUser(user.Id, user.Email, user.GetSetting(), notify); err != nil {
+			common.SysLog(fmt.Sprintf("failed to notify loyalty tier change (userId=%d): %s", user.Id, err.Error()))
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	postpaidTickInterval = 5 * time.Minute
+	postpaidBatchSize    = 200
+)
+
+var (
+	postpaidTaskOnce    sync.Once
+	postpaidTaskRunning atomic.Bool
+)
+
+// PostpaidPaymentLinkFunc 为账单生成支付链接并记录到账单上，返回支付链接。
+// 支付渠道实现在 controller 中，由 main 注入以避免 service -> controller 的循环依赖。
+var PostpaidPaymentLinkFunc func(statement *model.PostpaidStatement, paymentMethod string) (string, error)
+
+func StartPostpaidBillingTask() {
+	postpaidTaskOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("postpaid billing task started: tick=%s", postpaidTickInterval))
+			ticker := time.NewTicker(postpaidTickInterval)
+			defer ticker.Stop()
+
+			runPostpaidBillingOnce()
+			for range ticker.C {
+				runPostpaidBillingOnce()
+			}
+		})
+	})
+}
+
+func runPostpaidBillingOnce() {
+	if !operation_setting.IsPostpaidEnabled() {
+		return
+	}
+	if !postpaidTaskRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer postpaidTaskRunning.Store(false)
+
+	ctx := context.Background()
+	totalClosed := 0
+	for {
+		ids, err := model.GetDuePostpaidAccountIds(postpaidBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("postpaid billing task failed: %v", err))
+			return
+		}
+		for _, id := range ids {
+			statement, err := model.ClosePostpaidCycle(id)
+			if err != nil {
+				logger.LogWarn(ctx, fmt.Sprintf("close postpaid cycle failed (account=%d): %v", id, err))
+				return
+			}
+			if statement == nil {
+				continue
+			}
+			totalClosed++
+			issuePostpaidPaymentLink(ctx, statement)
+		}
+		if len(ids) < postpaidBatchSize {
+			break
+		}
+	}
+	suspended, err := model.SuspendOverduePostpaidAccounts(postpaidBatchSize)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("suspend overdue postpaid accounts failed: %v", err))
+	}
+	if common.DebugEnabled && (totalClosed > 0 || suspended > 0) {
+		logger.LogDebug(ctx, "postpaid billing: closed_count=%d, suspended_count=%d", totalClosed, suspended)
+	}
+}
+
+// issuePostpaidPaymentLink 按配置的支付方式为新账单生成支付链接，失败时用户仍可在账单页自行发起支付
+func issuePostpaidPaymentLink(ctx context.Context, statement *model.PostpaidStatement) {
+	method := operation_setting.GetPostpaidSetting().PaymentMethod
+	if method == "" || statement.AmountDue <= 0 || PostpaidPaymentLinkFunc == nil {
+		return
+	}
+	if _, err := PostpaidPaymentLinkFunc(statement, method); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("issue postpaid payment link failed (statement=%d): %v", statement.Id, err))
+	}
+}
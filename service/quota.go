@@ -459,13 +459,43 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
 	}
+	claims := ephemeralTokenClaims(relayInfo)
+	if claims != nil {
+		if err := model.ReserveEphemeralTokenQuota(claims, quota); err != nil {
+			return err
+		}
+	}
 	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
 	if err != nil {
+		if claims != nil {
+			model.AddEphemeralTokenSpent(claims, -quota)
+		}
 		return err
 	}
 	return nil
 }
 
+// ephemeralTokenClaims 请求使用带独立预算的临时令牌时返回其声明，否则返回 nil
+func ephemeralTokenClaims(relayInfo *relaycommon.RelayInfo) *model.EphemeralTokenClaims {
+	if relayInfo == nil || relayInfo.EphemeralTokenId == "" || relayInfo.EphemeralTokenQuota <= 0 {
+		return nil
+	}
+	return &model.EphemeralTokenClaims{
+		Id:        relayInfo.EphemeralTokenId,
+		TokenId:   relayInfo.TokenId,
+		UserId:    relayInfo.UserId,
+		ExpiresAt: relayInfo.EphemeralTokenExpiresAt,
+		Quota:     relayInfo.EphemeralTokenQuota,
+	}
+}
+
+// adjustEphemeralTokenSpent 临时令牌预算随令牌额度一起调整，delta 为负数时退还
+func adjustEphemeralTokenSpent(relayInfo *relaycommon.RelayInfo, delta int) {
+	if claims := ephemeralTokenClaims(relayInfo); claims != nil {
+		model.AddEphemeralTokenSpent(claims, delta)
+	}
+}
+
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
 	// 1) Consume from wallet quota OR subscription item
@@ -478,16 +508,12 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 			if err := model.PostConsumeUserSubscriptionDelta(relayInfo.SubscriptionId, delta); err != nil {
 				return err
 			}
+			model.SettleSubscriptionAllowances(relayInfo.RequestId, delta)
 			relayInfo.SubscriptionPostDelta += delta
 		}
 	} else {
 		// Wallet
-		if quota > 0 {
-			err = model.DecreaseUserQuota(relayInfo.UserId, quota)
-		} else {
-			err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
-		}
-		if err != nil {
+		if err = AdjustWalletQuota(relayInfo.UserId, quota); err != nil {
 			return err
 		}
 	}
@@ -501,6 +527,7 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 		if err != nil {
 			return err
 		}
+		adjustEphemeralTokenSpent(relayInfo, quota)
 	}
 
 	if sendEmail {
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const quotaReconcileTickInterval = 1 * time.Hour
+
+var (
+	quotaReconcileOnce    sync.Once
+	quotaReconcileRunning atomic.Bool
+	quotaReconcileLastRun atomic.Int64
+)
+
+// StartQuotaReconcileTask 按配置的间隔定时对账，窗口为上次对账以来
+func StartQuotaReconcileTask() {
+	quotaReconcileOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("quota reconcile task started: tick=%s", quotaReconcileTickInterval))
+			ticker := time.NewTicker(quotaReconcileTickInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				runQuotaReconcileOnce()
+			}
+		})
+	})
+}
+
+func runQuotaReconcileOnce() {
+	if !operation_setting.IsQuotaReconcileEnabled() || !common.LogConsumeEnabled {
+		return
+	}
+	if !quotaReconcileRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer quotaReconcileRunning.Store(false)
+
+	setting := operation_setting.GetQuotaReconcileSetting()
+	interval := int64(setting.IntervalHours)
+	if interval <= 0 {
+		interval = 24
+	}
+	now := common.GetTimestamp()
+	if last := quotaReconcileLastRun.Load(); last > 0 && now-last < interval*3600 {
+		return
+	}
+	quotaReconcileLastRun.Store(now)
+
+	ctx := context.Background()
+	run, err := model.RunQuotaReconcile(model.QuotaReconcileOptions{
+		StartTime:       now - interval*3600,
+		EndTime:         now,
+		Tolerance:       setting.Tolerance,
+		Apply:           setting.AutoApply,
+		AdjustUserQuota: setting.AdjustUserQuota,
+	})
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("quota reconcile task failed: %v", err))
+		return
+	}
+	if run.Discrepancies > 0 {
+		logger.LogWarn(ctx, fmt.Sprintf("quota reconcile run #%d: checked=%d discrepancies=%d applied=%d",
+			run.Id, run.Checked, run.Discrepancies, run.Applied))
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostConsumeQuota_AdjustsEphemeralBudget(t *testing.T) {
+	truncate(t)
+	const userID, tokenID = 40, 40
+	seedUser(t, userID, 10000)
+	seedToken(t, tokenID, userID, "sk-ephemeral-parent", 5000)
+
+	relayInfo := &relaycommon.RelayInfo{UserId: userID, TokenId: tokenID, TokenKey: "sk-ephemeral-parent",
+		EphemeralTokenId: common.GetUUID(), EphemeralTokenQuota: 100, EphemeralTokenExpiresAt: common.GetTimestamp() + 300}
+	claims := ephemeralTokenClaims(relayInfo)
+	require.NotNil(t, claims)
+	require.NoError(t, model.ReserveEphemeralTokenQuota(claims, 80))
+	assert.ErrorIs(t, model.ReserveEphemeralTokenQuota(claims, 30), model.ErrEphemeralTokenQuotaExceeded)
+
+	// 结算时按实际用量退还多预扣的部分
+	require.NoError(t, PostConsumeQuota(relayInfo, -50, 80, false))
+	assert.Equal(t, int64(30), model.GetEphemeralTokenSpent(relayInfo.EphemeralTokenId))
+	assert.NoError(t, model.ReserveEphemeralTokenQuota(claims, 30))
+}
+
+func TestBillingSession_EphemeralBudgetSkipsTrust(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	session := &BillingSession{
+		relayInfo: &relaycommon.RelayInfo{UserQuota: common.GetTrustQuota() + 1, TokenUnlimited: true},
+		funding:   &WalletFunding{},
+	}
+	assert.True(t, session.shouldTrust(c))
+
+	// 带独立预算的临时令牌必须预扣，不能走信任旁路
+	session.relayInfo.EphemeralTokenId = common.GetUUID()
+	session.relayInfo.EphemeralTokenQuota = 100
+	assert.False(t, session.shouldTrust(c))
+}
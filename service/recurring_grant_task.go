@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const recurringGrantTickInterval = 5 * time.Minute
+
+var (
+	recurringGrantOnce    sync.Once
+	recurringGrantRunning atomic.Bool
+)
+
+// StartRecurringGrantTask 定期执行到期的定期赠送规则；发放记录按规则、用户和期次去重，多节点重复执行不会重复发放
+func StartRecurringGrantTask() {
+	recurringGrantOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("recurring grant task started: tick=%s", recurringGrantTickInterval))
+			ticker := time.NewTicker(recurringGrantTickInterval)
+			defer ticker.Stop()
+
+			runRecurringGrantOnce()
+			for range ticker.C {
+				runRecurringGrantOnce()
+			}
+		})
+	})
+}
+
+func runRecurringGrantOnce() {
+	if !recurringGrantRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer recurringGrantRunning.Store(false)
+
+	ctx := context.Background()
+	granted, err := model.RunDueRecurringGrants()
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("recurring grant task failed: %v", err))
+	}
+	if common.DebugEnabled && granted > 0 {
+		logger.LogDebug(ctx, "recurring grant execution: granted_count=%d", granted)
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+)
+
+// WriteRedemptionCodesCSV 导出活动下未使用的兑换码
+func WriteRedemptionCodesCSV(w io.Writer, campaign *model.RedemptionCampaign, redemptions []*model.Redemption) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"id", "key", "campaign", "partner", "grant_type", "quota", "created_at", "expires_at"}); err != nil {
+		return err
+	}
+	for _, redemption := range redemptions {
+		expiresAt := ""
+		if redemption.ExpiredTime > 0 {
+			expiresAt = time.Unix(redemption.ExpiredTime, 0).Format(time.RFC3339)
+		}
+		if err := writer.Write([]string{
+			strconv.Itoa(redemption.Id),
+			redemption.Key,
+			campaign.Name,
+			campaign.Partner,
+			campaign.GrantType,
+			strconv.Itoa(redemption.Quota),
+			time.Unix(redemption.CreatedTime, 0).Format(time.RFC3339),
+			expiresAt,
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
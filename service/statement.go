@@ -0,0 +1,279 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// StatementTotals 账单金额汇总（按 QuotaPerUnit 折算为账单币种）
+type StatementTotals struct {
+	Subtotal float64
+	Tax      float64
+	Total    float64
+}
+
+func statementAmount(quota int64) float64 {
+	if common.QuotaPerUnit <= 0 {
+		return 0
+	}
+	return math.Round(float64(quota)/common.QuotaPerUnit*100) / 100
+}
+
+// CalcStatementTotals 消费金额扣除退款后按配置税率计税
+func CalcStatementTotals(detail *model.UserStatementDetail) StatementTotals {
+	setting := operation_setting.GetInvoiceSetting()
+	subtotal := statementAmount(detail.Consumed - detail.Refunded)
+	if subtotal < 0 {
+		subtotal = 0
+	}
+	tax := math.Round(subtotal*setting.TaxRate) / 100
+	return StatementTotals{
+		Subtotal: subtotal,
+		Tax:      tax,
+		Total:    math.Round((subtotal+tax)*100) / 100,
+	}
+}
+
+func formatMoney(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// WriteStatementCSV 输出单个用户的月度账单 CSV
+func WriteStatementCSV(w io.Writer, detail *model.UserStatementDetail) error {
+	setting := operation_setting.GetInvoiceSetting()
+	totals := CalcStatementTotals(detail)
+	writer := csv.NewWriter(w)
+	rows := [][]string{
+		{"section", "name", "requests", "prompt_tokens", "completion_tokens", "quota", "amount_" + setting.Currency},
+	}
+	for _, line := range detail.ByModel {
+		rows = append(rows, []string{"model", line.Name, strconv.FormatInt(line.RequestCount, 10), strconv.FormatInt(line.PromptTokens, 10),
+			strconv.FormatInt(line.CompletionTokens, 10), strconv.FormatInt(line.Quota, 10), formatMoney(statementAmount(line.Quota))})
+	}
+	for _, line := range detail.ByToken {
+		rows = append(rows, []string{"token", line.Name, strconv.FormatInt(line.RequestCount, 10), strconv.FormatInt(line.PromptTokens, 10),
+			strconv.FormatInt(line.CompletionTokens, 10), strconv.FormatInt(line.Quota, 10), formatMoney(statementAmount(line.Quota))})
+	}
+	for _, p := range detail.TopUps {
+		rows = append(rows, []string{"topup", p.TradeNo + " (" + p.PaymentMethod + ")", "", "", "", "", formatMoney(p.Money)})
+	}
+	for _, p := range detail.Subscriptions {
+		rows = append(rows, []string{"subscription", p.TradeNo + " (" + p.PaymentMethod + ")", "", "", "", "", formatMoney(p.Money)})
+	}
+	rows = append(rows,
+		[]string{"refund", "", "", "", "", strconv.FormatInt(detail.Refunded, 10), formatMoney(statementAmount(detail.Refunded))},
+		[]string{"subtotal", "", strconv.FormatInt(detail.RequestCount, 10), "", "", strconv.FormatInt(detail.Consumed, 10), formatMoney(totals.Subtotal)},
+		[]string{"tax", fmt.Sprintf("%s %.2f%%", setting.TaxName, setting.TaxRate), "", "", "", "", formatMoney(totals.Tax)},
+		[]string{"total", "", "", "", "", "", formatMoney(totals.Total)},
+	)
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteStatementUsageCSV 输出全平台按用户、模型、令牌汇总的月度消费 CSV
+func WriteStatementUsageCSV(w io.Writer, rows []model.StatementUsageRow) error {
+	setting := operation_setting.GetInvoiceSetting()
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"user_id", "username", "model_name", "token_name", "requests", "prompt_tokens", "completion_tokens", "quota", "amount_" + setting.Currency}); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := writer.Write([]string{
+			strconv.Itoa(row.UserId), row.Username, row.ModelName, row.TokenName,
+			strconv.FormatInt(row.RequestCount, 10), strconv.FormatInt(row.PromptTokens, 10), strconv.FormatInt(row.CompletionTokens, 10),
+			strconv.FormatInt(row.Quota, 10), formatMoney(statementAmount(row.Quota)),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"amount": statementAmount,
+	"money":  formatMoney,
+	"date": func(ts int64) string {
+		return time.Unix(ts, 0).Format("2006-01-02")
+	},
+	// 账单周期为 [start, end)，展示时取结束前一秒所在日期
+	"prev": func(ts int64) int64 {
+		return ts - 1
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.SystemName}} Statement {{.Detail.Period}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, "PingFang SC", sans-serif; color: #222; margin: 32px; }
+h1 { font-size: 22px; margin-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; margin: 12px 0 24px; font-size: 13px; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+td.num, th.num { text-align: right; }
+.header { display: flex; justify-content: space-between; }
+.muted { color: #666; font-size: 12px; }
+.totals td { font-weight: bold; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<div class="header">
+  <div>
+    <h1>{{if .Setting.CompanyName}}{{.Setting.CompanyName}}{{else}}{{.SystemName}}{{end}}</h1>
+    {{if .Setting.CompanyAddress}}<div class="muted">{{.Setting.CompanyAddress}}</div>{{end}}
+    {{if .Setting.CompanyEmail}}<div class="muted">{{.Setting.CompanyEmail}}</div>{{end}}
+    {{if .Setting.TaxId}}<div class="muted">Tax ID: {{.Setting.TaxId}}</div>{{end}}
+  </div>
+  <div>
+    <div><strong>Statement {{.Detail.Period}}</strong></div>
+    <div class="muted">{{date .Detail.PeriodStart}} ~ {{date (prev .Detail.PeriodEnd)}}</div>
+    <div class="muted">{{.Detail.Username}}{{if .Detail.Email}} &lt;{{.Detail.Email}}&gt;{{end}}</div>
+  </div>
+</div>
+
+<h3>Usage by model</h3>
+<table>
+<tr><th>Model</th><th class="num">Requests</th><th class="num">Prompt tokens</th><th class="num">Completion tokens</th><th class="num">Amount ({{.Setting.Currency}})</th></tr>
+{{range .Detail.ByModel}}<tr><td>{{.Name}}</td><td class="num">{{.RequestCount}}</td><td class="num">{{.PromptTokens}}</td><td class="num">{{.CompletionTokens}}</td><td class="num">{{money (amount .Quota)}}</td></tr>
+{{end}}</table>
+
+{{if .Detail.ByToken}}<h3>Usage by token</h3>
+<table>
+<tr><th>Token</th><th class="num">Requests</th><th class="num">Prompt tokens</th><th class="num">Completion tokens</th><th class="num">Amount ({{.Setting.Currency}})</th></tr>
+{{range .Detail.ByToken}}<tr><td>{{.Name}}</td><td class="num">{{.RequestCount}}</td><td class="num">{{.PromptTokens}}</td><td class="num">{{.CompletionTokens}}</td><td class="num">{{money (amount .Quota)}}</td></tr>
+{{end}}</table>{{end}}
+
+{{if or .Detail.TopUps .Detail.Subscriptions}}<h3>Payments</h3>
+<table>
+<tr><th>Date</th><th>Type</th><th>Reference</th><th>Method</th><th class="num">Amount</th></tr>
+{{range .Detail.TopUps}}<tr><td>{{date .CompleteTime}}</td><td>Top-up</td><td>{{.TradeNo}}</td><td>{{.PaymentMethod}}</td><td class="num">{{money .Money}}</td></tr>
+{{end}}{{range .Detail.Subscriptions}}<tr><td>{{date .CompleteTime}}</td><td>Subscription</td><td>{{.TradeNo}}</td><td>{{.PaymentMethod}}</td><td class="num">{{money .Money}}</td></tr>
+{{end}}</table>{{end}}
+
+<table>
+<tr><td>Usage</td><td class="num">{{money (amount .Detail.Consumed)}}</td></tr>
+<tr><td>Refunds</td><td class="num">-{{money (amount .Detail.Refunded)}}</td></tr>
+<tr><td>Subtotal</td><td class="num">{{money .Totals.Subtotal}}</td></tr>
+{{if .Setting.TaxRate}}<tr><td>{{.Setting.TaxName}} ({{.Setting.TaxRate}}%)</td><td class="num">{{money .Totals.Tax}}</td></tr>{{end}}
+<tr class="totals"><td>Total ({{.Setting.Currency}})</td><td class="num">{{money .Totals.Total}}</td></tr>
+</table>
+{{if .Setting.FooterNote}}<p class="muted">{{.Setting.FooterNote}}</p>{{end}}
+</body>
+</html>
+`))
+
+// RenderStatementHTML 渲染可打印的 HTML 账单（浏览器打印即可另存为 PDF）
+func RenderStatementHTML(w io.Writer, detail *model.UserStatementDetail) error {
+	setting := *operation_setting.GetInvoiceSetting()
+	return statementTemplate.Execute(w, map[string]interface{}{
+		"SystemName": common.SystemName,
+		"Setting":    setting,
+		"Detail":     detail,
+		"Totals":     CalcStatementTotals(detail),
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 月初自动发送上月账单邮件
+// ---------------------------------------------------------------------------
+
+const statementEmailTickInterval = 1 * time.Hour
+
+var (
+	statementEmailOnce    sync.Once
+	statementEmailRunning atomic.Bool
+)
+
+func StartStatementEmailTask() {
+	statementEmailOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("statement email task started: tick=%s", statementEmailTickInterval))
+			ticker := time.NewTicker(statementEmailTickInterval)
+			defer ticker.Stop()
+
+			runStatementEmailOnce()
+			for range ticker.C {
+				runStatementEmailOnce()
+			}
+		})
+	})
+}
+
+func runStatementEmailOnce() {
+	if !operation_setting.GetInvoiceSetting().EmailEnabled {
+		return
+	}
+	if !statementEmailRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer statementEmailRunning.Store(false)
+
+	ctx := context.Background()
+	period := model.PreviousStatementPeriod(time.Now())
+	userIds, err := model.GetStatementUserIds(period)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("statement email task failed: %v", err))
+		return
+	}
+	claimedUsers, err := model.GetClaimedStatementUserIds(period)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("statement email task failed: %v", err))
+		return
+	}
+	sent := 0
+	for _, userId := range userIds {
+		if claimedUsers[userId] {
+			continue
+		}
+		detail, err := model.BuildUserStatement(userId, period)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("build statement failed (user=%d, period=%s): %v", userId, period, err))
+			continue
+		}
+		claimed, err := model.ClaimUserStatement(detail)
+		if err != nil || !claimed {
+			continue
+		}
+		if detail.Email == "" {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := RenderStatementHTML(&buf, detail); err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("render statement failed (user=%d): %v", userId, err))
+			continue
+		}
+		subject := fmt.Sprintf("%s %s 月度账单", common.SystemName, period)
+		if err := common.SendEmail(subject, detail.Email, buf.String()); err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("send statement email failed (user=%d): %v", userId, err))
+			continue
+		}
+		_ = model.MarkUserStatementEmailed(userId, period)
+		sent++
+	}
+	if common.DebugEnabled && sent > 0 {
+		logger.LogDebug(ctx, "statement email: period=%s, sent_count=%d", period, sent)
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+)
+
+// NotifySubscriptionRenewalFailed 通知用户自动续费扣款失败（催缴）
+func NotifySubscriptionRenewalFailed(sub *model.UserSubscription) {
+	if sub == nil || sub.UserId <= 0 {
+		return
+	}
+	user, err := model.GetUserById(sub.UserId, false)
+	if err != nil {
+		return
+	}
+	planTitle := fmt.Sprintf("#%d", sub.PlanId)
+	if plan, err := model.GetSubscriptionPlanById(sub.PlanId); err == nil {
+		planTitle = plan.Title
+	}
+	content := "您的订阅套餐 {{value}} 自动续费扣款失败，支付平台将在接下来几天内自动重试。请及时更新支付方式，以免订阅在宽限期结束后失效。"
+	notify := dto.NewNotify(dto.NotifyTypeSubscriptionRenewal, "订阅续费失败", content, []interface{}{planTitle})
+	if err := NotifyUser(user.Id, user.Email, user.GetSetting(), notify); err != nil {
+		common.SysLog(fmt.Sprintf("failed to notify subscription renewal failure (userId=%d): %s", user.Id, err.Error()))
+	}
+}
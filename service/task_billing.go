@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
@@ -47,14 +48,15 @@ func LogTaskConsumption(c *gin.Context, info *relaycommon.RelayInfo) {
 		other["upstream_model_name"] = info.UpstreamModelName
 	}
 	model.RecordConsumeLog(c, info.UserId, model.RecordConsumeLogParams{
-		ChannelId: info.ChannelId,
-		ModelName: info.OriginModelName,
-		TokenName: tokenName,
-		Quota:     info.PriceData.Quota,
-		Content:   logContent,
-		TokenId:   info.TokenId,
-		Group:     info.UsingGroup,
-		Other:     other,
+		ChannelId:  info.ChannelId,
+		ModelName:  info.OriginModelName,
+		TokenName:  tokenName,
+		Quota:      info.PriceData.Quota,
+		Content:    logContent,
+		TokenId:    info.TokenId,
+		Group:      info.UsingGroup,
+		Other:      other,
+		UsageRatio: taskUsageRatio(info.OriginModelName, info.PriceData.OtherRatios),
 	})
 	model.UpdateUserUsedQuotaAndRequestCount(info.UserId, info.PriceData.Quota)
 	model.UpdateChannelUsedQuota(info.ChannelId, info.PriceData.Quota)
@@ -85,10 +87,7 @@ func taskAdjustFunding(task *model.Task, delta int) error {
 	if taskIsSubscription(task) {
 		return model.PostConsumeUserSubscriptionDelta(task.PrivateData.SubscriptionId, int64(delta))
 	}
-	if delta > 0 {
-		return model.DecreaseUserQuota(task.UserId, delta)
-	}
-	return model.IncreaseUserQuota(task.UserId, -delta, false)
+	return AdjustWalletQuota(task.UserId, delta)
 }
 
 // taskAdjustTokenQuota 调整任务的令牌额度，delta > 0 表示扣费，delta < 0 表示退还。
@@ -132,6 +131,31 @@ func taskBillingOther(task *model.Task) map[string]interface{} {
 	return other
 }
 
+// taskUsageRatio 返回任务按次计费的用量倍数（各 OtherRatios 之积），仅按次计费的模型不计算倍数。
+func taskUsageRatio(modelName string, otherRatios map[string]float64) float64 {
+	usageRatio := 1.0
+	if common.StringsContains(constant.TaskPricePatches, modelName) {
+		return usageRatio
+	}
+	for _, ra := range otherRatios {
+		usageRatio *= ra
+	}
+	return usageRatio
+}
+
+// taskUpstreamCost 按模型价格和任务用量计算任务的上游成本额度，与向用户收取的额度无关。
+func taskUpstreamCost(task *model.Task) int {
+	bc := task.PrivateData.BillingContext
+	if bc == nil {
+		return 0
+	}
+	modelName := taskModelName(task)
+	return model.CalcChannelUpstreamCost(task.ChannelId, modelName, model.UpstreamUsage{
+		ModelPrice: bc.ModelPrice,
+		UsageRatio: taskUsageRatio(modelName, bc.OtherRatios),
+	})
+}
+
 // taskModelName 从 BillingContext 或 Properties 中获取模型名称。
 func taskModelName(task *model.Task) string {
 	if bc := task.PrivateData.BillingContext; bc != nil && bc.OriginModelName != "" {
@@ -162,15 +186,16 @@ func RefundTaskQuota(ctx context.Context, task *model.Task, reason string) {
 	other["task_id"] = task.TaskID
 	other["reason"] = reason
 	model.RecordTaskBillingLog(model.RecordTaskBillingLogParams{
-		UserId:    task.UserId,
-		LogType:   model.LogTypeRefund,
-		Content:   "",
-		ChannelId: task.ChannelId,
-		ModelName: taskModelName(task),
-		Quota:     quota,
-		TokenId:   task.PrivateData.TokenId,
-		Group:     task.Group,
-		Other:     other,
+		UserId:       task.UserId,
+		LogType:      model.LogTypeRefund,
+		Content:      "",
+		ChannelId:    task.ChannelId,
+		ModelName:    taskModelName(task),
+		Quota:        quota,
+		TokenId:      task.PrivateData.TokenId,
+		Group:        task.Group,
+		Other:        other,
+		UpstreamCost: taskUpstreamCost(task),
 	})
 }
 
@@ -207,14 +232,20 @@ func RecalculateTaskQuota(ctx context.Context, task *model.Task, actualQuota int
 	// 调整令牌额度
 	taskAdjustTokenQuota(ctx, task, quotaDelta)
 
+	// 差额对应的上游成本按实际额度与预扣额度的比例调整，比例与分组倍率无关
+	upstreamCost := 0
+	if preConsumedQuota > 0 {
+		upstreamCost = int(math.Round(float64(taskUpstreamCost(task)) * math.Abs(float64(quotaDelta)) / float64(preConsumedQuota)))
+	}
 	task.Quota = actualQuota
 
 	var logType int
 	var logQuota int
 	if quotaDelta > 0 {
-		logType = model.LogTypeConsume
+		// 补扣不是一次新请求，记为系统日志，避免消费统计和请求数重复计算
+		logType = model.LogTypeSystem
 		logQuota = quotaDelta
-		model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quotaDelta)
+		model.UpdateUserUsedQuota(task.UserId, quotaDelta)
 		model.UpdateChannelUsedQuota(task.ChannelId, quotaDelta)
 	} else {
 		logType = model.LogTypeRefund
@@ -226,15 +257,16 @@ func RecalculateTaskQuota(ctx context.Context, task *model.Task, actualQuota int
 	other["pre_consumed_quota"] = preConsumedQuota
 	other["actual_quota"] = actualQuota
 	model.RecordTaskBillingLog(model.RecordTaskBillingLogParams{
-		UserId:    task.UserId,
-		LogType:   logType,
-		Content:   "",
-		ChannelId: task.ChannelId,
-		ModelName: taskModelName(task),
-		Quota:     logQuota,
-		TokenId:   task.PrivateData.TokenId,
-		Group:     task.Group,
-		Other:     other,
+		UserId:       task.UserId,
+		LogType:      logType,
+		Content:      "",
+		ChannelId:    task.ChannelId,
+		ModelName:    taskModelName(task),
+		Quota:        logQuota,
+		TokenId:      task.PrivateData.TokenId,
+		Group:        task.Group,
+		Other:        other,
+		UpstreamCost: upstreamCost,
 	})
 }
 
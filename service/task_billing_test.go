@@ -43,6 +43,7 @@ func TestMain(m *testing.M) {
 		&model.Log{},
 		&model.Channel{},
 		&model.UserSubscription{},
+		&model.CreditGrant{},
 	); err != nil {
 		panic("failed to migrate: " + err.Error())
 	}
@@ -63,6 +64,7 @@ func truncate(t *testing.T) {
 		model.DB.Exec("DELETE FROM logs")
 		model.DB.Exec("DELETE FROM channels")
 		model.DB.Exec("DELETE FROM user_subscriptions")
+		model.DB.Exec("DELETE FROM credit_grants")
 	})
 }
 
@@ -317,10 +319,10 @@ func TestRecalculate_PositiveDelta(t *testing.T) {
 	// task.Quota should be updated to actualQuota
 	assert.Equal(t, actualQuota, task.Quota)
 
-	// Log type should be Consume (additional charge)
+	// Log type should be System (additional charge is not a new request)
 	log := getLastLog(t)
 	require.NotNil(t, log)
-	assert.Equal(t, model.LogTypeConsume, log.Type)
+	assert.Equal(t, model.LogTypeSystem, log.Type)
 	assert.Equal(t, actualQuota-preConsumed, log.Quota)
 }
 
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 令牌限流窗口（秒），限流值均为每分钟的数量
+const tokenRateLimitWindow = 60
+
+func tokenRateLimitKey(kind string, tokenId int) string {
+	return fmt.Sprintf("tokenRateLimit:%s:%d", kind, tokenId)
+}
+
+// takeTokenBucket 从每分钟补满 limit 的令牌桶中取出 amount，返回是否允许及剩余量（放大 tokenRateLimitWindow 倍）
+func takeTokenBucket(ctx context.Context, key string, limit int, amount int64, force bool) (bool, int64, error) {
+	opts := []limiter.Option{
+		limiter.WithCapacity(int64(limit) * tokenRateLimitWindow),
+		limiter.WithRate(int64(limit)),
+		limiter.WithRequested(amount * tokenRateLimitWindow),
+	}
+	if force {
+		opts = append(opts, limiter.WithForce())
+	}
+	allowed, remaining, err := limiter.Default(ctx).Take(ctx, key, opts...)
+	if err != nil && common.RedisEnabled {
+		// Redis 不可用时退回单机内存限流，避免限流故障导致请求全部失败
+		common.SysError("token rate limit redis error, falling back to memory limiter: " + err.Error())
+		return limiter.NewMemory().Take(ctx, key, opts...)
+	}
+	return allowed, remaining, err
+}
+
+// setRateLimitHeaders 写入 x-ratelimit-* 响应头，resource 为 requests 或 tokens
+func setRateLimitHeaders(c *gin.Context, resource string, limit int, remaining int64) {
+	reset := (int64(limit)*tokenRateLimitWindow - remaining + int64(limit) - 1) / int64(limit)
+	c.Header("x-ratelimit-limit-"+resource, strconv.Itoa(limit))
+	c.Header("x-ratelimit-remaining-"+resource, strconv.FormatInt(max(remaining/tokenRateLimitWindow, 0), 10))
+	c.Header("x-ratelimit-reset-"+resource, fmt.Sprintf("%ds", max(reset, 0)))
+}
+
+// AcquireTokenConcurrency 占用令牌的并发名额，未设置并发限制时直接通过；
+// 通过时返回的 release 必须在请求结束后调用
+func AcquireTokenConcurrency(c *gin.Context) (allowed bool, release func(), err error) {
+	limit := common.GetContextKeyInt(c, constant.ContextKeyTokenConcurrencyLimit)
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if limit <= 0 || tokenId == 0 {
+		return true, func() {}, nil
+	}
+	ctx := context.Background()
+	key := tokenRateLimitKey("concurrency", tokenId)
+	var rl limiter.Limiter = limiter.Default(ctx)
+	allowed, err = rl.Acquire(ctx, key, int64(limit))
+	if err != nil && common.RedisEnabled {
+		common.SysError("token concurrency redis error, falling back to memory limiter: " + err.Error())
+		rl = limiter.NewMemory()
+		allowed, err = rl.Acquire(ctx, key, int64(limit))
+	}
+	if err != nil || !allowed {
+		return false, nil, err
+	}
+	return true, func() {
+		if err := rl.Release(ctx, key); err != nil {
+			logger.LogWarn(c, fmt.Sprintf("failed to release token concurrency: %s", err.Error()))
+		}
+	}, nil
+}
+
+// TakeTokenRequestRate 检查令牌的 RPM 限制并写入响应头
+func TakeTokenRequestRate(c *gin.Context) (bool, error) {
+	limit := common.GetContextKeyInt(c, constant.ContextKeyTokenRpmLimit)
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if limit <= 0 || tokenId == 0 {
+		return true, nil
+	}
+	allowed, remaining, err := takeTokenBucket(context.Background(), tokenRateLimitKey("rpm", tokenId), limit, 1, false)
+	if err != nil {
+		return false, err
+	}
+	setRateLimitHeaders(c, "requests", limit, remaining)
+	return allowed, nil
+}
+
+// ReserveTokenTPM 按预估的输入 token 数预占令牌的 TPM 额度，请求结束后由 SettleTokenTPM 按实际用量修正
+func ReserveTokenTPM(c *gin.Context, estimatedTokens int) *types.NewAPIError {
+	limit := common.GetContextKeyInt(c, constant.ContextKeyTokenTpmLimit)
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if limit <= 0 || tokenId == 0 {
+		return nil
+	}
+	allowed, remaining, err := takeTokenBucket(context.Background(), tokenRateLimitKey("tpm", tokenId), limit, int64(estimatedTokens), false)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeUpdateDataError, http.StatusInternalServerError, types.ErrOptionWithSkipRetry())
+	}
+	setRateLimitHeaders(c, "tokens", limit, remaining)
+	if !allowed {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌已达到 token 数限制：每分钟最多 %d 个 token", limit),
+			types.ErrorCodeTokenRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+	}
+	common.SetContextKey(c, constant.ContextKeyTokenTpmReserved, estimatedTokens)
+	return nil
+}
+
+// SettleTokenTPM 请求结束后按实际消耗的 token 数修正预占的 TPM 额度，失败的请求归还全部预占
+func SettleTokenTPM(c *gin.Context) {
+	limit := common.GetContextKeyInt(c, constant.ContextKeyTokenTpmLimit)
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	reserved, ok := common.GetContextKey(c, constant.ContextKeyTokenTpmReserved)
+	if limit <= 0 || tokenId == 0 || !ok {
+		return
+	}
+	delta := common.GetContextKeyInt(c, constant.ContextKeyConsumedTokens) - reserved.(int)
+	if delta == 0 {
+		return
+	}
+	if _, _, err := takeTokenBucket(context.Background(), tokenRateLimitKey("tpm", tokenId), limit, int64(delta), true); err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to settle token tpm: %s", err.Error()))
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildTokenRateLimitContextForTest(tokenId int, rpm, tpm, concurrency int) (*gin.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	common.SetContextKey(ctx, constant.ContextKeyTokenId, tokenId)
+	common.SetContextKey(ctx, constant.ContextKeyTokenRpmLimit, rpm)
+	common.SetContextKey(ctx, constant.ContextKeyTokenTpmLimit, tpm)
+	common.SetContextKey(ctx, constant.ContextKeyTokenConcurrencyLimit, concurrency)
+	return ctx, rec
+}
+
+func TestTakeTokenRequestRate(t *testing.T) {
+	tokenId := int(time.Now().UnixNano() % 1000000000)
+	for i := 0; i < 2; i++ {
+		ctx, rec := buildTokenRateLimitContextForTest(tokenId, 2, 0, 0)
+		allowed, err := TakeTokenRequestRate(ctx)
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, "2", rec.Header().Get("x-ratelimit-limit-requests"))
+	}
+	ctx, rec := buildTokenRateLimitContextForTest(tokenId, 2, 0, 0)
+	allowed, err := TakeTokenRequestRate(ctx)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, "0", rec.Header().Get("x-ratelimit-remaining-requests"))
+	assert.NotEmpty(t, rec.Header().Get("x-ratelimit-reset-requests"))
+}
+
+func TestTokenTPMReserveAndSettle(t *testing.T) {
+	tokenId := int(time.Now().UnixNano()%1000000000) + 1
+	ctx, rec := buildTokenRateLimitContextForTest(tokenId, 0, 1000, 0)
+	require.Nil(t, ReserveTokenTPM(ctx, 600))
+	assert.Equal(t, "400", rec.Header().Get("x-ratelimit-remaining-tokens"))
+
+	// 实际只消耗 100，修正后归还 500
+	common.SetContextKey(ctx, constant.ContextKeyConsumedTokens, 100)
+	SettleTokenTPM(ctx)
+
+	ctx, rec = buildTokenRateLimitContextForTest(tokenId, 0, 1000, 0)
+	require.Nil(t, ReserveTokenTPM(ctx, 800))
+	assert.Equal(t, "100", rec.Header().Get("x-ratelimit-remaining-tokens"))
+
+	ctx, _ = buildTokenRateLimitContextForTest(tokenId, 0, 1000, 0)
+	apiErr := ReserveTokenTPM(ctx, 200)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+}
+
+func TestAcquireTokenConcurrency(t *testing.T) {
+	tokenId := int(time.Now().UnixNano()%1000000000) + 2
+	ctx, _ := buildTokenRateLimitContextForTest(tokenId, 0, 0, 1)
+	allowed, release, err := AcquireTokenConcurrency(ctx)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	allowed, _, err = AcquireTokenConcurrency(ctx)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	release()
+	allowed, release, err = AcquireTokenConcurrency(ctx)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	release()
+}
+
+func TestTokenRateLimit_RedisFailureFallsBackToMemory(t *testing.T) {
+	prevEnabled, prevRDB := common.RedisEnabled, common.RDB
+	t.Cleanup(func() {
+		common.RedisEnabled, common.RDB = prevEnabled, prevRDB
+	})
+	common.RedisEnabled = true
+	common.RDB = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond, MaxRetries: -1})
+
+	tokenId := int(time.Now().UnixNano()%1000000000) + 3
+	ctx, rec := buildTokenRateLimitContextForTest(tokenId, 1, 1000, 1)
+	allowed, release, err := AcquireTokenConcurrency(ctx)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	release()
+
+	allowed, err = TakeTokenRequestRate(ctx)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	require.Nil(t, ReserveTokenTPM(ctx, 600))
+	assert.Equal(t, "400", rec.Header().Get("x-ratelimit-remaining-tokens"))
+}
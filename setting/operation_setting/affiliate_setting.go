@@ -0,0 +1,78 @@
+package operation_setting
+
+import (
+	"sort"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	AffiliateBasisTopUp       = "topup"       // 按被邀请用户的支付充值（含订阅）计算返佣
+	AffiliateBasisConsumption = "consumption" // 按被邀请用户的实际消耗计算返佣
+)
+
+// AffiliateCommissionTier 阶梯返佣：邀请人累计带来的流水达到 MinQuota 后按 Percent 计算
+type AffiliateCommissionTier struct {
+	MinQuota int64   `json:"min_quota"`
+	Percent  float64 `json:"percent"`
+}
+
+// AffiliateSetting 邀请返佣配置
+type AffiliateSetting struct {
+	CommissionEnabled bool                      `json:"commission_enabled"` // 是否启用持续返佣
+	Basis             string                    `json:"basis"`              // 返佣基数：topup / consumption
+	Percent           float64                   `json:"percent"`            // 基础返佣比例（百分比）
+	DurationDays      int                       `json:"duration_days"`      // 被邀请用户注册后多少天内产生返佣，0 表示不限
+	Tiers             []AffiliateCommissionTier `json:"tiers"`              // 按累计流水的阶梯比例，未达到任何阶梯时使用 Percent
+}
+
+// 默认配置
+var affiliateSetting = AffiliateSetting{
+	CommissionEnabled: false,
+	Basis:             AffiliateBasisTopUp,
+	Percent:           10,
+	DurationDays:      0,
+	Tiers:             []AffiliateCommissionTier{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("affiliate_setting", &affiliateSetting)
+}
+
+// GetAffiliateSetting 获取邀请返佣配置
+func GetAffiliateSetting() *AffiliateSetting {
+	return &affiliateSetting
+}
+
+// IsAffiliateCommissionEnabled 是否按指定基数启用持续返佣
+func IsAffiliateCommissionEnabled(basis string) bool {
+	if !affiliateSetting.CommissionEnabled {
+		return false
+	}
+	current := affiliateSetting.Basis
+	if current == "" {
+		current = AffiliateBasisTopUp
+	}
+	return current == basis
+}
+
+// CommissionPercent 根据邀请人累计带来的流水返回适用的返佣比例
+func (s *AffiliateSetting) CommissionPercent(volume int64) float64 {
+	percent := s.Percent
+	tiers := make([]AffiliateCommissionTier, len(s.Tiers))
+	copy(tiers, s.Tiers)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinQuota < tiers[j].MinQuota })
+	for _, tier := range tiers {
+		if volume >= tier.MinQuota {
+			percent = tier.Percent
+		}
+	}
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
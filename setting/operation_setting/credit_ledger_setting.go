@@ -0,0 +1,37 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// CreditLedgerSetting 额度账本配置
+type CreditLedgerSetting struct {
+	Enabled              bool `json:"enabled"`                // 是否启用额度账本（按到期时间先后消耗授予额度）
+	TopUpExpireDays      int  `json:"topup_expire_days"`      // 在线充值额度有效天数，0 表示永不过期
+	RedemptionExpireDays int  `json:"redemption_expire_days"` // 兑换码额度有效天数，0 表示永不过期
+	CheckinExpireDays    int  `json:"checkin_expire_days"`    // 签到奖励有效天数，0 表示永不过期
+	PromotionExpireDays  int  `json:"promotion_expire_days"`  // 注册/邀请等赠送额度有效天数，0 表示永不过期
+	AffiliateExpireDays  int  `json:"affiliate_expire_days"`  // 邀请奖励划转额度有效天数，0 表示永不过期
+}
+
+// 默认配置
+var creditLedgerSetting = CreditLedgerSetting{
+	Enabled:              false,
+	TopUpExpireDays:      0,
+	RedemptionExpireDays: 0,
+	CheckinExpireDays:    30,
+	PromotionExpireDays:  90,
+	AffiliateExpireDays:  0,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("credit_ledger_setting", &creditLedgerSetting)
+}
+
+func GetCreditLedgerSetting() *CreditLedgerSetting {
+	return &creditLedgerSetting
+}
+
+// IsCreditLedgerEnabled 是否启用额度账本
+func IsCreditLedgerEnabled() bool {
+	return creditLedgerSetting.Enabled
+}
@@ -0,0 +1,34 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// InvoiceSetting 月度账单配置
+type InvoiceSetting struct {
+	CompanyName    string  `json:"company_name"`    // 开票方名称
+	CompanyAddress string  `json:"company_address"` // 开票方地址
+	CompanyEmail   string  `json:"company_email"`   // 联系邮箱
+	TaxId          string  `json:"tax_id"`          // 税号
+	TaxName        string  `json:"tax_name"`        // 税种名称，如 VAT / GST
+	TaxRate        float64 `json:"tax_rate"`        // 税率（百分比），0 表示不计税
+	Currency       string  `json:"currency"`        // 账单币种
+	FooterNote     string  `json:"footer_note"`     // 账单底部备注
+	EmailEnabled   bool    `json:"email_enabled"`   // 是否在月初自动邮件发送上月账单
+}
+
+// 默认配置
+var invoiceSetting = InvoiceSetting{
+	TaxName:      "VAT",
+	TaxRate:      0,
+	Currency:     "USD",
+	EmailEnabled: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("invoice_setting", &invoiceSetting)
+}
+
+// GetInvoiceSetting 获取月度账单配置
+func GetInvoiceSetting() *InvoiceSetting {
+	return &invoiceSetting
+}
@@ -0,0 +1,59 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// LoyaltyTier 会员等级规则：统计周期内累计支付金额达到 MinMoney 的用户自动调整到 Group
+type LoyaltyTier struct {
+	Group      string  `json:"group"`
+	MinMoney   float64 `json:"min_money"`
+	WindowDays int     `json:"window_days"` // 统计最近多少天的充值，0 表示使用默认 90 天
+}
+
+// LoyaltySetting 按累计充值自动升降分组配置
+type LoyaltySetting struct {
+	Enabled    bool          `json:"enabled"`     // 是否启用会员等级
+	BaseGroups []string      `json:"base_groups"` // 允许自动升级的初始分组，其他分组视为人工指定，不会被覆盖
+	Tiers      []LoyaltyTier `json:"tiers"`
+}
+
+const defaultLoyaltyWindowDays = 90
+
+// 默认配置
+var loyaltySetting = LoyaltySetting{
+	Enabled:    false,
+	BaseGroups: []string{"default"},
+	Tiers:      []LoyaltyTier{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("loyalty_setting", &loyaltySetting)
+}
+
+// GetLoyaltySetting 获取会员等级配置
+func GetLoyaltySetting() *LoyaltySetting {
+	return &loyaltySetting
+}
+
+// IsLoyaltyEnabled 是否启用会员等级
+func IsLoyaltyEnabled() bool {
+	return loyaltySetting.Enabled && len(loyaltySetting.Tiers) > 0
+}
+
+// TierWindowDays 返回等级规则的统计周期（天）
+func (t LoyaltyTier) TierWindowDays() int {
+	if t.WindowDays <= 0 {
+		return defaultLoyaltyWindowDays
+	}
+	return t.WindowDays
+}
+
+// IsBaseGroup 判断分组是否允许被自动升级
+func (s *LoyaltySetting) IsBaseGroup(group string) bool {
+	for _, g := range s.BaseGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ManualPaymentSetting 人工转账收款：用户下单后按说明线下付款，由管理员确认到账
+type ManualPaymentSetting struct {
+	Enabled      bool   `json:"enabled"`
+	Name         string `json:"name"`         // 前端展示名称，如 “银行转账”
+	Instructions string `json:"instructions"` // 收款账户及付款说明，支持 {trade_no} {money} 占位符
+}
+
+// 默认配置
+var manualPaymentSetting = ManualPaymentSetting{
+	Enabled: false,
+	Name:    "银行转账",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("manual_payment_setting", &manualPaymentSetting)
+}
+
+func GetManualPaymentSetting() *ManualPaymentSetting {
+	return &manualPaymentSetting
+}
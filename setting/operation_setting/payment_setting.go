@@ -5,6 +5,8 @@ import "github.com/QuantumNous/new-api/setting/config"
 type PaymentSetting struct {
 	AmountOptions  []int           `json:"amount_options"`
 	AmountDiscount map[int]float64 `json:"amount_discount"` // 充值金额对应的折扣，例如 100 元 0.9 表示 100 元充值享受 9 折优惠
+	// 退款或拒付扣回额度后余额为负时封禁用户；关闭时仅允许余额为负
+	SuspendOnRefundDebt bool `json:"suspend_on_refund_debt"`
 }
 
 // 默认配置
@@ -0,0 +1,40 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// PostpaidSetting 后付费（先用后付）配置
+type PostpaidSetting struct {
+	Enabled             bool   `json:"enabled"`               // 是否启用后付费账户
+	DefaultCreditLimit  int    `json:"default_credit_limit"`  // 新开通后付费账户的默认信用额度
+	DefaultBillingCycle string `json:"default_billing_cycle"` // 默认账期：weekly / monthly
+	PaymentDueDays      int    `json:"payment_due_days"`      // 账单出具后的付款期限（天）
+	SuspendAfterDays    int    `json:"suspend_after_days"`    // 逾期超过该天数自动暂停账户
+	PaymentMethod       string `json:"payment_method"`        // 出账时自动生成支付链接的支付方式，为空则不生成
+	CreemProductId      string `json:"creem_product_id"`      // Creem 账单支付使用的产品ID（单价需为 1）
+}
+
+// 默认配置
+var postpaidSetting = PostpaidSetting{
+	Enabled:             false,
+	DefaultCreditLimit:  0,
+	DefaultBillingCycle: "monthly",
+	PaymentDueDays:      7,
+	SuspendAfterDays:    3,
+	PaymentMethod:       "",
+	CreemProductId:      "",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("postpaid_setting", &postpaidSetting)
+}
+
+// GetPostpaidSetting 获取后付费配置
+func GetPostpaidSetting() *PostpaidSetting {
+	return &postpaidSetting
+}
+
+// IsPostpaidEnabled 是否启用后付费账户
+func IsPostpaidEnabled() bool {
+	return postpaidSetting.Enabled
+}
@@ -0,0 +1,36 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// QuotaReconcileSetting 额度对账配置
+type QuotaReconcileSetting struct {
+	Enabled         bool  `json:"enabled"`           // 是否启用定时对账
+	IntervalHours   int   `json:"interval_hours"`    // 定时对账间隔（小时），同时作为对账窗口
+	Tolerance       int64 `json:"tolerance"`         // 允许的误差额度，差异不超过该值不视为异常
+	AutoApply       bool  `json:"auto_apply"`        // 定时对账发现差异后自动修正计数
+	AdjustUserQuota bool  `json:"adjust_user_quota"` // 修正用户已用额度时同步修正用户余额
+}
+
+// 默认配置
+var quotaReconcileSetting = QuotaReconcileSetting{
+	Enabled:         false,
+	IntervalHours:   24,
+	Tolerance:       0,
+	AutoApply:       false,
+	AdjustUserQuota: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("quota_reconcile_setting", &quotaReconcileSetting)
+}
+
+// GetQuotaReconcileSetting 获取额度对账配置
+func GetQuotaReconcileSetting() *QuotaReconcileSetting {
+	return &quotaReconcileSetting
+}
+
+// IsQuotaReconcileEnabled 是否启用定时对账
+func IsQuotaReconcileEnabled() bool {
+	return quotaReconcileSetting.Enabled
+}
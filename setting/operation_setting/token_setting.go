@@ -4,12 +4,16 @@ import "github.com/QuantumNous/new-api/setting/config"
 
 // TokenSetting 令牌相关配置
 type TokenSetting struct {
-	MaxUserTokens int `json:"max_user_tokens"` // 每用户最大令牌数量
+	MaxUserTokens          int `json:"max_user_tokens"`           // 每用户最大令牌数量
+	RotationGraceMinutes   int `json:"rotation_grace_minutes"`    // 令牌轮换后旧 key 的有效时长（分钟），0 为立即失效
+	EphemeralMaxTTLMinutes int `json:"ephemeral_max_ttl_minutes"` // 临时令牌的最长有效期（分钟）
 }
 
 // 默认配置
 var tokenSetting = TokenSetting{
-	MaxUserTokens: 1000, // 默认每用户最多 1000 个令牌
+	MaxUserTokens:          1000, // 默认每用户最多 1000 个令牌
+	RotationGraceMinutes:   1440, // 默认旧 key 保留 24 小时
+	EphemeralMaxTTLMinutes: 60,   // 默认临时令牌最长有效 1 小时
 }
 
 func init() {
@@ -26,3 +30,19 @@ func GetTokenSetting() *TokenSetting {
 func GetMaxUserTokens() int {
 	return GetTokenSetting().MaxUserTokens
 }
+
+// GetTokenRotationGraceSeconds 获取令牌轮换宽限期（秒）
+func GetTokenRotationGraceSeconds() int64 {
+	if tokenSetting.RotationGraceMinutes <= 0 {
+		return 0
+	}
+	return int64(tokenSetting.RotationGraceMinutes) * 60
+}
+
+// GetEphemeralMaxTTLMinutes 获取临时令牌的最长有效期（分钟）
+func GetEphemeralMaxTTLMinutes() int {
+	if tokenSetting.EphemeralMaxTTLMinutes <= 0 {
+		return 60
+	}
+	return tokenSetting.EphemeralMaxTTLMinutes
+}
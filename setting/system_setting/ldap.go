@@ -0,0 +1,60 @@
+package system_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// LDAPGroupMapping 目录组到网关分组或角色的映射，Group 可以是组的完整 DN 或 CN
+type LDAPGroupMapping struct {
+	Group string `json:"group"`
+	Value string `json:"value"`
+}
+
+type LDAPSettings struct {
+	Enabled            bool   `json:"enabled"`
+	Url                string `json:"url"`       // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool   `json:"start_tls"` // 仅对 ldap:// 生效
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	TimeoutSeconds     int    `json:"timeout_seconds"`
+	// 用于查找用户的服务账号，为空时匿名查找
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+	SearchBase   string `json:"search_base"`
+	// 查找用户的过滤器，{username} 会被替换为转义后的登录名，AD 通常为 (sAMAccountName={username})
+	UserFilter           string `json:"user_filter"`
+	IdAttribute          string `json:"id_attribute"` // 用户唯一标识，OpenLDAP 为 entryUUID，AD 为 objectGUID，为空时使用 DN
+	UsernameAttribute    string `json:"username_attribute"`
+	EmailAttribute       string `json:"email_attribute"`
+	DisplayNameAttribute string `json:"display_name_attribute"`
+	// 用户条目上记录所属组的属性，AD 与启用 memberOf overlay 的 OpenLDAP 为 memberOf
+	GroupAttribute string `json:"group_attribute"`
+	// 另外按组条目查找所属组，{dn} 会被替换为转义后的用户 DN，如 (member={dn})；为空时不查找
+	GroupSearchBase   string `json:"group_search_base"`
+	GroupSearchFilter string `json:"group_search_filter"`
+	// 按顺序匹配，第一个匹配的映射生效
+	GroupMapping []LDAPGroupMapping `json:"group_mapping"`
+	RoleMapping  []LDAPGroupMapping `json:"role_mapping"`
+	// 首次登录时自动创建用户，不受是否开放注册的限制
+	AutoCreateUser bool `json:"auto_create_user"`
+}
+
+// 默认配置
+var defaultLDAPSettings = LDAPSettings{
+	TimeoutSeconds:       5,
+	UserFilter:           "(&(objectClass=person)(uid={username}))",
+	IdAttribute:          "entryUUID",
+	UsernameAttribute:    "uid",
+	EmailAttribute:       "mail",
+	DisplayNameAttribute: "cn",
+	GroupAttribute:       "memberOf",
+	GroupMapping:         []LDAPGroupMapping{},
+	RoleMapping:          []LDAPGroupMapping{},
+	AutoCreateUser:       true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("ldap", &defaultLDAPSettings)
+}
+
+func GetLDAPSettings() *LDAPSettings {
+	return &defaultLDAPSettings
+}
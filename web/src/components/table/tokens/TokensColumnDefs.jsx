@@ -39,6 +39,8 @@ import {
   renderQuota,
   getModelCategories,
   showError,
+  getTokenSecret,
+  maskTokenKey,
 } from '../../../helpers';
 import {
   IconTreeTriangleDown,
@@ -108,31 +110,33 @@ const renderGroupColumn = (text, record, t) => {
 };
 
 // Render token key column with show/hide and copy functionality
-const renderTokenKey = (text, record, showKeys, setShowKeys, copyText) => {
-  const fullKey = 'sk-' + record.key;
-  const maskedKey =
-    'sk-' + record.key.slice(0, 4) + '**********' + record.key.slice(-4);
-  const revealed = !!showKeys[record.id];
+// 服务端只返回 key_prefix，明文仅在本浏览器保存过（创建或轮换时）才能查看和复制
+const renderTokenKey = (text, record, showKeys, setShowKeys, copyText, t) => {
+  const secret = getTokenSecret(record.id);
+  const maskedKey = maskTokenKey(record);
+  const revealed = secret !== '' && !!showKeys[record.id];
 
   return (
     <div className='w-[200px]'>
       <Input
         readOnly
-        value={revealed ? fullKey : maskedKey}
+        value={revealed ? 'sk-' + secret : maskedKey}
         size='small'
         suffix={
           <div className='flex items-center'>
-            <Button
-              theme='borderless'
-              size='small'
-              type='tertiary'
-              icon={revealed ? <IconEyeClosed /> : <IconEyeOpened />}
-              aria-label='toggle token visibility'
-              onClick={(e) => {
-                e.stopPropagation();
-                setShowKeys((prev) => ({ ...prev, [record.id]: !revealed }));
-              }}
-            />
+            {secret !== '' && (
+              <Button
+                theme='borderless'
+                size='small'
+                type='tertiary'
+                icon={revealed ? <IconEyeClosed /> : <IconEyeOpened />}
+                aria-label='toggle token visibility'
+                onClick={(e) => {
+                  e.stopPropagation();
+                  setShowKeys((prev) => ({ ...prev, [record.id]: !revealed }));
+                }}
+              />
+            )}
             <Button
               theme='borderless'
               size='small'
@@ -141,7 +145,13 @@ const renderTokenKey = (text, record, showKeys, setShowKeys, copyText) => {
               aria-label='copy token key'
               onClick={async (e) => {
                 e.stopPropagation();
-                await copyText(fullKey);
+                if (secret === '') {
+                  showError(
+                    t('本浏览器未保存该令牌的明文，请重置密钥后复制新的令牌'),
+                  );
+                  return;
+                }
+                await copyText('sk-' + secret);
               }}
             />
           </div>
@@ -314,6 +324,7 @@ const renderOperations = (
   setEditingToken,
   setShowEdit,
   manageToken,
+  rotateToken,
   refresh,
   t,
 ) => {
@@ -402,6 +413,20 @@ const renderOperations = (
         {t('编辑')}
       </Button>
 
+      <Button
+        type='tertiary'
+        size='small'
+        onClick={() => {
+          Modal.confirm({
+            title: t('确定要重置此令牌的密钥吗？'),
+            content: t('重置后会生成新的密钥，旧密钥仅在宽限期内继续有效'),
+            onOk: () => rotateToken(record),
+          });
+        }}
+      >
+        {t('重置密钥')}
+      </Button>
+
       <Button
         type='danger'
         size='small'
@@ -430,6 +455,7 @@ export const getTokensColumns = ({
   setShowKeys,
   copyText,
   manageToken,
+  rotateToken,
   onOpenLink,
   setEditingToken,
   setShowEdit,
@@ -461,7 +487,7 @@ export const getTokensColumns = ({
       title: t('密钥'),
       key: 'token_key',
       render: (text, record) =>
-        renderTokenKey(text, record, showKeys, setShowKeys, copyText),
+        renderTokenKey(text, record, showKeys, setShowKeys, copyText, t),
     },
     {
       title: t('可用模型'),
@@ -503,6 +529,7 @@ export const getTokensColumns = ({
           setEditingToken,
           setShowEdit,
           manageToken,
+          rotateToken,
           refresh,
           t,
         ),
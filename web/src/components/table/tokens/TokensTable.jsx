@@ -42,6 +42,7 @@ const TokensTable = (tokensData) => {
     setShowKeys,
     copyText,
     manageToken,
+    rotateToken,
     onOpenLink,
     setEditingToken,
     setShowEdit,
@@ -57,6 +58,7 @@ const TokensTable = (tokensData) => {
       setShowKeys,
       copyText,
       manageToken,
+      rotateToken,
       onOpenLink,
       setEditingToken,
       setShowEdit,
@@ -68,6 +70,7 @@ const TokensTable = (tokensData) => {
     setShowKeys,
     copyText,
     manageToken,
+    rotateToken,
     onOpenLink,
     setEditingToken,
     setShowEdit,
@@ -29,6 +29,7 @@ import {
 import {
   API,
   showError,
+  getTokenSecret,
   getModelCategories,
   selectFilter,
 } from '../../../helpers';
@@ -227,7 +228,12 @@ function TokensPage() {
         Toast.warning(t('没有可用令牌用于填充'));
         return;
       }
-      apiKeyToUse = 'sk-' + token.key;
+      const secret = getTokenSecret(token.id);
+      if (!secret) {
+        Toast.warning(t('本浏览器未保存该令牌的明文，请重置密钥后再使用'));
+        return;
+      }
+      apiKeyToUse = 'sk-' + secret;
     }
 
     const payload = {
@@ -19,13 +19,20 @@ For commercial licensing, please contact support@quantumnous.com
 
 import React from 'react';
 import { Modal, Button, Space } from '@douyinfe/semi-ui';
+import { getTokenSecret } from '../../../../helpers';
 
 const CopyTokensModal = ({ visible, onCancel, selectedKeys, copyText, t }) => {
+  // 只复制本浏览器保存过明文的令牌
+  const copyable = selectedKeys
+    .map((token) => ({ name: token.name, secret: getTokenSecret(token.id) }))
+    .filter((token) => token.secret !== '');
+  const missing = selectedKeys.length - copyable.length;
+
   // Handle copy with name and key format
   const handleCopyWithName = async () => {
     let content = '';
-    for (let i = 0; i < selectedKeys.length; i++) {
-      content += selectedKeys[i].name + '    sk-' + selectedKeys[i].key + '\n';
+    for (let i = 0; i < copyable.length; i++) {
+      content += copyable[i].name + '    sk-' + copyable[i].secret + '\n';
     }
     await copyText(content);
     onCancel();
@@ -34,8 +41,8 @@ const CopyTokensModal = ({ visible, onCancel, selectedKeys, copyText, t }) => {
   // Handle copy with key only format
   const handleCopyKeyOnly = async () => {
     let content = '';
-    for (let i = 0; i < selectedKeys.length; i++) {
-      content += 'sk-' + selectedKeys[i].key + '\n';
+    for (let i = 0; i < copyable.length; i++) {
+      content += 'sk-' + copyable[i].secret + '\n';
     }
     await copyText(content);
     onCancel();
@@ -49,13 +56,23 @@ const CopyTokensModal = ({ visible, onCancel, selectedKeys, copyText, t }) => {
       onCancel={onCancel}
       footer={
         <Space>
-          <Button type='tertiary' onClick={handleCopyWithName}>
+          <Button
+            type='tertiary'
+            disabled={copyable.length === 0}
+            onClick={handleCopyWithName}
+          >
             {t('名称+密钥')}
           </Button>
-          <Button onClick={handleCopyKeyOnly}>{t('仅密钥')}</Button>
+          <Button disabled={copyable.length === 0} onClick={handleCopyKeyOnly}>
+            {t('仅密钥')}
+          </Button>
         </Space>
       }
     >
+      {missing > 0 &&
+        t('有 {{count}} 个令牌未在本浏览器保存明文，将被跳过。', {
+          count: missing,
+        })}
       {t('请选择你的复制方式')}
     </Modal>
   );
@@ -0,0 +1,54 @@
+/*
+Copyright (C) 2025 QuantumNous
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+For commercial licensing, please contact support@quantumnous.com
+*/
+
+import React from 'react';
+import { Modal, Typography, Banner } from '@douyinfe/semi-ui';
+
+const { Text } = Typography;
+
+// 展示仅返回一次的令牌明文，keys 为 [{ name, key }]
+export const showTokenSecretModal = ({ keys, t, extra }) => {
+  Modal.info({
+    title: t('请立即复制并妥善保存令牌'),
+    icon: null,
+    size: 'medium',
+    okText: t('我已保存'),
+    hasCancel: false,
+    content: (
+      <div className='flex flex-col gap-3'>
+        <Banner
+          type='warning'
+          closeIcon={null}
+          description={t(
+            '令牌明文只显示这一次，关闭后服务端无法再次查看；本浏览器会保存一份用于复制和聊天链接。',
+          )}
+        />
+        {extra}
+        {keys.map((item) => (
+          <div key={item.key} className='flex flex-col gap-1'>
+            {item.name && <Text type='tertiary'>{item.name}</Text>}
+            <Text code copyable>
+              {item.key}
+            </Text>
+          </div>
+        ))}
+      </div>
+    ),
+  });
+};
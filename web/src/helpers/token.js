@@ -19,8 +19,61 @@ For commercial licensing, please contact support@quantumnous.com
 
 import { API } from './api';
 
+const TOKEN_SECRETS_KEY = 'token_secrets';
+
+function loadTokenSecrets() {
+  try {
+    return JSON.parse(localStorage.getItem(TOKEN_SECRETS_KEY)) || {};
+  } catch (error) {
+    return {};
+  }
+}
+
+/**
+ * 保存令牌明文
+ * 服务端只在创建和轮换时返回一次明文，保存在本浏览器中供复制和聊天链接使用
+ * @param {number} id 令牌 ID
+ * @param {string} key 令牌明文，可带 sk- 前缀
+ */
+export function saveTokenSecret(id, key) {
+  if (!id || !key) return;
+  const secrets = loadTokenSecrets();
+  secrets[id] = key.startsWith('sk-') ? key.slice(3) : key;
+  localStorage.setItem(TOKEN_SECRETS_KEY, JSON.stringify(secrets));
+}
+
+/**
+ * 获取本浏览器保存的令牌明文
+ * @param {number} id 令牌 ID
+ * @returns {string} 不带 sk- 前缀的明文，未保存时返回空字符串
+ */
+export function getTokenSecret(id) {
+  return loadTokenSecrets()[id] || '';
+}
+
+/**
+ * 删除本浏览器保存的令牌明文
+ * @param {number} id 令牌 ID
+ */
+export function removeTokenSecret(id) {
+  const secrets = loadTokenSecrets();
+  if (!(id in secrets)) return;
+  delete secrets[id];
+  localStorage.setItem(TOKEN_SECRETS_KEY, JSON.stringify(secrets));
+}
+
+/**
+ * 令牌的脱敏展示，只包含前缀
+ * @param {object} token 令牌记录
+ * @returns {string}
+ */
+export function maskTokenKey(token) {
+  return 'sk-' + (token?.key_prefix || '') + '********';
+}
+
 /**
  * 获取可用的token keys
+ * 只返回本浏览器保存了明文的令牌
  * @returns {Promise<string[]>} 返回active状态的token key数组
  */
 export async function fetchTokenKeys() {
@@ -31,7 +84,9 @@ export async function fetchTokenKeys() {
 
     const tokenItems = Array.isArray(data) ? data : data.items || [];
     const activeTokens = tokenItems.filter((token) => token.status === 1);
-    return activeTokens.map((token) => token.key);
+    return activeTokens
+      .map((token) => getTokenSecret(token.id))
+      .filter((key) => key !== '');
   } catch (error) {
     console.error('Error fetching token keys:', error);
     return [];
@@ -26,9 +26,14 @@ import {
   showError,
   showSuccess,
   encodeToBase64,
+  timestamp2string,
+  getTokenSecret,
+  saveTokenSecret,
+  removeTokenSecret,
 } from '../../helpers';
 import { ITEMS_PER_PAGE } from '../../constants';
 import { useTableCompactMode } from '../common/useTableCompactMode';
+import { showTokenSecretModal } from '../../components/table/tokens/modals/TokenSecretModal';
 
 export const useTokensData = (openFluentNotification) => {
   const { t } = useTranslation();
@@ -124,8 +129,13 @@ export const useTokensData = (openFluentNotification) => {
 
   // Open link function for chat integrations
   const onOpenLink = async (type, url, record) => {
+    const secret = getTokenSecret(record.id);
+    if (secret === '') {
+      showError(t('本浏览器未保存该令牌的明文，请重置密钥后再使用'));
+      return;
+    }
     if (url && url.startsWith('fluent')) {
-      openFluentNotification(record.key);
+      openFluentNotification(secret);
       return;
     }
     let status = localStorage.getItem('status');
@@ -141,7 +151,7 @@ export const useTokensData = (openFluentNotification) => {
       let cherryConfig = {
         id: 'new-api',
         baseUrl: serverAddress,
-        apiKey: 'sk-' + record.key,
+        apiKey: 'sk-' + secret,
       };
       let encodedConfig = encodeURIComponent(
         encodeToBase64(JSON.stringify(cherryConfig)),
@@ -150,7 +160,7 @@ export const useTokensData = (openFluentNotification) => {
     } else {
       let encodedServerAddress = encodeURIComponent(serverAddress);
       url = url.replaceAll('{address}', encodedServerAddress);
-      url = url.replaceAll('{key}', 'sk-' + record.key);
+      url = url.replaceAll('{key}', 'sk-' + secret);
     }
 
     window.open(url, '_blank');
@@ -181,6 +191,8 @@ export const useTokensData = (openFluentNotification) => {
       let newTokens = [...tokens];
       if (action !== 'delete') {
         record.status = token.status;
+      } else {
+        removeTokenSecret(id);
       }
       setTokens(newTokens);
     } else {
@@ -189,6 +201,28 @@ export const useTokensData = (openFluentNotification) => {
     setLoading(false);
   };
 
+  // Rotate token key, the new key is only returned once
+  const rotateToken = async (record) => {
+    const res = await API.post(`/api/token/${record.id}/rotate`);
+    const { success, message, data } = res.data;
+    if (!success) {
+      showError(message);
+      return;
+    }
+    saveTokenSecret(data.id, data.key);
+    showTokenSecretModal({
+      keys: [{ name: record.name, key: data.key }],
+      t,
+      extra:
+        data.previous_key_expired_time > 0
+          ? t('旧密钥将在 {{time}} 失效', {
+              time: timestamp2string(data.previous_key_expired_time),
+            })
+          : null,
+    });
+    await refresh();
+  };
+
   // Search tokens function
   const searchTokens = async (page = 1, size = pageSize) => {
     const normalizedPage = Number.isInteger(page) && page > 0 ? page : 1;
@@ -281,6 +315,7 @@ export const useTokensData = (openFluentNotification) => {
       const ids = selectedKeys.map((token) => token.id);
       const res = await API.post('/api/token/batch', { ids });
       if (res?.data?.success) {
+        ids.forEach((id) => removeTokenSecret(id));
         const count = res.data.data || 0;
         showSuccess(t('已删除 {{count}} 个令牌！', { count }));
         await refresh();
@@ -300,25 +335,39 @@ export const useTokensData = (openFluentNotification) => {
   };
 
   // Batch copy tokens
+  // 只能复制本浏览器保存过明文的令牌
   const batchCopyTokens = (copyType) => {
     if (selectedKeys.length === 0) {
       showError(t('请至少选择一个令牌！'));
       return;
     }
+    const copyable = selectedKeys
+      .map((token) => ({ name: token.name, secret: getTokenSecret(token.id) }))
+      .filter((token) => token.secret !== '');
+    if (copyable.length === 0) {
+      showError(t('所选令牌在本浏览器均未保存明文，请重置密钥后复制'));
+      return;
+    }
+    const missing = selectedKeys.length - copyable.length;
 
     Modal.info({
       title: t('复制令牌'),
       icon: null,
-      content: t('请选择你的复制方式'),
+      content:
+        missing > 0
+          ? t('有 {{count}} 个令牌未在本浏览器保存明文，将被跳过。', {
+              count: missing,
+            }) + t('请选择你的复制方式')
+          : t('请选择你的复制方式'),
       footer: (
         <div className='flex gap-2'>
           <button
             className='px-3 py-1 bg-gray-200 rounded'
             onClick={async () => {
               let content = '';
-              for (let i = 0; i < selectedKeys.length; i++) {
+              for (let i = 0; i < copyable.length; i++) {
                 content +=
-                  selectedKeys[i].name + '    sk-' + selectedKeys[i].key + '\n';
+                  copyable[i].name + '    sk-' + copyable[i].secret + '\n';
               }
               await copyText(content);
               Modal.destroyAll();
@@ -330,8 +379,8 @@ export const useTokensData = (openFluentNotification) => {
             className='px-3 py-1 bg-blue-500 text-white rounded'
             onClick={async () => {
               let content = '';
-              for (let i = 0; i < selectedKeys.length; i++) {
-                content += 'sk-' + selectedKeys[i].key + '\n';
+              for (let i = 0; i < copyable.length; i++) {
+                content += 'sk-' + copyable[i].secret + '\n';
               }
               await copyText(content);
               Modal.destroyAll();
@@ -391,6 +440,7 @@ export const useTokensData = (openFluentNotification) => {
     copyText,
     onOpenLink,
     manageToken,
+    rotateToken,
     searchTokens,
     sortToken,
     handlePageChange,